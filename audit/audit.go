@@ -0,0 +1,124 @@
+package audit
+
+import (
+	"cache-api/auth"
+	"cache-api/config"
+	"cache-api/server"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+var _ server.Auditor = &Auditor{}
+
+// level sets the records apart from the logs when they share stdout
+const level = "audit"
+
+// record is a line of the audit log
+type record struct {
+	Level     string    `json:"level"`
+	Time      time.Time `json:"time"`
+	Action    string    `json:"action"`
+	Principal string    `json:"principal,omitempty"`
+	Client    string    `json:"client"`
+	RequestID string    `json:"request_id,omitempty"`
+	Namespace string    `json:"namespace,omitempty"`
+	Key       string    `json:"key,omitempty"`
+	ValueHash string    `json:"value_sha256,omitempty"`
+	Prefix    string    `json:"prefix,omitempty"`
+	Pattern   string    `json:"pattern,omitempty"`
+	Tag       string    `json:"tag,omitempty"`
+	// Count is only set for the actions removing keys, where 0 is meaningful
+	Count *int `json:"count,omitempty"`
+}
+
+// Auditor writes a JSON line for every change made to the caches, recording who made it, when, and which keys it
+// touched. Values are only recorded by their hash.
+type Auditor struct {
+	mutex  sync.Mutex
+	out    io.Writer
+	closer io.Closer
+	now    func() time.Time
+	logger *zerolog.Logger
+}
+
+// New returns an auditor writing to the rotating file of conf, or to stdout if it has none. Failures to write a
+// record are logged to logger.
+func New(conf config.AuditConfig, stdout io.Writer, logger *zerolog.Logger) (*Auditor, error) {
+	a := &Auditor{out: stdout, now: time.Now, logger: logger}
+	if conf.File != "" {
+		file, err := OpenRotatingFile(conf.File, int64(conf.MaxSizeMB)<<20,
+			time.Duration(conf.MaxAgeHours)*time.Hour, conf.MaxBackups)
+		if err != nil {
+			return nil, fmt.Errorf("error opening audit file %w", err)
+		}
+		a.out, a.closer = file, file
+	}
+	return a, nil
+}
+
+// Audit implements server.Auditor
+func (a *Auditor) Audit(r *http.Request, event server.AuditEvent) {
+	rec := record{
+		Level:     level,
+		Time:      a.now().UTC(),
+		Action:    event.Action,
+		Client:    clientIP(r),
+		Namespace: event.Namespace,
+		Key:       event.Key,
+		ValueHash: event.ValueHash,
+		Prefix:    event.Prefix,
+		Pattern:   event.Pattern,
+		Tag:       event.Tag,
+	}
+	if principal, ok := auth.PrincipalFrom(r.Context()); ok {
+		rec.Principal = principal.Name
+	}
+	if id, ok := server.RequestIDFrom(r.Context()); ok {
+		rec.RequestID = id
+	}
+	if event.Action != server.AuditSet && event.Action != server.AuditIncrement {
+		count := event.Count
+		rec.Count = &count
+	}
+	line, err := json.Marshal(rec)
+	if err != nil {
+		a.logger.Error().Err(err).Str("action", event.Action).Msg("Failed to encode audit record")
+		return
+	}
+	line = append(line, '\n')
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	if n, err := a.out.Write(line); err != nil {
+		if n == len(line) {
+			// the record was written to the current file, which could not be rotated
+			a.logger.Error().Err(err).Msg("Failed to rotate audit file")
+			return
+		}
+		a.logger.Error().Err(err).Str("action", event.Action).Msg("Failed to write audit record")
+	}
+}
+
+// Close closes the audit file, if any
+func (a *Auditor) Close() error {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	if a.closer == nil {
+		return nil
+	}
+	return a.closer.Close()
+}
+
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
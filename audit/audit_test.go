@@ -0,0 +1,110 @@
+package audit
+
+import (
+	"bytes"
+	"cache-api/auth"
+	"cache-api/config"
+	"cache-api/server"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+type failingWriter struct{}
+
+func (failingWriter) Write([]byte) (int, error) {
+	return 0, errors.New("disk full")
+}
+
+func TestAuditor_Audit(t *testing.T) {
+	t.Parallel()
+	now := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	tests := []struct {
+		name      string
+		principal string
+		event     server.AuditEvent
+		expected  string
+	}{
+		{
+			name:      "Should record a set with the principal and the hash of the value",
+			principal: "alice",
+			event:     server.AuditEvent{Action: server.AuditSet, Namespace: "team-a", Key: "key", ValueHash: "abc"},
+			expected: `{"level":"audit","time":"2024-01-02T03:04:05Z","action":"set","principal":"alice",` +
+				`"client":"192.0.2.1","request_id":"req-1","namespace":"team-a","key":"key","value_sha256":"abc"}`,
+		},
+		{
+			name:  "Should record the count of a bulk action even if zero",
+			event: server.AuditEvent{Action: server.AuditDeleteKeys, Prefix: "tenant42:"},
+			expected: `{"level":"audit","time":"2024-01-02T03:04:05Z","action":"delete_keys",` +
+				`"client":"192.0.2.1","request_id":"req-1","prefix":"tenant42:","count":0}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			var out bytes.Buffer
+			logger := zerolog.Nop()
+			auditor, err := New(config.AuditConfig{}, &out, &logger)
+			if err != nil {
+				t.Fatal(err)
+			}
+			auditor.now = func() time.Time { return now }
+			handler := server.AccessLog(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				auditor.Audit(r, tt.event)
+			}), &logger, 1)
+			req := httptest.NewRequest(http.MethodPost, "/key", nil)
+			req.Header.Set("X-Request-ID", "req-1")
+			if tt.principal != "" {
+				req = req.WithContext(auth.WithPrincipal(req.Context(), &auth.Principal{Name: tt.principal}))
+			}
+			handler.ServeHTTP(httptest.NewRecorder(), req)
+			if got := strings.TrimSuffix(out.String(), "\n"); got != tt.expected {
+				t.Errorf("Expected record %s, got %s", tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestAuditor_WriteError(t *testing.T) {
+	t.Parallel()
+	var logs bytes.Buffer
+	logger := zerolog.New(&logs)
+	auditor := &Auditor{out: failingWriter{}, now: time.Now, logger: &logger}
+	auditor.Audit(httptest.NewRequest(http.MethodPost, "/key", nil), server.AuditEvent{Action: server.AuditFlush})
+	if !strings.Contains(logs.String(), "disk full") {
+		t.Errorf("Expected the write error to be logged, got %s", logs.String())
+	}
+}
+
+func TestNew_File(t *testing.T) {
+	t.Parallel()
+	path := filepath.Join(t.TempDir(), "audit.log")
+	logger := zerolog.Nop()
+	auditor, err := New(config.AuditConfig{File: path, MaxSizeMB: 1}, &bytes.Buffer{}, &logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	auditor.Audit(httptest.NewRequest(http.MethodPost, "/key", nil), server.AuditEvent{Action: server.AuditFlush, Count: 3})
+	if err := auditor.Close(); err != nil {
+		t.Fatal(err)
+	}
+	content, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var rec record
+	if err := json.Unmarshal(content, &rec); err != nil {
+		t.Fatal(err)
+	}
+	if rec.Action != server.AuditFlush || rec.Count == nil || *rec.Count != 3 {
+		t.Errorf("Expected a flush of 3 keys, got %+v", rec)
+	}
+}
@@ -0,0 +1,130 @@
+package audit
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// backupTimeFormat names the rotated files after the time of their rotation, so they sort chronologically
+const backupTimeFormat = "20060102T150405.000000000"
+
+// RotatingFile is a file that is rotated once a write would grow it past maxBytes, or once it was created longer than
+// maxAge ago. Rotated files get the time of the rotation as a suffix, and only the maxBackups latest are kept. Zero
+// turns the corresponding limit off. It is not safe for concurrent use.
+type RotatingFile struct {
+	path       string
+	maxBytes   int64
+	maxAge     time.Duration
+	maxBackups int
+	file       *os.File
+	size       int64
+	// created is when the current file was started, which restarts do not change
+	created time.Time
+	now     func() time.Time
+}
+
+// OpenRotatingFile opens the file at path for appending, creating it if needed
+func OpenRotatingFile(path string, maxBytes int64, maxAge time.Duration, maxBackups int) (*RotatingFile, error) {
+	f := &RotatingFile{path: path, maxBytes: maxBytes, maxAge: maxAge, maxBackups: maxBackups, now: time.Now}
+	if err := f.open(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func (f *RotatingFile) open() error {
+	file, err := os.OpenFile(f.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		_ = file.Close()
+		return err
+	}
+	f.file, f.size, f.created = file, info.Size(), f.createdAt(info)
+	return nil
+}
+
+// createdAt returns when the file was started: now if it is empty, else at the latest rotation, which names the
+// newest backup, or at its last modification if it was never rotated
+func (f *RotatingFile) createdAt(info os.FileInfo) time.Time {
+	if info.Size() == 0 {
+		return f.now()
+	}
+	backups, _ := filepath.Glob(f.path + ".*")
+	sort.Strings(backups)
+	for i := len(backups) - 1; i >= 0; i-- {
+		rotated, err := time.Parse(backupTimeFormat, strings.TrimPrefix(backups[i], f.path+"."))
+		if err == nil && rotated.Before(info.ModTime()) {
+			return rotated
+		}
+	}
+	return info.ModTime()
+}
+
+// Write appends p to the file, rotating it first if p would not fit or the file is too old. A failed rotation is
+// returned along with the count of bytes written, as p is still appended to the current file and the rotation is
+// tried again on the next write.
+func (f *RotatingFile) Write(p []byte) (int, error) {
+	if f.file == nil {
+		if err := f.open(); err != nil {
+			return 0, err
+		}
+	}
+	tooBig := f.maxBytes > 0 && f.size > 0 && f.size+int64(len(p)) > f.maxBytes
+	tooOld := f.maxAge > 0 && f.now().Sub(f.created) >= f.maxAge
+	var rotateErr error
+	if tooBig || tooOld {
+		if rotateErr = f.rotate(); f.file == nil {
+			return 0, rotateErr
+		}
+	}
+	n, err := f.file.Write(p)
+	f.size += int64(n)
+	if err != nil {
+		return n, err
+	}
+	return n, rotateErr
+}
+
+// rotate renames the current file after the time of the rotation, opens a new one and removes the oldest backups. If
+// the file can not be renamed, the current one is opened again, and f.file is left nil only if no file could be
+// opened, for the next write to try again.
+func (f *RotatingFile) rotate() error {
+	err := f.file.Close()
+	f.file = nil
+	if err == nil {
+		err = os.Rename(f.path, f.path+"."+f.now().UTC().Format(backupTimeFormat))
+	}
+	if openErr := f.open(); openErr != nil || err != nil {
+		return errors.Join(err, openErr)
+	}
+	if f.maxBackups <= 0 {
+		return nil
+	}
+	backups, err := filepath.Glob(f.path + ".*")
+	if err != nil {
+		return err
+	}
+	sort.Strings(backups)
+	for len(backups) > f.maxBackups {
+		if err := os.Remove(backups[0]); err != nil {
+			return err
+		}
+		backups = backups[1:]
+	}
+	return nil
+}
+
+// Close closes the current file
+func (f *RotatingFile) Close() error {
+	if f.file == nil {
+		return os.ErrClosed
+	}
+	return f.file.Close()
+}
@@ -0,0 +1,174 @@
+package audit
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestRotatingFile(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name            string
+		maxBytes        int64
+		maxAge          time.Duration
+		maxBackups      int
+		writes          int
+		advance         time.Duration
+		expectedBackups int
+		expectedContent string
+	}{
+		{name: "Should not rotate below the limits", maxBytes: 100, writes: 3, expectedContent: "line\nline\nline\n"},
+		{name: "Should rotate by size", maxBytes: 10, writes: 5, expectedBackups: 2, expectedContent: "line\n"},
+		{name: "Should rotate by age", maxAge: time.Hour, writes: 3, advance: time.Hour, expectedBackups: 2, expectedContent: "line\n"},
+		{name: "Should keep max backups", maxBytes: 5, maxBackups: 2, writes: 5, expectedBackups: 2, expectedContent: "line\n"},
+		{name: "Should not rotate without limits", writes: 3, advance: time.Hour, expectedContent: "line\nline\nline\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			path := filepath.Join(t.TempDir(), "audit.log")
+			file, err := OpenRotatingFile(path, tt.maxBytes, tt.maxAge, tt.maxBackups)
+			if err != nil {
+				t.Fatal(err)
+			}
+			now := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+			file.now = func() time.Time { return now }
+			file.created = now
+			for i := 0; i < tt.writes; i++ {
+				if _, err := file.Write([]byte("line\n")); err != nil {
+					t.Fatal(err)
+				}
+				// distinct times keep the names of the backups apart
+				now = now.Add(time.Millisecond + tt.advance)
+			}
+			if err := file.Close(); err != nil {
+				t.Fatal(err)
+			}
+			backups, err := filepath.Glob(path + ".*")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(backups) != tt.expectedBackups {
+				t.Errorf("Expected %d backups, got %v", tt.expectedBackups, backups)
+			}
+			content, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(content) != tt.expectedContent {
+				t.Errorf("Expected content %q, got %q", tt.expectedContent, content)
+			}
+		})
+	}
+}
+
+func TestRotatingFile_Append(t *testing.T) {
+	t.Parallel()
+	path := filepath.Join(t.TempDir(), "audit.log")
+	if err := os.WriteFile(path, []byte("line\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	file, err := OpenRotatingFile(path, 8, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := file.Write([]byte("line\n")); err != nil {
+		t.Fatal(err)
+	}
+	if err := file.Close(); err != nil {
+		t.Fatal(err)
+	}
+	backups, _ := filepath.Glob(path + ".*")
+	if len(backups) != 1 {
+		t.Errorf("Expected the size of the existing file to count, got backups %v", backups)
+	}
+}
+
+func TestRotatingFile_AgeOfExistingFile(t *testing.T) {
+	t.Parallel()
+	now := time.Now().UTC()
+	tests := []struct {
+		name       string
+		backup     time.Time
+		modified   time.Time
+		wantRotate bool
+	}{
+		{
+			name:   "Should count the age from the latest rotation",
+			backup: now.Add(-2 * time.Hour), modified: now, wantRotate: true,
+		},
+		{
+			name:     "Should count the age from the modification without backups",
+			modified: now.Add(-2 * time.Hour), wantRotate: true,
+		},
+		{name: "Should not rotate a recent file", backup: now.Add(-time.Minute), modified: now},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			path := filepath.Join(t.TempDir(), "audit.log")
+			if !tt.backup.IsZero() {
+				backup := path + "." + tt.backup.Format(backupTimeFormat)
+				if err := os.WriteFile(backup, []byte("old\n"), 0o600); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := os.WriteFile(path, []byte("line\n"), 0o600); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.Chtimes(path, tt.modified, tt.modified); err != nil {
+				t.Fatal(err)
+			}
+			// a restart does not postpone the rotation
+			file, err := OpenRotatingFile(path, 0, time.Hour, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer file.Close()
+			if _, err := file.Write([]byte("line\n")); err != nil {
+				t.Fatal(err)
+			}
+			content, _ := os.ReadFile(path)
+			if rotated := string(content) == "line\n"; rotated != tt.wantRotate {
+				t.Errorf("Expected rotation %v, got content %q", tt.wantRotate, content)
+			}
+		})
+	}
+}
+
+func TestRotatingFile_RotateError(t *testing.T) {
+	t.Parallel()
+	path := filepath.Join(t.TempDir(), "audit.log")
+	file, err := OpenRotatingFile(path, 8, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	now := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	file.now = func() time.Time { return now }
+	// a directory in the way of the backup makes the rename fail
+	blocked := path + "." + now.Format(backupTimeFormat)
+	if err := os.MkdirAll(filepath.Join(blocked, "in the way"), 0o700); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := file.Write([]byte("line\n")); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := file.Write([]byte("line\n")); err == nil || n != 5 {
+		t.Errorf("Expected the failed rotation along with the write, got %d and %v", n, err)
+	}
+
+	// the writes go on to the current file, and the rotation succeeds once it can
+	if err := os.RemoveAll(blocked); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := file.Write([]byte("line\n")); err != nil {
+		t.Errorf("Expected the rotation to succeed, got %v", err)
+	}
+	backup, _ := os.ReadFile(blocked)
+	if string(backup) != "line\nline\n" {
+		t.Errorf("Expected the lines written before the rotation in the backup, got %q", backup)
+	}
+}
@@ -0,0 +1,395 @@
+package auth
+
+import (
+	"cache-api/config"
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/rs/zerolog"
+)
+
+const (
+	headerAPIKey          = "X-API-Key"
+	headerAuthorization   = "Authorization"
+	headerWWWAuthenticate = "WWW-Authenticate"
+	bearerPrefix          = "Bearer "
+	// queryToken carries the token of the browsers watching keys, which can not set headers on an EventSource or a
+	// WebSocket
+	queryToken = "token"
+
+	errUnauthorizedResponse = "Unauthorized"
+	errForbiddenResponse    = "Forbidden"
+)
+
+// Access is a set of permissions granted by a rule
+type Access uint8
+
+const (
+	// Read allows getting values and listing keys
+	Read Access = 1 << iota
+	// Write allows storing, incrementing and invalidating values
+	Write
+	// Admin allows the administrative routes, e.g. listing the namespaces
+	Admin
+)
+
+// AnyNamespace matches every namespace in a rule
+const AnyNamespace = "*"
+
+// Rule grants access to the keys starting with Prefix in Namespace. The default namespace is the empty string.
+type Rule struct {
+	Access    Access
+	Namespace string
+	Prefix    string
+}
+
+// Principal is an authenticated client
+type Principal struct {
+	Name  string
+	Rules []Rule
+}
+
+// Allows reports whether one of the rules of the principal grants access to the resource
+func (p *Principal) Allows(resource Resource) bool {
+	for _, rule := range p.Rules {
+		if rule.Access&resource.Access != resource.Access {
+			continue
+		}
+		if rule.Namespace != AnyNamespace && rule.Namespace != resource.Namespace {
+			continue
+		}
+		if strings.HasPrefix(resource.Key, rule.Prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// Resource is what a request accesses
+type Resource struct {
+	Access    Access
+	Namespace string
+	// Key is the key of the request, or the prefix of the keys it accesses. It is empty if the request accesses
+	// every key of the namespace.
+	Key string
+}
+
+// ResourceOf maps a request to the resource it accesses, following the routes of the server package: values are at
+// `/{key}`, namespaces at `/ns/{namespace}/` and the routes starting with an underscore act on many keys at once. Like
+// the routing of the server, the path is split into segments before they are unescaped, so a key holding an escaped
+// slash is the key the handler serves.
+func ResourceOf(r *http.Request) Resource {
+	var resource Resource
+	segments := pathSegments(r.URL.EscapedPath())
+	if len(segments) > 1 && segments[0] == "ns" {
+		resource.Namespace, segments = segments[1], segments[2:]
+	}
+	first := ""
+	if len(segments) > 0 {
+		first = segments[0]
+	}
+	resource.Access = Write
+	if r.Method == http.MethodGet || r.Method == http.MethodHead {
+		resource.Access = Read
+	}
+	switch {
+	case first == "_keys" && len(segments) == 1:
+		resource.Key = r.URL.Query().Get("prefix")
+	case first == "_watch" && len(segments) == 1:
+		// a watch of a single key reads it, like a prefix of itself
+		resource.Key = r.URL.Query().Get("key")
+		if resource.Key == "" {
+			resource.Key = r.URL.Query().Get("prefix")
+		}
+	case first == "_tags" && len(segments) > 1:
+		resource.Access = Write
+	case strings.HasPrefix(first, "_"):
+		resource.Access = Admin
+	default:
+		resource.Key = first
+	}
+	return resource
+}
+
+// isWatch reports whether the request watches keys, at `/_watch` of the default cache or of a namespace
+func isWatch(r *http.Request) bool {
+	segments := pathSegments(r.URL.EscapedPath())
+	if len(segments) > 1 && segments[0] == "ns" {
+		segments = segments[2:]
+	}
+	return r.Method == http.MethodGet && len(segments) == 1 && segments[0] == "_watch"
+}
+
+// pathSegments splits the escaped path on its slashes and unescapes each segment. A segment that is not validly
+// escaped is kept as is, the server rejects its request anyway.
+func pathSegments(escapedPath string) []string {
+	segments := strings.Split(strings.TrimPrefix(escapedPath, "/"), "/")
+	for i, segment := range segments {
+		if unescaped, err := url.PathUnescape(segment); err == nil {
+			segments[i] = unescaped
+		}
+	}
+	return segments
+}
+
+type contextKey struct{}
+
+// WithPrincipal returns a copy of ctx carrying the principal
+func WithPrincipal(ctx context.Context, principal *Principal) context.Context {
+	return context.WithValue(ctx, contextKey{}, principal)
+}
+
+// PrincipalFrom returns the principal authenticated for the request the context belongs to, if any
+func PrincipalFrom(ctx context.Context) (*Principal, bool) {
+	principal, ok := ctx.Value(contextKey{}).(*Principal)
+	return principal, ok
+}
+
+// Authenticator authenticates requests with static API keys or bearer JWTs and authorizes them against the rules of
+// their principal
+type Authenticator struct {
+	// apiKeys maps the sha256 of each API key to its principal, so keys are not compared byte by byte
+	apiKeys map[[sha256.Size]byte]*Principal
+	// principals maps the names of the principals to their rules
+	principals map[string]*Principal
+	jwt        *jwtVerifier
+}
+
+var (
+	errNoCredentials     = errors.New("no credentials")
+	errUnknownAPIKey     = errors.New("unknown api key")
+	errUnknownPrincipal  = errors.New("unknown principal")
+	errJWTNotConfigured  = errors.New("bearer JWTs are not configured")
+	errInvalidRuleFormat = errors.New("rule is not in the access@namespace/prefix form")
+)
+
+type fileConfig struct {
+	Principals []struct {
+		Name    string   `json:"name"`
+		APIKeys []string `json:"api_keys"`
+		Rules   []string `json:"rules"`
+	} `json:"principals"`
+}
+
+// New creates an authenticator from the API keys and rules of the config, the principals of its file and the keys
+// of its JWKS file
+func New(conf config.AuthConfig) (*Authenticator, error) {
+	a := &Authenticator{
+		apiKeys:    make(map[[sha256.Size]byte]*Principal),
+		principals: make(map[string]*Principal),
+	}
+	if err := a.addRules(conf.Rules); err != nil {
+		return nil, err
+	}
+	if err := a.addAPIKeys(conf.APIKeys); err != nil {
+		return nil, err
+	}
+	if conf.File != "" {
+		if err := a.addFile(conf.File); err != nil {
+			return nil, err
+		}
+	}
+	if conf.JWKSFile != "" {
+		verifier, err := newJWTVerifier(conf.JWKSFile, conf.JWTIssuer, conf.JWTAudience)
+		if err != nil {
+			return nil, err
+		}
+		a.jwt = verifier
+	}
+	return a, nil
+}
+
+func (a *Authenticator) principal(name string) *Principal {
+	principal, ok := a.principals[name]
+	if !ok {
+		principal = &Principal{Name: name}
+		a.principals[name] = principal
+	}
+	return principal
+}
+
+// addRules adds rules in the `name=rule,rule;name=rule` form
+func (a *Authenticator) addRules(spec string) error {
+	return forEachAssignment(spec, func(name string, value string) error {
+		for _, ruleSpec := range strings.Split(value, ",") {
+			rule, err := ParseRule(ruleSpec)
+			if err != nil {
+				return fmt.Errorf("rule %q of %q: %w", ruleSpec, name, err)
+			}
+			principal := a.principal(name)
+			principal.Rules = append(principal.Rules, rule)
+		}
+		return nil
+	})
+}
+
+// addAPIKeys adds API keys in the `name=key;name=key` form
+func (a *Authenticator) addAPIKeys(spec string) error {
+	return forEachAssignment(spec, func(name string, key string) error {
+		return a.addAPIKey(name, key)
+	})
+}
+
+func (a *Authenticator) addAPIKey(name string, key string) error {
+	if key == "" {
+		return fmt.Errorf("empty api key for %q", name)
+	}
+	hash := sha256.Sum256([]byte(key))
+	if existing, ok := a.apiKeys[hash]; ok && existing.Name != name {
+		return fmt.Errorf("api key of %q is also used by %q", name, existing.Name)
+	}
+	a.apiKeys[hash] = a.principal(name)
+	return nil
+}
+
+func (a *Authenticator) addFile(path string) error {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("error reading auth file %w", err)
+	}
+	var file fileConfig
+	if err := json.Unmarshal(content, &file); err != nil {
+		return fmt.Errorf("error parsing auth file %w", err)
+	}
+	for _, p := range file.Principals {
+		if p.Name == "" {
+			return errors.New("principal without a name in auth file")
+		}
+		principal := a.principal(p.Name)
+		for _, ruleSpec := range p.Rules {
+			rule, err := ParseRule(ruleSpec)
+			if err != nil {
+				return fmt.Errorf("rule %q of %q: %w", ruleSpec, p.Name, err)
+			}
+			principal.Rules = append(principal.Rules, rule)
+		}
+		for _, key := range p.APIKeys {
+			if err := a.addAPIKey(p.Name, key); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// ParseRule parses a rule in the `access@namespace/prefix` form. access is a combination of r (read), w (write) and
+// a (admin), namespace is empty for the default namespace or * for any namespace, and prefix is optional, e.g.
+// `rw@team-a/user:` or `r@*/`.
+func ParseRule(spec string) (Rule, error) {
+	var rule Rule
+	access, target, ok := strings.Cut(strings.TrimSpace(spec), "@")
+	if !ok {
+		return rule, errInvalidRuleFormat
+	}
+	namespace, prefix, ok := strings.Cut(target, "/")
+	if !ok {
+		return rule, errInvalidRuleFormat
+	}
+	for _, ch := range access {
+		switch ch {
+		case 'r':
+			rule.Access |= Read
+		case 'w':
+			rule.Access |= Write
+		case 'a':
+			rule.Access |= Admin
+		default:
+			return rule, fmt.Errorf("unknown access %q", ch)
+		}
+	}
+	if rule.Access == 0 {
+		return rule, errInvalidRuleFormat
+	}
+	rule.Namespace = namespace
+	rule.Prefix = prefix
+	return rule, nil
+}
+
+func forEachAssignment(spec string, fn func(name string, value string) error) error {
+	for _, assignment := range strings.Split(spec, ";") {
+		assignment = strings.TrimSpace(assignment)
+		if assignment == "" {
+			continue
+		}
+		name, value, ok := strings.Cut(assignment, "=")
+		if !ok || strings.TrimSpace(name) == "" {
+			return fmt.Errorf("%q is not in the name=value form", assignment)
+		}
+		if err := fn(strings.TrimSpace(name), strings.TrimSpace(value)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Authenticate returns the principal of the credentials of the request. API keys are accepted in the X-API-Key
+// header or as a bearer token, bearer tokens in the JWT form are verified against the JWKS. The watches of keys also
+// accept either of them in the token query parameter, for the browsers.
+func (a *Authenticator) Authenticate(r *http.Request) (*Principal, error) {
+	token := r.Header.Get(headerAPIKey)
+	if token == "" {
+		var ok bool
+		token, ok = strings.CutPrefix(r.Header.Get(headerAuthorization), bearerPrefix)
+		if !ok && isWatch(r) {
+			token, ok = r.URL.Query().Get(queryToken), r.URL.Query().Has(queryToken)
+		}
+		if !ok {
+			return nil, errNoCredentials
+		}
+		token = strings.TrimSpace(token)
+		if strings.Count(token, ".") == 2 {
+			return a.authenticateJWT(token)
+		}
+	}
+	principal, ok := a.apiKeys[sha256.Sum256([]byte(token))]
+	if !ok {
+		return nil, errUnknownAPIKey
+	}
+	return principal, nil
+}
+
+func (a *Authenticator) authenticateJWT(token string) (*Principal, error) {
+	if a.jwt == nil {
+		return nil, errJWTNotConfigured
+	}
+	subject, err := a.jwt.verify(token)
+	if err != nil {
+		return nil, err
+	}
+	principal, ok := a.principals[subject]
+	if !ok {
+		return nil, fmt.Errorf("%w %q", errUnknownPrincipal, subject)
+	}
+	return principal, nil
+}
+
+// Middleware authenticates every request and checks that its principal is allowed to access the resource of the
+// request. It responds 401 if the request has no valid credentials and 403 if the principal lacks access. The
+// principal is available to the next handlers through PrincipalFrom.
+func (a *Authenticator) Middleware(next http.Handler, logger *zerolog.Logger) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		principal, err := a.Authenticate(r)
+		if err != nil {
+			logger.Warn().Err(err).Str("method", r.Method).Str("path", r.URL.Path).
+				Str("remote", r.RemoteAddr).Msg("Unauthenticated request")
+			w.Header().Set(headerWWWAuthenticate, "Bearer")
+			http.Error(w, errUnauthorizedResponse, http.StatusUnauthorized)
+			return
+		}
+		resource := ResourceOf(r)
+		if !principal.Allows(resource) {
+			logger.Warn().Str("principal", principal.Name).Str("method", r.Method).Str("path", r.URL.Path).
+				Str("namespace", resource.Namespace).Str("key", resource.Key).Msg("Forbidden request")
+			http.Error(w, errForbiddenResponse, http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(WithPrincipal(r.Context(), principal)))
+	})
+}
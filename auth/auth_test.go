@@ -0,0 +1,202 @@
+package auth
+
+import (
+	"cache-api/config"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/rs/zerolog"
+)
+
+func TestParseRule(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		spec    string
+		want    Rule
+		wantErr bool
+	}{
+		{spec: "rw@team-a/user:", want: Rule{Access: Read | Write, Namespace: "team-a", Prefix: "user:"}},
+		{spec: "r@*/", want: Rule{Access: Read, Namespace: AnyNamespace}},
+		{spec: "rwa@/", want: Rule{Access: Read | Write | Admin}},
+		{spec: "rw", wantErr: true},
+		{spec: "rw@team-a", wantErr: true},
+		{spec: "@team-a/", wantErr: true},
+		{spec: "x@team-a/", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.spec, func(t *testing.T) {
+			got, err := ParseRule(tt.spec)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("Expected an error, got %+v", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unexpected error %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("Expected %+v, got %+v", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestResourceOf(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		method string
+		target string
+		want   Resource
+	}{
+		{method: http.MethodGet, target: "/user:1", want: Resource{Access: Read, Key: "user:1"}},
+		{method: http.MethodPost, target: "/user:1", want: Resource{Access: Write, Key: "user:1"}},
+		{method: http.MethodPost, target: "/user:1/incr", want: Resource{Access: Write, Key: "user:1"}},
+		{method: http.MethodGet, target: "/ns/team-a/user:1", want: Resource{Access: Read, Namespace: "team-a", Key: "user:1"}},
+		{method: http.MethodGet, target: "/_keys?prefix=user:", want: Resource{Access: Read, Key: "user:"}},
+		{method: http.MethodDelete, target: "/ns/team-a/_keys?pattern=*", want: Resource{Access: Write, Namespace: "team-a"}},
+		{method: http.MethodPost, target: "/_tags/news/invalidate", want: Resource{Access: Write}},
+		{method: http.MethodGet, target: "/_namespaces", want: Resource{Access: Admin}},
+		{method: http.MethodGet, target: "/_watch?key=user:1", want: Resource{Access: Read, Key: "user:1"}},
+		{method: http.MethodGet, target: "/ns/team-a/_watch?prefix=user:", want: Resource{Access: Read, Namespace: "team-a", Key: "user:"}},
+		{method: http.MethodPost, target: "/_admin/flush", want: Resource{Access: Admin}},
+		{method: http.MethodGet, target: "/ns/team-a/_admin/keys/user:1", want: Resource{Access: Admin, Namespace: "team-a"}},
+		{method: http.MethodGet, target: "/user%2F1", want: Resource{Access: Read, Key: "user/1"}},
+		{method: http.MethodPost, target: "/user%2F1/incr", want: Resource{Access: Write, Key: "user/1"}},
+		{method: http.MethodGet, target: "/ns/team%2Fa/user:1", want: Resource{Access: Read, Namespace: "team/a", Key: "user:1"}},
+		{method: http.MethodGet, target: "/%5Fadmin/stats", want: Resource{Access: Admin}},
+		{method: http.MethodGet, target: "/%5Fkeys", want: Resource{Access: Read}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.method+" "+tt.target, func(t *testing.T) {
+			got := ResourceOf(httptest.NewRequest(tt.method, tt.target, nil))
+			if got != tt.want {
+				t.Errorf("Expected %+v, got %+v", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestPrincipal_Allows(t *testing.T) {
+	t.Parallel()
+	principal := Principal{Name: "alice", Rules: []Rule{
+		{Access: Read | Write, Namespace: "team-a", Prefix: "user:"},
+		{Access: Read, Namespace: AnyNamespace, Prefix: "public:"},
+	}}
+	tests := []struct {
+		name     string
+		resource Resource
+		want     bool
+	}{
+		{name: "write in prefix", resource: Resource{Access: Write, Namespace: "team-a", Key: "user:1"}, want: true},
+		{name: "write outside prefix", resource: Resource{Access: Write, Namespace: "team-a", Key: "order:1"}},
+		{name: "write in other namespace", resource: Resource{Access: Write, Namespace: "team-b", Key: "user:1"}},
+		{name: "read in any namespace", resource: Resource{Access: Read, Namespace: "team-b", Key: "public:1"}, want: true},
+		{name: "write in any namespace", resource: Resource{Access: Write, Namespace: "team-b", Key: "public:1"}},
+		{name: "every key", resource: Resource{Access: Read, Namespace: "team-a"}},
+		{name: "admin", resource: Resource{Access: Admin}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := principal.Allows(tt.resource); got != tt.want {
+				t.Errorf("Expected %v, got %v", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestNew(t *testing.T) {
+	t.Parallel()
+	t.Run("invalid rule", func(t *testing.T) {
+		_, err := New(config.AuthConfig{Rules: "alice=rw"})
+		if err == nil {
+			t.Error("Expected an error")
+		}
+	})
+	t.Run("shared api key", func(t *testing.T) {
+		_, err := New(config.AuthConfig{APIKeys: "alice=key;bob=key"})
+		if err == nil {
+			t.Error("Expected an error")
+		}
+	})
+	t.Run("file", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "auth.json")
+		content := `{"principals":[{"name":"carol","api_keys":["carol-key"],"rules":["r@*/"]}]}`
+		if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		a, err := New(config.AuthConfig{File: path, Rules: "carol=w@/x"})
+		if err != nil {
+			t.Fatalf("Unexpected error %v", err)
+		}
+		req := httptest.NewRequest(http.MethodGet, "/key", nil)
+		req.Header.Set(headerAPIKey, "carol-key")
+		principal, err := a.Authenticate(req)
+		if err != nil {
+			t.Fatalf("Unexpected error %v", err)
+		}
+		if principal.Name != "carol" || len(principal.Rules) != 2 {
+			t.Errorf("Expected carol with both rules, got %+v", principal)
+		}
+	})
+}
+
+func TestAuthenticator_Middleware(t *testing.T) {
+	t.Parallel()
+	a, err := New(config.AuthConfig{
+		APIKeys: "alice=alice-key;bob=bob-key",
+		Rules:   "alice=rw@/user:;bob=r@*/",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	logger := zerolog.Nop()
+	var gotPrincipal string
+	handler := a.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		principal, _ := PrincipalFrom(r.Context())
+		gotPrincipal = principal.Name
+	}), &logger)
+
+	tests := []struct {
+		name       string
+		method     string
+		target     string
+		header     string
+		value      string
+		wantStatus int
+	}{
+		{name: "no credentials", method: http.MethodGet, target: "/user:1", wantStatus: http.StatusUnauthorized},
+		{name: "unknown key", method: http.MethodGet, target: "/user:1", header: headerAPIKey, value: "nope", wantStatus: http.StatusUnauthorized},
+		{name: "api key header", method: http.MethodPost, target: "/user:1", header: headerAPIKey, value: "alice-key", wantStatus: http.StatusOK},
+		{name: "bearer api key", method: http.MethodPost, target: "/user:1", header: headerAuthorization, value: "Bearer alice-key", wantStatus: http.StatusOK},
+		{name: "outside prefix", method: http.MethodPost, target: "/order:1", header: headerAPIKey, value: "alice-key", wantStatus: http.StatusForbidden},
+		{name: "read only", method: http.MethodPost, target: "/ns/team-a/x", header: headerAPIKey, value: "bob-key", wantStatus: http.StatusForbidden},
+		{name: "read any namespace", method: http.MethodGet, target: "/ns/team-a/x", header: headerAPIKey, value: "bob-key", wantStatus: http.StatusOK},
+		{name: "watch token", method: http.MethodGet, target: "/_watch?prefix=user:&token=alice-key", wantStatus: http.StatusOK},
+		{name: "namespace watch token", method: http.MethodGet, target: "/ns/team-a/_watch?token=bob-key", wantStatus: http.StatusOK},
+		{name: "token outside watch", method: http.MethodGet, target: "/user:1?token=alice-key", wantStatus: http.StatusUnauthorized},
+		{name: "jwt not configured", method: http.MethodGet, target: "/x", header: headerAuthorization, value: "Bearer a.b.c", wantStatus: http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.target, nil)
+			if tt.header != "" {
+				req.Header.Set(tt.header, tt.value)
+			}
+			responseRecorder := httptest.NewRecorder()
+			handler.ServeHTTP(responseRecorder, req)
+			if responseRecorder.Code != tt.wantStatus {
+				t.Fatalf("Expected status code %d, got %d", tt.wantStatus, responseRecorder.Code)
+			}
+			if tt.wantStatus == http.StatusUnauthorized && responseRecorder.Header().Get(headerWWWAuthenticate) == "" {
+				t.Error("Expected a WWW-Authenticate header")
+			}
+			if tt.wantStatus == http.StatusOK && gotPrincipal == "" {
+				t.Error("Expected the principal in the context")
+			}
+		})
+	}
+}
@@ -0,0 +1,259 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"strings"
+	"time"
+)
+
+// clockSkew is tolerated when checking the exp and nbf claims
+const clockSkew = 30 * time.Second
+
+var (
+	errMalformedJWT     = errors.New("malformed jwt")
+	errUnsupportedAlg   = errors.New("unsupported jwt algorithm")
+	errUnknownKeyID     = errors.New("unknown jwt key id")
+	errInvalidSignature = errors.New("invalid jwt signature")
+	errExpiredJWT       = errors.New("jwt is expired")
+	errMissingExpiry    = errors.New("jwt has no expiration")
+	errNotYetValidJWT   = errors.New("jwt is not valid yet")
+	errInvalidIssuer    = errors.New("invalid jwt issuer")
+	errInvalidAudience  = errors.New("invalid jwt audience")
+	errMissingSubject   = errors.New("jwt has no subject")
+)
+
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+type jwtHeader struct {
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+}
+
+type jwtClaims struct {
+	Subject   string   `json:"sub"`
+	Issuer    string   `json:"iss"`
+	Audience  audience `json:"aud"`
+	ExpiresAt *int64   `json:"exp"`
+	NotBefore *int64   `json:"nbf"`
+}
+
+// audience is the aud claim, which is either a string or an array of strings
+type audience []string
+
+func (a *audience) UnmarshalJSON(data []byte) error {
+	var single string
+	if err := json.Unmarshal(data, &single); err == nil {
+		*a = audience{single}
+		return nil
+	}
+	var many []string
+	if err := json.Unmarshal(data, &many); err != nil {
+		return err
+	}
+	*a = many
+	return nil
+}
+
+// jwtVerifier verifies RS256/384/512 and ES256/384/512 signed JWTs against the keys of a JWKS file
+type jwtVerifier struct {
+	keys     map[string]crypto.PublicKey
+	issuer   string
+	audience string
+	now      func() time.Time
+}
+
+func newJWTVerifier(jwksFile string, issuer string, audience string) (*jwtVerifier, error) {
+	content, err := os.ReadFile(jwksFile)
+	if err != nil {
+		return nil, fmt.Errorf("error reading jwks file %w", err)
+	}
+	keys, err := parseJWKS(content)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing jwks file %w", err)
+	}
+	return &jwtVerifier{keys: keys, issuer: issuer, audience: audience, now: time.Now}, nil
+}
+
+func parseJWKS(content []byte) (map[string]crypto.PublicKey, error) {
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := json.Unmarshal(content, &set); err != nil {
+		return nil, err
+	}
+	keys := make(map[string]crypto.PublicKey, len(set.Keys))
+	for _, key := range set.Keys {
+		publicKey, err := key.publicKey()
+		if err != nil {
+			return nil, fmt.Errorf("key %q: %w", key.Kid, err)
+		}
+		keys[key.Kid] = publicKey
+	}
+	return keys, nil
+}
+
+func (k jwk) publicKey() (crypto.PublicKey, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := decodeBigInt(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeBigInt(k.E)
+		if err != nil {
+			return nil, err
+		}
+		if !e.IsInt64() || e.Int64() > 1<<31-1 {
+			return nil, errors.New("rsa exponent is too large")
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+		x, err := decodeBigInt(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeBigInt(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		if !curve.IsOnCurve(x, y) {
+			return nil, errors.New("ec point is not on the curve")
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	default:
+		return nil, fmt.Errorf("unsupported key type %q", k.Kty)
+	}
+}
+
+func decodeBigInt(s string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	return new(big.Int).SetBytes(b), nil
+}
+
+// verify checks the signature and the claims of the token and returns its subject
+func (v *jwtVerifier) verify(token string) (string, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return "", errMalformedJWT
+	}
+	var header jwtHeader
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return "", err
+	}
+	hash, ok := map[string]crypto.Hash{
+		"RS256": crypto.SHA256, "RS384": crypto.SHA384, "RS512": crypto.SHA512,
+		"ES256": crypto.SHA256, "ES384": crypto.SHA384, "ES512": crypto.SHA512,
+	}[header.Alg]
+	if !ok {
+		return "", fmt.Errorf("%w %q", errUnsupportedAlg, header.Alg)
+	}
+	key, ok := v.keys[header.Kid]
+	if !ok {
+		return "", fmt.Errorf("%w %q", errUnknownKeyID, header.Kid)
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return "", errMalformedJWT
+	}
+	hasher := hash.New()
+	hasher.Write([]byte(parts[0] + "." + parts[1]))
+	digest := hasher.Sum(nil)
+	if !verifySignature(key, header.Alg, hash, digest, signature) {
+		return "", errInvalidSignature
+	}
+
+	var claims jwtClaims
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return "", err
+	}
+	now := v.now()
+	// a token without expiration would be valid forever once leaked
+	if claims.ExpiresAt == nil {
+		return "", errMissingExpiry
+	}
+	if now.After(time.Unix(*claims.ExpiresAt, 0).Add(clockSkew)) {
+		return "", errExpiredJWT
+	}
+	if claims.NotBefore != nil && now.Add(clockSkew).Before(time.Unix(*claims.NotBefore, 0)) {
+		return "", errNotYetValidJWT
+	}
+	if v.issuer != "" && claims.Issuer != v.issuer {
+		return "", errInvalidIssuer
+	}
+	if v.audience != "" && !claims.Audience.contains(v.audience) {
+		return "", errInvalidAudience
+	}
+	if claims.Subject == "" {
+		return "", errMissingSubject
+	}
+	return claims.Subject, nil
+}
+
+func (a audience) contains(value string) bool {
+	for _, aud := range a {
+		if aud == value {
+			return true
+		}
+	}
+	return false
+}
+
+func verifySignature(key crypto.PublicKey, alg string, hash crypto.Hash, digest []byte, signature []byte) bool {
+	switch key := key.(type) {
+	case *rsa.PublicKey:
+		return strings.HasPrefix(alg, "RS") && rsa.VerifyPKCS1v15(key, hash, digest, signature) == nil
+	case *ecdsa.PublicKey:
+		// JWS encodes ECDSA signatures as the fixed size concatenation of r and s
+		size := (key.Curve.Params().BitSize + 7) / 8
+		if !strings.HasPrefix(alg, "ES") || len(signature) != 2*size {
+			return false
+		}
+		r := new(big.Int).SetBytes(signature[:size])
+		s := new(big.Int).SetBytes(signature[size:])
+		return ecdsa.Verify(key, digest, r, s)
+	default:
+		return false
+	}
+}
+
+func decodeSegment(segment string, v any) error {
+	content, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return errMalformedJWT
+	}
+	if err := json.Unmarshal(content, v); err != nil {
+		return errMalformedJWT
+	}
+	return nil
+}
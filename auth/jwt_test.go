@@ -0,0 +1,151 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"testing"
+	"time"
+)
+
+func encodeSegment(t *testing.T, v any) string {
+	t.Helper()
+	content, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return base64.RawURLEncoding.EncodeToString(content)
+}
+
+func signRS256(t *testing.T, key *rsa.PrivateKey, kid string, claims map[string]any) string {
+	t.Helper()
+	signingInput := encodeSegment(t, map[string]string{"alg": "RS256", "kid": kid}) + "." + encodeSegment(t, claims)
+	digest := sha256.Sum256([]byte(signingInput))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+func signES256(t *testing.T, key *ecdsa.PrivateKey, kid string, claims map[string]any) string {
+	t.Helper()
+	signingInput := encodeSegment(t, map[string]string{"alg": "ES256", "kid": kid}) + "." + encodeSegment(t, claims)
+	digest := sha256.Sum256([]byte(signingInput))
+	r, s, err := ecdsa.Sign(rand.Reader, key, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	signature := make([]byte, 64)
+	r.FillBytes(signature[:32])
+	s.FillBytes(signature[32:])
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+func TestJWTVerifier(t *testing.T) {
+	t.Parallel()
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	jwks, err := json.Marshal(map[string]any{"keys": []map[string]string{
+		{
+			"kty": "RSA", "kid": "rsa",
+			"n": base64.RawURLEncoding.EncodeToString(rsaKey.N.Bytes()),
+			"e": base64.RawURLEncoding.EncodeToString(big.NewInt(int64(rsaKey.E)).Bytes()),
+		},
+		{
+			"kty": "EC", "kid": "ec", "crv": "P-256",
+			"x": base64.RawURLEncoding.EncodeToString(ecKey.X.Bytes()),
+			"y": base64.RawURLEncoding.EncodeToString(ecKey.Y.Bytes()),
+		},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys, err := parseJWKS(jwks)
+	if err != nil {
+		t.Fatalf("Unexpected error parsing the jwks %v", err)
+	}
+	now := time.Unix(1_700_000_000, 0)
+	verifier := &jwtVerifier{keys: keys, issuer: "issuer", audience: "cache", now: func() time.Time { return now }}
+	valid := func() map[string]any {
+		return map[string]any{"sub": "alice", "iss": "issuer", "aud": "cache", "exp": now.Add(time.Hour).Unix()}
+	}
+
+	tests := []struct {
+		name    string
+		token   func(t *testing.T) string
+		wantErr error
+	}{
+		{name: "rs256", token: func(t *testing.T) string { return signRS256(t, rsaKey, "rsa", valid()) }},
+		{name: "es256", token: func(t *testing.T) string { return signES256(t, ecKey, "ec", valid()) }},
+		{name: "audience array", token: func(t *testing.T) string {
+			claims := valid()
+			claims["aud"] = []string{"other", "cache"}
+			return signRS256(t, rsaKey, "rsa", claims)
+		}},
+		{name: "wrong key", token: func(t *testing.T) string { return signRS256(t, otherKey, "rsa", valid()) }, wantErr: errInvalidSignature},
+		{name: "unknown kid", token: func(t *testing.T) string { return signRS256(t, rsaKey, "nope", valid()) }, wantErr: errUnknownKeyID},
+		{name: "expired", token: func(t *testing.T) string {
+			claims := valid()
+			claims["exp"] = now.Add(-time.Hour).Unix()
+			return signRS256(t, rsaKey, "rsa", claims)
+		}, wantErr: errExpiredJWT},
+		{name: "no expiration", token: func(t *testing.T) string {
+			claims := valid()
+			delete(claims, "exp")
+			return signRS256(t, rsaKey, "rsa", claims)
+		}, wantErr: errMissingExpiry},
+		{name: "not yet valid", token: func(t *testing.T) string {
+			claims := valid()
+			claims["nbf"] = now.Add(time.Hour).Unix()
+			return signRS256(t, rsaKey, "rsa", claims)
+		}, wantErr: errNotYetValidJWT},
+		{name: "wrong issuer", token: func(t *testing.T) string {
+			claims := valid()
+			claims["iss"] = "other"
+			return signRS256(t, rsaKey, "rsa", claims)
+		}, wantErr: errInvalidIssuer},
+		{name: "wrong audience", token: func(t *testing.T) string {
+			claims := valid()
+			claims["aud"] = "other"
+			return signRS256(t, rsaKey, "rsa", claims)
+		}, wantErr: errInvalidAudience},
+		{name: "unsigned", token: func(t *testing.T) string {
+			return encodeSegment(t, map[string]string{"alg": "none"}) + "." + encodeSegment(t, valid()) + "."
+		}, wantErr: errUnsupportedAlg},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			subject, err := verifier.verify(tt.token(t))
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Errorf("Expected error %v, got %v", tt.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unexpected error %v", err)
+			}
+			if subject != "alice" {
+				t.Errorf("Expected subject alice, got %s", subject)
+			}
+		})
+	}
+}
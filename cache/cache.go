@@ -3,12 +3,40 @@ package cache
 import (
 	"cache-api/config"
 	"cache-api/server"
+	"cmp"
+	"container/list"
 	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
+
+	"github.com/rs/zerolog"
+)
+
+var (
+	_ server.Cache          = &Cache[string]{}
+	_ server.VersionedCache = &Cache[string]{}
+	_ server.Counter        = &Cache[string]{}
+	_ server.KeyScanner     = &Cache[string]{}
+	_ server.KeyDeleter     = &Cache[string]{}
+	_ server.TagCache       = &Cache[string]{}
+	_ server.ExpiringCache  = &Cache[string]{}
+	_ server.StatsProvider  = &Cache[string]{}
+	_ server.HealthChecker  = &Cache[string]{}
+	_ server.KeyInspector   = &Cache[string]{}
+	_ server.Flusher        = &Cache[string]{}
+	_ server.ExpiredDeleter = &Cache[string]{}
+	_ server.EvictionRunner = &Cache[string]{}
 )
 
-var _ server.Cache = &Cache[string]{}
+var errEvictionStopped = errors.New("eviction is not running")
 
 type Cache[T any] struct {
 	ctx context.Context
@@ -21,110 +49,832 @@ type Cache[T any] struct {
 	// StopEviction is a channel to stop the eviction process
 	stopEviction chan bool
 	// isEvictionRunning is a flag to indicate whether the eviction process is running
-	isEvictionRunning bool
+	isEvictionRunning atomic.Bool
+	// isEvictionPaused is set while the eviction process is stopped by StopEviction, as opposed to having died
+	isEvictionPaused atomic.Bool
 	// evictionInterval is the interval at which the cache is checked for expired items
 	evictionInterval time.Duration
+	// lastVersion is the last version handed out to a write, shared by all keys so a re-created key never
+	// reuses a version of a previous incarnation
+	lastVersion uint64
+	// keys holds the keys of the items in lexical order, for ScanKeys
+	keys keyIndex
+	// tags is the reverse index of the tags of the items, mapping each tag to the set of keys carrying it
+	tags map[string]map[string]struct{}
+	// maxSize is the maximum number of items in the cache, 0 means unlimited
+	maxSize int
+	// writeOrder holds the keys from the least to the most recently written, to pick the item to evict when the
+	// cache is full. It is only maintained if maxSize is set.
+	writeOrder *list.List
+	// evictionMutex serializes stopping and starting the eviction process
+	evictionMutex sync.Mutex
+	// bytes is the size of the keys and values of the items
+	bytes int64
+	// hits and misses count the reads of keys that were found and not found
+	hits   atomic.Uint64
+	misses atomic.Uint64
+	// evictions counts the items removed because they expired or the cache was full
+	evictions atomic.Uint64
+	// logger is set by SetLogger, the cache does not log without it
+	logger atomic.Pointer[zerolog.Logger]
+	// followers receive the changes of the items, see Primary. It is guarded by mutex.
+	followers map[*replicaStream[T]]struct{}
+	// syncing is set while a Follower waits for the first snapshot of the cache
+	syncing atomic.Bool
+	// invalidations receives the writes the other instances evict their copies for, see Invalidator. It is guarded
+	// by mutex.
+	invalidations func(Invalidation)
+	// watchers receive the events of the keys they watch, see Watch. It is guarded by mutex.
+	watchers map[*watcher]struct{}
+	// onEvict and onSet are the callbacks of OnEvict and OnSet, and pending holds the calls to them queued until the
+	// write lock is released. They are guarded by mutex.
+	onEvict []func(key string, value T, reason EvictReason)
+	onSet   []func(key string, value T)
+	pending []func()
+	// loader fills the keys missing from the cache and loads holds the loads in progress by key, see SetLoader. They
+	// are guarded by mutex.
+	loader Loader[T]
+	loads  map[string]*load[T]
+	// through is the store the writes go to before they return, see SetStore, and behind the one they go to in the
+	// background, see NewWriteBehind
+	through atomic.Pointer[writeThrough[T]]
+	behind  atomic.Pointer[WriteBehind[T]]
 }
 
 type cacheItem[T any] struct {
 	value     T
 	expiresAt int64
+	version   uint64
+	tags      []string
+	// element is the entry of the key in writeOrder
+	element *list.Element
+	// createdAt is when the key was first written, kept when its value is replaced
+	createdAt int64
 }
 
 const (
 	defaultEvictionInterval = time.Second
 	defaultTTL              = 30 * time.Minute
+	// deleteChunkSize is the number of keys DeleteKeys removes per write lock, so readers get the lock in between
+	deleteChunkSize = 1000
+	// scanChunkSize is the number of keys ScanKeys walks per read lock, so writers get the lock in between
+	scanChunkSize = 1000
 )
 
 // NewCache creates a new cache with the given time to live
 func NewCache[T any](ctx context.Context, conf config.CacheConfig) *Cache[T] {
 	stopChan := make(chan bool)
-	evictionInterval := defaultEvictionInterval
-	if conf.EvictionIntervalMilliSec != 0 {
-		evictionInterval = time.Duration(conf.EvictionIntervalMilliSec) * time.Millisecond
-	}
-
-	ttl := defaultTTL
-	if conf.TTLSec != 0 {
-		ttl = time.Duration(conf.TTLSec) * time.Second
-	}
 	c := &Cache[T]{
 		ctx:              ctx,
 		items:            make(map[string]cacheItem[T]),
-		ttl:              ttl,
+		ttl:              ttlOf(conf),
 		mutex:            &sync.RWMutex{},
 		stopEviction:     stopChan,
-		evictionInterval: evictionInterval,
+		evictionInterval: evictionIntervalOf(conf),
+		maxSize:          conf.MaxSize,
+	}
+	if c.maxSize > 0 {
+		c.writeOrder = list.New()
 	}
 	c.startEviction()
 	return c
 }
 
+// SetLogger makes the cache log its background work to logger
+func (c *Cache[T]) SetLogger(logger *zerolog.Logger) {
+	c.logger.Store(logger)
+}
+
+// log returns the logger of the cache, which discards the events if none is set
+func (c *Cache[T]) log() *zerolog.Logger {
+	if logger := c.logger.Load(); logger != nil {
+		return logger
+	}
+	nop := zerolog.Nop()
+	return &nop
+}
+
+func ttlOf(conf config.CacheConfig) time.Duration {
+	if conf.TTLSec != 0 {
+		return time.Duration(conf.TTLSec) * time.Second
+	}
+	return defaultTTL
+}
+
+func evictionIntervalOf(conf config.CacheConfig) time.Duration {
+	if conf.EvictionIntervalMilliSec != 0 {
+		return time.Duration(conf.EvictionIntervalMilliSec) * time.Millisecond
+	}
+	return defaultEvictionInterval
+}
+
+// Reconfigure applies the TTL, the maximum size and the eviction interval of conf to the running cache. The TTL
+// applies to the items written from now on, and lowering the maximum size evicts the least recently written items.
+func (c *Cache[T]) Reconfigure(conf config.CacheConfig) {
+	c.evictionMutex.Lock()
+	defer c.evictionMutex.Unlock()
+	evictionInterval := evictionIntervalOf(conf)
+	c.mutex.Lock()
+	c.ttl = ttlOf(conf)
+	c.resize(conf.MaxSize)
+	intervalChanged := c.evictionInterval != evictionInterval
+	c.evictionInterval = evictionInterval
+	c.unlock()
+	if intervalChanged && c.isEvictionRunning.Load() {
+		c.stopEvictionLocked()
+		c.startEviction()
+	}
+	c.log().Debug().Int("ttl_seconds", conf.TTLSec).Int("max_size", conf.MaxSize).
+		Dur("eviction_interval", evictionInterval).Msg("Reconfigured cache")
+}
+
+// resize changes the maximum number of items, starting or dropping the write order as needed. When the write order
+// starts, the items are ordered by their expiry as the time they were written is not known. The caller must hold the
+// write lock.
+func (c *Cache[T]) resize(maxSize int) {
+	if maxSize <= 0 {
+		c.maxSize = 0
+		if c.writeOrder != nil {
+			c.writeOrder = nil
+			for key, item := range c.items {
+				item.element = nil
+				c.items[key] = item
+			}
+		}
+		return
+	}
+	c.maxSize = maxSize
+	if c.writeOrder == nil {
+		c.writeOrder = list.New()
+		keys := make([]string, 0, len(c.items))
+		for key := range c.items {
+			keys = append(keys, key)
+		}
+		slices.SortFunc(keys, func(a, b string) int { return cmp.Compare(c.items[a].expiresAt, c.items[b].expiresAt) })
+		for _, key := range keys {
+			item := c.items[key]
+			item.element = c.writeOrder.PushBack(key)
+			c.items[key] = item
+		}
+	}
+	for len(c.items) > c.maxSize {
+		c.evictOldest()
+	}
+}
+
 // Set adds a new key-value pair to the cache
 func (c *Cache[T]) Set(key string, value T) error {
-	c.mutex.Lock()
-	defer c.mutex.Unlock()
-	c.items[key] = cacheItem[T]{
+	return c.SetWithTags(key, value, nil)
+}
+
+// SetWithTags adds a new key-value pair to the cache and attaches the given tags to it, replacing the tags of the
+// previous value
+func (c *Cache[T]) SetWithTags(key string, value T, tags []string) error {
+	return c.SetWithTTL(key, value, tags, 0)
+}
+
+// SetWithTTL is SetWithTags keeping the value for ttl rather than the TTL of the cache, unless ttl is 0
+func (c *Cache[T]) SetWithTTL(key string, value T, tags []string, ttl time.Duration) error {
+	return c.write(key, func() (StoreWrite[T], bool) {
+		c.set(key, value, tags, ttl)
+		return StoreWrite[T]{Key: key, Value: value}, true
+	})
+}
+
+// set stores the value with a new version for ttl, or the TTL of the cache if it is 0. The caller must hold the write
+// lock.
+func (c *Cache[T]) set(key string, value T, tags []string, ttl time.Duration) uint64 {
+	if ttl <= 0 {
+		ttl = c.ttl
+	}
+	c.publish(Invalidation{Key: key})
+	c.lastVersion++
+	c.put(key, cacheItem[T]{
 		value:     value,
-		expiresAt: time.Now().Add(c.ttl).UnixNano(),
+		expiresAt: time.Now().Add(ttl).UnixNano(),
+		version:   c.lastVersion,
+		tags:      tags,
+	})
+	return c.lastVersion
+}
+
+// put stores the item and keeps the tag index and the write order up to date. If the cache is full, the least
+// recently written item is evicted to make room. The caller must hold the write lock.
+func (c *Cache[T]) put(key string, item cacheItem[T]) {
+	now := time.Now().UnixNano()
+	previous, exists := c.items[key]
+	if exists {
+		c.unindexTags(key, previous.tags)
+		c.bytes -= int64(len(key) + sizeOf(previous.value))
+	}
+	if item.createdAt == 0 {
+		item.createdAt = now
+		if exists && now <= previous.expiresAt {
+			item.createdAt = previous.createdAt
+		}
+	}
+	c.bytes += int64(len(key) + sizeOf(item.value))
+	if !exists {
+		c.keys.insert(key)
+	}
+	if c.writeOrder != nil {
+		if exists {
+			item.element = previous.element
+			c.writeOrder.MoveToBack(item.element)
+		} else {
+			if len(c.items) >= c.maxSize {
+				c.evictOldest()
+			}
+			item.element = c.writeOrder.PushBack(key)
+		}
+	}
+	c.items[key] = item
+	c.staleLoads(key)
+	c.emit(opSet, key, item)
+	c.notifySet(key, item)
+	if len(c.onEvict) > 0 || len(c.onSet) > 0 {
+		var replaced *cacheItem[T]
+		if exists {
+			replaced = &previous
+		}
+		c.stored(key, item, replaced)
+	}
+	if len(item.tags) == 0 {
+		return
+	}
+	if c.tags == nil {
+		c.tags = make(map[string]map[string]struct{})
+	}
+	for _, tag := range item.tags {
+		keys, ok := c.tags[tag]
+		if !ok {
+			keys = make(map[string]struct{})
+			c.tags[tag] = keys
+		}
+		keys[key] = struct{}{}
+	}
+}
+
+// remove deletes the item and drops it from the tag index and the write order, notifying the watchers with the event
+// type saying why. The caller must hold the write lock.
+func (c *Cache[T]) remove(key string, event string) {
+	if item, ok := c.items[key]; ok {
+		c.unindexTags(key, item.tags)
+		if item.element != nil {
+			c.writeOrder.Remove(item.element)
+		}
+		c.bytes -= int64(len(key) + sizeOf(item.value))
+		delete(c.items, key)
+		c.keys.delete(key)
+		c.staleLoads(key)
+		c.emit(opDelete, key, item)
+		c.notify(server.Event{Type: event, Key: key})
+		c.evicted(key, item.value, evictReasons[event])
+	}
+}
+
+// evictOldest removes the least recently written item, the caller must hold the write lock
+func (c *Cache[T]) evictOldest() {
+	if oldest := c.writeOrder.Front(); oldest != nil {
+		c.remove(oldest.Value.(string), server.EventEvict)
+		c.evictions.Add(1)
+	}
+}
+
+func (c *Cache[T]) unindexTags(key string, tags []string) {
+	for _, tag := range tags {
+		keys := c.tags[tag]
+		delete(keys, key)
+		if len(keys) == 0 {
+			delete(c.tags, tag)
+		}
 	}
-	return nil
 }
 
 // Get returns the value for the given key and a boolean indicating whether the key was found
 func (c *Cache[T]) Get(key string) (T, bool) {
+	value, _, ok := c.GetWithVersion(key)
+	return value, ok
+}
+
+// GetWithVersion returns the value for the given key along with its version and a boolean indicating whether the key
+// was found. A key that is not found has version 0. A miss is loaded if the cache has a loader, see SetLoader.
+func (c *Cache[T]) GetWithVersion(key string) (T, uint64, bool) {
+	c.mutex.RLock()
+	item, ok := c.lookup(key)
+	loader := c.loader
+	c.mutex.RUnlock()
+	c.countRead(ok)
+	if !ok && loader != nil {
+		item, ok = c.load(loader, key)
+	}
+	if !ok {
+		var zero T
+		return zero, 0, false
+	}
+	return item.value, item.version, true
+}
+
+func (c *Cache[T]) countRead(hit bool) {
+	if hit {
+		c.hits.Add(1)
+	} else {
+		c.misses.Add(1)
+	}
+}
+
+// CompareAndSwap stores newValue only if the current version of the key equals expectedVersion, and returns the new
+// version. An expectedVersion of 0 means the key must not exist. swapped is false if the key was changed in between.
+func (c *Cache[T]) CompareAndSwap(key string, expectedVersion uint64, newValue T) (uint64, bool, error) {
+	return c.CompareAndSwapWithTags(key, expectedVersion, newValue, nil)
+}
+
+// CompareAndSwapWithTags is CompareAndSwap attaching the given tags to the new value
+func (c *Cache[T]) CompareAndSwapWithTags(key string, expectedVersion uint64, newValue T, tags []string) (uint64, bool, error) {
+	return c.CompareAndSwapWithTTL(key, expectedVersion, newValue, tags, 0)
+}
+
+// CompareAndSwapWithTTL is CompareAndSwapWithTags keeping the new value for ttl rather than the TTL of the cache,
+// unless ttl is 0
+func (c *Cache[T]) CompareAndSwapWithTTL(key string, expectedVersion uint64, newValue T, tags []string,
+	ttl time.Duration) (uint64, bool, error) {
+	c.ensureLoaded(key)
+	var version uint64
+	swapped := false
+	err := c.write(key, func() (StoreWrite[T], bool) {
+		if item, ok := c.lookup(key); ok {
+			version = item.version
+		}
+		if version != expectedVersion {
+			return StoreWrite[T]{}, false
+		}
+		version, swapped = c.set(key, newValue, tags, ttl), true
+		return StoreWrite[T]{Key: key, Value: newValue}, true
+	})
+	if err != nil {
+		return 0, false, err
+	}
+	return version, swapped, nil
+}
+
+// InvalidateTag removes every item carrying the tag and returns how many were removed
+func (c *Cache[T]) InvalidateTag(tag string) (int, error) {
+	c.mutex.Lock()
+	defer c.unlock()
+	c.publish(Invalidation{Tag: tag})
+	return c.removeTag(tag), nil
+}
+
+// removeTag removes every item carrying the tag and returns how many were not expired, the caller must hold the
+// write lock
+func (c *Cache[T]) removeTag(tag string) int {
+	now := time.Now().UnixNano()
+	removed := 0
+	for key := range c.tags[tag] {
+		if now <= c.items[key].expiresAt {
+			removed++
+		}
+		c.remove(key, server.EventDelete)
+	}
+	return removed
+}
+
+// Increment adds delta to the integer stored at key and returns the new value. It works for caches of strings, which
+// hold the value in decimal form, and of int and int64. A missing key starts from opts.Initial.
+func (c *Cache[T]) Increment(key string, delta int64, opts server.CounterOptions) (int64, error) {
+	c.ensureLoaded(key)
+	var next int64
+	var err error
+	writeErr := c.write(key, func() (StoreWrite[T], bool) {
+		var value T
+		next, value, err = c.increment(key, delta, opts)
+		return StoreWrite[T]{Key: key, Value: value}, err == nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	if writeErr != nil {
+		return 0, writeErr
+	}
+	return next, nil
+}
+
+// increment is Increment returning the value stored as well, the caller must hold the write lock
+func (c *Cache[T]) increment(key string, delta int64, opts server.CounterOptions) (int64, T, error) {
+	var zero T
+	current := opts.Initial
+	item, exists := c.lookup(key)
+	if exists {
+		var err error
+		current, err = toInt64(item.value)
+		if err != nil {
+			return 0, zero, err
+		}
+	}
+	var next int64
+	switch {
+	case delta > 0 && current > math.MaxInt64-delta:
+		// the bound asked for in the direction of the delta holds the result
+		if opts.Max == nil {
+			return 0, zero, server.ErrOverflow
+		}
+		next = *opts.Max
+	case delta < 0 && current < math.MinInt64-delta:
+		if opts.Min == nil {
+			return 0, zero, server.ErrOverflow
+		}
+		next = *opts.Min
+	default:
+		next = opts.Clamp(current + delta)
+	}
+	value, err := fromInt64[T](next)
+	if err != nil {
+		return 0, zero, err
+	}
+	c.publish(Invalidation{Key: key})
+	c.lastVersion++
+	if exists {
+		item.value = value
+		item.version = c.lastVersion
+		c.put(key, item)
+		return next, value, nil
+	}
+	ttl := c.ttl
+	if opts.TTL > 0 {
+		ttl = opts.TTL
+	}
+	c.put(key, cacheItem[T]{
+		value:     value,
+		expiresAt: time.Now().Add(ttl).UnixNano(),
+		version:   c.lastVersion,
+	})
+	return next, value, nil
+}
+
+// Decrement subtracts delta from the integer stored at key and returns the new value
+func (c *Cache[T]) Decrement(key string, delta int64, opts server.CounterOptions) (int64, error) {
+	if delta == math.MinInt64 {
+		return 0, server.ErrOverflow
+	}
+	return c.Increment(key, -delta, opts)
+}
+
+// ScanKeys returns a page of keys in lexical order. The cursor is the last key of the previous page, so keys written
+// between pages are not missed if they sort after the cursor. The page is read from the ordered key index starting at
+// the cursor, releasing the read lock every scanChunkSize keys so a sparse pattern does not block the writers.
+func (c *Cache[T]) ScanKeys(opts server.ScanOptions) ([]server.KeyInfo, string, error) {
+	after, err := decodeCursor(opts.Cursor)
+	if err != nil {
+		return nil, "", err
+	}
+	limit := opts.Limit
+	if limit <= 0 {
+		limit = server.DefaultScanLimit
+	}
+
+	now := time.Now().UnixNano()
+	keys := make([]server.KeyInfo, 0, limit)
+	next := ""
+	// from is the first key the next chunk may return, the keys before the prefix never match
+	from := max(opts.Prefix, after)
+	skip := opts.Cursor != ""
+	for done := false; !done; {
+		c.mutex.RLock()
+		node := c.keys.seek(from)
+		for walked := 0; ; walked++ {
+			if node == nil || !strings.HasPrefix(node.key, opts.Prefix) {
+				done = true
+				break
+			}
+			if walked == scanChunkSize {
+				from, skip = node.key, false
+				break
+			}
+			key := node.key
+			node = node.next[0]
+			if skip && key == after {
+				continue
+			}
+			item := c.items[key]
+			if now > item.expiresAt || !keyMatches(key, "", opts.Pattern) {
+				continue
+			}
+			if len(keys) == limit {
+				next = encodeCursor(keys[limit-1].Key)
+				done = true
+				break
+			}
+			info := server.KeyInfo{Key: key}
+			if opts.WithTTL {
+				info.TTL = time.Duration(item.expiresAt - now)
+			}
+			keys = append(keys, info)
+		}
+		c.mutex.RUnlock()
+	}
+	return keys, next, nil
+}
+
+// DeleteKeys removes the keys starting with prefix and matching the glob-style pattern. The candidates are collected
+// under the read lock and removed in chunks of deleteChunkSize, releasing the write lock between chunks so a huge
+// invalidation does not starve readers. A key written again after it was collected is kept.
+func (c *Cache[T]) DeleteKeys(prefix string, pattern string) (int, error) {
+	c.mutex.Lock()
+	if prefix == "" && pattern == "" {
+		c.publish(Invalidation{Flush: true})
+	} else {
+		c.publish(Invalidation{Prefix: prefix, Pattern: pattern})
+	}
+	c.unlock()
+	return c.deleteKeys(prefix, pattern), nil
+}
+
+// deleteKeys is DeleteKeys without publishing the invalidation
+func (c *Cache[T]) deleteKeys(prefix string, pattern string) int {
+	type candidate struct {
+		key     string
+		version uint64
+	}
+	now := time.Now().UnixNano()
+	var candidates []candidate
+	c.mutex.RLock()
+	for key, item := range c.items {
+		if now <= item.expiresAt && keyMatches(key, prefix, pattern) {
+			candidates = append(candidates, candidate{key: key, version: item.version})
+		}
+	}
+	c.mutex.RUnlock()
+
+	deleted := 0
+	for start := 0; start < len(candidates); start += deleteChunkSize {
+		chunk := candidates[start:min(start+deleteChunkSize, len(candidates))]
+		c.mutex.Lock()
+		for _, cand := range chunk {
+			if item, ok := c.items[cand.key]; ok && item.version == cand.version {
+				c.remove(cand.key, server.EventDelete)
+				deleted++
+			}
+		}
+		c.unlock()
+	}
+	return deleted
+}
+
+// Stats returns the number of items in the cache, including the expired ones not evicted yet, its settings and its
+// counters. Finding the oldest item walks all the items under the read lock.
+func (c *Cache[T]) Stats() (server.Stats, error) {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	var oldest int64
+	for _, item := range c.items {
+		if oldest == 0 || (item.createdAt != 0 && item.createdAt < oldest) {
+			oldest = item.createdAt
+		}
+	}
+	stats := server.Stats{
+		Keys:             len(c.items),
+		TTL:              c.ttl,
+		MaxSize:          c.maxSize,
+		EvictionInterval: c.evictionInterval,
+		Bytes:            c.bytes,
+		Hits:             c.hits.Load(),
+		Misses:           c.misses.Load(),
+		Evictions:        c.evictions.Load(),
+	}
+	if oldest != 0 {
+		stats.Oldest = time.Unix(0, oldest)
+	}
+	return stats, nil
+}
+
+// Inspect returns the metadata of the item of key if it exists and is not expired
+func (c *Cache[T]) Inspect(key string) (server.KeyMetadata, bool, error) {
 	c.mutex.RLock()
 	defer c.mutex.RUnlock()
+	item, ok := c.lookup(key)
+	if !ok {
+		return server.KeyMetadata{}, false, nil
+	}
+	return server.KeyMetadata{
+		TTL:     time.Until(time.Unix(0, item.expiresAt)),
+		Size:    sizeOf(item.value),
+		Version: item.version,
+		Created: time.Unix(0, item.createdAt),
+		Tags:    item.tags,
+	}, true, nil
+}
+
+// Flush removes every item and returns how many were removed. The versions keep increasing, so a version handed out
+// before the flush is never reused.
+func (c *Cache[T]) Flush() (int, error) {
+	c.mutex.Lock()
+	defer c.unlock()
+	flushed := len(c.items)
+	c.publish(Invalidation{Flush: true})
+	c.reset()
+	return flushed, nil
+}
+
+// reset removes every item, the caller must hold the write lock
+func (c *Cache[T]) reset() {
+	c.staleLoads("")
+	if len(c.onEvict) > 0 {
+		for key, item := range c.items {
+			c.evicted(key, item.value, EvictDeleted)
+		}
+	}
+	c.items = make(map[string]cacheItem[T])
+	c.keys.reset()
+	c.tags = nil
+	c.bytes = 0
+	if c.writeOrder != nil {
+		c.writeOrder.Init()
+	}
+	c.emit(opFlush, "", cacheItem[T]{})
+	c.notify(server.Event{Type: server.EventFlush})
+}
+
+// lookup returns the item for the given key if it exists and is not expired, the caller must hold the lock
+func (c *Cache[T]) lookup(key string) (cacheItem[T], bool) {
 	item, ok := c.items[key]
-	if time.Now().UnixNano() > item.expiresAt {
-		return item.value, false
+	if !ok || time.Now().UnixNano() > item.expiresAt {
+		return item, false
 	}
-	return item.value, ok
+	return item, true
 }
 
-// Delete removes the key-value pair from the cache
+// Delete removes the key-value pair from the cache, and from its store if it has one. A failure of a write-through
+// store is logged.
 func (c *Cache[T]) Delete(key string) {
-	c.mutex.Lock()
-	defer c.mutex.Unlock()
-	delete(c.items, key)
+	err := c.write(key, func() (StoreWrite[T], bool) {
+		c.publish(Invalidation{Key: key})
+		c.remove(key, server.EventDelete)
+		return StoreWrite[T]{Key: key, Delete: true}, true
+	})
+	if err != nil {
+		c.log().Error().Err(err).Str("key", key).Msg("Failed to delete key")
+	}
 }
 
-// DeleteExpired removes all expired items from the cache
-func (c *Cache[T]) DeleteExpired() {
+// DeleteExpired removes all expired items from the cache and returns how many were removed
+func (c *Cache[T]) DeleteExpired() int {
 	now := time.Now().UnixNano()
 	c.mutex.Lock()
-	defer c.mutex.Unlock()
+	defer c.unlock()
+	removed := 0
 	for key, item := range c.items {
 		if now > item.expiresAt {
-			delete(c.items, key)
+			c.remove(key, server.EventExpire)
+			removed++
 		}
 	}
+	c.evictions.Add(uint64(removed))
+	return removed
 }
 
+// Check reports the cache as unusable once its eviction process died, as expired items would pile up, and while a
+// follower has not received its first snapshot. An eviction stopped on purpose by StopEviction does not fail the
+// check.
+func (c *Cache[T]) Check(context.Context) error {
+	if !c.isEvictionRunning.Load() && !c.isEvictionPaused.Load() {
+		return errEvictionStopped
+	}
+	if c.syncing.Load() {
+		return errNotSynced
+	}
+	return nil
+}
+
+// StopEviction stops the background removal of expired items, if it is running
 func (c *Cache[T]) StopEviction() {
-	c.stopEviction <- true
+	c.evictionMutex.Lock()
+	defer c.evictionMutex.Unlock()
+	c.stopEvictionLocked()
+	c.isEvictionPaused.Store(c.ctx.Err() == nil && !c.isEvictionRunning.Load())
+}
+
+// stopEvictionLocked stops the eviction process, the caller must hold the eviction mutex
+func (c *Cache[T]) stopEvictionLocked() {
+	if !c.isEvictionRunning.Load() {
+		return
+	}
+	select {
+	case c.stopEviction <- true:
+		c.isEvictionRunning.Store(false)
+	case <-c.ctx.Done():
+	}
+}
+
+// StartEviction restarts the background removal of expired items, if it is not running
+func (c *Cache[T]) StartEviction() {
+	c.evictionMutex.Lock()
+	defer c.evictionMutex.Unlock()
+	c.startEviction()
+	c.isEvictionPaused.Store(c.ctx.Err() == nil && !c.isEvictionRunning.Load())
+}
+
+// IsEvictionRunning reports whether the background removal of expired items is running
+func (c *Cache[T]) IsEvictionRunning() bool {
+	return c.isEvictionRunning.Load()
 }
 
 func (c *Cache[T]) startEviction() {
-	if c.isEvictionRunning {
+	if c.ctx.Err() != nil || !c.isEvictionRunning.CompareAndSwap(false, true) {
 		return
 	}
-	c.isEvictionRunning = true
+	ticker := time.NewTicker(c.evictionInterval)
 	go func() {
-		ticker := time.NewTicker(c.evictionInterval)
+		// a panicking eviction callback stops the eviction rather than the process, and Check reports it
+		defer func() {
+			if r := recover(); r != nil {
+				ticker.Stop()
+				c.isEvictionRunning.Store(false)
+				c.log().Error().Interface("panic", r).Msg("Eviction stopped by a panic")
+			}
+		}()
 		for {
 			select {
 			case <-ticker.C:
-				c.DeleteExpired()
+				if deleted := c.DeleteExpired(); deleted > 0 {
+					c.log().Debug().Int("deleted", deleted).Msg("Deleted expired keys")
+				}
 			case <-c.stopEviction:
 				ticker.Stop()
-				c.isEvictionRunning = false
 				return
 			case <-c.ctx.Done():
 				ticker.Stop()
-				c.isEvictionRunning = false
+				c.isEvictionRunning.Store(false)
 				return
 			}
 		}
 	}()
 }
+
+// sizeOf returns the size of a value in bytes, exact for strings and byte slices and approximated by the length of
+// its default format for other types
+func sizeOf[T any](value T) int {
+	switch v := any(value).(type) {
+	case string:
+		return len(v)
+	case []byte:
+		return len(v)
+	default:
+		return len(fmt.Sprint(v))
+	}
+}
+
+// toInt64 converts a stored value to an integer for the counter operations
+func toInt64[T any](value T) (int64, error) {
+	switch v := any(value).(type) {
+	case string:
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return 0, server.ErrNotInteger
+		}
+		return n, nil
+	case int:
+		return int64(v), nil
+	case int64:
+		return v, nil
+	}
+	return 0, server.ErrNotInteger
+}
+
+// fromInt64 converts the result of a counter operation back to the type stored in the cache
+func fromInt64[T any](n int64) (T, error) {
+	var value T
+	switch any(value).(type) {
+	case string:
+		return any(strconv.FormatInt(n, 10)).(T), nil
+	case int:
+		if n < math.MinInt || n > math.MaxInt {
+			return value, server.ErrOverflow
+		}
+		return any(int(n)).(T), nil
+	case int64:
+		return any(n).(T), nil
+	}
+	return value, server.ErrNotInteger
+}
+
+// CursorAfter returns the cursor resuming ScanKeys after key, which lets the pages of several caches be merged
+func CursorAfter(key string) string {
+	return encodeCursor(key)
+}
+
+func encodeCursor(key string) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(key))
+}
+
+func decodeCursor(cursor string) (string, error) {
+	key, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return "", server.ErrInvalidCursor
+	}
+	return string(key), nil
+}
+
+// keyMatches reports whether the key starts with prefix and matches the glob-style pattern, empty ones match any key
+func keyMatches(key string, prefix string, pattern string) bool {
+	return strings.HasPrefix(key, prefix) && (pattern == "" || globMatch(pattern, key))
+}
@@ -1,12 +1,20 @@
 package cache
 
 import (
+	"bytes"
 	"cache-api/config"
+	"cache-api/server"
 	"context"
+	"errors"
 	"fmt"
+	"slices"
+	"strconv"
+	"strings"
 	"sync"
 	"testing"
 	"time"
+
+	"github.com/rs/zerolog"
 )
 
 func createNewCache() *Cache[string] {
@@ -90,6 +98,559 @@ func TestCache_Get(t *testing.T) {
 	}
 }
 
+func TestCache_CompareAndSwap(t *testing.T) {
+	cache := createNewCache()
+
+	version, swapped, err := cache.CompareAndSwap("key", 0, "first")
+	if err != nil || !swapped {
+		t.Fatalf("Expected creating a missing key with version 0 to succeed, got swapped=%v err=%v", swapped, err)
+	}
+	assertValueExists(t, cache, "key", "first")
+
+	_, gotVersion, ok := cache.GetWithVersion("key")
+	if !ok || gotVersion != version {
+		t.Fatalf("Expected version %d, got %d", version, gotVersion)
+	}
+
+	_, swapped, _ = cache.CompareAndSwap("key", 0, "again")
+	if swapped {
+		t.Errorf("Expected swap with version 0 to fail on an existing key")
+	}
+
+	err = cache.Set("key", "concurrent")
+	if err != nil {
+		t.Fatal(err)
+	}
+	current, swapped, _ := cache.CompareAndSwap("key", version, "stale")
+	if swapped {
+		t.Errorf("Expected swap with a stale version to fail")
+	}
+	if current <= version {
+		t.Errorf("Expected the current version %d to be greater than %d", current, version)
+	}
+	assertValueExists(t, cache, "key", "concurrent")
+
+	newVersion, swapped, _ := cache.CompareAndSwap("key", current, "second")
+	if !swapped {
+		t.Errorf("Expected swap with the current version to succeed")
+	}
+	if newVersion <= current {
+		t.Errorf("Expected the new version %d to be greater than %d", newVersion, current)
+	}
+	assertValueExists(t, cache, "key", "second")
+}
+
+func TestCache_VersionsAreNotReused(t *testing.T) {
+	cache := createNewCache()
+	_ = cache.Set("key", "value")
+	_, first, _ := cache.GetWithVersion("key")
+	cache.Delete("key")
+	_ = cache.Set("key", "value")
+	_, second, _ := cache.GetWithVersion("key")
+	if second <= first {
+		t.Errorf("Expected re-created key to get a version greater than %d, got %d", first, second)
+	}
+}
+
+func TestCache_Increment(t *testing.T) {
+	minValue, maxValue := int64(0), int64(10)
+	tests := []struct {
+		name     string
+		stored   *string
+		delta    int64
+		opts     server.CounterOptions
+		expected int64
+		err      error
+	}{
+		{name: "missing key starts from 0", delta: 2, expected: 2},
+		{name: "missing key starts from initial", delta: 2, opts: server.CounterOptions{Initial: 5}, expected: 7},
+		{name: "existing key ignores initial", stored: ptr("3"), delta: 2, opts: server.CounterOptions{Initial: 5}, expected: 5},
+		{name: "negative delta", stored: ptr("3"), delta: -5, expected: -2},
+		{name: "clamped to max", stored: ptr("8"), delta: 5, opts: server.CounterOptions{Max: &maxValue}, expected: 10},
+		{name: "clamped to min", stored: ptr("2"), delta: -5, opts: server.CounterOptions{Min: &minValue}, expected: 0},
+		{name: "not an integer", stored: ptr("abc"), delta: 1, err: server.ErrNotInteger},
+		{name: "overflow", stored: ptr("9223372036854775807"), delta: 1, err: server.ErrOverflow},
+		{name: "overflow clamped to max", stored: ptr("9223372036854775807"), delta: 1, opts: server.CounterOptions{Max: &maxValue}, expected: 10},
+		{name: "underflow clamped to min", stored: ptr("-9223372036854775808"), delta: -1, opts: server.CounterOptions{Min: &minValue}, expected: 0},
+		{name: "overflow with a bound the other way", stored: ptr("9223372036854775807"), delta: 1, opts: server.CounterOptions{Min: &minValue}, err: server.ErrOverflow},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cache := createNewCache()
+			if tt.stored != nil {
+				_ = cache.Set("counter", *tt.stored)
+			}
+			got, err := cache.Increment("counter", tt.delta, tt.opts)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("Expected error %v but got %v", tt.err, err)
+			}
+			if tt.err != nil {
+				return
+			}
+			if got != tt.expected {
+				t.Errorf("Expected %d but got %d", tt.expected, got)
+			}
+			assertValueExists(t, cache, "counter", strconv.FormatInt(tt.expected, 10))
+		})
+	}
+}
+
+func TestCache_IncrementKeepsExpiration(t *testing.T) {
+	cache := createNewCache()
+	_, err := cache.Increment("counter", 1, server.CounterOptions{TTL: time.Minute})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expiresAt := cache.items["counter"].expiresAt
+	if expiresAt > time.Now().Add(time.Minute).UnixNano() {
+		t.Errorf("Expected the counter to expire within the given TTL")
+	}
+	_, err = cache.Decrement("counter", 1, server.CounterOptions{TTL: time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cache.items["counter"].expiresAt != expiresAt {
+		t.Errorf("Expected updating the counter to keep its expiration")
+	}
+	assertValueExists(t, cache, "counter", "0")
+}
+
+func TestCache_IncrementIntegerCache(t *testing.T) {
+	cache := NewCache[int64](context.Background(), config.CacheConfig{})
+	_, _ = cache.Increment("counter", 40, server.CounterOptions{})
+	got, err := cache.Increment("counter", 2, server.CounterOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	value, _ := cache.Get("counter")
+	if got != 42 || value != 42 {
+		t.Errorf("Expected 42 but got %d and stored %d", got, value)
+	}
+}
+
+func ptr[T any](value T) *T {
+	return &value
+}
+
+func TestCache_ScanKeys(t *testing.T) {
+	cache := createNewCache()
+	for i := 0; i < 25; i++ {
+		_ = cache.Set(fmt.Sprintf("user:%02d", i), "value")
+	}
+	_ = cache.Set("order:1", "value")
+	cache.items["user:expired"] = cacheItem[string]{
+		value:     "expired",
+		expiresAt: time.Now().Add(-10 * time.Second).UnixNano(),
+	}
+
+	var got []string
+	cursor := ""
+	pages := 0
+	for {
+		keys, next, err := cache.ScanKeys(server.ScanOptions{Prefix: "user:", Cursor: cursor, Limit: 10, WithTTL: true})
+		if err != nil {
+			t.Fatal(err)
+		}
+		pages++
+		for _, info := range keys {
+			if info.TTL <= 0 {
+				t.Errorf("Expected '%s' to have a positive TTL, got %s", info.Key, info.TTL)
+			}
+			got = append(got, info.Key)
+		}
+		if next == "" {
+			break
+		}
+		cursor = next
+	}
+	if pages != 3 {
+		t.Errorf("Expected 3 pages but got %d", pages)
+	}
+	if len(got) != 25 {
+		t.Fatalf("Expected 25 keys but got %d: %v", len(got), got)
+	}
+	for i, key := range got {
+		if key != fmt.Sprintf("user:%02d", i) {
+			t.Errorf("Expected keys in lexical order, got %s at %d", key, i)
+		}
+	}
+
+	_, _, err := cache.ScanKeys(server.ScanOptions{Cursor: "not a cursor!"})
+	if !errors.Is(err, server.ErrInvalidCursor) {
+		t.Errorf("Expected ErrInvalidCursor but got %v", err)
+	}
+}
+
+func TestCache_ScanKeys_Chunks(t *testing.T) {
+	t.Parallel()
+	cache := createNewCache()
+	// more keys than a chunk, so a page spans multiple read locks
+	var want []string
+	for i := 0; i < 2*scanChunkSize+500; i++ {
+		key := fmt.Sprintf("key:%04d", i)
+		_ = cache.Set(key, "value")
+		if i%700 == 0 {
+			_ = cache.Set(key+":match", "value")
+			want = append(want, key+":match")
+		}
+	}
+	cache.Delete("key:0700:match")
+	want = slices.DeleteFunc(want, func(key string) bool { return key == "key:0700:match" })
+
+	var got []string
+	cursor := ""
+	for {
+		keys, next, err := cache.ScanKeys(server.ScanOptions{Pattern: "*:match", Cursor: cursor, Limit: 2})
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, info := range keys {
+			got = append(got, info.Key)
+		}
+		if next == "" {
+			break
+		}
+		cursor = next
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("Expected %v, got %v", want, got)
+	}
+
+	_, _ = cache.Flush()
+	if keys, _, _ := cache.ScanKeys(server.ScanOptions{}); len(keys) != 0 {
+		t.Errorf("Expected no keys after a flush, got %v", keys)
+	}
+}
+
+func TestCache_DeleteKeys(t *testing.T) {
+	tests := []struct {
+		name      string
+		prefix    string
+		pattern   string
+		deleted   int
+		remaining []string
+	}{
+		{name: "prefix", prefix: "tenant42:", deleted: 2500, remaining: []string{"tenant4:1", "other:session"}},
+		{name: "pattern", pattern: "*:session", deleted: 1, remaining: []string{"tenant42:0", "tenant4:1"}},
+		{name: "prefix and pattern", prefix: "tenant4", pattern: "*:1", deleted: 2, remaining: []string{"tenant42:0"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cache := createNewCache()
+			// more keys than a chunk so the deletion spans multiple locks
+			for i := 0; i < 2*deleteChunkSize+500; i++ {
+				_ = cache.Set(fmt.Sprintf("tenant42:%d", i), "value")
+			}
+			_ = cache.Set("tenant4:1", "value")
+			_ = cache.Set("other:session", "value")
+
+			deleted, err := cache.DeleteKeys(tt.prefix, tt.pattern)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if deleted != tt.deleted {
+				t.Errorf("Expected %d keys to be deleted but got %d", tt.deleted, deleted)
+			}
+			for _, key := range tt.remaining {
+				assertValueExists(t, cache, key, "value")
+			}
+		})
+	}
+}
+
+func TestCache_InvalidateTag(t *testing.T) {
+	cache := createNewCache()
+	_ = cache.SetWithTags("page:1", "value", []string{"product:12", "category:4"})
+	_ = cache.SetWithTags("page:2", "value", []string{"product:12"})
+	_ = cache.SetWithTags("page:3", "value", []string{"category:4"})
+	_ = cache.Set("page:4", "value")
+
+	invalidated, err := cache.InvalidateTag("product:12")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if invalidated != 2 {
+		t.Errorf("Expected 2 keys to be invalidated but got %d", invalidated)
+	}
+	for _, key := range []string{"page:1", "page:2"} {
+		if _, ok := cache.items[key]; ok {
+			t.Errorf("Expected '%s' to be invalidated", key)
+		}
+	}
+	assertValueExists(t, cache, "page:3", "value")
+	assertValueExists(t, cache, "page:4", "value")
+	if _, ok := cache.tags["product:12"]; ok {
+		t.Errorf("Expected the invalidated tag to be removed from the index")
+	}
+	if _, ok := cache.tags["category:4"]["page:1"]; ok {
+		t.Errorf("Expected the invalidated key to be removed from the index of its other tags")
+	}
+}
+
+func TestCache_TagIndexCleanup(t *testing.T) {
+	cache := createNewCache()
+	_ = cache.SetWithTags("page:1", "value", []string{"product:12"})
+	_ = cache.SetWithTags("page:1", "value", []string{"product:13"})
+	if _, ok := cache.tags["product:12"]; ok {
+		t.Errorf("Expected overwriting a key to drop its previous tags")
+	}
+
+	_ = cache.Set("page:1", "value")
+	if len(cache.tags) != 0 {
+		t.Errorf("Expected overwriting a key without tags to drop its tags, got %v", cache.tags)
+	}
+
+	_ = cache.SetWithTags("page:2", "value", []string{"product:12"})
+	cache.Delete("page:2")
+	if len(cache.tags) != 0 {
+		t.Errorf("Expected deleting a key to drop its tags, got %v", cache.tags)
+	}
+
+	_ = cache.SetWithTags("page:3", "value", []string{"product:12"})
+	item := cache.items["page:3"]
+	item.expiresAt = time.Now().Add(-10 * time.Second).UnixNano()
+	cache.items["page:3"] = item
+	cache.DeleteExpired()
+	if len(cache.tags) != 0 {
+		t.Errorf("Expected expired keys to drop their tags, got %v", cache.tags)
+	}
+
+	_, swapped, _ := cache.CompareAndSwapWithTags("page:4", 0, "value", []string{"product:12"})
+	if !swapped {
+		t.Fatalf("Expected swap to succeed")
+	}
+	invalidated, _ := cache.InvalidateTag("product:12")
+	if invalidated != 1 {
+		t.Errorf("Expected the swapped key to be invalidated by its tag")
+	}
+}
+
+func TestCache_MaxSize(t *testing.T) {
+	cache := NewCache[string](context.Background(), config.CacheConfig{MaxSize: 3})
+	_ = cache.Set("key1", "value")
+	_ = cache.SetWithTags("key2", "value", []string{"tag"})
+	_ = cache.Set("key3", "value")
+	// re-writing key1 makes key2 the least recently written
+	_ = cache.Set("key1", "value")
+	_ = cache.Set("key4", "value")
+
+	if len(cache.items) != 3 {
+		t.Errorf("Expected the cache to hold 3 items but got %d", len(cache.items))
+	}
+	if _, ok := cache.items["key2"]; ok {
+		t.Errorf("Expected 'key2' to be evicted")
+	}
+	if len(cache.tags) != 0 {
+		t.Errorf("Expected the evicted key to be removed from the tag index")
+	}
+	for _, key := range []string{"key1", "key3", "key4"} {
+		assertValueExists(t, cache, key, "value")
+	}
+
+	cache.Delete("key3")
+	_ = cache.Set("key5", "value")
+	if len(cache.items) != 3 {
+		t.Errorf("Expected the cache to hold 3 items but got %d", len(cache.items))
+	}
+	if _, ok := cache.items["key1"]; !ok {
+		t.Errorf("Expected 'key1' to be kept as there was room after a delete")
+	}
+	if cache.writeOrder.Len() != len(cache.items) {
+		t.Errorf("Expected the write order to track %d keys but got %d", len(cache.items), cache.writeOrder.Len())
+	}
+}
+
+func TestCache_Stats(t *testing.T) {
+	cache := NewCache[string](context.Background(), config.CacheConfig{
+		TTLSec:                   20,
+		EvictionIntervalMilliSec: 500,
+		MaxSize:                  100,
+	})
+	_ = cache.Set("key", "value")
+	stats, err := cache.Stats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Oldest.IsZero() {
+		t.Errorf("Expected the creation of the oldest key")
+	}
+	expected := server.Stats{Keys: 1, TTL: 20 * time.Second, MaxSize: 100, EvictionInterval: 500 * time.Millisecond,
+		Bytes: int64(len("key") + len("value")), Oldest: stats.Oldest}
+	if stats != expected {
+		t.Errorf("Expected %+v but got %+v", expected, stats)
+	}
+}
+
+func TestCache_StatsCounters(t *testing.T) {
+	cache := NewCache[string](context.Background(), config.CacheConfig{MaxSize: 1})
+	cache.StopEviction()
+	_ = cache.Set("key1", "value")
+	_ = cache.Set("key1", "longer value")
+	cache.Get("key1")
+	cache.Get("missing")
+	_ = cache.Set("key2", "value")
+	stats, err := cache.Stats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Hits != 1 || stats.Misses != 1 || stats.Evictions != 1 {
+		t.Errorf("Expected 1 hit, 1 miss and 1 eviction but got %+v", stats)
+	}
+	if stats.Bytes != int64(len("key2")+len("value")) {
+		t.Errorf("Expected the bytes of the remaining key but got %d", stats.Bytes)
+	}
+}
+
+func TestCache_SetWithTTL(t *testing.T) {
+	cache := createNewCache()
+	_ = cache.SetWithTTL("key", "value", []string{"tag"}, time.Minute)
+	if metadata, _, _ := cache.Inspect("key"); metadata.TTL <= 59*time.Second || len(metadata.Tags) != 1 {
+		t.Errorf("Expected a remaining ttl close to 1m with the tag, got %+v", metadata)
+	}
+	version, swapped, err := cache.CompareAndSwapWithTTL("key", 1, "new value", nil, time.Hour)
+	if err != nil || !swapped || version != 2 {
+		t.Fatalf("Expected the swap to version 2, got %d %t %v", version, swapped, err)
+	}
+	if metadata, _, _ := cache.Inspect("key"); metadata.TTL <= 59*time.Minute {
+		t.Errorf("Expected a remaining ttl close to 1h, got %s", metadata.TTL)
+	}
+	_ = cache.SetWithTTL("key", "default", nil, 0)
+	if metadata, _, _ := cache.Inspect("key"); metadata.TTL > 10*time.Second {
+		t.Errorf("Expected the ttl of the cache, got %s", metadata.TTL)
+	}
+}
+
+func TestCache_Inspect(t *testing.T) {
+	cache := createNewCache()
+	_ = cache.SetWithTags("key", "value", []string{"tag"})
+	first, ok, _ := cache.Inspect("key")
+	if !ok {
+		t.Fatal("Expected the key to be found")
+	}
+	time.Sleep(time.Millisecond)
+	_ = cache.Set("key", "new value")
+	metadata, ok, err := cache.Inspect("key")
+	if err != nil || !ok {
+		t.Fatalf("Expected the key to be found, got %v", err)
+	}
+	if metadata.Size != len("new value") || metadata.Version <= first.Version || len(metadata.Tags) != 0 {
+		t.Errorf("Unexpected metadata %+v", metadata)
+	}
+	if !metadata.Created.Equal(first.Created) {
+		t.Errorf("Expected the creation time to be kept on overwrite, got %s and %s", first.Created, metadata.Created)
+	}
+	if metadata.TTL <= 9*time.Second || metadata.TTL > 10*time.Second {
+		t.Errorf("Expected a remaining ttl close to 10s, got %s", metadata.TTL)
+	}
+	if _, ok, _ := cache.Inspect("missing"); ok {
+		t.Errorf("Expected a missing key not to be found")
+	}
+}
+
+func TestCache_Flush(t *testing.T) {
+	cache := NewCache[string](context.Background(), config.CacheConfig{MaxSize: 10})
+	_ = cache.SetWithTags("key1", "value", []string{"tag"})
+	_ = cache.Set("key2", "value")
+	flushed, err := cache.Flush()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if flushed != 2 || len(cache.items) != 0 || len(cache.tags) != 0 || cache.writeOrder.Len() != 0 || cache.bytes != 0 {
+		t.Errorf("Expected the cache to be empty after flushing %d keys", flushed)
+	}
+	_ = cache.Set("key1", "value")
+	if _, ok := cache.Get("key1"); !ok {
+		t.Errorf("Expected the cache to be usable after a flush")
+	}
+}
+
+func TestCache_StartEviction(t *testing.T) {
+	cache := NewCache[string](context.Background(), config.CacheConfig{EvictionIntervalMilliSec: 10})
+	cache.StopEviction()
+	cache.StopEviction()
+	if cache.IsEvictionRunning() {
+		t.Fatalf("Expected eviction to be stopped")
+	}
+	cache.StartEviction()
+	cache.StartEviction()
+	if !cache.IsEvictionRunning() {
+		t.Fatalf("Expected eviction to be running")
+	}
+	cache.mutex.Lock()
+	cache.items["expired"] = cacheItem[string]{value: "value", expiresAt: time.Now().Add(-time.Second).UnixNano()}
+	cache.mutex.Unlock()
+	time.Sleep(50 * time.Millisecond)
+	cache.mutex.RLock()
+	_, ok := cache.items["expired"]
+	cache.mutex.RUnlock()
+	if ok {
+		t.Errorf("Expected the restarted eviction to remove the expired key")
+	}
+}
+
+func TestCache_Reconfigure(t *testing.T) {
+	cache := NewCache[string](context.Background(), config.CacheConfig{TTLSec: 60})
+	for _, key := range []string{"a", "b", "c"} {
+		_ = cache.Set(key, "value")
+	}
+
+	cache.Reconfigure(config.CacheConfig{TTLSec: 1, MaxSize: 2, EvictionIntervalMilliSec: 10})
+	stats, _ := cache.Stats()
+	if stats.TTL != time.Second || stats.MaxSize != 2 || stats.EvictionInterval != 10*time.Millisecond {
+		t.Errorf("Expected the new settings, got %+v", stats)
+	}
+	if _, ok := cache.Get("a"); ok || stats.Keys != 2 {
+		t.Errorf("Expected the oldest key to be evicted to fit the max size, got %d keys", stats.Keys)
+	}
+	if !cache.IsEvictionRunning() {
+		t.Errorf("Expected eviction to keep running")
+	}
+	_ = cache.Set("d", "value")
+	if _, ok := cache.Get("b"); ok {
+		t.Errorf("Expected the write order to be kept after shrinking")
+	}
+
+	cache.Reconfigure(config.CacheConfig{TTLSec: 1})
+	for _, key := range []string{"e", "f", "g"} {
+		_ = cache.Set(key, "value")
+	}
+	if stats, _ := cache.Stats(); stats.Keys != 5 {
+		t.Errorf("Expected no limit once the max size is removed, got %d keys", stats.Keys)
+	}
+}
+
+func TestCache_SetLogger(t *testing.T) {
+	cache := NewCache[string](context.Background(), config.CacheConfig{EvictionIntervalMilliSec: 10})
+	out := &lockedWriter{}
+	logger := zerolog.New(out)
+	cache.SetLogger(&logger)
+	cache.mutex.Lock()
+	cache.items["expired"] = cacheItem[string]{value: "value", expiresAt: time.Now().Add(-time.Second).UnixNano()}
+	cache.mutex.Unlock()
+	time.Sleep(50 * time.Millisecond)
+	if !strings.Contains(out.String(), "Deleted expired keys") {
+		t.Errorf("Expected the eviction to be logged, got %s", out.String())
+	}
+}
+
+// lockedWriter serializes the writes of the eviction goroutine with the reads of the test
+type lockedWriter struct {
+	mutex sync.Mutex
+	buf   bytes.Buffer
+}
+
+func (l *lockedWriter) Write(p []byte) (int, error) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	return l.buf.Write(p)
+}
+
+func (l *lockedWriter) String() string {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	return l.buf.String()
+}
+
 func TestCache_Delete(t *testing.T) {
 	cache := createNewCache()
 	cache.items["key"] = cacheItem[string]{
@@ -174,7 +735,7 @@ func TestCache_Eviction(t *testing.T) {
 
 func TestCache_StopEviction(t *testing.T) {
 	cache := createNewCache()
-	if cache.isEvictionRunning == false {
+	if !cache.isEvictionRunning.Load() {
 		t.Errorf("Expected eviction to be running")
 	}
 	cache.StopEviction()
@@ -186,11 +747,53 @@ func TestCache_StopEviction(t *testing.T) {
 	if _, ok := cache.items["expiredKey1"]; !ok {
 		t.Errorf("Expected 'expiredKey1' to be present in the cache, as eviction is stopped")
 	}
-	if cache.isEvictionRunning == true {
+	if cache.isEvictionRunning.Load() {
 		t.Errorf("Expected eviction to be stopped")
 	}
 }
 
+func TestCache_Check(t *testing.T) {
+	tests := []struct {
+		name    string
+		stop    func(cache *Cache[string], cancel context.CancelFunc)
+		wantErr bool
+	}{
+		{name: "running", stop: func(*Cache[string], context.CancelFunc) {}},
+		{name: "stopped by an admin", stop: func(cache *Cache[string], _ context.CancelFunc) { cache.StopEviction() }},
+		{
+			name: "restarted by an admin",
+			stop: func(cache *Cache[string], _ context.CancelFunc) {
+				cache.StopEviction()
+				cache.StartEviction()
+			},
+		},
+		{
+			name: "died",
+			stop: func(cache *Cache[string], _ context.CancelFunc) {
+				cache.OnEvict(func(string, string, EvictReason) { panic("callback failed") })
+				cache.apply(opSet, "expired", cacheItem[string]{value: "value", expiresAt: time.Now().Add(-time.Second).UnixNano()})
+			},
+			wantErr: true,
+		},
+		{name: "context done", stop: func(_ *Cache[string], cancel context.CancelFunc) { cancel() }, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			cache := NewCache[string](ctx, config.CacheConfig{EvictionIntervalMilliSec: 5})
+			if err := cache.Check(context.Background()); err != nil {
+				t.Errorf("Expected the cache to be usable, got %v", err)
+			}
+			tt.stop(cache, cancel)
+			time.Sleep(50 * time.Millisecond)
+			if err := cache.Check(context.Background()); (err != nil) != tt.wantErr {
+				t.Errorf("Expected error %v, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
+
 func assertValueExists(t *testing.T, cache *Cache[string], key string, expectedValue string) {
 	val, ok := cache.items[key]
 	if !ok {
@@ -0,0 +1,92 @@
+package cache
+
+import (
+	"cache-api/server"
+	"time"
+)
+
+// EvictReason says why an item left the cache
+type EvictReason int
+
+const (
+	// EvictExpired is an item removed or replaced after it expired
+	EvictExpired EvictReason = iota + 1
+	// EvictCapacity is the least recently written item, removed to make room in a full cache
+	EvictCapacity
+	// EvictDeleted is an item removed by Delete, DeleteKeys, InvalidateTag or Flush, or by the primary or another
+	// instance of the cache
+	EvictDeleted
+	// EvictReplaced is the previous value of an item that was written again before it expired
+	EvictReplaced
+)
+
+func (r EvictReason) String() string {
+	switch r {
+	case EvictExpired:
+		return "expired"
+	case EvictCapacity:
+		return "capacity"
+	case EvictDeleted:
+		return "deleted"
+	case EvictReplaced:
+		return "replaced"
+	default:
+		return "unknown"
+	}
+}
+
+// evictReasons maps the events of the watchers to the reasons given to the eviction callbacks
+var evictReasons = map[string]EvictReason{
+	server.EventExpire: EvictExpired,
+	server.EventEvict:  EvictCapacity,
+	server.EventDelete: EvictDeleted,
+}
+
+// OnEvict registers a callback receiving every item leaving the cache along with the reason, e.g. to release the
+// resources held by the value. The callbacks run after the write lock is released, in the goroutine of the write that
+// removed the item, so they may use the cache but the key may have been written again by then. Expired items are
+// only passed once DeleteExpired removes them.
+func (c *Cache[T]) OnEvict(callback func(key string, value T, reason EvictReason)) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.onEvict = append(c.onEvict, callback)
+}
+
+// OnSet registers a callback receiving every value stored in the cache, including the ones of counters and the ones
+// received from a primary. The callbacks run like the ones of OnEvict.
+func (c *Cache[T]) OnSet(callback func(key string, value T)) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.onSet = append(c.onSet, callback)
+}
+
+// unlock releases the write lock, then runs the callbacks queued while it was held
+func (c *Cache[T]) unlock() {
+	pending := c.pending
+	c.pending = nil
+	c.mutex.Unlock()
+	for _, callback := range pending {
+		callback()
+	}
+}
+
+// evicted queues the eviction callbacks for the item, the caller must hold the write lock
+func (c *Cache[T]) evicted(key string, value T, reason EvictReason) {
+	for _, callback := range c.onEvict {
+		c.pending = append(c.pending, func() { callback(key, value, reason) })
+	}
+}
+
+// stored queues the set callbacks for the item, replacing previous if it exists. The caller must hold the write lock.
+func (c *Cache[T]) stored(key string, item cacheItem[T], previous *cacheItem[T]) {
+	if previous != nil {
+		reason := EvictReplaced
+		if time.Now().UnixNano() > previous.expiresAt {
+			reason = EvictExpired
+		}
+		c.evicted(key, previous.value, reason)
+	}
+	for _, callback := range c.onSet {
+		c.pending = append(c.pending, func() { callback(key, item.value) })
+	}
+}
@@ -0,0 +1,179 @@
+package cache
+
+import (
+	"cache-api/config"
+	"cache-api/server"
+	"context"
+	"reflect"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+type evictCall struct {
+	key    string
+	value  string
+	reason EvictReason
+}
+
+// recordCallbacks registers callbacks on the cache recording their calls, and checks that the write lock is released
+// when they run
+func recordCallbacks(t *testing.T, cache *Cache[string]) (evicted *[]evictCall, set *[]string) {
+	t.Helper()
+	var mutex sync.Mutex
+	evicted, set = &[]evictCall{}, &[]string{}
+	cache.OnEvict(func(key string, value string, reason EvictReason) {
+		if !cache.mutex.TryLock() {
+			t.Errorf("Expected the eviction callback of %s to run without the lock", key)
+		} else {
+			cache.mutex.Unlock()
+		}
+		mutex.Lock()
+		defer mutex.Unlock()
+		*evicted = append(*evicted, evictCall{key: key, value: value, reason: reason})
+	})
+	cache.OnSet(func(key string, value string) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		*set = append(*set, key+"="+value)
+	})
+	return evicted, set
+}
+
+func TestCache_OnEvict(t *testing.T) {
+	t.Parallel()
+	expired := time.Now().Add(-time.Second).UnixNano()
+	// the expired item takes the place of a, so the cache is not full
+	seedExpired := func(cache *Cache[string]) {
+		cache.Delete("a")
+		cache.apply(opSet, "old", cacheItem[string]{value: "0", expiresAt: expired})
+	}
+	tests := []struct {
+		name  string
+		setup func(cache *Cache[string])
+		write func(cache *Cache[string])
+		want  []evictCall
+	}{
+		{
+			name:  "delete",
+			write: func(cache *Cache[string]) { cache.Delete("a") },
+			want:  []evictCall{{key: "a", value: "1", reason: EvictDeleted}},
+		},
+		{
+			name:  "delete missing key",
+			write: func(cache *Cache[string]) { cache.Delete("missing") },
+		},
+		{
+			name:  "expire",
+			setup: seedExpired,
+			write: func(cache *Cache[string]) { cache.DeleteExpired() },
+			want:  []evictCall{{key: "old", value: "0", reason: EvictExpired}},
+		},
+		{
+			name:  "capacity",
+			write: func(cache *Cache[string]) { _ = cache.Set("c", "3") },
+			want:  []evictCall{{key: "a", value: "1", reason: EvictCapacity}},
+		},
+		{
+			name:  "replace",
+			write: func(cache *Cache[string]) { _ = cache.Set("a", "2") },
+			want:  []evictCall{{key: "a", value: "1", reason: EvictReplaced}},
+		},
+		{
+			name:  "replace expired",
+			setup: seedExpired,
+			write: func(cache *Cache[string]) { _ = cache.Set("old", "1") },
+			want:  []evictCall{{key: "old", value: "0", reason: EvictExpired}},
+		},
+		{
+			name:  "delete keys",
+			write: func(cache *Cache[string]) { _, _ = cache.DeleteKeys("b", "") },
+			want:  []evictCall{{key: "b", value: "2", reason: EvictDeleted}},
+		},
+		{
+			name:  "tag",
+			write: func(cache *Cache[string]) { _, _ = cache.InvalidateTag("tag") },
+			want:  []evictCall{{key: "b", value: "2", reason: EvictDeleted}},
+		},
+		{
+			name:  "flush",
+			write: func(cache *Cache[string]) { _, _ = cache.Flush() },
+			want:  []evictCall{{key: "a", value: "1", reason: EvictDeleted}, {key: "b", value: "2", reason: EvictDeleted}},
+		},
+		{
+			name:  "invalidation",
+			write: func(cache *Cache[string]) { cache.discard(Invalidation{Key: "b"}) },
+			want:  []evictCall{{key: "b", value: "2", reason: EvictDeleted}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			cache := NewCache[string](context.Background(), config.CacheConfig{TTLSec: 10, MaxSize: 2})
+			_ = cache.Set("a", "1")
+			_ = cache.SetWithTags("b", "2", []string{"tag"})
+			if tt.setup != nil {
+				tt.setup(cache)
+			}
+			evicted, _ := recordCallbacks(t, cache)
+
+			tt.write(cache)
+			got := *evicted
+			// a flush walks the items in no particular order
+			slices.SortFunc(got, func(a, b evictCall) int { return strings.Compare(a.key, b.key) })
+			if len(got) != len(tt.want) || (len(got) > 0 && !reflect.DeepEqual(got, tt.want)) {
+				t.Errorf("Expected %v, got %v", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestCache_OnSet(t *testing.T) {
+	t.Parallel()
+	cache := createNewCache()
+	_, set := recordCallbacks(t, cache)
+	_ = cache.Set("a", "1")
+	_, _, _ = cache.CompareAndSwap("a", 1, "2")
+	_, _ = cache.Increment("n", 5, server.CounterOptions{})
+	want := []string{"a=1", "a=2", "n=5"}
+	if !reflect.DeepEqual(*set, want) {
+		t.Errorf("Expected %v, got %v", want, *set)
+	}
+}
+
+func TestCache_OnEvict_Reentrant(t *testing.T) {
+	t.Parallel()
+	cache := createNewCache()
+	// a callback writing to the cache does not deadlock
+	cache.OnEvict(func(key string, value string, reason EvictReason) {
+		if key != "archived:"+value {
+			_ = cache.Set("archived:"+value, value)
+		}
+	})
+	_ = cache.Set("a", "1")
+	cache.Delete("a")
+	if value, ok := cache.Get("archived:1"); !ok || value != "1" {
+		t.Errorf("Expected the callback to archive the value, got %q", value)
+	}
+}
+
+func TestEvictReason_String(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		reason EvictReason
+		want   string
+	}{
+		{reason: EvictExpired, want: "expired"},
+		{reason: EvictCapacity, want: "capacity"},
+		{reason: EvictDeleted, want: "deleted"},
+		{reason: EvictReplaced, want: "replaced"},
+		{reason: 0, want: "unknown"},
+	}
+	for _, tt := range tests {
+		if got := tt.reason.String(); got != tt.want {
+			t.Errorf("Expected %s, got %s", tt.want, got)
+		}
+	}
+}
@@ -0,0 +1,81 @@
+package cache
+
+// globMatch reports whether s matches the glob-style pattern, following the rules of redis' KEYS and SCAN MATCH:
+// `*` matches any sequence, `?` matches a single byte, `[abc]`, `[a-z]` and `[^a]` match classes of bytes and `\`
+// escapes the next character. This keeps prefix and pattern invalidation consistent between the two caches.
+func globMatch(pattern, s string) bool {
+	p, n := 0, 0
+	// position of the last star in the pattern and of the byte of s it was matched against, for backtracking
+	starP, starN := -1, 0
+	for n < len(s) {
+		if p < len(pattern) {
+			if pattern[p] == '*' {
+				starP, starN = p, n
+				p++
+				continue
+			}
+			if next, ok := matchOne(pattern, p, s[n]); ok {
+				p = next
+				n++
+				continue
+			}
+		}
+		if starP >= 0 {
+			// let the last star swallow one more byte and retry
+			starN++
+			p, n = starP+1, starN
+			continue
+		}
+		return false
+	}
+	for p < len(pattern) && pattern[p] == '*' {
+		p++
+	}
+	return p == len(pattern)
+}
+
+// matchOne matches c against the single element of the pattern starting at p and returns the index of the next
+// element
+func matchOne(pattern string, p int, c byte) (int, bool) {
+	switch pattern[p] {
+	case '?':
+		return p + 1, true
+	case '\\':
+		if p+1 < len(pattern) {
+			return p + 2, pattern[p+1] == c
+		}
+		return p + 1, c == '\\'
+	case '[':
+		i := p + 1
+		negate := false
+		if i < len(pattern) && pattern[i] == '^' {
+			negate = true
+			i++
+		}
+		matched := false
+		for i < len(pattern) && pattern[i] != ']' {
+			switch {
+			case pattern[i] == '\\' && i+1 < len(pattern):
+				matched = matched || pattern[i+1] == c
+				i += 2
+			case i+2 < len(pattern) && pattern[i+1] == '-' && pattern[i+2] != ']':
+				lo, hi := pattern[i], pattern[i+2]
+				if lo > hi {
+					lo, hi = hi, lo
+				}
+				matched = matched || (c >= lo && c <= hi)
+				i += 3
+			default:
+				matched = matched || pattern[i] == c
+				i++
+			}
+		}
+		if i < len(pattern) {
+			// skip the closing bracket, an unterminated class runs to the end of the pattern like in redis
+			i++
+		}
+		return i, matched != negate
+	default:
+		return p + 1, pattern[p] == c
+	}
+}
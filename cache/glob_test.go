@@ -0,0 +1,39 @@
+package cache
+
+import "testing"
+
+func TestGlobMatch(t *testing.T) {
+	tests := []struct {
+		pattern  string
+		s        string
+		expected bool
+	}{
+		{pattern: "*", s: "", expected: true},
+		{pattern: "*", s: "anything", expected: true},
+		{pattern: "user:*", s: "user:1", expected: true},
+		{pattern: "user:*", s: "order:1", expected: false},
+		{pattern: "*:1", s: "user:1", expected: true},
+		{pattern: "*:1", s: "user:12", expected: false},
+		{pattern: "u*r:*2", s: "user:12", expected: true},
+		{pattern: "user:?", s: "user:1", expected: true},
+		{pattern: "user:?", s: "user:12", expected: false},
+		{pattern: "user:[12]", s: "user:2", expected: true},
+		{pattern: "user:[12]", s: "user:3", expected: false},
+		{pattern: "user:[^12]", s: "user:3", expected: true},
+		{pattern: "user:[a-c]", s: "user:b", expected: true},
+		{pattern: "user:[c-a]", s: "user:b", expected: true},
+		{pattern: "user:[a-c]", s: "user:d", expected: false},
+		{pattern: `user:\*`, s: "user:*", expected: true},
+		{pattern: `user:\*`, s: "user:1", expected: false},
+		{pattern: `user:[\]]`, s: "user:]", expected: true},
+		{pattern: "tenant42:*:session", s: "tenant42:user:1:session", expected: true},
+		{pattern: "a*a*a*a*b", s: "aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa", expected: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.pattern+" "+tt.s, func(t *testing.T) {
+			if got := globMatch(tt.pattern, tt.s); got != tt.expected {
+				t.Errorf("Expected globMatch(%q, %q) to be %v", tt.pattern, tt.s, tt.expected)
+			}
+		})
+	}
+}
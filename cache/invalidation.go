@@ -0,0 +1,188 @@
+package cache
+
+import (
+	"cache-api/server"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"sync/atomic"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+const (
+	// invalidationBatch is the largest number of invalidations published at once
+	invalidationBatch = 100
+	// publishMinBackoff and publishMaxBackoff bound the wait between attempts to publish invalidations
+	publishMinBackoff = 100 * time.Millisecond
+	publishMaxBackoff = 5 * time.Second
+)
+
+// Invalidation tells the other instances to evict their copies of keys of an in-memory cache. It names a key, a tag,
+// a prefix and pattern as DeleteKeys takes them, or every key with Flush.
+type Invalidation struct {
+	// Origin identifies the instance publishing the invalidation, which ignores it when it comes back
+	Origin string `json:"origin"`
+	// Cache is the name of the cache, `default` or `ns/<name>`, empty for every cache
+	Cache   string `json:"cache,omitempty"`
+	Key     string `json:"key,omitempty"`
+	Tag     string `json:"tag,omitempty"`
+	Prefix  string `json:"prefix,omitempty"`
+	Pattern string `json:"pattern,omitempty"`
+	Flush   bool   `json:"flush,omitempty"`
+}
+
+// Broadcaster carries the invalidations between the instances
+type Broadcaster interface {
+	// Publish delivers the invalidations to the other instances. An error means some instances may not get them, and
+	// they are published again.
+	Publish(ctx context.Context, invalidations []Invalidation) error
+	// Subscribe calls handle with the invalidations published by the instances, which may include the ones of this
+	// instance, until ctx is done. When invalidations may have been missed, e.g. while reconnecting, handle is called
+	// with one flushing every cache.
+	Subscribe(ctx context.Context, handle func([]Invalidation))
+}
+
+// publish queues the invalidation for the other instances, the caller must hold the write lock
+func (c *Cache[T]) publish(invalidation Invalidation) {
+	if c.invalidations != nil {
+		c.invalidations(invalidation)
+	}
+}
+
+// discard applies an invalidation of another instance, without publishing it again
+func (c *Cache[T]) discard(invalidation Invalidation) {
+	if invalidation.Prefix != "" || invalidation.Pattern != "" {
+		c.deleteKeys(invalidation.Prefix, invalidation.Pattern)
+		return
+	}
+	c.mutex.Lock()
+	defer c.unlock()
+	switch {
+	case invalidation.Flush:
+		c.reset()
+	case invalidation.Tag != "":
+		c.removeTag(invalidation.Tag)
+	default:
+		c.remove(invalidation.Key, server.EventDelete)
+	}
+}
+
+// Invalidator keeps the in-memory caches of several instances from serving stale data. The writes to its caches are
+// published on a Broadcaster, and the invalidations of the other instances evict the keys they name. Publishing is
+// retried until it succeeds, so every write is delivered at least once. When more writes are waiting than the
+// buffer holds, they are replaced by a flush of every cache.
+type Invalidator[T any] struct {
+	origin      string
+	caches      map[string]*Cache[T]
+	broadcaster Broadcaster
+	queue       chan Invalidation
+	// overflowed is set when an invalidation did not fit in the queue
+	overflowed atomic.Bool
+	logger     *zerolog.Logger
+}
+
+// NewInvalidator returns an invalidator for the caches of the map by their name, which publishes up to buffer writes
+// waiting to be published
+func NewInvalidator[T any](caches map[string]*Cache[T], broadcaster Broadcaster, buffer int,
+	logger *zerolog.Logger) *Invalidator[T] {
+	origin := make([]byte, 16)
+	_, _ = rand.Read(origin)
+	i := &Invalidator[T]{
+		origin:      hex.EncodeToString(origin),
+		caches:      caches,
+		broadcaster: broadcaster,
+		queue:       make(chan Invalidation, buffer),
+		logger:      logger,
+	}
+	for name, c := range caches {
+		c.mutex.Lock()
+		c.invalidations = func(invalidation Invalidation) {
+			invalidation.Origin, invalidation.Cache = i.origin, name
+			select {
+			case i.queue <- invalidation:
+			default:
+				i.overflowed.Store(true)
+			}
+		}
+		c.mutex.Unlock()
+	}
+	return i
+}
+
+// Run publishes the writes and applies the invalidations of the other instances until ctx is done
+func (i *Invalidator[T]) Run(ctx context.Context) {
+	go i.broadcaster.Subscribe(ctx, i.apply)
+	for {
+		batch, ok := i.next(ctx)
+		if !ok {
+			return
+		}
+		backoff := publishMinBackoff
+		for {
+			err := i.broadcaster.Publish(ctx, batch)
+			if err == nil {
+				break
+			}
+			if ctx.Err() != nil {
+				return
+			}
+			i.logger.Warn().Err(err).Int("invalidations", len(batch)).Dur("retry_in", backoff).
+				Msg("Failed to publish invalidations")
+			select {
+			case <-time.After(backoff):
+			case <-ctx.Done():
+				return
+			}
+			backoff = min(2*backoff, publishMaxBackoff)
+		}
+	}
+}
+
+// next waits for the invalidations to publish and returns up to invalidationBatch of them, without duplicates. Once
+// the queue overflowed, the invalidations waiting are replaced by a flush of every cache.
+func (i *Invalidator[T]) next(ctx context.Context) ([]Invalidation, bool) {
+	var first Invalidation
+	select {
+	case first = <-i.queue:
+	case <-ctx.Done():
+		return nil, false
+	}
+	if i.overflowed.Swap(false) {
+		for len(i.queue) > 0 {
+			<-i.queue
+		}
+		i.logger.Warn().Msg("Too many invalidations waiting, flushing every cache of the other instances")
+		return []Invalidation{{Origin: i.origin, Flush: true}}, true
+	}
+	batch := []Invalidation{first}
+	seen := map[Invalidation]struct{}{first: {}}
+	// Run is the only receiver, so the queue does not empty in between
+	for len(batch) < invalidationBatch && len(i.queue) > 0 {
+		invalidation := <-i.queue
+		if _, ok := seen[invalidation]; !ok {
+			seen[invalidation] = struct{}{}
+			batch = append(batch, invalidation)
+		}
+	}
+	return batch, true
+}
+
+// apply evicts the keys named by the invalidations of the other instances
+func (i *Invalidator[T]) apply(invalidations []Invalidation) {
+	for _, invalidation := range invalidations {
+		if invalidation.Origin == i.origin {
+			continue
+		}
+		i.logger.Debug().Str("origin", invalidation.Origin).Str("cache", invalidation.Cache).
+			Str("key", invalidation.Key).Bool("flush", invalidation.Flush).Msg("Applying invalidation")
+		if invalidation.Cache == "" {
+			for _, c := range i.caches {
+				c.discard(invalidation)
+			}
+		} else if c, ok := i.caches[invalidation.Cache]; ok {
+			c.discard(invalidation)
+		}
+	}
+}
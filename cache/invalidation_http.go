@@ -0,0 +1,217 @@
+package cache
+
+import (
+	"bytes"
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+const (
+	// invalidationPath is where the instances receive the invalidations of their peers
+	invalidationPath = "/invalidations"
+	// headerInvalidationToken carries the token shared by the peers
+	headerInvalidationToken = "X-Invalidation-Token"
+	// invalidationTimeout bounds every request to a peer
+	invalidationTimeout = 5 * time.Second
+)
+
+// HTTPBroadcaster fans the invalidations out to its peers over HTTP. Every peer has its own queue, sent in order and
+// retried until the peer acknowledges it, so a peer that is down does not hold back the others. When more
+// invalidations are waiting for a peer than the buffer holds, they are replaced by a flush of every cache.
+type HTTPBroadcaster struct {
+	token  string
+	client *http.Client
+	peers  []*httpPeer
+	// handle receives the invalidations of the peers while subscribed
+	handle atomic.Pointer[func([]Invalidation)]
+	logger *zerolog.Logger
+}
+
+// httpPeer is the queue of the invalidations for a peer
+type httpPeer struct {
+	url    string
+	buffer int
+	// ready is signaled when invalidations are queued
+	ready chan struct{}
+	// mutex guards the fields below
+	mutex   sync.Mutex
+	pending []Invalidation
+	// flush replaces the pending invalidations once they overflowed, it is sent with the origin of the last of them
+	flush *Invalidation
+}
+
+// NewHTTPBroadcaster returns a broadcaster sending the invalidations to the peers, the addresses they serve the
+// broadcaster at, until ctx is done. Up to buffer invalidations are queued per peer.
+func NewHTTPBroadcaster(ctx context.Context, peers []string, token string, buffer int,
+	logger *zerolog.Logger) *HTTPBroadcaster {
+	b := &HTTPBroadcaster{token: token, client: &http.Client{Timeout: invalidationTimeout}, logger: logger}
+	for _, addr := range peers {
+		peer := &httpPeer{url: "http://" + addr + invalidationPath, buffer: buffer, ready: make(chan struct{}, 1)}
+		b.peers = append(b.peers, peer)
+		go b.send(ctx, peer)
+	}
+	return b
+}
+
+// Publish implements Broadcaster, it queues the invalidations for every peer and does not fail
+func (b *HTTPBroadcaster) Publish(_ context.Context, invalidations []Invalidation) error {
+	if len(invalidations) == 0 {
+		return nil
+	}
+	for _, peer := range b.peers {
+		peer.mutex.Lock()
+		if peer.flush == nil && len(peer.pending)+len(invalidations) <= peer.buffer {
+			peer.pending = append(peer.pending, invalidations...)
+		} else {
+			peer.pending = nil
+			peer.flush = &Invalidation{Origin: invalidations[len(invalidations)-1].Origin, Flush: true}
+		}
+		peer.mutex.Unlock()
+		select {
+		case peer.ready <- struct{}{}:
+		default:
+		}
+	}
+	return nil
+}
+
+// Subscribe implements Broadcaster
+func (b *HTTPBroadcaster) Subscribe(ctx context.Context, handle func([]Invalidation)) {
+	b.handle.Store(&handle)
+	<-ctx.Done()
+	b.handle.Store(nil)
+}
+
+// Serve receives the invalidations of the peers on listener until ctx is done
+func (b *HTTPBroadcaster) Serve(ctx context.Context, listener net.Listener) error {
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST "+invalidationPath, b.receive)
+	server := &http.Server{Handler: mux, ReadHeaderTimeout: invalidationTimeout}
+	stop := context.AfterFunc(ctx, func() { _ = server.Close() })
+	defer stop()
+	if err := server.Serve(listener); !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+// receive handles `POST /invalidations`. The invalidations are only acknowledged once applied, so a peer retries
+// the ones sent while this instance is not subscribed.
+func (b *HTTPBroadcaster) receive(w http.ResponseWriter, r *http.Request) {
+	if subtle.ConstantTimeCompare([]byte(r.Header.Get(headerInvalidationToken)), []byte(b.token)) != 1 {
+		http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+		return
+	}
+	var invalidations []Invalidation
+	if err := json.NewDecoder(r.Body).Decode(&invalidations); err != nil {
+		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		return
+	}
+	handle := b.handle.Load()
+	if handle == nil {
+		http.Error(w, http.StatusText(http.StatusServiceUnavailable), http.StatusServiceUnavailable)
+		return
+	}
+	(*handle)(invalidations)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// send delivers the queue of the peer until ctx is done, waiting longer between failed attempts up to
+// publishMaxBackoff
+func (b *HTTPBroadcaster) send(ctx context.Context, peer *httpPeer) {
+	logger := b.logger.With().Str("peer", peer.url).Logger()
+	backoff := publishMinBackoff
+	for {
+		batch := peer.take()
+		if len(batch) == 0 {
+			select {
+			case <-peer.ready:
+				continue
+			case <-ctx.Done():
+				return
+			}
+		}
+		err := b.post(ctx, peer.url, batch)
+		if err == nil {
+			backoff = publishMinBackoff
+			continue
+		}
+		if ctx.Err() != nil {
+			return
+		}
+		peer.putBack(batch)
+		logger.Warn().Err(err).Int("invalidations", len(batch)).Dur("retry_in", backoff).
+			Msg("Failed to send invalidations")
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return
+		}
+		backoff = min(2*backoff, publishMaxBackoff)
+	}
+}
+
+func (b *HTTPBroadcaster) post(ctx context.Context, url string, batch []Invalidation) error {
+	body, err := json.Marshal(batch)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(headerInvalidationToken, b.token)
+	resp, err := b.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return nil
+}
+
+// take removes up to invalidationBatch invalidations from the queue, or the flush replacing them
+func (p *httpPeer) take() []Invalidation {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if p.flush != nil {
+		flush := *p.flush
+		p.flush = nil
+		return []Invalidation{flush}
+	}
+	n := min(invalidationBatch, len(p.pending))
+	batch := p.pending[:n:n]
+	p.pending = p.pending[n:]
+	return batch
+}
+
+// putBack queues a batch that could not be sent again, ahead of the invalidations queued since
+func (p *httpPeer) putBack(batch []Invalidation) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	switch {
+	case p.flush != nil:
+		// the flush covers the batch
+	case len(batch) == 1 && batch[0].Flush && batch[0].Cache == "":
+		p.pending = nil
+		p.flush = &batch[0]
+	case len(batch)+len(p.pending) <= p.buffer:
+		p.pending = append(batch, p.pending...)
+	default:
+		p.pending = nil
+		p.flush = &Invalidation{Origin: batch[0].Origin, Flush: true}
+	}
+}
@@ -0,0 +1,90 @@
+package cache
+
+import (
+	"cache-api/config"
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/rs/zerolog"
+)
+
+// RedisBroadcaster carries the invalidations over a redis pub/sub channel. Redis does not keep the messages published
+// while a subscriber is disconnected, so a subscriber flushes every cache once it subscribed again.
+type RedisBroadcaster struct {
+	rdb     *redis.Client
+	channel string
+	logger  *zerolog.Logger
+}
+
+// NewRedisBroadcaster returns a broadcaster publishing on channel of the redis server of redisConfig
+func NewRedisBroadcaster(ctx context.Context, redisConfig *config.RedisConfig, channel string,
+	logger *zerolog.Logger) (*RedisBroadcaster, error) {
+	client := redis.NewClient(&redis.Options{
+		Addr:     redisConfig.Host,
+		Username: redisConfig.Username,
+		Password: redisConfig.Password,
+		DB:       redisConfig.DB,
+	})
+	if _, err := client.Ping(ctx).Result(); err != nil {
+		return nil, err
+	}
+	return &RedisBroadcaster{rdb: client, channel: channel, logger: logger}, nil
+}
+
+// Publish implements Broadcaster
+func (r *RedisBroadcaster) Publish(ctx context.Context, invalidations []Invalidation) error {
+	message, err := json.Marshal(invalidations)
+	if err != nil {
+		return err
+	}
+	return r.rdb.Publish(ctx, r.channel, message).Err()
+}
+
+// Subscribe implements Broadcaster. The subscription is restored after a connection error, waiting longer between
+// failed attempts up to publishMaxBackoff.
+func (r *RedisBroadcaster) Subscribe(ctx context.Context, handle func([]Invalidation)) {
+	pubsub := r.rdb.Subscribe(ctx, r.channel)
+	defer pubsub.Close()
+	backoff := publishMinBackoff
+	missed := false
+	for {
+		message, err := pubsub.Receive(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			missed = true
+			r.logger.Warn().Err(err).Dur("retry_in", backoff).Msg("Lost the invalidation channel")
+			select {
+			case <-time.After(backoff):
+			case <-ctx.Done():
+				return
+			}
+			backoff = min(2*backoff, publishMaxBackoff)
+			continue
+		}
+		backoff = publishMinBackoff
+		switch message := message.(type) {
+		case *redis.Subscription:
+			if missed {
+				missed = false
+				r.logger.Info().Msg("Subscribed to the invalidation channel again, flushing every cache")
+				handle([]Invalidation{{Flush: true}})
+			}
+		case *redis.Message:
+			var invalidations []Invalidation
+			if err := json.Unmarshal([]byte(message.Payload), &invalidations); err != nil {
+				r.logger.Warn().Err(err).Msg("Ignored an invalid invalidation message")
+				continue
+			}
+			handle(invalidations)
+		}
+	}
+}
+
+// Close closes the connection to redis
+func (r *RedisBroadcaster) Close() error {
+	return r.rdb.Close()
+}
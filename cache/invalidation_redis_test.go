@@ -0,0 +1,48 @@
+package cache
+
+import (
+	"cache-api/config"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+func TestRedisBroadcaster(t *testing.T) {
+	connectionString := setupRedis(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	logger := zerolog.Nop()
+	redisCfg := &config.RedisConfig{Host: connectionString}
+	newBroadcaster := func() *RedisBroadcaster {
+		broadcaster, err := NewRedisBroadcaster(ctx, redisCfg, "invalidations", &logger)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { _ = broadcaster.Close() })
+		return broadcaster
+	}
+	instances := startInstances(ctx, newBroadcaster(), newBroadcaster())
+	// the messages published before subscribing are lost, so the probe is written until its invalidation arrives
+	expiresAt := time.Now().Add(time.Hour).UnixNano()
+	instances[1].apply(opSet, "probe", cacheItem[string]{value: "b", expiresAt: expiresAt})
+	waitFor(t, "the subscription", func() bool {
+		_ = instances[0].Set("probe", "a")
+		_, ok := instances[1].Get("probe")
+		return !ok
+	})
+	instances[1].apply(opSet, "key", cacheItem[string]{value: "stale", expiresAt: expiresAt})
+	_ = instances[0].Set("key", "fresh")
+	waitFor(t, "the invalidation", func() bool {
+		_, ok := instances[1].Get("key")
+		return !ok
+	})
+	if value, ok := instances[0].Get("key"); !ok || value != "fresh" {
+		t.Errorf("Expected the writer to keep its value, got %q", value)
+	}
+
+	if _, err := NewRedisBroadcaster(ctx, &config.RedisConfig{Host: "invalid:1234"}, "invalidations", &logger); err == nil {
+		t.Errorf("Expected an error but got nil")
+	}
+}
@@ -0,0 +1,256 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+// memBus is a Broadcaster delivering the invalidations to every subscriber in memory, including the publisher. It
+// fails the first failures publishes.
+type memBus struct {
+	mutex       sync.Mutex
+	subscribers []func([]Invalidation)
+	failures    int
+	published   [][]Invalidation
+}
+
+func (b *memBus) Publish(_ context.Context, invalidations []Invalidation) error {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	if b.failures > 0 {
+		b.failures--
+		return errors.New("bus unavailable")
+	}
+	b.published = append(b.published, invalidations)
+	for _, handle := range b.subscribers {
+		handle(invalidations)
+	}
+	return nil
+}
+
+func (b *memBus) Subscribe(ctx context.Context, handle func([]Invalidation)) {
+	b.mutex.Lock()
+	b.subscribers = append(b.subscribers, handle)
+	b.mutex.Unlock()
+	<-ctx.Done()
+}
+
+func (b *memBus) publishedCount() int {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return len(b.published)
+}
+
+// startInstances runs n instances with one cache each, invalidated over broadcasters
+func startInstances(ctx context.Context, broadcasters ...Broadcaster) []*Cache[string] {
+	logger := zerolog.Nop()
+	caches := make([]*Cache[string], len(broadcasters))
+	for i, broadcaster := range broadcasters {
+		caches[i] = createNewCache()
+		go NewInvalidator(map[string]*Cache[string]{"default": caches[i]}, broadcaster, 100, &logger).Run(ctx)
+	}
+	return caches
+}
+
+func TestInvalidator(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	bus := &memBus{}
+	instances := startInstances(ctx, bus, bus)
+	a, b := instances[0], instances[1]
+	waitFor(t, "the subscriptions", func() bool {
+		bus.mutex.Lock()
+		defer bus.mutex.Unlock()
+		return len(bus.subscribers) == 2
+	})
+
+	tests := []struct {
+		name  string
+		write func()
+		gone  []string
+		kept  []string
+	}{
+		{name: "set", write: func() { _ = a.Set("key", "a") }, gone: []string{"key"}, kept: []string{"other"}},
+		{name: "delete", write: func() { a.Delete("key") }, gone: []string{"key"}, kept: []string{"other"}},
+		{name: "tag", write: func() { _, _ = a.InvalidateTag("tag") }, gone: []string{"tagged"}, kept: []string{"key"}},
+		{name: "prefix", write: func() { _, _ = a.DeleteKeys("user:", "") }, gone: []string{"user:1"}, kept: []string{"key"}},
+		{name: "flush", write: func() { _, _ = a.Flush() }, gone: []string{"key", "user:1", "tagged"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// the keys are stored as a replica would, without publishing them
+			expiresAt := time.Now().Add(time.Hour).UnixNano()
+			for _, key := range []string{"key", "other", "user:1"} {
+				b.apply(opSet, key, cacheItem[string]{value: "b", expiresAt: expiresAt})
+			}
+			b.apply(opSet, "tagged", cacheItem[string]{value: "b", expiresAt: expiresAt, tags: []string{"tag"}})
+			published := bus.publishedCount()
+
+			tt.write()
+			waitFor(t, "the invalidation", func() bool {
+				for _, key := range tt.gone {
+					if _, ok := b.Get(key); ok {
+						return false
+					}
+				}
+				return true
+			})
+			for _, key := range tt.kept {
+				if _, ok := b.Get(key); !ok {
+					t.Errorf("Expected %s to be kept", key)
+				}
+			}
+			// the instance evicting the keys does not publish the invalidation again
+			time.Sleep(20 * time.Millisecond)
+			if count := bus.publishedCount(); count != published+1 {
+				t.Errorf("Expected 1 publish, got %d", count-published)
+			}
+		})
+	}
+
+	// an instance ignores its own invalidations
+	published := bus.publishedCount()
+	_ = a.Set("own", "a")
+	waitFor(t, "the publish", func() bool { return bus.publishedCount() > published })
+	if value, ok := a.Get("own"); !ok || value != "a" {
+		t.Errorf("Expected the writer to keep its value, got %q", value)
+	}
+}
+
+func TestInvalidator_RetriesPublish(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	bus := &memBus{failures: 2}
+	instances := startInstances(ctx, bus, bus)
+	_ = instances[1].Set("key", "stale")
+	_ = instances[0].Set("key", "fresh")
+	// the failed publishes are retried after 100ms then 200ms
+	start := time.Now()
+	for {
+		if _, ok := instances[1].Get("key"); !ok {
+			break
+		}
+		if time.Since(start) > 2*time.Second {
+			t.Fatalf("Expected the invalidation to be delivered once the bus recovers")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestInvalidator_Overflow(t *testing.T) {
+	t.Parallel()
+	logger := zerolog.Nop()
+	cache := createNewCache()
+	invalidator := NewInvalidator(map[string]*Cache[string]{"default": cache}, &memBus{}, 2, &logger)
+	for _, key := range []string{"a", "b", "c"} {
+		_ = cache.Set(key, "value")
+	}
+	batch, _ := invalidator.next(context.Background())
+	if len(batch) != 1 || !batch[0].Flush || batch[0].Cache != "" {
+		t.Errorf("Expected a flush of every cache once the queue overflowed, got %+v", batch)
+	}
+	_ = cache.Set("d", "value")
+	_ = cache.Set("d", "value")
+	batch, _ = invalidator.next(context.Background())
+	if len(batch) != 1 || batch[0].Key != "d" || batch[0].Cache != "default" {
+		t.Errorf("Expected a single invalidation of d, got %+v", batch)
+	}
+}
+
+// startHTTPBroadcaster serves a broadcaster sending to the peers on listener
+func startHTTPBroadcaster(t *testing.T, ctx context.Context, listener net.Listener, peers ...string) *HTTPBroadcaster {
+	t.Helper()
+	logger := zerolog.Nop()
+	broadcaster := NewHTTPBroadcaster(ctx, peers, "secret", 100, &logger)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		if err := broadcaster.Serve(ctx, listener); err != nil {
+			t.Errorf("Expected the broadcaster to stop without error, got %v", err)
+		}
+	}()
+	t.Cleanup(func() { <-done })
+	return broadcaster
+}
+
+func listenLoopback(t *testing.T) net.Listener {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return listener
+}
+
+func TestHTTPBroadcaster(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	listenerA, listenerB := listenLoopback(t), listenLoopback(t)
+	addrB := listenerB.Addr().String()
+	// b starts listening late, so the invalidations of a are retried until it acknowledges them
+	_ = listenerB.Close()
+	a := startHTTPBroadcaster(t, ctx, listenerA, addrB)
+	instances := startInstances(ctx, a)
+	_ = instances[0].Set("key", "fresh")
+	time.Sleep(50 * time.Millisecond)
+
+	stale := createNewCache()
+	_ = stale.Set("key", "stale")
+	listenerB, err := net.Listen("tcp", addrB)
+	if err != nil {
+		t.Skipf("Could not listen on %s again: %v", addrB, err)
+	}
+	b := startHTTPBroadcaster(t, ctx, listenerB, listenerA.Addr().String())
+	logger := zerolog.Nop()
+	go NewInvalidator(map[string]*Cache[string]{"default": stale}, b, 100, &logger).Run(ctx)
+	waitFor(t, "the retried invalidation", func() bool {
+		_, ok := stale.Get("key")
+		return !ok
+	})
+
+	// the invalidations go both ways
+	_ = instances[0].Set("other", "a")
+	_ = stale.Set("other", "b")
+	waitFor(t, "the invalidation of a", func() bool {
+		_, ok := instances[0].Get("other")
+		return !ok
+	})
+}
+
+func TestHTTPBroadcaster_Token(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	listener := listenLoopback(t)
+	startHTTPBroadcaster(t, ctx, listener)
+	url := "http://" + listener.Addr().String() + invalidationPath
+	tests := []struct {
+		name       string
+		token      string
+		wantStatus int
+	}{
+		{name: "invalid token", token: "wrong", wantStatus: http.StatusForbidden},
+		{name: "not subscribed", token: "secret", wantStatus: http.StatusServiceUnavailable},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, _ := http.NewRequest(http.MethodPost, url, strings.NewReader(`[{"origin":"x","key":"k"}]`))
+			req.Header.Set(headerInvalidationToken, tt.token)
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			_ = resp.Body.Close()
+			if resp.StatusCode != tt.wantStatus {
+				t.Errorf("Expected status code %d, got %d", tt.wantStatus, resp.StatusCode)
+			}
+		})
+	}
+}
@@ -0,0 +1,93 @@
+package cache
+
+import "math/rand/v2"
+
+// keyIndexMaxLevel bounds the height of the skip list, enough for billions of keys with a branching factor of 4
+const keyIndexMaxLevel = 16
+
+// keyIndex is a skip list holding the keys of the cache in lexical order, so ScanKeys can resume a page from its
+// cursor without sorting every key. The zero value is an empty index. It is not safe for concurrent use, the cache
+// guards it with its mutex.
+type keyIndex struct {
+	head keyIndexNode
+	// level is the number of levels in use
+	level int
+}
+
+type keyIndexNode struct {
+	key  string
+	next []*keyIndexNode
+}
+
+// insert adds key to the index, it must not already be in it
+func (x *keyIndex) insert(key string) {
+	if x.head.next == nil {
+		x.head.next = make([]*keyIndexNode, keyIndexMaxLevel)
+	}
+	var update [keyIndexMaxLevel]*keyIndexNode
+	node := &x.head
+	for i := x.level - 1; i >= 0; i-- {
+		for node.next[i] != nil && node.next[i].key < key {
+			node = node.next[i]
+		}
+		update[i] = node
+	}
+	level := 1
+	for level < keyIndexMaxLevel && rand.IntN(4) == 0 {
+		level++
+	}
+	for ; x.level < level; x.level++ {
+		update[x.level] = &x.head
+	}
+	inserted := &keyIndexNode{key: key, next: make([]*keyIndexNode, level)}
+	for i := 0; i < level; i++ {
+		inserted.next[i] = update[i].next[i]
+		update[i].next[i] = inserted
+	}
+}
+
+// delete removes key from the index if it is in it
+func (x *keyIndex) delete(key string) {
+	if x.level == 0 {
+		return
+	}
+	var update [keyIndexMaxLevel]*keyIndexNode
+	node := &x.head
+	for i := x.level - 1; i >= 0; i-- {
+		for node.next[i] != nil && node.next[i].key < key {
+			node = node.next[i]
+		}
+		update[i] = node
+	}
+	deleted := node.next[0]
+	if deleted == nil || deleted.key != key {
+		return
+	}
+	for i := 0; i < len(deleted.next); i++ {
+		update[i].next[i] = deleted.next[i]
+	}
+	for x.level > 0 && x.head.next[x.level-1] == nil {
+		x.level--
+	}
+}
+
+// seek returns the node of the first key greater than or equal to key, or nil if there is none. Following next[0]
+// from it walks the keys in lexical order.
+func (x *keyIndex) seek(key string) *keyIndexNode {
+	if x.level == 0 {
+		return nil
+	}
+	node := &x.head
+	for i := x.level - 1; i >= 0; i-- {
+		for node.next[i] != nil && node.next[i].key < key {
+			node = node.next[i]
+		}
+	}
+	return node.next[0]
+}
+
+// reset removes every key
+func (x *keyIndex) reset() {
+	clear(x.head.next)
+	x.level = 0
+}
@@ -0,0 +1,65 @@
+package cache
+
+import (
+	"fmt"
+	"math/rand/v2"
+	"slices"
+	"testing"
+)
+
+func keysOf(x *keyIndex, from string) []string {
+	var keys []string
+	for node := x.seek(from); node != nil; node = node.next[0] {
+		keys = append(keys, node.key)
+	}
+	return keys
+}
+
+func TestKeyIndex(t *testing.T) {
+	t.Parallel()
+	var index keyIndex
+	if keys := keysOf(&index, ""); keys != nil {
+		t.Errorf("Expected an empty index, got %v", keys)
+	}
+	index.delete("missing")
+
+	want := make(map[string]struct{})
+	for _, i := range rand.Perm(1000) {
+		key := fmt.Sprintf("key:%04d", i)
+		index.insert(key)
+		want[key] = struct{}{}
+	}
+	for i := 0; i < 1000; i += 3 {
+		key := fmt.Sprintf("key:%04d", i)
+		index.delete(key)
+		delete(want, key)
+	}
+	sorted := make([]string, 0, len(want))
+	for key := range want {
+		sorted = append(sorted, key)
+	}
+	slices.Sort(sorted)
+
+	tests := []struct {
+		name string
+		from string
+		want []string
+	}{
+		{name: "every key", from: "", want: sorted},
+		{name: "existing key", from: "key:0500", want: sorted[slices.Index(sorted, "key:0500"):]},
+		{name: "deleted key", from: "key:0501", want: sorted[slices.Index(sorted, "key:0502"):]},
+		{name: "after the last key", from: "z"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := keysOf(&index, tt.from); !slices.Equal(got, tt.want) {
+				t.Errorf("Expected %d keys from %q, got %d", len(tt.want), tt.from, len(got))
+			}
+		})
+	}
+
+	index.reset()
+	if keys := keysOf(&index, ""); keys != nil {
+		t.Errorf("Expected the reset index to be empty, got %v", keys)
+	}
+}
@@ -2,24 +2,142 @@ package cache
 
 import (
 	"cache-api/config"
+	"cache-api/ratelimit"
 	"cache-api/server"
 	"context"
 	"errors"
+	"math"
+	"strconv"
+	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/redis/go-redis/v9"
 	"github.com/rs/zerolog"
 )
 
-var _ server.Cache = &RedisCache{}
+var (
+	_ server.Cache          = &RedisCache{}
+	_ server.VersionedCache = &RedisCache{}
+	_ server.Counter        = &RedisCache{}
+	_ server.KeyScanner     = &RedisCache{}
+	_ server.KeyDeleter     = &RedisCache{}
+	_ server.TagCache       = &RedisCache{}
+	_ server.ExpiringCache  = &RedisCache{}
+	_ server.StatsProvider  = &RedisCache{}
+	_ server.HealthChecker  = &RedisCache{}
+	_ server.KeyInspector   = &RedisCache{}
+	_ server.Flusher        = &RedisCache{}
+	_ ratelimit.Limiter     = &RedisCache{}
+)
+
+const (
+	// metaKeyPrefix prefixes every key the cache keeps for its own bookkeeping next to the stored values
+	metaKeyPrefix = "_meta:"
+	// versionKeyPrefix prefixes the key holding the version of a stored value
+	versionKeyPrefix = metaKeyPrefix + "version:"
+	// versionSeqKey is the counter versions are taken from, shared by all keys so a re-created key never reuses a
+	// version of a previous incarnation
+	versionSeqKey = metaKeyPrefix + "version-seq"
+	// tagsKeyPrefix prefixes the set holding the tags of a stored value
+	tagsKeyPrefix = metaKeyPrefix + "tags:"
+	// tagKeyPrefix prefixes the set holding the keys carrying a tag, the reverse index used by InvalidateTag
+	tagKeyPrefix = metaKeyPrefix + "tag:"
+	// namespaceKeyPrefix prefixes the keys of every namespace, followed by the name of the namespace and a colon
+	namespaceKeyPrefix = "_ns:"
+	// rateLimitKeyPrefix prefixes the token buckets of the distributed rate limiter
+	rateLimitKeyPrefix = metaKeyPrefix + "ratelimit:"
+	// deleteBatchSize is the SCAN COUNT and the maximum number of keys per UNLINK when deleting keys in bulk
+	deleteBatchSize = 500
+)
+
+// writeLua is shared by the scripts storing a value. It stores the value with a new version, moves the key from the
+// index sets of its previous tags to the ones of its new tags, and returns the new version. A tag index set lives as
+// long as the longest living key added to it, stale members left by expired keys are skipped on invalidation.
+// KEYS: value key, version key, version sequence key, tags key. ARGV[firstTag:] are the tags, and tagIndexPrefix
+// prefixes the tag index sets of the namespace.
+// The tag index sets are not declared in KEYS, so the scripts do not support redis cluster.
+const writeLua = `
+local function write(value, ttl, tagIndexPrefix, firstTag)
+	local version = redis.call('INCR', KEYS[3])
+	if ttl > 0 then
+		redis.call('SET', KEYS[1], value, 'PX', ttl)
+		redis.call('SET', KEYS[2], version, 'PX', ttl)
+	else
+		redis.call('SET', KEYS[1], value)
+		redis.call('SET', KEYS[2], version)
+	end
+	for _, tag in ipairs(redis.call('SMEMBERS', KEYS[4])) do
+		redis.call('SREM', tagIndexPrefix .. tag, KEYS[1])
+	end
+	redis.call('DEL', KEYS[4])
+	for i = firstTag, #ARGV do
+		local tagKey = tagIndexPrefix .. ARGV[i]
+		local existed = redis.call('EXISTS', tagKey)
+		redis.call('SADD', KEYS[4], ARGV[i])
+		redis.call('SADD', tagKey, KEYS[1])
+		if ttl <= 0 then
+			redis.call('PERSIST', tagKey)
+		else
+			local current = redis.call('PTTL', tagKey)
+			if existed == 0 or (current >= 0 and current < ttl) then
+				redis.call('PEXPIRE', tagKey, ttl)
+			end
+		end
+	end
+	if ttl > 0 and #ARGV >= firstTag then
+		redis.call('PEXPIRE', KEYS[4], ttl)
+	end
+	return version
+end
+`
+
+// setScript stores a value along with a new version and its tags.
+// KEYS: see writeLua. ARGV: value, ttl in milliseconds (0 means no expiration), tag index prefix, tags...
+var setScript = redis.NewScript(writeLua + `
+return write(ARGV[1], tonumber(ARGV[2]), ARGV[3], 4)
+`)
+
+// compareAndSwapScript stores a value along with a new version and its tags only if the current version matches.
+// KEYS: see writeLua. ARGV: value, ttl in milliseconds, tag index prefix, expected version, tags...
+// Returns {1, new version} on success and {0, current version} on a mismatch.
+var compareAndSwapScript = redis.NewScript(writeLua + `
+local current = 0
+if redis.call('EXISTS', KEYS[1]) == 1 then
+	current = tonumber(redis.call('GET', KEYS[2]) or '0')
+end
+if current ~= tonumber(ARGV[4]) then
+	return {0, current}
+end
+return {1, write(ARGV[1], tonumber(ARGV[2]), ARGV[3], 5)}
+`)
+
+// invalidateTagScript removes the keys of a batch of the tag index set that still carry the tag, along with their
+// metadata, and drops the batch from the index set.
+// KEYS: tag index set, then the keys of the batch. ARGV: tag. Returns the number of removed keys.
+var invalidateTagScript = redis.NewScript(`
+local removed = 0
+for i = 2, #KEYS do
+	local key = KEYS[i]
+	if redis.call('SISMEMBER', '` + tagsKeyPrefix + `' .. key, ARGV[1]) == 1 then
+		removed = removed + redis.call('UNLINK', key)
+		redis.call('UNLINK', '` + versionKeyPrefix + `' .. key, '` + tagsKeyPrefix + `' .. key)
+	end
+	redis.call('SREM', KEYS[1], key)
+end
+return removed
+`)
 
 type RedisCache struct {
 	ctx    context.Context
 	rdb    *redis.Client
 	logger *zerolog.Logger
 
-	// The time to live for each item in the cache - 0 means no expiration
-	ttl time.Duration
+	// The time to live for each item in the cache in nanoseconds - 0 means no expiration. It is shared by the copies
+	// of the cache and can change at runtime, see Reconfigure.
+	ttl *atomic.Int64
+	// prefix is prepended to every key of the namespace of the cache, empty for the default namespace
+	prefix string
 }
 
 func NewRedisCache(
@@ -40,22 +158,128 @@ func NewRedisCache(
 		return nil, err
 	}
 
-	return &RedisCache{
+	r := &RedisCache{
 		ctx:    ctx,
 		logger: logger,
 		rdb:    client,
-		ttl:    time.Duration(cacheConfig.TTLSec) * time.Second}, nil
+		ttl:    new(atomic.Int64),
+	}
+	r.Reconfigure(*cacheConfig)
+	return r, nil
+}
+
+// Reconfigure applies the TTL of the config to the values written from now on. The other settings do not apply, as
+// redis evicts keys on its own.
+func (r *RedisCache) Reconfigure(cacheConfig config.CacheConfig) {
+	r.ttl.Store(int64(time.Duration(cacheConfig.TTLSec) * time.Second))
 }
 
+// currentTTL returns the time to live of the values written now
+func (r RedisCache) currentTTL() time.Duration {
+	return time.Duration(r.ttl.Load())
+}
+
+// ttlOr returns ttl, or the time to live of the values written now if it is 0
+func (r RedisCache) ttlOr(ttl time.Duration) time.Duration {
+	if ttl > 0 {
+		return ttl
+	}
+	return r.currentTTL()
+}
+
+// Namespace returns a cache sharing the redis connection of r whose keys live under their own prefix, isolated from
+// the default namespace and the other namespaces. Only the TTL of the config applies, as redis evicts keys on its own.
+func (r RedisCache) Namespace(name string, cacheConfig config.CacheConfig) *RedisCache {
+	r.prefix = namespaceKeyPrefix + name + ":"
+	r.ttl = new(atomic.Int64)
+	r.Reconfigure(cacheConfig)
+	return &r
+}
+
+// incrementScript applies INCRBY, creating the key from the initial value first and clamping the result. A result
+// overflowing is set to the bound in the direction of the delta, if there is one.
+// KEYS: value key, version key, version sequence key.
+// ARGV: delta, initial value, ttl in milliseconds of a created key, min and max (empty means unbounded).
+// Returns the new value as a string.
+var incrementScript = redis.NewScript(`
+if redis.call('EXISTS', KEYS[1]) == 0 then
+	local ttl = tonumber(ARGV[3])
+	if ttl > 0 then
+		redis.call('SET', KEYS[1], ARGV[2], 'PX', ttl)
+	else
+		redis.call('SET', KEYS[1], ARGV[2])
+	end
+end
+local value = redis.pcall('INCRBY', KEYS[1], ARGV[1])
+if type(value) == 'table' then
+	local bound = ARGV[5]
+	if tonumber(ARGV[1]) < 0 then
+		bound = ARGV[4]
+	end
+	if bound == '' or not string.find(value.err, 'overflow') then
+		return redis.error_reply(value.err)
+	end
+	redis.call('SET', KEYS[1], bound, 'KEEPTTL')
+elseif ARGV[4] ~= '' and value < tonumber(ARGV[4]) then
+	redis.call('SET', KEYS[1], ARGV[4], 'KEEPTTL')
+elseif ARGV[5] ~= '' and value > tonumber(ARGV[5]) then
+	redis.call('SET', KEYS[1], ARGV[5], 'KEEPTTL')
+end
+local version = redis.call('INCR', KEYS[3])
+local pttl = redis.call('PTTL', KEYS[1])
+if pttl > 0 then
+	redis.call('SET', KEYS[2], version, 'PX', pttl)
+else
+	redis.call('SET', KEYS[2], version)
+end
+return redis.call('GET', KEYS[1])
+`)
+
+// rateLimitScript takes a token from a token bucket kept in a hash, using the clock of redis so every instance sees
+// the same time. The bucket expires once it would be full again, as a new bucket starts full anyway.
+// KEYS: bucket. ARGV: rate in tokens per second, burst. Returns {1, 0} if a token was taken, otherwise {0, the
+// milliseconds until the next token}.
+var rateLimitScript = redis.NewScript(`
+local rate = tonumber(ARGV[1])
+local burst = tonumber(ARGV[2])
+local time = redis.call('TIME')
+local now = tonumber(time[1]) * 1000 + math.floor(tonumber(time[2]) / 1000)
+local state = redis.call('HMGET', KEYS[1], 'tokens', 'ts')
+local tokens = tonumber(state[1]) or burst
+local ts = tonumber(state[2]) or now
+tokens = math.min(burst, tokens + math.max(0, now - ts) * rate / 1000)
+local allowed, wait = 0, 0
+if tokens >= 1 then
+	tokens = tokens - 1
+	allowed = 1
+else
+	wait = math.ceil((1 - tokens) * 1000 / rate)
+end
+redis.call('HSET', KEYS[1], 'tokens', tostring(tokens), 'ts', now)
+redis.call('PEXPIRE', KEYS[1], math.ceil((burst - tokens) * 1000 / rate) + 1)
+return {allowed, wait}
+`)
+
 func (r RedisCache) Set(key string, value string) error {
-	if err := r.rdb.Set(r.ctx, key, value, r.ttl).Err(); err != nil {
+	return r.SetWithTags(key, value, nil)
+}
+
+// SetWithTags stores the value and attaches the tags to it, replacing the tags of the previous value
+func (r RedisCache) SetWithTags(key string, value string, tags []string) error {
+	return r.SetWithTTL(key, value, tags, 0)
+}
+
+// SetWithTTL is SetWithTags keeping the value for ttl rather than the TTL of the cache, unless ttl is 0
+func (r RedisCache) SetWithTTL(key string, value string, tags []string, ttl time.Duration) error {
+	args := append([]interface{}{value, r.ttlOr(ttl).Milliseconds(), r.tagIndexPrefix()}, stringsToArgs(tags)...)
+	if err := setScript.Run(r.ctx, r.rdb, writeKeys(r.key(key)), args...).Err(); err != nil {
 		return err
 	}
 	return nil
 }
 
 func (r RedisCache) Get(key string) (string, bool) {
-	val, err := r.rdb.Get(r.ctx, key).Result()
+	val, err := r.rdb.Get(r.ctx, r.key(key)).Result()
 	if errors.Is(err, redis.Nil) {
 		return "", false
 	} else if err != nil {
@@ -64,3 +288,368 @@ func (r RedisCache) Get(key string) (string, bool) {
 	}
 	return val, true
 }
+
+// GetWithVersion returns the value, its version and whether the key was found. Keys that are missing, or were
+// written to redis without going through this cache, have version 0.
+func (r RedisCache) GetWithVersion(key string) (string, uint64, bool) {
+	values, err := r.rdb.MGet(r.ctx, r.key(key), versionKey(r.key(key))).Result()
+	if err != nil {
+		r.logger.Error().Err(err).Msg("Failed to get value from redis cache")
+		return "", 0, false
+	}
+	value, ok := values[0].(string)
+	if !ok {
+		return "", 0, false
+	}
+	var version uint64
+	if versionStr, ok := values[1].(string); ok {
+		version, err = strconv.ParseUint(versionStr, 10, 64)
+		if err != nil {
+			r.logger.Warn().Err(err).Str("key", key).Msg("Invalid version in redis cache")
+		}
+	}
+	return value, version, true
+}
+
+// CompareAndSwap stores value only if the current version of key is expectedVersion and returns the new version.
+// The check and the write run in a single lua script, so they are atomic.
+func (r RedisCache) CompareAndSwap(key string, expectedVersion uint64, value string) (uint64, bool, error) {
+	return r.CompareAndSwapWithTags(key, expectedVersion, value, nil)
+}
+
+// CompareAndSwapWithTags is CompareAndSwap attaching the tags to the new value
+func (r RedisCache) CompareAndSwapWithTags(key string, expectedVersion uint64, value string, tags []string) (uint64, bool, error) {
+	return r.CompareAndSwapWithTTL(key, expectedVersion, value, tags, 0)
+}
+
+// CompareAndSwapWithTTL is CompareAndSwapWithTags keeping the new value for ttl rather than the TTL of the cache,
+// unless ttl is 0
+func (r RedisCache) CompareAndSwapWithTTL(key string, expectedVersion uint64, value string, tags []string,
+	ttl time.Duration) (uint64, bool, error) {
+	args := append([]interface{}{value, r.ttlOr(ttl).Milliseconds(), r.tagIndexPrefix(), expectedVersion},
+		stringsToArgs(tags)...)
+	result, err := compareAndSwapScript.Run(r.ctx, r.rdb, writeKeys(r.key(key)), args...).Int64Slice()
+	if err != nil {
+		return 0, false, err
+	}
+	return uint64(result[1]), result[0] == 1, nil
+}
+
+// InvalidateTag removes every key carrying the tag and returns how many were removed. The tag index set is walked
+// with SSCAN and removed in batches, so redis is not blocked by a tag with many keys.
+func (r RedisCache) InvalidateTag(tag string) (int, error) {
+	indexKey := r.tagIndexPrefix() + tag
+	var cursor uint64
+	invalidated := 0
+	for {
+		members, next, err := r.rdb.SScan(r.ctx, indexKey, cursor, "", deleteBatchSize).Result()
+		if err != nil {
+			return invalidated, err
+		}
+		if len(members) > 0 {
+			keys := append([]string{indexKey}, members...)
+			removed, err := invalidateTagScript.Run(r.ctx, r.rdb, keys, tag).Int()
+			if err != nil {
+				return invalidated, err
+			}
+			invalidated += removed
+		}
+		cursor = next
+		if cursor == 0 {
+			return invalidated, nil
+		}
+	}
+}
+
+// Increment adds delta to the integer stored at key with INCRBY and returns the new value
+func (r RedisCache) Increment(key string, delta int64, opts server.CounterOptions) (int64, error) {
+	ttl := r.currentTTL()
+	if opts.TTL > 0 {
+		ttl = opts.TTL
+	}
+	var minArg, maxArg string
+	if opts.Min != nil {
+		minArg = strconv.FormatInt(*opts.Min, 10)
+	}
+	if opts.Max != nil {
+		maxArg = strconv.FormatInt(*opts.Max, 10)
+	}
+	keys := []string{r.key(key), versionKey(r.key(key)), versionSeqKey}
+	result, err := incrementScript.Run(r.ctx, r.rdb, keys, delta, opts.Initial, ttl.Milliseconds(), minArg, maxArg).Text()
+	if err != nil {
+		if strings.Contains(err.Error(), "not an integer") {
+			return 0, server.ErrNotInteger
+		}
+		if strings.Contains(err.Error(), "overflow") {
+			return 0, server.ErrOverflow
+		}
+		return 0, err
+	}
+	return strconv.ParseInt(result, 10, 64)
+}
+
+// Decrement subtracts delta from the integer stored at key and returns the new value
+func (r RedisCache) Decrement(key string, delta int64, opts server.CounterOptions) (int64, error) {
+	if delta == math.MinInt64 {
+		return 0, server.ErrOverflow
+	}
+	return r.Increment(key, -delta, opts)
+}
+
+// Check pings redis, so the cache is reported unusable while redis does not answer
+func (r RedisCache) Check(ctx context.Context) error {
+	return r.rdb.Ping(ctx).Err()
+}
+
+// Allow takes a token from the bucket of key shared by every instance using the same redis, so the limits apply to
+// the whole deployment rather than to each instance
+func (r RedisCache) Allow(key string, limit ratelimit.Limit) (bool, time.Duration, error) {
+	result, err := rateLimitScript.Run(r.ctx, r.rdb, []string{rateLimitKeyPrefix + key}, limit.Rate, limit.Burst).Int64Slice()
+	if err != nil {
+		return false, 0, err
+	}
+	return result[0] == 1, time.Duration(result[1]) * time.Millisecond, nil
+}
+
+// ScanKeys returns a page of keys using SCAN MATCH, so redis is never blocked by a full keyspace walk. The limit is
+// passed to SCAN as COUNT, so a page may hold a few more keys than asked for. The keys the cache keeps for its own
+// bookkeeping are skipped.
+func (r RedisCache) ScanKeys(opts server.ScanOptions) ([]server.KeyInfo, string, error) {
+	var cursor uint64
+	if opts.Cursor != "" {
+		var err error
+		cursor, err = strconv.ParseUint(opts.Cursor, 10, 64)
+		if err != nil {
+			return nil, "", server.ErrInvalidCursor
+		}
+	}
+	limit := opts.Limit
+	if limit <= 0 {
+		limit = server.DefaultScanLimit
+	}
+	match := r.scanPattern(opts.Prefix, opts.Pattern)
+	keys := make([]string, 0, limit)
+	for {
+		batch, next, err := r.rdb.Scan(r.ctx, cursor, match, int64(limit-len(keys))).Result()
+		if err != nil {
+			return nil, "", err
+		}
+		keys = append(keys, r.filterKeys(batch, opts.Prefix)...)
+		cursor = next
+		if cursor == 0 || len(keys) >= limit {
+			break
+		}
+	}
+
+	infos := make([]server.KeyInfo, 0, len(keys))
+	if !opts.WithTTL {
+		for _, key := range keys {
+			infos = append(infos, server.KeyInfo{Key: key})
+		}
+	} else {
+		pipe := r.rdb.Pipeline()
+		ttls := make([]*redis.DurationCmd, len(keys))
+		for i, key := range keys {
+			ttls[i] = pipe.PTTL(r.ctx, r.key(key))
+		}
+		if _, err := pipe.Exec(r.ctx); err != nil {
+			return nil, "", err
+		}
+		for i, key := range keys {
+			ttl := ttls[i].Val()
+			if ttl == -2 {
+				// the key was removed after it was scanned
+				continue
+			}
+			infos = append(infos, server.KeyInfo{Key: key, TTL: ttl})
+		}
+	}
+
+	nextCursor := ""
+	if cursor != 0 {
+		nextCursor = strconv.FormatUint(cursor, 10)
+	}
+	return infos, nextCursor, nil
+}
+
+// DeleteKeys removes the keys starting with prefix and matching the glob-style pattern along with their metadata. It
+// walks the keyspace with SCAN and removes each batch with UNLINK, which frees the memory in the background, so redis
+// is never blocked by a large invalidation.
+func (r RedisCache) DeleteKeys(prefix string, pattern string) (int, error) {
+	match := r.scanPattern(prefix, pattern)
+	var cursor uint64
+	deleted := 0
+	for {
+		batch, next, err := r.rdb.Scan(r.ctx, cursor, match, deleteBatchSize).Result()
+		if err != nil {
+			return deleted, err
+		}
+		keys := r.filterKeys(batch, prefix)
+		if len(keys) > 0 {
+			metaKeys := make([]string, 0, 2*len(keys))
+			for i, key := range keys {
+				keys[i] = r.key(key)
+				metaKeys = append(metaKeys, versionKey(keys[i]), tagsKey(keys[i]))
+			}
+			pipe := r.rdb.Pipeline()
+			unlinked := pipe.Unlink(r.ctx, keys...)
+			pipe.Unlink(r.ctx, metaKeys...)
+			if _, err := pipe.Exec(r.ctx); err != nil {
+				return deleted, err
+			}
+			deleted += int(unlinked.Val())
+		}
+		cursor = next
+		if cursor == 0 {
+			return deleted, nil
+		}
+	}
+}
+
+// Stats returns the number of keys in the namespace of the cache, counted with SCAN, and its TTL. The memory and
+// the hit, miss and eviction counters come from INFO, so they cover the whole redis server rather than the namespace.
+func (r RedisCache) Stats() (server.Stats, error) {
+	match := r.scanPattern("", "")
+	var cursor uint64
+	keys := 0
+	for {
+		batch, next, err := r.rdb.Scan(r.ctx, cursor, match, deleteBatchSize).Result()
+		if err != nil {
+			return server.Stats{}, err
+		}
+		keys += len(r.filterKeys(batch, ""))
+		cursor = next
+		if cursor == 0 {
+			break
+		}
+	}
+	// one section per INFO, as redis before 7 does not take several. The counters are left out rather than failing
+	// the stats if INFO is not available, e.g. on managed servers restricting it.
+	pipe := r.rdb.Pipeline()
+	statsInfo := pipe.Info(r.ctx, "stats")
+	memoryInfo := pipe.Info(r.ctx, "memory")
+	if _, err := pipe.Exec(r.ctx); err != nil {
+		r.logger.Warn().Err(err).Msg("Failed to get redis info")
+	}
+	fields := parseInfo(statsInfo.Val() + memoryInfo.Val())
+	return server.Stats{
+		Keys:      keys,
+		TTL:       r.currentTTL(),
+		Bytes:     int64(fields["used_memory"]),
+		Hits:      fields["keyspace_hits"],
+		Misses:    fields["keyspace_misses"],
+		Evictions: fields["evicted_keys"] + fields["expired_keys"],
+	}, nil
+}
+
+// parseInfo returns the integer fields of the output of INFO
+func parseInfo(info string) map[string]uint64 {
+	fields := make(map[string]uint64)
+	for _, line := range strings.Split(info, "\n") {
+		name, value, ok := strings.Cut(strings.TrimSpace(line), ":")
+		if !ok {
+			continue
+		}
+		if n, err := strconv.ParseUint(value, 10, 64); err == nil {
+			fields[name] = n
+		}
+	}
+	return fields
+}
+
+// Inspect returns the remaining TTL, the size, the version and the tags of key. Redis does not keep the creation
+// time of keys, so it is left zero.
+func (r RedisCache) Inspect(key string) (server.KeyMetadata, bool, error) {
+	pipe := r.rdb.Pipeline()
+	ttl := pipe.PTTL(r.ctx, r.key(key))
+	size := pipe.StrLen(r.ctx, r.key(key))
+	version := pipe.Get(r.ctx, versionKey(r.key(key)))
+	tags := pipe.SMembers(r.ctx, tagsKey(r.key(key)))
+	if _, err := pipe.Exec(r.ctx); err != nil && !errors.Is(err, redis.Nil) {
+		return server.KeyMetadata{}, false, err
+	}
+	// PTTL is -2 for a missing key and -1 for a key without expiry, go-redis returns them as is
+	if ttl.Val() == -2 {
+		return server.KeyMetadata{}, false, nil
+	}
+	metadata := server.KeyMetadata{TTL: ttl.Val(), Size: int(size.Val()), Tags: tags.Val()}
+	if ttl.Val() < 0 {
+		metadata.TTL = -1
+	}
+	metadata.Version, _ = strconv.ParseUint(version.Val(), 10, 64)
+	return metadata, true, nil
+}
+
+// Flush removes every key of the namespace of the cache along with its metadata
+func (r RedisCache) Flush() (int, error) {
+	return r.DeleteKeys("", "")
+}
+
+// scanPattern returns the SCAN MATCH pattern for the given prefix and pattern in the namespace of the cache. If both
+// are given the pattern is used and the prefix has to be checked on the results with filterKeys.
+func (r RedisCache) scanPattern(prefix string, pattern string) string {
+	if pattern != "" {
+		return escapeGlob(r.prefix) + pattern
+	}
+	return escapeGlob(r.prefix+prefix) + "*"
+}
+
+// filterKeys keeps the scanned keys starting with prefix and strips the namespace prefix from them. The keys the
+// cache keeps for its own bookkeeping and the keys of the namespaces are not part of the default namespace.
+func (r RedisCache) filterKeys(keys []string, prefix string) []string {
+	filtered := keys[:0]
+	for _, key := range keys {
+		if !strings.HasPrefix(key, r.prefix+prefix) {
+			continue
+		}
+		if r.prefix == "" && (strings.HasPrefix(key, metaKeyPrefix) || strings.HasPrefix(key, namespaceKeyPrefix)) {
+			continue
+		}
+		filtered = append(filtered, strings.TrimPrefix(key, r.prefix))
+	}
+	return filtered
+}
+
+// escapeGlob escapes the characters that have a special meaning in redis glob-style patterns
+func escapeGlob(s string) string {
+	var b strings.Builder
+	for _, ch := range s {
+		switch ch {
+		case '*', '?', '[', ']', '\\':
+			b.WriteRune('\\')
+		}
+		b.WriteRune(ch)
+	}
+	return b.String()
+}
+
+// key returns the redis key of the given key in the namespace of the cache
+func (r RedisCache) key(key string) string {
+	return r.prefix + key
+}
+
+// tagIndexPrefix returns the prefix of the tag index sets of the namespace of the cache
+func (r RedisCache) tagIndexPrefix() string {
+	return tagKeyPrefix + r.prefix
+}
+
+// writeKeys returns the KEYS of the scripts storing a value, see writeLua
+func writeKeys(key string) []string {
+	return []string{key, versionKey(key), versionSeqKey, tagsKey(key)}
+}
+
+func stringsToArgs(values []string) []interface{} {
+	args := make([]interface{}, len(values))
+	for i, value := range values {
+		args[i] = value
+	}
+	return args
+}
+
+func tagsKey(key string) string {
+	return tagsKeyPrefix + key
+}
+
+func versionKey(key string) string {
+	return versionKeyPrefix + key
+}
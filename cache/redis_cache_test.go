@@ -2,6 +2,8 @@ package cache
 
 import (
 	"cache-api/config"
+	"cache-api/ratelimit"
+	"cache-api/server"
 	"context"
 	"errors"
 	"fmt"
@@ -147,6 +149,482 @@ func TestRedisCache_Get(t *testing.T) {
 	})
 }
 
+func TestRedisCache_CompareAndSwap(t *testing.T) {
+	connectionString := setupRedis(t)
+	ctx := context.Background()
+	rdb := redis.NewClient(&redis.Options{
+		Addr: connectionString,
+	})
+	logger := zerolog.Nop()
+	cache, err := NewRedisCache(ctx, &config.CacheConfig{TTLSec: 0}, &config.RedisConfig{Host: connectionString}, &logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("create and swap", func(t *testing.T) {
+		version, swapped, err := cache.CompareAndSwap("casKey", 0, "first")
+		if err != nil || !swapped {
+			t.Fatalf("Expected creating a missing key to succeed, got swapped=%v err=%v", swapped, err)
+		}
+		value, gotVersion, ok := cache.GetWithVersion("casKey")
+		if !ok || value != "first" || gotVersion != version {
+			t.Fatalf("Expected (first, %d), got (%s, %d)", version, value, gotVersion)
+		}
+
+		err = cache.Set("casKey", "concurrent")
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, swapped, err = cache.CompareAndSwap("casKey", version, "stale")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if swapped {
+			t.Errorf("Expected swap with a stale version to fail")
+		}
+		got, err := rdb.Get(ctx, "casKey").Result()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != "concurrent" {
+			t.Errorf("Expected value to stay 'concurrent' but got %s", got)
+		}
+	})
+
+	t.Run("key written outside of the cache has version 0", func(t *testing.T) {
+		err := rdb.Set(ctx, "plainKey", "plain", 0).Err()
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, version, ok := cache.GetWithVersion("plainKey")
+		if !ok || version != 0 {
+			t.Errorf("Expected version 0 but got %d", version)
+		}
+		_, swapped, err := cache.CompareAndSwap("plainKey", 0, "versioned")
+		if err != nil || !swapped {
+			t.Errorf("Expected swap to succeed, got swapped=%v err=%v", swapped, err)
+		}
+	})
+}
+
+func TestRedisCache_Increment(t *testing.T) {
+	connectionString := setupRedis(t)
+	ctx := context.Background()
+	rdb := redis.NewClient(&redis.Options{
+		Addr: connectionString,
+	})
+	logger := zerolog.Nop()
+	cache, err := NewRedisCache(ctx, &config.CacheConfig{TTLSec: 0}, &config.RedisConfig{Host: connectionString}, &logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("missing key starts from initial", func(t *testing.T) {
+		got, err := cache.Increment("counter", 2, server.CounterOptions{Initial: 5, TTL: time.Minute})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != 7 {
+			t.Errorf("Expected 7 but got %d", got)
+		}
+		ttl, err := rdb.PTTL(ctx, "counter").Result()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ttl <= 0 || ttl > time.Minute {
+			t.Errorf("Expected the counter to expire within a minute but got %s", ttl)
+		}
+	})
+
+	t.Run("clamped to bounds", func(t *testing.T) {
+		minValue, maxValue := int64(0), int64(10)
+		got, err := cache.Increment("counter", 100, server.CounterOptions{Max: &maxValue})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != 10 {
+			t.Errorf("Expected 10 but got %d", got)
+		}
+		got, err = cache.Decrement("counter", 100, server.CounterOptions{Min: &minValue})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != 0 {
+			t.Errorf("Expected 0 but got %d", got)
+		}
+	})
+
+	t.Run("not an integer", func(t *testing.T) {
+		err := cache.Set("text", "abc")
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = cache.Increment("text", 1, server.CounterOptions{})
+		if !errors.Is(err, server.ErrNotInteger) {
+			t.Errorf("Expected ErrNotInteger but got %v", err)
+		}
+	})
+
+	t.Run("overflow", func(t *testing.T) {
+		if err := cache.Set("big", "9223372036854775807"); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := cache.Increment("big", 1, server.CounterOptions{}); !errors.Is(err, server.ErrOverflow) {
+			t.Errorf("Expected ErrOverflow but got %v", err)
+		}
+		maxValue := int64(10)
+		got, err := cache.Increment("big", 1, server.CounterOptions{Max: &maxValue})
+		if err != nil || got != 10 {
+			t.Errorf("Expected the overflow clamped to 10 but got %d, %v", got, err)
+		}
+	})
+}
+
+func TestRedisCache_ScanKeys(t *testing.T) {
+	connectionString := setupRedis(t)
+	ctx := context.Background()
+	logger := zerolog.Nop()
+	cache, err := NewRedisCache(ctx, &config.CacheConfig{TTLSec: 60}, &config.RedisConfig{Host: connectionString}, &logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 25; i++ {
+		err = cache.Set(fmt.Sprintf("user:%02d", i), "value")
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err = cache.Set("order:1", "value")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	seen := map[string]bool{}
+	cursor := ""
+	for {
+		keys, next, err := cache.ScanKeys(server.ScanOptions{Prefix: "user:", Cursor: cursor, Limit: 10, WithTTL: true})
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, info := range keys {
+			if info.TTL <= 0 {
+				t.Errorf("Expected '%s' to have a positive TTL, got %s", info.Key, info.TTL)
+			}
+			seen[info.Key] = true
+		}
+		if next == "" {
+			break
+		}
+		cursor = next
+	}
+	if len(seen) != 25 {
+		t.Errorf("Expected 25 keys but got %d: %v", len(seen), seen)
+	}
+	for key := range seen {
+		if key == "order:1" || key == versionKey("user:00") {
+			t.Errorf("Expected '%s' not to be listed", key)
+		}
+	}
+}
+
+func TestRedisCache_DeleteKeys(t *testing.T) {
+	connectionString := setupRedis(t)
+	ctx := context.Background()
+	rdb := redis.NewClient(&redis.Options{
+		Addr: connectionString,
+	})
+	logger := zerolog.Nop()
+	cache, err := NewRedisCache(ctx, &config.CacheConfig{TTLSec: 0}, &config.RedisConfig{Host: connectionString}, &logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2*deleteBatchSize+10; i++ {
+		err = cache.Set(fmt.Sprintf("tenant42:%d", i), "value")
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, key := range []string{"tenant4:1", "other:session"} {
+		err = cache.Set(key, "value")
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	deleted, err := cache.DeleteKeys("tenant42:", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if deleted != 2*deleteBatchSize+10 {
+		t.Errorf("Expected %d keys to be deleted but got %d", 2*deleteBatchSize+10, deleted)
+	}
+	exists, err := rdb.Exists(ctx, "tenant42:0", versionKey("tenant42:0")).Result()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exists != 0 {
+		t.Errorf("Expected the key and its version to be deleted")
+	}
+
+	deleted, err = cache.DeleteKeys("", "*:session")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if deleted != 1 {
+		t.Errorf("Expected 1 key to be deleted but got %d", deleted)
+	}
+	if _, ok := cache.Get("tenant4:1"); !ok {
+		t.Errorf("Expected 'tenant4:1' to be kept")
+	}
+}
+
+func TestRedisCache_InvalidateTag(t *testing.T) {
+	connectionString := setupRedis(t)
+	ctx := context.Background()
+	rdb := redis.NewClient(&redis.Options{
+		Addr: connectionString,
+	})
+	logger := zerolog.Nop()
+	cache, err := NewRedisCache(ctx, &config.CacheConfig{TTLSec: 60}, &config.RedisConfig{Host: connectionString}, &logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tagged := map[string][]string{
+		"page:1": {"product:12", "category:4"},
+		"page:2": {"product:12"},
+		"page:3": {"category:4"},
+	}
+	for key, tags := range tagged {
+		err = cache.SetWithTags(key, "value", tags)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	// re-writing page:4 without the tag must drop it from the index
+	err = cache.SetWithTags("page:4", "value", []string{"product:12"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = cache.Set("page:4", "value")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	invalidated, err := cache.InvalidateTag("product:12")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if invalidated != 2 {
+		t.Errorf("Expected 2 keys to be invalidated but got %d", invalidated)
+	}
+	exists, err := rdb.Exists(ctx, "page:1", "page:2", versionKey("page:1"), tagsKey("page:1")).Result()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exists != 0 {
+		t.Errorf("Expected the invalidated keys and their metadata to be removed")
+	}
+	for _, key := range []string{"page:3", "page:4"} {
+		if _, ok := cache.Get(key); !ok {
+			t.Errorf("Expected '%s' to be kept", key)
+		}
+	}
+	ttl, err := rdb.PTTL(ctx, tagKeyPrefix+"category:4").Result()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ttl <= 0 || ttl > time.Minute {
+		t.Errorf("Expected the tag index to expire with its keys, got %s", ttl)
+	}
+}
+
+func TestRedisCache_Namespace(t *testing.T) {
+	connectionString := setupRedis(t)
+	ctx := context.Background()
+	logger := zerolog.Nop()
+	root, err := NewRedisCache(ctx, &config.CacheConfig{TTLSec: 0}, &config.RedisConfig{Host: connectionString}, &logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	teamA := root.Namespace("team-a", config.CacheConfig{TTLSec: 60})
+	teamB := root.Namespace("team-b", config.CacheConfig{})
+
+	for _, c := range []*RedisCache{root, teamA, teamB} {
+		err = c.SetWithTags("key", c.prefix, []string{"tag"})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, c := range []*RedisCache{root, teamA, teamB} {
+		value, ok := c.Get("key")
+		if !ok || value != c.prefix {
+			t.Errorf("Expected the namespace %q to hold its own value but got %q", c.prefix, value)
+		}
+		keys, _, err := c.ScanKeys(server.ScanOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(keys) != 1 || keys[0].Key != "key" {
+			t.Errorf("Expected the namespace %q to list only its own key but got %v", c.prefix, keys)
+		}
+	}
+
+	invalidated, err := teamA.InvalidateTag("tag")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if invalidated != 1 {
+		t.Errorf("Expected 1 key to be invalidated but got %d", invalidated)
+	}
+	if _, ok := teamB.Get("key"); !ok {
+		t.Errorf("Expected invalidating a tag not to affect the other namespaces")
+	}
+
+	stats, err := teamB.Stats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Keys != 1 {
+		t.Errorf("Expected 1 key in the namespace but got %d", stats.Keys)
+	}
+}
+
+func TestRedisCache_Check(t *testing.T) {
+	connectionString := setupRedis(t)
+	ctx := context.Background()
+	logger := zerolog.Nop()
+	c, err := NewRedisCache(ctx, &config.CacheConfig{}, &config.RedisConfig{Host: connectionString}, &logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Check(ctx); err != nil {
+		t.Errorf("Expected redis to be usable, got %v", err)
+	}
+	_ = c.rdb.Close()
+	if err := c.Check(ctx); err == nil {
+		t.Errorf("Expected redis not to be usable once the connection is closed")
+	}
+}
+
+func TestRedisCache_Admin(t *testing.T) {
+	connectionString := setupRedis(t)
+	ctx := context.Background()
+	logger := zerolog.Nop()
+	root, err := NewRedisCache(ctx, &config.CacheConfig{TTLSec: 60}, &config.RedisConfig{Host: connectionString}, &logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	teamA := root.Namespace("team-a", config.CacheConfig{})
+	if err := root.SetWithTags("key", "value", []string{"tag"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := teamA.Set("key", "value"); err != nil {
+		t.Fatal(err)
+	}
+
+	metadata, ok, err := root.Inspect("key")
+	if err != nil || !ok {
+		t.Fatalf("Expected the key to be found, got %v", err)
+	}
+	if metadata.Size != len("value") || metadata.Version == 0 || len(metadata.Tags) != 1 {
+		t.Errorf("Unexpected metadata %+v", metadata)
+	}
+	if metadata.TTL <= 0 || metadata.TTL > time.Minute {
+		t.Errorf("Expected a remaining ttl of at most a minute but got %s", metadata.TTL)
+	}
+	if metadata, _, _ := teamA.Inspect("key"); metadata.TTL != -1 {
+		t.Errorf("Expected a ttl of -1 for a key without expiry but got %s", metadata.TTL)
+	}
+	if _, ok, err := root.Inspect("missing"); ok || err != nil {
+		t.Errorf("Expected a missing key not to be found, got %v", err)
+	}
+
+	root.Get("key")
+	stats, err := root.Stats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Keys != 1 || stats.Hits == 0 {
+		t.Errorf("Expected 1 key and the hits of redis but got %+v", stats)
+	}
+
+	flushed, err := root.Flush()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if flushed != 1 {
+		t.Errorf("Expected 1 key to be flushed but got %d", flushed)
+	}
+	if _, ok := teamA.Get("key"); !ok {
+		t.Errorf("Expected flushing the default namespace not to affect the other namespaces")
+	}
+}
+
+func TestRedisCache_Allow(t *testing.T) {
+	connectionString := setupRedis(t)
+	ctx := context.Background()
+	logger := zerolog.Nop()
+	// two caches sharing a redis act as two instances of the service
+	first, err := NewRedisCache(ctx, &config.CacheConfig{}, &config.RedisConfig{Host: connectionString}, &logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := NewRedisCache(ctx, &config.CacheConfig{}, &config.RedisConfig{Host: connectionString}, &logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	limit := ratelimit.Limit{Rate: 1, Burst: 2}
+
+	for _, c := range []*RedisCache{first, second} {
+		allowed, _, err := c.Allow("client", limit)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !allowed {
+			t.Errorf("Expected the burst to be allowed")
+		}
+	}
+	allowed, retryAfter, err := first.Allow("client", limit)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if allowed {
+		t.Errorf("Expected the limit to be shared by both instances")
+	}
+	if retryAfter <= 0 || retryAfter > time.Second {
+		t.Errorf("Expected to retry within a second but got %s", retryAfter)
+	}
+	if allowed, _, _ := second.Allow("other", limit); !allowed {
+		t.Errorf("Expected another client to have its own bucket")
+	}
+	keys, _, err := first.ScanKeys(server.ScanOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 0 {
+		t.Errorf("Expected the buckets to be hidden from the keys but got %v", keys)
+	}
+}
+
+func TestParseInfo(t *testing.T) {
+	info := "# Stats\r\nkeyspace_hits:12\r\nkeyspace_misses:3\r\ninstantaneous_input_kbps:0.01\r\n\r\n# Memory\r\nused_memory:1024\r\n"
+	fields := parseInfo(info)
+	if fields["keyspace_hits"] != 12 || fields["keyspace_misses"] != 3 || fields["used_memory"] != 1024 {
+		t.Errorf("Unexpected fields %v", fields)
+	}
+	if _, ok := fields["instantaneous_input_kbps"]; ok {
+		t.Errorf("Expected non integer fields to be skipped")
+	}
+}
+
+func TestEscapeGlob(t *testing.T) {
+	got := escapeGlob(`user:*?[x]\`)
+	expected := `user:\*\?\[x\]\\`
+	if got != expected {
+		t.Errorf("Expected %s but got %s", expected, got)
+	}
+}
+
 func setupRedis(t *testing.T) string {
 	ctx := context.Background()
 
@@ -164,3 +642,52 @@ func setupRedis(t *testing.T) string {
 	}
 	return fmt.Sprintf("%s:%d", host, port.Int())
 }
+
+func TestRedisCache_Reconfigure(t *testing.T) {
+	connectionString := setupRedis(t)
+	ctx := context.Background()
+	logger := zerolog.Nop()
+	root, err := NewRedisCache(ctx, &config.CacheConfig{TTLSec: 60}, &config.RedisConfig{Host: connectionString}, &logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	teamA := root.Namespace("team-a", config.CacheConfig{TTLSec: 60})
+	root.Reconfigure(config.CacheConfig{TTLSec: 10})
+	if err := root.Set("key", "value"); err != nil {
+		t.Fatal(err)
+	}
+	ttl, err := root.rdb.TTL(ctx, "key").Result()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ttl > 10*time.Second {
+		t.Errorf("Expected the new ttl of 10s to apply, got %v", ttl)
+	}
+	if stats, _ := teamA.Stats(); stats.TTL != time.Minute {
+		t.Errorf("Expected the namespace to keep its ttl, got %v", stats.TTL)
+	}
+}
+
+func TestRedisCache_SetWithTTL(t *testing.T) {
+	connectionString := setupRedis(t)
+	ctx := context.Background()
+	logger := zerolog.Nop()
+	cache, err := NewRedisCache(ctx, &config.CacheConfig{TTLSec: 10}, &config.RedisConfig{Host: connectionString}, &logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cache.SetWithTTL("key", "value", []string{"tag"}, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	if ttl, _ := cache.rdb.TTL(ctx, "key").Result(); ttl <= 59*time.Minute {
+		t.Errorf("Expected a ttl close to 1h, got %v", ttl)
+	}
+	_, version, _ := cache.GetWithVersion("key")
+	_, swapped, err := cache.CompareAndSwapWithTTL("key", version, "new value", nil, time.Minute)
+	if err != nil || !swapped {
+		t.Fatalf("Expected the swap to succeed, got %t %v", swapped, err)
+	}
+	if ttl, _ := cache.rdb.TTL(ctx, "key").Result(); ttl > time.Minute {
+		t.Errorf("Expected a ttl of at most 1m, got %v", ttl)
+	}
+}
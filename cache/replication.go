@@ -0,0 +1,408 @@
+package cache
+
+import (
+	"bufio"
+	"cache-api/config"
+	"cache-api/server"
+	"context"
+	"crypto/subtle"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+// The operations of the replication stream
+const (
+	opSet    = "set"
+	opDelete = "delete"
+	opFlush  = "flush"
+	// opSynced ends the snapshot a follower starts from, the changes made after it follow
+	opSynced = "synced"
+	// opPing keeps an idle stream alive, so followers can tell a silent primary from a dead one
+	opPing  = "ping"
+	opError = "error"
+)
+
+const (
+	// replicationHeartbeat is how often the primary pings idle followers, followers give up on a primary silent for
+	// three heartbeats
+	replicationHeartbeat = 5 * time.Second
+	// replicationTimeout bounds the handshake and every write to a follower
+	replicationTimeout = 10 * time.Second
+	// followerMinBackoff and followerMaxBackoff bound the wait of a follower between attempts to reach the primary
+	followerMinBackoff = 100 * time.Millisecond
+	followerMaxBackoff = 5 * time.Second
+)
+
+var (
+	errNotSynced       = errors.New("the replica has not synced from the primary yet")
+	errFollowerDropped = errors.New("the follower fell behind and is dropped, it resyncs from a snapshot")
+)
+
+// replicationHello is the first message of a follower, naming the cache it replicates
+type replicationHello struct {
+	Token string `json:"token"`
+	Cache string `json:"cache"`
+}
+
+// replicationMessage is a line of the stream from the primary to a follower
+type replicationMessage struct {
+	Op        string          `json:"op"`
+	Key       string          `json:"key,omitempty"`
+	Value     json.RawMessage `json:"value,omitempty"`
+	ExpiresAt int64           `json:"expires_at,omitempty"`
+	CreatedAt int64           `json:"created_at,omitempty"`
+	Version   uint64          `json:"version,omitempty"`
+	Tags      []string        `json:"tags,omitempty"`
+	Error     string          `json:"error,omitempty"`
+}
+
+// change is a write to the items of a cache, sent to the followers in the order the cache applied it
+type change[T any] struct {
+	op   string
+	key  string
+	item cacheItem[T]
+}
+
+// replicaStream queues the changes of a cache for a follower. dropped is closed when the follower fell so far behind
+// that the queue was full, after which the stream receives no more changes.
+type replicaStream[T any] struct {
+	changes chan change[T]
+	dropped chan struct{}
+}
+
+// follow registers a stream of the changes made from now on and returns the items the changes apply to. Both are
+// taken under the write lock, so no change is missed or applied twice.
+func (c *Cache[T]) follow(buffer int) (map[string]cacheItem[T], *replicaStream[T]) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	snapshot := make(map[string]cacheItem[T], len(c.items))
+	for key, item := range c.items {
+		item.element = nil
+		snapshot[key] = item
+	}
+	stream := &replicaStream[T]{changes: make(chan change[T], buffer), dropped: make(chan struct{})}
+	if c.followers == nil {
+		c.followers = make(map[*replicaStream[T]]struct{})
+	}
+	c.followers[stream] = struct{}{}
+	return snapshot, stream
+}
+
+// unfollow stops the changes to the stream
+func (c *Cache[T]) unfollow(stream *replicaStream[T]) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	delete(c.followers, stream)
+}
+
+// emit queues the change for every follower without blocking, dropping the followers whose queue is full. The
+// caller must hold the write lock.
+func (c *Cache[T]) emit(op string, key string, item cacheItem[T]) {
+	if len(c.followers) == 0 {
+		return
+	}
+	item.element = nil
+	for stream := range c.followers {
+		select {
+		case stream.changes <- change[T]{op: op, key: key, item: item}:
+		default:
+			close(stream.dropped)
+			delete(c.followers, stream)
+		}
+	}
+}
+
+// replace swaps the items for the ones of a snapshot, keeping the versions increasing. Only the keys missing from the
+// snapshot are deleted and only the keys it changes are set, so a resync shows the eviction callbacks and the
+// watchers what changed while the follower was away rather than a flush of every key.
+func (c *Cache[T]) replace(items map[string]cacheItem[T]) {
+	c.mutex.Lock()
+	defer c.unlock()
+	for key := range c.items {
+		if _, ok := items[key]; !ok {
+			c.remove(key, server.EventDelete)
+		}
+	}
+	for key, item := range items {
+		c.lastVersion = max(c.lastVersion, item.version)
+		// the versions come from the primary, so the same version is the same write
+		if current, ok := c.items[key]; ok && current.version == item.version && current.expiresAt == item.expiresAt {
+			continue
+		}
+		c.put(key, item)
+	}
+}
+
+// apply makes a change received from the primary
+func (c *Cache[T]) apply(op string, key string, item cacheItem[T]) {
+	c.mutex.Lock()
+	defer c.unlock()
+	switch op {
+	case opSet:
+		c.lastVersion = max(c.lastVersion, item.version)
+		c.put(key, item)
+	case opDelete:
+		c.remove(key, server.EventDelete)
+	case opFlush:
+		c.reset()
+	}
+}
+
+// Primary streams the changes of in-memory caches to the followers connecting to it. Each follower starts from a
+// snapshot of its cache, then receives every change made after the snapshot, in order.
+type Primary[T any] struct {
+	caches map[string]*Cache[T]
+	token  string
+	buffer int
+	logger *zerolog.Logger
+}
+
+// NewPrimary returns a primary serving the caches of the map by their name
+func NewPrimary[T any](caches map[string]*Cache[T], conf config.ReplicationConfig, logger *zerolog.Logger) *Primary[T] {
+	return &Primary[T]{caches: caches, token: conf.Token, buffer: conf.Buffer, logger: logger}
+}
+
+// Serve accepts followers on listener until ctx is done, then closes it along with the connections of the followers
+func (p *Primary[T]) Serve(ctx context.Context, listener net.Listener) error {
+	stop := context.AfterFunc(ctx, func() { _ = listener.Close() })
+	defer stop()
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			p.serveFollower(ctx, conn)
+		}()
+	}
+}
+
+func (p *Primary[T]) serveFollower(ctx context.Context, conn net.Conn) {
+	defer conn.Close()
+	stop := context.AfterFunc(ctx, func() { _ = conn.Close() })
+	defer stop()
+	logger := p.logger.With().Str("follower", conn.RemoteAddr().String()).Logger()
+	writer := bufio.NewWriter(conn)
+	encoder := json.NewEncoder(writer)
+	send := func(message replicationMessage) error {
+		_ = conn.SetWriteDeadline(time.Now().Add(replicationTimeout))
+		return encoder.Encode(message)
+	}
+	flush := func() error {
+		_ = conn.SetWriteDeadline(time.Now().Add(replicationTimeout))
+		return writer.Flush()
+	}
+
+	var hello replicationHello
+	_ = conn.SetReadDeadline(time.Now().Add(replicationTimeout))
+	if err := json.NewDecoder(conn).Decode(&hello); err != nil {
+		logger.Warn().Err(err).Msg("Failed to read the hello of a follower")
+		return
+	}
+	if subtle.ConstantTimeCompare([]byte(hello.Token), []byte(p.token)) != 1 {
+		logger.Warn().Msg("Rejected a follower with an invalid token")
+		_ = send(replicationMessage{Op: opError, Error: "invalid token"})
+		_ = flush()
+		return
+	}
+	c, ok := p.caches[hello.Cache]
+	if !ok {
+		logger.Warn().Str("cache", hello.Cache).Msg("Rejected a follower of an unknown cache")
+		_ = send(replicationMessage{Op: opError, Error: fmt.Sprintf("unknown cache %q", hello.Cache)})
+		_ = flush()
+		return
+	}
+	logger = logger.With().Str("cache", hello.Cache).Logger()
+
+	snapshot, stream := c.follow(p.buffer)
+	defer c.unfollow(stream)
+	for key, item := range snapshot {
+		message, err := encodeChange(opSet, key, item)
+		if err == nil {
+			err = send(message)
+		}
+		if err != nil {
+			logger.Warn().Err(err).Msg("Failed to send the snapshot")
+			return
+		}
+	}
+	if err := send(replicationMessage{Op: opSynced}); err != nil {
+		logger.Warn().Err(err).Msg("Failed to send the snapshot")
+		return
+	}
+	if err := flush(); err != nil {
+		logger.Warn().Err(err).Msg("Failed to send the snapshot")
+		return
+	}
+	logger.Info().Int("keys", len(snapshot)).Msg("Follower synced")
+
+	heartbeat := time.NewTicker(replicationHeartbeat)
+	defer heartbeat.Stop()
+	for {
+		var err error
+		select {
+		case ch := <-stream.changes:
+			var message replicationMessage
+			message, err = encodeChange(ch.op, ch.key, ch.item)
+			if err == nil {
+				err = send(message)
+			}
+			if err == nil && len(stream.changes) == 0 {
+				err = flush()
+			}
+		case <-heartbeat.C:
+			if err = send(replicationMessage{Op: opPing}); err == nil {
+				err = flush()
+			}
+		case <-stream.dropped:
+			err = errFollowerDropped
+		case <-ctx.Done():
+			return
+		}
+		if err != nil {
+			if ctx.Err() == nil {
+				logger.Warn().Err(err).Msg("Stopped streaming to follower")
+			}
+			return
+		}
+	}
+}
+
+func encodeChange[T any](op string, key string, item cacheItem[T]) (replicationMessage, error) {
+	message := replicationMessage{Op: op, Key: key}
+	if op != opSet {
+		return message, nil
+	}
+	value, err := json.Marshal(item.value)
+	if err != nil {
+		return message, err
+	}
+	message.Value = value
+	message.ExpiresAt = item.expiresAt
+	message.CreatedAt = item.createdAt
+	message.Version = item.version
+	message.Tags = item.tags
+	return message, nil
+}
+
+func decodeChange[T any](message replicationMessage) (cacheItem[T], error) {
+	item := cacheItem[T]{
+		expiresAt: message.ExpiresAt,
+		createdAt: message.CreatedAt,
+		version:   message.Version,
+		tags:      message.Tags,
+	}
+	if message.Op != opSet {
+		return item, nil
+	}
+	err := json.Unmarshal(message.Value, &item.value)
+	return item, err
+}
+
+// Follower keeps an in-memory cache in sync with the cache of the same name on a primary. It reconnects when the
+// stream breaks and starts again from a snapshot, serving the data it has in the meantime.
+type Follower[T any] struct {
+	cache     *Cache[T]
+	name      string
+	primary   string
+	token     string
+	tlsConfig *tls.Config
+	logger    *zerolog.Logger
+}
+
+// NewFollower returns a follower replicating the cache called name on the primary into c. The cache reports itself
+// unhealthy until the first snapshot is received. The primary is reached over TLS if tlsConfig is not nil.
+func NewFollower[T any](c *Cache[T], name string, conf config.ReplicationConfig, tlsConfig *tls.Config,
+	logger *zerolog.Logger) *Follower[T] {
+	c.syncing.Store(true)
+	return &Follower[T]{cache: c, name: name, primary: conf.Primary, token: conf.Token, tlsConfig: tlsConfig,
+		logger: logger}
+}
+
+// Run replicates until ctx is done, waiting longer between failed attempts up to followerMaxBackoff
+func (f *Follower[T]) Run(ctx context.Context) {
+	logger := f.logger.With().Str("cache", f.name).Str("primary", f.primary).Logger()
+	backoff := followerMinBackoff
+	for {
+		synced, err := f.sync(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		if synced {
+			backoff = followerMinBackoff
+		}
+		logger.Warn().Err(err).Dur("retry_in", backoff).Msg("Lost the replication stream")
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return
+		}
+		backoff = min(2*backoff, followerMaxBackoff)
+	}
+}
+
+// sync connects to the primary, loads the snapshot and applies the changes until the stream breaks. synced reports
+// whether the snapshot was loaded.
+func (f *Follower[T]) sync(ctx context.Context) (synced bool, err error) {
+	dialer := &net.Dialer{Timeout: replicationTimeout}
+	dial := dialer.DialContext
+	if f.tlsConfig != nil {
+		dial = (&tls.Dialer{NetDialer: dialer, Config: f.tlsConfig}).DialContext
+	}
+	conn, err := dial(ctx, "tcp", f.primary)
+	if err != nil {
+		return false, err
+	}
+	defer conn.Close()
+	stop := context.AfterFunc(ctx, func() { _ = conn.Close() })
+	defer stop()
+	_ = conn.SetWriteDeadline(time.Now().Add(replicationTimeout))
+	if err := json.NewEncoder(conn).Encode(replicationHello{Token: f.token, Cache: f.name}); err != nil {
+		return false, err
+	}
+
+	decoder := json.NewDecoder(bufio.NewReader(conn))
+	snapshot := make(map[string]cacheItem[T])
+	for {
+		_ = conn.SetReadDeadline(time.Now().Add(3 * replicationHeartbeat))
+		var message replicationMessage
+		if err := decoder.Decode(&message); err != nil {
+			return synced, err
+		}
+		switch message.Op {
+		case opError:
+			return synced, fmt.Errorf("the primary refused to replicate: %s", message.Error)
+		case opPing:
+			continue
+		case opSynced:
+			f.cache.replace(snapshot)
+			f.cache.syncing.Store(false)
+			f.logger.Info().Str("cache", f.name).Int("keys", len(snapshot)).Msg("Synced from primary")
+			snapshot, synced = nil, true
+			continue
+		}
+		item, err := decodeChange[T](message)
+		if err != nil {
+			return synced, err
+		}
+		if !synced {
+			snapshot[message.Key] = item
+			continue
+		}
+		f.cache.apply(message.Op, message.Key, item)
+	}
+}
@@ -0,0 +1,272 @@
+package cache
+
+import (
+	"cache-api/config"
+	"cache-api/server"
+	"context"
+	"crypto/tls"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+// startPrimary serves the caches on a loopback port, over TLS if tlsConfig is not nil, and returns its address
+func startPrimary(t *testing.T, ctx context.Context, caches map[string]*Cache[string], conf config.ReplicationConfig,
+	tlsConfig *tls.Config) string {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tlsConfig != nil {
+		listener = tls.NewListener(listener, tlsConfig)
+	}
+	logger := zerolog.Nop()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		if err := NewPrimary(caches, conf, &logger).Serve(ctx, listener); err != nil {
+			t.Errorf("Expected the primary to stop without error, got %v", err)
+		}
+	}()
+	t.Cleanup(func() { <-done })
+	return listener.Addr().String()
+}
+
+// startFollower replicates the cache called name from the primary at addr into a new cache
+func startFollower(ctx context.Context, addr string, name string, token string, tlsConfig *tls.Config) *Cache[string] {
+	follower := NewCache[string](ctx, config.CacheConfig{TTLSec: 10})
+	logger := zerolog.Nop()
+	go NewFollower(follower, name, config.ReplicationConfig{Primary: addr, Token: token}, tlsConfig, &logger).Run(ctx)
+	return follower
+}
+
+// waitFor polls condition until it holds or a second passed
+func waitFor(t *testing.T, message string, condition func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatalf("Timed out waiting for %s", message)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestReplication(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	primary := createNewCache()
+	team := createNewCache()
+	_ = primary.SetWithTags("before", "snapshot", []string{"tag"})
+	_ = team.Set("team-key", "team-value")
+	addr := startPrimary(t, ctx, map[string]*Cache[string]{"default": primary, "ns/team": team},
+		config.ReplicationConfig{Token: "secret", Buffer: 100}, nil)
+
+	follower := startFollower(ctx, addr, "default", "secret", nil)
+	teamFollower := startFollower(ctx, addr, "ns/team", "secret", nil)
+	waitFor(t, "the snapshot", func() bool { return follower.Check(ctx) == nil && teamFollower.Check(ctx) == nil })
+	if value, ok := follower.Get("before"); !ok || value != "snapshot" {
+		t.Errorf("Expected the snapshot to hold 'before', got %q", value)
+	}
+	if value, ok := teamFollower.Get("team-key"); !ok || value != "team-value" {
+		t.Errorf("Expected the namespace to be replicated, got %q", value)
+	}
+	if _, version, _ := follower.GetWithVersion("before"); version == 0 {
+		t.Errorf("Expected the version to be replicated")
+	}
+
+	_ = primary.Set("after", "stream")
+	if _, err := primary.Increment("counter", 5, server.CounterOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "the stream", func() bool {
+		value, _ := follower.Get("counter")
+		return value == "5"
+	})
+	if value, ok := follower.Get("after"); !ok || value != "stream" {
+		t.Errorf("Expected the streamed 'after', got %q", value)
+	}
+
+	primary.Delete("after")
+	if _, err := primary.InvalidateTag("tag"); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "the deletes", func() bool {
+		_, after := follower.Get("after")
+		_, before := follower.Get("before")
+		return !after && !before
+	})
+
+	if _, err := primary.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "the flush", func() bool {
+		stats, _ := follower.Stats()
+		return stats.Keys == 0
+	})
+}
+
+func TestReplication_TLS(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	// the test server holds a certificate for the loopback address and a client trusting it
+	certServer := httptest.NewTLSServer(http.NotFoundHandler())
+	defer certServer.Close()
+	clientTLS := certServer.Client().Transport.(*http.Transport).TLSClientConfig
+	primary := createNewCache()
+	_ = primary.Set("key", "value")
+	addr := startPrimary(t, ctx, map[string]*Cache[string]{"default": primary}, config.ReplicationConfig{Buffer: 100},
+		certServer.TLS)
+
+	follower := startFollower(ctx, addr, "default", "", clientTLS)
+	waitFor(t, "the snapshot", func() bool { return follower.Check(ctx) == nil })
+	if value, ok := follower.Get("key"); !ok || value != "value" {
+		t.Errorf("Expected the snapshot over TLS to hold 'key', got %q", value)
+	}
+	// a follower without TLS can not talk to the primary
+	plain := startFollower(ctx, addr, "default", "", nil)
+	time.Sleep(50 * time.Millisecond)
+	if err := plain.Check(ctx); err != errNotSynced {
+		t.Errorf("Expected %v, got %v", errNotSynced, err)
+	}
+}
+
+func TestReplication_Rejected(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	addr := startPrimary(t, ctx, map[string]*Cache[string]{"default": createNewCache()},
+		config.ReplicationConfig{Token: "secret", Buffer: 100}, nil)
+
+	tests := []struct {
+		name  string
+		cache string
+		token string
+	}{
+		{name: "invalid token", cache: "default", token: "wrong"},
+		{name: "unknown cache", cache: "ns/unknown", token: "secret"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			follower := startFollower(ctx, addr, tt.cache, tt.token, nil)
+			time.Sleep(50 * time.Millisecond)
+			if err := follower.Check(ctx); err != errNotSynced {
+				t.Errorf("Expected %v, got %v", errNotSynced, err)
+			}
+		})
+	}
+}
+
+func TestReplication_Reconnect(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	primary := createNewCache()
+	_ = primary.Set("key", "first")
+	primaryCtx, stopPrimary := context.WithCancel(ctx)
+	addr := startPrimary(t, primaryCtx, map[string]*Cache[string]{"default": primary},
+		config.ReplicationConfig{Buffer: 100}, nil)
+	follower := startFollower(ctx, addr, "default", "", nil)
+	waitFor(t, "the snapshot", func() bool { return follower.Check(ctx) == nil })
+
+	// the follower keeps serving its data while the primary is away, then resyncs from a new snapshot
+	stopPrimary()
+	waitFor(t, "the stream to stop", func() bool {
+		primary.mutex.RLock()
+		defer primary.mutex.RUnlock()
+		return len(primary.followers) == 0
+	})
+	_ = primary.Set("key", "second")
+	if value, _ := follower.Get("key"); value != "first" {
+		t.Errorf("Expected the follower to keep 'first', got %q", value)
+	}
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		t.Skipf("Could not listen on %s again: %v", addr, err)
+	}
+	logger := zerolog.Nop()
+	restarted := NewPrimary(map[string]*Cache[string]{"default": primary}, config.ReplicationConfig{Buffer: 100}, &logger)
+	go func() { _ = restarted.Serve(ctx, listener) }()
+	waitFor(t, "the resync", func() bool {
+		value, _ := follower.Get("key")
+		return value == "second"
+	})
+}
+
+func TestCache_Replace(t *testing.T) {
+	t.Parallel()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	primary := createNewCache()
+	_ = primary.Set("kept", "same")
+	_ = primary.Set("changed", "old")
+	_ = primary.Set("gone", "deleted")
+	snapshot, stream := primary.follow(10)
+	primary.unfollow(stream)
+	follower := createNewCache()
+	follower.replace(snapshot)
+
+	evicted, set := recordCallbacks(t, follower)
+	events, _ := follower.Watch(ctx, server.WatchOptions{Buffer: 10})
+	_ = primary.Set("changed", "new")
+	primary.Delete("gone")
+	_ = primary.Set("added", "value")
+	snapshot, stream = primary.follow(10)
+	primary.unfollow(stream)
+	follower.replace(snapshot)
+
+	// a resync only reports the keys that changed while the follower was away
+	wantEvicted := []evictCall{
+		{key: "gone", value: "deleted", reason: EvictDeleted},
+		{key: "changed", value: "old", reason: EvictReplaced},
+	}
+	if !slices.Equal(*evicted, wantEvicted) {
+		t.Errorf("Expected %v to be evicted, got %v", wantEvicted, *evicted)
+	}
+	slices.Sort(*set)
+	if want := []string{"added=value", "changed=new"}; !slices.Equal(*set, want) {
+		t.Errorf("Expected %v to be set, got %v", want, *set)
+	}
+	var got []string
+	for len(events) > 0 {
+		event := <-events
+		got = append(got, event.Type+" "+event.Key)
+	}
+	slices.Sort(got)
+	if want := []string{"delete gone", "set added", "set changed"}; !slices.Equal(got, want) {
+		t.Errorf("Expected the events %v, got %v", want, got)
+	}
+	if value, _ := follower.Get("kept"); value != "same" {
+		t.Errorf("Expected kept to stay, got %q", value)
+	}
+}
+
+func TestCache_FollowDropsSlowFollowers(t *testing.T) {
+	t.Parallel()
+	cache := createNewCache()
+	_ = cache.Set("key", "value")
+	snapshot, stream := cache.follow(1)
+	if len(snapshot) != 1 || snapshot["key"].value != "value" {
+		t.Errorf("Expected a snapshot holding 'key', got %v", snapshot)
+	}
+	_ = cache.Set("a", "1")
+	select {
+	case <-stream.dropped:
+		t.Fatalf("Expected the stream not to be dropped while its queue has room")
+	default:
+	}
+	_ = cache.Set("b", "2")
+	select {
+	case <-stream.dropped:
+	default:
+		t.Fatalf("Expected the stream to be dropped once its queue is full")
+	}
+	if ch := <-stream.changes; ch.op != opSet || ch.key != "a" {
+		t.Errorf("Expected the queued set of 'a', got %+v", ch)
+	}
+	cache.unfollow(stream)
+}
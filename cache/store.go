@@ -0,0 +1,181 @@
+package cache
+
+import (
+	"cache-api/server"
+	"context"
+	"errors"
+	"fmt"
+	"hash/maphash"
+	"sync"
+	"time"
+)
+
+// Loader reads the values of a backing store, which the cache loads the keys it misses from
+type Loader[T any] interface {
+	// Load returns the value of key, found is false if the store does not have the key
+	Load(ctx context.Context, key string) (value T, found bool, err error)
+}
+
+// Store persists the writes of the cache to a backing store
+type Store[T any] interface {
+	// Write applies the writes in order. The writes of a batch are for distinct keys, and a batch that failed may be
+	// written again, so writing must be idempotent.
+	Write(ctx context.Context, writes []StoreWrite[T]) error
+}
+
+// StoreWrite is the value set for a key, or its deletion
+type StoreWrite[T any] struct {
+	Key    string
+	Value  T
+	Delete bool
+}
+
+// writeThroughLocks is the number of locks the keys are spread over to order their writes to a write-through store
+const writeThroughLocks = 64
+
+var errWriteThrough = errors.New("failed to write to the store")
+
+// writeThrough is a store written to before the writes of the cache return
+type writeThrough[T any] struct {
+	store Store[T]
+	seed  maphash.Seed
+	// locks serialize the writes of a key, so the store gets them in the order the cache applied them
+	locks [writeThroughLocks]sync.Mutex
+}
+
+func (w *writeThrough[T]) lock(key string) *sync.Mutex {
+	return &w.locks[maphash.String(w.seed, key)%writeThroughLocks]
+}
+
+// load is the read of a key from the loader, shared by the concurrent misses of the key
+type load[T any] struct {
+	done  chan struct{}
+	item  cacheItem[T]
+	found bool
+	// stale is set when the key is written while it is loaded, so the loaded value is not cached. It is guarded by
+	// the mutex of the cache.
+	stale bool
+}
+
+// SetLoader makes the cache load the keys it misses from loader (read-through). The loaded values are cached with
+// the TTL of the cache unless the key is written while it is loaded, and the concurrent misses of a key share a single
+// load. The counters and CompareAndSwap load the key too, so they start from the value of the store.
+func (c *Cache[T]) SetLoader(loader Loader[T]) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.loader = loader
+}
+
+// SetStore makes the writes of Set, SetWithTags, CompareAndSwap, the counters and Delete go to store before they
+// return (write-through). A write the store rejects returns its error and evicts the key, so the cache does not serve
+// a value the store does not have. Removing keys by prefix, pattern or tag, flushing, expirations and evictions only
+// apply to the cache. See NewWriteBehind to write to the store in the background instead.
+func (c *Cache[T]) SetStore(store Store[T]) {
+	c.through.Store(&writeThrough[T]{store: store, seed: maphash.MakeSeed()})
+}
+
+// write applies a write of key to the cache under the write lock, then hands it to the store if there is one. apply
+// returns the write for the store, or false if it did not change the key.
+func (c *Cache[T]) write(key string, apply func() (StoreWrite[T], bool)) error {
+	through := c.through.Load()
+	if through != nil {
+		lock := through.lock(key)
+		lock.Lock()
+		defer lock.Unlock()
+	}
+	behind := c.behind.Load()
+	c.mutex.Lock()
+	if behind != nil && !behind.accepts(key) {
+		c.unlock()
+		return errWriteBehindFull
+	}
+	write, changed := apply()
+	if changed && behind != nil {
+		behind.enqueue(write)
+	}
+	c.unlock()
+	if !changed || through == nil {
+		return nil
+	}
+	if err := through.store.Write(c.ctx, []StoreWrite[T]{write}); err != nil {
+		c.mutex.Lock()
+		c.remove(key, server.EventDelete)
+		c.unlock()
+		return fmt.Errorf("%w: %w", errWriteThrough, err)
+	}
+	return nil
+}
+
+// ensureLoaded loads the key if it is missing and the cache has a loader, before a write depending on its value
+func (c *Cache[T]) ensureLoaded(key string) {
+	c.mutex.RLock()
+	_, ok := c.lookup(key)
+	loader := c.loader
+	c.mutex.RUnlock()
+	if !ok && loader != nil {
+		c.load(loader, key)
+	}
+}
+
+// load returns the item of key read from the loader, or from the writes waiting for a write-behind store, waiting for
+// the load of a concurrent miss if there is one. A load that fails is logged and reported as a miss.
+func (c *Cache[T]) load(loader Loader[T], key string) (cacheItem[T], bool) {
+	c.mutex.Lock()
+	if item, ok := c.lookup(key); ok {
+		c.unlock()
+		return item, true
+	}
+	l, loading := c.loads[key]
+	if loading {
+		c.unlock()
+		<-l.done
+		return l.item, l.found
+	}
+	l = &load[T]{done: make(chan struct{})}
+	if c.loads == nil {
+		c.loads = make(map[string]*load[T])
+	}
+	c.loads[key] = l
+	c.unlock()
+
+	value, found, err := c.fetch(loader, key)
+	if err != nil {
+		c.log().Warn().Err(err).Str("key", key).Msg("Failed to load key")
+	}
+	c.mutex.Lock()
+	defer c.unlock()
+	delete(c.loads, key)
+	l.found = err == nil && found
+	l.item = cacheItem[T]{value: value}
+	if l.found && !l.stale {
+		c.lastVersion++
+		l.item = cacheItem[T]{value: value, expiresAt: time.Now().Add(c.ttl).UnixNano(), version: c.lastVersion}
+		c.put(key, l.item)
+	}
+	close(l.done)
+	return l.item, l.found
+}
+
+// fetch reads key from the writes waiting for the write-behind store, which are more recent than the store, or else
+// from the loader
+func (c *Cache[T]) fetch(loader Loader[T], key string) (T, bool, error) {
+	if behind := c.behind.Load(); behind != nil {
+		if write, ok := behind.waiting(key); ok {
+			return write.Value, !write.Delete, nil
+		}
+	}
+	return loader.Load(c.ctx, key)
+}
+
+// staleLoads marks the loads of key, or of every key if it is empty, as stale. The caller must hold the write lock.
+func (c *Cache[T]) staleLoads(key string) {
+	if key != "" {
+		if l, ok := c.loads[key]; ok {
+			l.stale = true
+		}
+		return
+	}
+	for _, l := range c.loads {
+		l.stale = true
+	}
+}
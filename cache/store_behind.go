@@ -0,0 +1,186 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+var errWriteBehindFull = errors.New("too many writes are waiting for the store")
+
+// WriteBehindOptions configures how the writes are batched
+type WriteBehindOptions struct {
+	// Batch is the maximum number of writes per call to the store, a full batch is written right away
+	Batch int
+	// Interval is how long the writes wait to be batched with the next ones
+	Interval time.Duration
+	// Buffer is the number of keys with writes waiting, past which the writes of other keys fail
+	Buffer int
+}
+
+// WriteBehind writes the writes of a cache to a store in the background. The writes of a key waiting to be written
+// are coalesced into the last one, and a batch the store rejects is retried, waiting longer between failed attempts
+// up to publishMaxBackoff. The misses of the cache read the writes waiting, as the store does not have them yet.
+type WriteBehind[T any] struct {
+	store  Store[T]
+	opts   WriteBehindOptions
+	logger *zerolog.Logger
+	// ready is signaled when a batch is full
+	ready chan struct{}
+	// mutex guards the fields below
+	mutex sync.Mutex
+	// pending maps the keys to their last write, and order holds them in the order they were first written
+	pending map[string]StoreWrite[T]
+	order   []string
+	// writing holds the batch being written
+	writing map[string]StoreWrite[T]
+}
+
+// NewWriteBehind makes the writes of Set, SetWithTags, CompareAndSwap, the counters and Delete go to store once Run
+// writes them, instead of before they return like with SetStore. Removing keys by prefix, pattern or tag, flushing,
+// expirations and evictions only apply to the cache.
+func NewWriteBehind[T any](c *Cache[T], store Store[T], opts WriteBehindOptions,
+	logger *zerolog.Logger) *WriteBehind[T] {
+	b := &WriteBehind[T]{
+		store:   store,
+		opts:    opts,
+		logger:  logger,
+		ready:   make(chan struct{}, 1),
+		pending: make(map[string]StoreWrite[T]),
+	}
+	c.behind.Store(b)
+	return b
+}
+
+// Run writes the waiting writes every interval, or as soon as a batch is full, until ctx is done. The writes still
+// waiting then are left for Flush.
+func (b *WriteBehind[T]) Run(ctx context.Context) {
+	ticker := time.NewTicker(b.opts.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-b.ready:
+		case <-ctx.Done():
+			return
+		}
+		if err := b.drain(ctx); err != nil {
+			return
+		}
+	}
+}
+
+// Flush writes every waiting write, retrying until ctx is done. It is meant for shutdown, once Run returned.
+func (b *WriteBehind[T]) Flush(ctx context.Context) error {
+	if err := b.drain(ctx); err != nil {
+		b.mutex.Lock()
+		defer b.mutex.Unlock()
+		return fmt.Errorf("%d writes were not stored: %w", len(b.pending), err)
+	}
+	return nil
+}
+
+// drain writes batches until no write is waiting, or returns the error of ctx once it is done
+func (b *WriteBehind[T]) drain(ctx context.Context) error {
+	backoff := publishMinBackoff
+	for {
+		batch := b.take()
+		if len(batch) == 0 {
+			return nil
+		}
+		err := b.store.Write(ctx, batch)
+		b.written(batch, err)
+		if err == nil {
+			backoff = publishMinBackoff
+			continue
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		b.logger.Warn().Err(err).Int("writes", len(batch)).Dur("retry_in", backoff).Msg("Failed to store writes")
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		backoff = min(2*backoff, publishMaxBackoff)
+	}
+}
+
+// accepts reports whether a write of key fits in the buffer
+func (b *WriteBehind[T]) accepts(key string) bool {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	_, ok := b.pending[key]
+	return ok || len(b.pending) < b.opts.Buffer
+}
+
+// enqueue replaces the write waiting for its key, or queues it after the others
+func (b *WriteBehind[T]) enqueue(write StoreWrite[T]) {
+	b.mutex.Lock()
+	if _, ok := b.pending[write.Key]; !ok {
+		b.order = append(b.order, write.Key)
+	}
+	b.pending[write.Key] = write
+	full := len(b.order) >= b.opts.Batch
+	b.mutex.Unlock()
+	if full {
+		select {
+		case b.ready <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// waiting returns the last write of key that the store may not have yet
+func (b *WriteBehind[T]) waiting(key string) (StoreWrite[T], bool) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	if write, ok := b.pending[key]; ok {
+		return write, true
+	}
+	write, ok := b.writing[key]
+	return write, ok
+}
+
+// take removes up to a batch of writes from the queue, in the order their keys were first written
+func (b *WriteBehind[T]) take() []StoreWrite[T] {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	n := min(b.opts.Batch, len(b.order))
+	if n == 0 {
+		return nil
+	}
+	batch := make([]StoreWrite[T], n)
+	b.writing = make(map[string]StoreWrite[T], n)
+	for i, key := range b.order[:n] {
+		batch[i] = b.pending[key]
+		b.writing[key] = batch[i]
+		delete(b.pending, key)
+	}
+	b.order = b.order[n:]
+	return batch
+}
+
+// written ends the write of the batch. A batch that failed is queued again ahead of the writes queued since, except
+// for the keys written again in the meantime, whose last write replaces the one of the batch.
+func (b *WriteBehind[T]) written(batch []StoreWrite[T], err error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.writing = nil
+	if err == nil {
+		return
+	}
+	keys := make([]string, 0, len(batch))
+	for _, write := range batch {
+		if _, ok := b.pending[write.Key]; !ok {
+			b.pending[write.Key] = write
+			keys = append(keys, write.Key)
+		}
+	}
+	b.order = append(keys, b.order...)
+}
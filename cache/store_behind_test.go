@@ -0,0 +1,144 @@
+package cache
+
+import (
+	"cache-api/config"
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+func newWriteBehind(cache *Cache[string], store *memStore, opts WriteBehindOptions) *WriteBehind[string] {
+	logger := zerolog.Nop()
+	return NewWriteBehind(cache, store, opts, &logger)
+}
+
+func TestWriteBehind(t *testing.T) {
+	t.Parallel()
+	cache := createNewCache()
+	store := newMemStore(nil)
+	behind := newWriteBehind(cache, store, WriteBehindOptions{Batch: 2, Interval: time.Hour, Buffer: 10})
+
+	_ = cache.Set("a", "1")
+	_ = cache.Set("a", "2")
+	cache.Delete("b")
+	_ = cache.Set("c", "3")
+	if len(store.batches) != 0 {
+		t.Errorf("Expected the writes to wait for Run, got %v", store.batches)
+	}
+	if err := behind.Flush(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	// the writes of a are coalesced, and the keys are written in the order they were first written
+	want := [][]StoreWrite[string]{
+		{{Key: "a", Value: "2"}, {Key: "b", Delete: true}},
+		{{Key: "c", Value: "3"}},
+	}
+	if len(store.batches) != len(want) {
+		t.Fatalf("Expected %v, got %v", want, store.batches)
+	}
+	for i := range want {
+		for j := range want[i] {
+			if j >= len(store.batches[i]) || store.batches[i][j] != want[i][j] {
+				t.Errorf("Expected %v, got %v", want, store.batches)
+			}
+		}
+	}
+}
+
+func TestWriteBehind_Run(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name string
+		opts WriteBehindOptions
+		keys []string
+	}{
+		{name: "interval", opts: WriteBehindOptions{Batch: 100, Interval: 10 * time.Millisecond, Buffer: 100}, keys: []string{"a"}},
+		{name: "full batch", opts: WriteBehindOptions{Batch: 2, Interval: time.Hour, Buffer: 100}, keys: []string{"a", "b"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			cache := createNewCache()
+			store := newMemStore(nil)
+			go newWriteBehind(cache, store, tt.opts).Run(ctx)
+			for _, key := range tt.keys {
+				_ = cache.Set(key, "value")
+			}
+			waitFor(t, "the writes", func() bool {
+				_, ok := store.get(tt.keys[len(tt.keys)-1])
+				return ok
+			})
+		})
+	}
+}
+
+func TestWriteBehind_Retry(t *testing.T) {
+	t.Parallel()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	cache := createNewCache()
+	store := newMemStore(nil)
+	store.failures = 1
+	behind := newWriteBehind(cache, store, WriteBehindOptions{Batch: 10, Interval: time.Millisecond, Buffer: 10})
+	_ = cache.Set("a", "1")
+	_ = cache.Set("b", "1")
+	// the failed batch is retried after 100ms, by then a was written again
+	go behind.Run(ctx)
+	waitFor(t, "the failure", func() bool {
+		store.mutex.Lock()
+		defer store.mutex.Unlock()
+		return store.failures == 0
+	})
+	_ = cache.Set("a", "2")
+	waitFor(t, "the retry", func() bool {
+		value, _ := store.get("a")
+		_, ok := store.get("b")
+		return value == "2" && ok
+	})
+}
+
+func TestWriteBehind_Pending(t *testing.T) {
+	t.Parallel()
+	cache := NewCache[string](context.Background(), config.CacheConfig{TTLSec: 10, MaxSize: 1})
+	store := newMemStore(map[string]string{"a": "stored", "b": "stored"})
+	cache.SetLoader(store)
+	behind := newWriteBehind(cache, store, WriteBehindOptions{Batch: 10, Interval: time.Hour, Buffer: 3})
+
+	// a and b are evicted before they are written, and the misses read their writes rather than the store
+	_ = cache.Set("a", "written")
+	cache.Delete("b")
+	_ = cache.Set("c", "written")
+	if value, _ := cache.Get("a"); value != "written" {
+		t.Errorf("Expected the waiting write, got %q", value)
+	}
+	if value, ok := cache.Get("b"); ok {
+		t.Errorf("Expected the waiting delete, got %q", value)
+	}
+
+	// the buffer is full, so the writes of the other keys fail until the waiting ones are written
+	if err := cache.Set("d", "written"); !errors.Is(err, errWriteBehindFull) {
+		t.Errorf("Expected a full buffer error, got %v", err)
+	}
+	if err := cache.Set("a", "again"); err != nil {
+		t.Errorf("Expected a write of a waiting key to be coalesced, got %v", err)
+	}
+	if err := behind.Flush(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if err := cache.Set("d", "written"); err != nil {
+		t.Errorf("Expected the write to be accepted once the buffer is written, got %v", err)
+	}
+
+	// a store that stays unavailable leaves the writes waiting
+	store.failures = 100
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := behind.Flush(ctx); err == nil {
+		t.Errorf("Expected an error while the store is unavailable")
+	}
+}
@@ -0,0 +1,83 @@
+package cache
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+var (
+	_ Loader[string] = &FileStore{}
+	_ Store[string]  = &FileStore{}
+)
+
+// FileStore keeps every key in a file of a directory. The files are named after the sha256 of the keys, so any key
+// maps to a valid file name of a fixed length.
+type FileStore struct {
+	dir string
+}
+
+// NewFileStore returns a store keeping the keys in dir, which is created if it does not exist
+func NewFileStore(dir string) (*FileStore, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, err
+	}
+	return &FileStore{dir: dir}, nil
+}
+
+// Load implements Loader
+func (f *FileStore) Load(_ context.Context, key string) (string, bool, error) {
+	data, err := os.ReadFile(f.path(key))
+	if errors.Is(err, fs.ErrNotExist) {
+		return "", false, nil
+	}
+	if err != nil {
+		return "", false, err
+	}
+	return string(data), true, nil
+}
+
+// Write implements Store. Every value is written to a temporary file renamed over the file of the key, so a reader
+// never sees a partial value.
+func (f *FileStore) Write(ctx context.Context, writes []StoreWrite[string]) error {
+	for _, write := range writes {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if write.Delete {
+			if err := os.Remove(f.path(write.Key)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+				return err
+			}
+			continue
+		}
+		if err := f.writeFile(f.path(write.Key), write.Value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (f *FileStore) writeFile(path string, value string) error {
+	file, err := os.CreateTemp(f.dir, ".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(file.Name())
+	if _, err := file.WriteString(value); err != nil {
+		_ = file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	return os.Rename(file.Name(), path)
+}
+
+func (f *FileStore) path(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return filepath.Join(f.dir, hex.EncodeToString(sum[:]))
+}
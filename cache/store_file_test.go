@@ -0,0 +1,62 @@
+package cache
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestFileStore(t *testing.T) {
+	t.Parallel()
+	dir := filepath.Join(t.TempDir(), "store")
+	store, err := NewFileStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	longKey := strings.Repeat("k", 250)
+	err = store.Write(context.Background(), []StoreWrite[string]{
+		{Key: "user:1", Value: "alice"},
+		{Key: "../escape", Value: "contained"},
+		{Key: longKey, Value: "long"},
+		{Key: "gone", Value: "value"},
+		{Key: "gone", Delete: true},
+		{Key: "never written", Delete: true},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		key       string
+		wantValue string
+		wantFound bool
+	}{
+		{key: "user:1", wantValue: "alice", wantFound: true},
+		{key: "../escape", wantValue: "contained", wantFound: true},
+		{key: longKey, wantValue: "long", wantFound: true},
+		{key: "gone"},
+		{key: "missing"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.key, func(t *testing.T) {
+			value, found, err := store.Load(context.Background(), tt.key)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if value != tt.wantValue || found != tt.wantFound {
+				t.Errorf("Expected %q and %v, got %q and %v", tt.wantValue, tt.wantFound, value, found)
+			}
+		})
+	}
+
+	// every key is a file of the directory, and no temporary file is left
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 3 {
+		t.Errorf("Expected 3 files, got %d", len(entries))
+	}
+}
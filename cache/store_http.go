@@ -0,0 +1,95 @@
+package cache
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+var (
+	_ Loader[string] = &HTTPOrigin{}
+	_ Store[string]  = &HTTPOrigin{}
+)
+
+// HTTPOrigin is an upstream HTTP API serving the value of every key at `{url}/{key}`, which the cache can front as a
+// transparent caching layer. Values are read with GET, where 404 and 410 mean the key does not exist, written with PUT
+// and deleted with DELETE.
+type HTTPOrigin struct {
+	url    string
+	client *http.Client
+	// maxValueBytes bounds the values read, 0 means no limit
+	maxValueBytes int64
+}
+
+// NewHTTPOrigin returns the origin at baseURL, giving up on the requests taking longer than timeout
+func NewHTTPOrigin(baseURL string, timeout time.Duration, maxValueBytes int64) *HTTPOrigin {
+	return &HTTPOrigin{
+		url:           strings.TrimSuffix(baseURL, "/"),
+		client:        &http.Client{Timeout: timeout},
+		maxValueBytes: maxValueBytes,
+	}
+}
+
+// Load implements Loader
+func (o *HTTPOrigin) Load(ctx context.Context, key string) (string, bool, error) {
+	resp, err := o.do(ctx, http.MethodGet, key, nil)
+	if err != nil {
+		return "", false, err
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound, http.StatusGone:
+		return "", false, nil
+	default:
+		return "", false, fmt.Errorf("unexpected status %s loading %q", resp.Status, key)
+	}
+	body := io.Reader(resp.Body)
+	if o.maxValueBytes > 0 {
+		body = io.LimitReader(resp.Body, o.maxValueBytes+1)
+	}
+	value, err := io.ReadAll(body)
+	if err != nil {
+		return "", false, err
+	}
+	if o.maxValueBytes > 0 && int64(len(value)) > o.maxValueBytes {
+		return "", false, fmt.Errorf("value of %q is larger than %d bytes", key, o.maxValueBytes)
+	}
+	return string(value), true, nil
+}
+
+// Write implements Store, with a request per write
+func (o *HTTPOrigin) Write(ctx context.Context, writes []StoreWrite[string]) error {
+	for _, write := range writes {
+		method, body := http.MethodPut, io.Reader(strings.NewReader(write.Value))
+		if write.Delete {
+			method, body = http.MethodDelete, nil
+		}
+		resp, err := o.do(ctx, method, write.Key, body)
+		if err != nil {
+			return err
+		}
+		_, _ = io.Copy(io.Discard, resp.Body)
+		_ = resp.Body.Close()
+		deleted := write.Delete && (resp.StatusCode == http.StatusNotFound || resp.StatusCode == http.StatusGone)
+		if !deleted && (resp.StatusCode < 200 || resp.StatusCode > 299) {
+			return fmt.Errorf("unexpected status %s writing %q", resp.Status, write.Key)
+		}
+	}
+	return nil
+}
+
+func (o *HTTPOrigin) do(ctx context.Context, method string, key string, body io.Reader) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, o.url+"/"+url.PathEscape(key), body)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/octet-stream")
+	}
+	return o.client.Do(req)
+}
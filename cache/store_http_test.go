@@ -0,0 +1,107 @@
+package cache
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// startOrigin serves the values of the map at /api/{key}, failing the keys starting with "fail"
+func startOrigin(t *testing.T, values map[string]string) *httptest.Server {
+	t.Helper()
+	var mutex sync.Mutex
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key, ok := strings.CutPrefix(r.URL.Path, "/api/")
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		if strings.HasPrefix(key, "fail") {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		mutex.Lock()
+		defer mutex.Unlock()
+		switch r.Method {
+		case http.MethodGet:
+			value, ok := values[key]
+			if !ok {
+				http.NotFound(w, r)
+				return
+			}
+			_, _ = io.WriteString(w, value)
+		case http.MethodPut:
+			body, _ := io.ReadAll(r.Body)
+			values[key] = string(body)
+			w.WriteHeader(http.StatusNoContent)
+		case http.MethodDelete:
+			if _, ok := values[key]; !ok {
+				http.NotFound(w, r)
+				return
+			}
+			delete(values, key)
+			w.WriteHeader(http.StatusNoContent)
+		}
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestHTTPOrigin_Load(t *testing.T) {
+	t.Parallel()
+	server := startOrigin(t, map[string]string{"user:1": "alice", "a/b": "slash", "big": "0123456789"})
+	origin := NewHTTPOrigin(server.URL+"/api/", time.Second, 5)
+	tests := []struct {
+		name      string
+		key       string
+		wantValue string
+		wantFound bool
+		wantErr   bool
+	}{
+		{name: "found", key: "user:1", wantValue: "alice", wantFound: true},
+		{name: "escaped", key: "a/b", wantValue: "slash", wantFound: true},
+		{name: "not found", key: "missing"},
+		{name: "failure", key: "fail", wantErr: true},
+		{name: "too large", key: "big", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			value, found, err := origin.Load(context.Background(), tt.key)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Expected error %v, got %v", tt.wantErr, err)
+			}
+			if value != tt.wantValue || found != tt.wantFound {
+				t.Errorf("Expected %q and %v, got %q and %v", tt.wantValue, tt.wantFound, value, found)
+			}
+		})
+	}
+}
+
+func TestHTTPOrigin_Write(t *testing.T) {
+	t.Parallel()
+	values := map[string]string{"old": "value"}
+	server := startOrigin(t, values)
+	origin := NewHTTPOrigin(server.URL+"/api", time.Second, 0)
+	err := origin.Write(context.Background(), []StoreWrite[string]{
+		{Key: "user:1", Value: "alice"},
+		{Key: "old", Delete: true},
+		{Key: "missing", Delete: true},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if value, _, _ := origin.Load(context.Background(), "user:1"); value != "alice" {
+		t.Errorf("Expected alice, got %q", value)
+	}
+	if _, found, _ := origin.Load(context.Background(), "old"); found {
+		t.Errorf("Expected old to be deleted")
+	}
+	if err := origin.Write(context.Background(), []StoreWrite[string]{{Key: "fail", Value: "x"}}); err == nil {
+		t.Errorf("Expected an error for a failed write")
+	}
+}
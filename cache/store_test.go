@@ -0,0 +1,218 @@
+package cache
+
+import (
+	"cache-api/server"
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// memStore is a Loader and Store keeping the values in memory. It fails the first failures writes, and Load blocks
+// until release is closed if it is set.
+type memStore struct {
+	mutex    sync.Mutex
+	values   map[string]string
+	batches  [][]StoreWrite[string]
+	failures int
+	loads    atomic.Int32
+	release  chan struct{}
+}
+
+func newMemStore(values map[string]string) *memStore {
+	if values == nil {
+		values = make(map[string]string)
+	}
+	return &memStore{values: values}
+}
+
+func (s *memStore) Load(ctx context.Context, key string) (string, bool, error) {
+	s.loads.Add(1)
+	if s.release != nil {
+		<-s.release
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	value, ok := s.values[key]
+	return value, ok, nil
+}
+
+func (s *memStore) Write(_ context.Context, writes []StoreWrite[string]) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.failures > 0 {
+		s.failures--
+		return errors.New("store unavailable")
+	}
+	s.batches = append(s.batches, writes)
+	for _, write := range writes {
+		if write.Delete {
+			delete(s.values, write.Key)
+		} else {
+			s.values[write.Key] = write.Value
+		}
+	}
+	return nil
+}
+
+func (s *memStore) get(key string) (string, bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	value, ok := s.values[key]
+	return value, ok
+}
+
+func TestCache_ReadThrough(t *testing.T) {
+	t.Parallel()
+	cache := createNewCache()
+	store := newMemStore(map[string]string{"stored": "value", "counter": "41"})
+	cache.SetLoader(store)
+
+	tests := []struct {
+		name      string
+		key       string
+		wantValue string
+		wantFound bool
+	}{
+		{name: "stored key", key: "stored", wantValue: "value", wantFound: true},
+		{name: "missing key", key: "missing"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			value, found := cache.Get(tt.key)
+			if value != tt.wantValue || found != tt.wantFound {
+				t.Errorf("Expected %q and %v, got %q and %v", tt.wantValue, tt.wantFound, value, found)
+			}
+		})
+	}
+	// the loaded keys are cached
+	loads := store.loads.Load()
+	if _, _, ok := cache.GetWithVersion("stored"); !ok || store.loads.Load() != loads {
+		t.Errorf("Expected the loaded key to be cached")
+	}
+	// the counters start from the stored value
+	if value, err := cache.Increment("counter", 1, server.CounterOptions{}); err != nil || value != 42 {
+		t.Errorf("Expected 42, got %d (%v)", value, err)
+	}
+	// a write is not loaded again
+	_ = cache.Set("written", "value")
+	if value, ok := cache.Get("written"); !ok || value != "value" {
+		t.Errorf("Expected the written value, got %q", value)
+	}
+}
+
+func TestCache_ReadThrough_Coalesced(t *testing.T) {
+	t.Parallel()
+	cache := createNewCache()
+	store := newMemStore(map[string]string{"key": "stored"})
+	store.release = make(chan struct{})
+	cache.SetLoader(store)
+
+	var wg sync.WaitGroup
+	values := make([]string, 10)
+	for i := range values {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			values[i], _ = cache.Get("key")
+		}()
+	}
+	waitFor(t, "the load", func() bool { return store.loads.Load() > 0 })
+	time.Sleep(10 * time.Millisecond)
+	close(store.release)
+	wg.Wait()
+	if loads := store.loads.Load(); loads != 1 {
+		t.Errorf("Expected a single load, got %d", loads)
+	}
+	for _, value := range values {
+		if value != "stored" {
+			t.Errorf("Expected every miss to get the loaded value, got %q", value)
+		}
+	}
+}
+
+func TestCache_ReadThrough_Stale(t *testing.T) {
+	t.Parallel()
+	cache := createNewCache()
+	store := newMemStore(map[string]string{"key": "stored"})
+	store.release = make(chan struct{})
+	cache.SetLoader(store)
+
+	loaded := make(chan string)
+	go func() {
+		value, _ := cache.Get("key")
+		loaded <- value
+	}()
+	waitFor(t, "the load", func() bool { return store.loads.Load() > 0 })
+	// the key is written while it is loaded, so the loaded value is not cached over the write
+	_ = cache.Set("key", "written")
+	close(store.release)
+	if value := <-loaded; value != "stored" {
+		t.Errorf("Expected the miss to get the loaded value, got %q", value)
+	}
+	if value, _ := cache.Get("key"); value != "written" {
+		t.Errorf("Expected the written value to be kept, got %q", value)
+	}
+}
+
+func TestCache_WriteThrough(t *testing.T) {
+	t.Parallel()
+	cache := createNewCache()
+	store := newMemStore(nil)
+	cache.SetStore(store)
+
+	tests := []struct {
+		name      string
+		write     func() error
+		key       string
+		wantValue string
+		wantFound bool
+	}{
+		{name: "set", write: func() error { return cache.Set("a", "1") }, key: "a", wantValue: "1", wantFound: true},
+		{
+			name: "compare and swap",
+			write: func() error {
+				_, _, err := cache.CompareAndSwap("a", 1, "2")
+				return err
+			},
+			key: "a", wantValue: "2", wantFound: true,
+		},
+		{
+			name: "increment",
+			write: func() error {
+				_, err := cache.Increment("n", 3, server.CounterOptions{})
+				return err
+			},
+			key: "n", wantValue: "3", wantFound: true,
+		},
+		{name: "delete", write: func() error { cache.Delete("a"); return nil }, key: "a"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.write(); err != nil {
+				t.Fatal(err)
+			}
+			value, found := store.get(tt.key)
+			if value != tt.wantValue || found != tt.wantFound {
+				t.Errorf("Expected %q and %v in the store, got %q and %v", tt.wantValue, tt.wantFound, value, found)
+			}
+		})
+	}
+
+	// a failed compare and swap is not written
+	batches := len(store.batches)
+	if _, swapped, _ := cache.CompareAndSwap("n", 100, "x"); swapped || len(store.batches) != batches {
+		t.Errorf("Expected a failed swap not to be written")
+	}
+
+	// a write the store rejects fails and is not cached
+	store.failures = 1
+	if err := cache.Set("b", "1"); !errors.Is(err, errWriteThrough) {
+		t.Errorf("Expected a write-through error, got %v", err)
+	}
+	if _, ok := cache.Get("b"); ok {
+		t.Errorf("Expected the rejected write not to be cached")
+	}
+}
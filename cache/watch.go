@@ -0,0 +1,71 @@
+package cache
+
+import (
+	"cache-api/server"
+	"context"
+)
+
+// watcher queues the events of the keys it watches for a client. Its channel holds one more event than its buffer,
+// so the overflow can always be queued.
+type watcher struct {
+	opts   server.WatchOptions
+	events chan server.Event
+}
+
+// Watch implements server.Watcher. The channel is also closed once the context of the cache is done.
+func (c *Cache[T]) Watch(ctx context.Context, opts server.WatchOptions) (<-chan server.Event, error) {
+	w := &watcher{opts: opts, events: make(chan server.Event, max(opts.Buffer, 1)+1)}
+	c.mutex.Lock()
+	if c.watchers == nil {
+		c.watchers = make(map[*watcher]struct{})
+	}
+	c.watchers[w] = struct{}{}
+	c.mutex.Unlock()
+	unwatch := func() {
+		c.mutex.Lock()
+		defer c.mutex.Unlock()
+		c.unwatch(w)
+	}
+	stop := context.AfterFunc(c.ctx, unwatch)
+	context.AfterFunc(ctx, func() {
+		stop()
+		unwatch()
+	})
+	return w.events, nil
+}
+
+// unwatch closes the channel of the watcher unless it is already closed, the caller must hold the write lock
+func (c *Cache[T]) unwatch(w *watcher) {
+	if _, ok := c.watchers[w]; ok {
+		delete(c.watchers, w)
+		close(w.events)
+	}
+}
+
+// notifySet notifies the watchers of key that it was set to the item, the caller must hold the write lock
+func (c *Cache[T]) notifySet(key string, item cacheItem[T]) {
+	if len(c.watchers) == 0 {
+		return
+	}
+	event := server.Event{Type: server.EventSet, Key: key, Version: item.version}
+	if value, ok := any(item.value).(string); ok {
+		event.Value = &value
+	}
+	c.notify(event)
+}
+
+// notify queues the event for the watchers of its key without blocking. A watcher whose buffer is full gets an
+// overflow and is closed. The caller must hold the write lock.
+func (c *Cache[T]) notify(event server.Event) {
+	for w := range c.watchers {
+		if !w.opts.Matches(event.Key) {
+			continue
+		}
+		if len(w.events) >= cap(w.events)-1 {
+			w.events <- server.Event{Type: server.EventOverflow}
+			c.unwatch(w)
+			continue
+		}
+		w.events <- event
+	}
+}
@@ -0,0 +1,135 @@
+package cache
+
+import (
+	"cache-api/server"
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// keyspaceEvents maps the keyspace notifications of redis to the events of the cache, the other notifications are
+// ignored
+var keyspaceEvents = map[string]string{
+	"set":     server.EventSet,
+	"incrby":  server.EventSet,
+	"del":     server.EventDelete,
+	"unlink":  server.EventDelete,
+	"expired": server.EventExpire,
+	"evicted": server.EventEvict,
+}
+
+var errKeyspaceNotificationsDisabled = errors.New(
+	"keyspace notifications are disabled, notify-keyspace-events must include the Kg$xe flags")
+
+// Watch implements server.Watcher with the keyspace notifications of redis, which must be enabled with at least the
+// `Kg$xe` flags of `notify-keyspace-events`. Flushes are notified as the deletes of every key. The channel is also
+// closed once the context of the cache is done.
+func (r RedisCache) Watch(ctx context.Context, opts server.WatchOptions) (<-chan server.Event, error) {
+	if err := r.checkKeyspaceNotifications(ctx); err != nil {
+		return nil, err
+	}
+	channelPrefix := fmt.Sprintf("__keyspace@%d__:", r.rdb.Options().DB)
+	var pubsub *redis.PubSub
+	if opts.Key != "" {
+		pubsub = r.rdb.Subscribe(ctx, channelPrefix+r.prefix+opts.Key)
+	} else {
+		pubsub = r.rdb.PSubscribe(ctx, escapeGlob(channelPrefix+r.prefix+opts.Prefix)+"*")
+	}
+	// the subscription is confirmed before returning, so no event following the call is missed
+	if _, err := pubsub.Receive(ctx); err != nil {
+		_ = pubsub.Close()
+		return nil, err
+	}
+	// the values of the set keys are read apart from the notifications, so a slow read does not hold them back and
+	// a watcher too slow for the notifications still overflows
+	notified := make(chan server.Event, max(opts.Buffer, 1)+1)
+	events := make(chan server.Event)
+	go func() {
+		defer close(notified)
+		defer pubsub.Close()
+		messages := pubsub.Channel()
+		for {
+			var message *redis.Message
+			select {
+			case message = <-messages:
+			case <-ctx.Done():
+				return
+			case <-r.ctx.Done():
+				return
+			}
+			event, ok := r.keyspaceEvent(channelPrefix, message)
+			if !ok {
+				continue
+			}
+			if len(notified) >= cap(notified)-1 {
+				notified <- server.Event{Type: server.EventOverflow}
+				return
+			}
+			notified <- event
+		}
+	}()
+	go func() {
+		defer close(events)
+		for event := range notified {
+			if event.Type == server.EventSet {
+				event = r.withValue(event)
+			}
+			select {
+			case events <- event:
+			case <-ctx.Done():
+				return
+			case <-r.ctx.Done():
+				return
+			}
+		}
+	}()
+	return events, nil
+}
+
+// keyspaceEvent returns the event of a keyspace notification, or false if it is not about a key of the cache
+func (r RedisCache) keyspaceEvent(channelPrefix string, message *redis.Message) (server.Event, bool) {
+	key, ok := strings.CutPrefix(message.Channel, channelPrefix+r.prefix)
+	if !ok || strings.HasPrefix(key, metaKeyPrefix) || strings.HasPrefix(key, namespaceKeyPrefix) {
+		return server.Event{}, false
+	}
+	eventType, ok := keyspaceEvents[message.Payload]
+	if !ok {
+		return server.Event{}, false
+	}
+	return server.Event{Type: eventType, Key: key}, true
+}
+
+// withValue returns the set event with the value of its key. The value is read after the notification, so it is the
+// latest value rather than the one that was set, and is left out if the key is gone by then.
+func (r RedisCache) withValue(event server.Event) server.Event {
+	if value, version, found := r.GetWithVersion(event.Key); found {
+		event.Value, event.Version = &value, version
+	}
+	return event
+}
+
+// checkKeyspaceNotifications returns an error if redis does not send the notifications Watch needs. Servers not
+// allowing CONFIG GET, like many managed ones, are assumed to send them.
+func (r RedisCache) checkKeyspaceNotifications(ctx context.Context) error {
+	config, err := r.rdb.ConfigGet(ctx, "notify-keyspace-events").Result()
+	if err != nil {
+		return nil
+	}
+	flags := config["notify-keyspace-events"]
+	if !strings.Contains(flags, "K") {
+		return errKeyspaceNotificationsDisabled
+	}
+	// A is an alias for g$lshzxet
+	if strings.Contains(flags, "A") && strings.Contains(flags, "e") {
+		return nil
+	}
+	for _, flag := range "g$xe" {
+		if !strings.ContainsRune(flags, flag) {
+			return errKeyspaceNotificationsDisabled
+		}
+	}
+	return nil
+}
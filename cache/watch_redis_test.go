@@ -0,0 +1,119 @@
+package cache
+
+import (
+	"cache-api/config"
+	"cache-api/server"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/rs/zerolog"
+)
+
+func TestRedisCache_Watch(t *testing.T) {
+	connectionString := setupRedis(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	rdb := redis.NewClient(&redis.Options{Addr: connectionString})
+	defer rdb.Close()
+	logger := zerolog.Nop()
+	redisCache, err := NewRedisCache(ctx, &config.CacheConfig{}, &config.RedisConfig{Host: connectionString}, &logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := rdb.ConfigSet(ctx, "notify-keyspace-events", "").Err(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := redisCache.Watch(ctx, server.WatchOptions{Buffer: 10}); err == nil {
+		t.Errorf("Expected an error while keyspace notifications are disabled")
+	}
+
+	if err := rdb.ConfigSet(ctx, "notify-keyspace-events", "KA").Err(); err != nil {
+		t.Fatal(err)
+	}
+	events, err := redisCache.Watch(ctx, server.WatchOptions{Prefix: "user:", Buffer: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = redisCache.Set("order:1", "ignored")
+	_ = redisCache.Set("user:1", "alice")
+	rdb.Del(ctx, "user:1")
+	if err := rdb.Set(ctx, "user:2", "bob", time.Millisecond).Err(); err != nil {
+		t.Fatal(err)
+	}
+	// redis notifies the expiry once it notices it, which the read forces
+	time.Sleep(10 * time.Millisecond)
+	_ = rdb.Get(ctx, "user:2")
+
+	want := []server.Event{
+		{Type: server.EventSet, Key: "user:1"},
+		{Type: server.EventDelete, Key: "user:1"},
+		{Type: server.EventSet, Key: "user:2"},
+		{Type: server.EventExpire, Key: "user:2"},
+	}
+	for _, want := range want {
+		got := receive(t, events)
+		if got.Type != want.Type || got.Key != want.Key {
+			t.Errorf("Expected %s of %q, got %s of %q", want.Type, want.Key, got.Type, got.Key)
+		}
+	}
+
+	cancel()
+	select {
+	case _, ok := <-events:
+		if ok {
+			t.Errorf("Expected the channel to be closed")
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("Timed out waiting for the channel to be closed")
+	}
+}
+
+func TestRedisCache_KeyspaceEvent(t *testing.T) {
+	connectionString := setupRedis(t)
+	logger := zerolog.Nop()
+	redisCache, err := NewRedisCache(context.Background(), &config.CacheConfig{},
+		&config.RedisConfig{Host: connectionString}, &logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = redisCache.Set("key", "value")
+	const channelPrefix = "__keyspace@0__:"
+	tests := []struct {
+		name    string
+		channel string
+		payload string
+		want    *server.Event
+	}{
+		{name: "set", channel: "key", payload: "set", want: &server.Event{Type: server.EventSet, Key: "key"}},
+		{name: "increment", channel: "key", payload: "incrby", want: &server.Event{Type: server.EventSet, Key: "key"}},
+		{name: "delete", channel: "gone", payload: "del", want: &server.Event{Type: server.EventDelete, Key: "gone"}},
+		{name: "expire", channel: "gone", payload: "expired", want: &server.Event{Type: server.EventExpire, Key: "gone"}},
+		{name: "evict", channel: "gone", payload: "evicted", want: &server.Event{Type: server.EventEvict, Key: "gone"}},
+		{name: "other command", channel: "key", payload: "expire"},
+		{name: "bookkeeping key", channel: versionKey("key"), payload: "set"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := redisCache.keyspaceEvent(channelPrefix, &redis.Message{Channel: channelPrefix + tt.channel, Payload: tt.payload})
+			if tt.want == nil {
+				if ok {
+					t.Errorf("Expected no event, got %s of %q", got.Type, got.Key)
+				}
+				return
+			}
+			if !ok || got.Type != tt.want.Type || got.Key != tt.want.Key || valueOf(got.Value) != valueOf(tt.want.Value) {
+				t.Errorf("Expected %s of %q to %q, got %s of %q to %q", tt.want.Type, tt.want.Key,
+					valueOf(tt.want.Value), got.Type, got.Key, valueOf(got.Value))
+			}
+		})
+	}
+	if got := redisCache.withValue(server.Event{Type: server.EventSet, Key: "key"}); valueOf(got.Value) != "value" {
+		t.Errorf("Expected the value of the key %q, got %q", "value", valueOf(got.Value))
+	}
+	if got := redisCache.withValue(server.Event{Type: server.EventSet, Key: "gone"}); got.Value != nil {
+		t.Errorf("Expected no value for a key gone by then, got %q", valueOf(got.Value))
+	}
+}
@@ -0,0 +1,184 @@
+package cache
+
+import (
+	"cache-api/config"
+	"cache-api/server"
+	"context"
+	"testing"
+	"time"
+)
+
+// receive returns the next event of the channel, or fails the test if none is queued
+func receive(t *testing.T, events <-chan server.Event) server.Event {
+	t.Helper()
+	select {
+	case event, ok := <-events:
+		if !ok {
+			t.Fatalf("Expected an event, got a closed channel")
+		}
+		return event
+	case <-time.After(time.Second):
+		t.Fatalf("Timed out waiting for an event")
+	}
+	return server.Event{}
+}
+
+func TestCache_Watch(t *testing.T) {
+	t.Parallel()
+	cache := NewCache[string](context.Background(), config.CacheConfig{TTLSec: 10, MaxSize: 2})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	events, err := cache.Watch(ctx, server.WatchOptions{Buffer: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name  string
+		write func()
+		want  []server.Event
+	}{
+		{
+			name:  "set",
+			write: func() { _ = cache.Set("a", "1") },
+			want:  []server.Event{{Type: server.EventSet, Key: "a", Value: ptr("1")}},
+		},
+		{
+			name:  "delete",
+			write: func() { cache.Delete("a") },
+			want:  []server.Event{{Type: server.EventDelete, Key: "a"}},
+		},
+		{
+			name: "expire",
+			write: func() {
+				cache.apply(opSet, "b", cacheItem[string]{value: "2", expiresAt: time.Now().Add(-time.Second).UnixNano()})
+				cache.DeleteExpired()
+			},
+			want: []server.Event{{Type: server.EventSet, Key: "b", Value: ptr("2")}, {Type: server.EventExpire, Key: "b"}},
+		},
+		{
+			name: "evict",
+			write: func() {
+				_ = cache.Set("c", "3")
+				_ = cache.Set("d", "4")
+				_ = cache.Set("e", "5")
+			},
+			want: []server.Event{
+				{Type: server.EventSet, Key: "c", Value: ptr("3")},
+				{Type: server.EventSet, Key: "d", Value: ptr("4")},
+				{Type: server.EventEvict, Key: "c"},
+				{Type: server.EventSet, Key: "e", Value: ptr("5")},
+			},
+		},
+		{
+			name:  "flush",
+			write: func() { _, _ = cache.Flush() },
+			want:  []server.Event{{Type: server.EventFlush}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.write()
+			for _, want := range tt.want {
+				got := receive(t, events)
+				// the versions depend on the writes of the previous cases
+				got.Version = 0
+				if got.Type != want.Type || got.Key != want.Key || valueOf(got.Value) != valueOf(want.Value) {
+					t.Errorf("Expected %s of %q to %q, got %s of %q to %q",
+						want.Type, want.Key, valueOf(want.Value), got.Type, got.Key, valueOf(got.Value))
+				}
+			}
+		})
+	}
+}
+
+func TestCache_Watch_Filter(t *testing.T) {
+	t.Parallel()
+	cache := createNewCache()
+	tests := []struct {
+		name     string
+		opts     server.WatchOptions
+		wantKeys []string
+	}{
+		{name: "every key", opts: server.WatchOptions{}, wantKeys: []string{"user:1", "user:10", "order:1"}},
+		{name: "key", opts: server.WatchOptions{Key: "user:1"}, wantKeys: []string{"user:1"}},
+		{name: "prefix", opts: server.WatchOptions{Prefix: "user:"}, wantKeys: []string{"user:1", "user:10"}},
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	watched := make([]<-chan server.Event, len(tests))
+	for i, tt := range tests {
+		watched[i], _ = cache.Watch(ctx, server.WatchOptions{Key: tt.opts.Key, Prefix: tt.opts.Prefix, Buffer: 10})
+	}
+	for _, key := range []string{"user:1", "user:10", "order:1"} {
+		_ = cache.Set(key, "value")
+	}
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, want := range tt.wantKeys {
+				if got := receive(t, watched[i]).Key; got != want {
+					t.Errorf("Expected an event of %q, got %q", want, got)
+				}
+			}
+			select {
+			case event := <-watched[i]:
+				t.Errorf("Expected no more events, got %s of %q", event.Type, event.Key)
+			default:
+			}
+		})
+	}
+}
+
+func TestCache_Watch_Overflow(t *testing.T) {
+	t.Parallel()
+	cache := createNewCache()
+	events, _ := cache.Watch(context.Background(), server.WatchOptions{Buffer: 2})
+	for _, key := range []string{"a", "b", "c", "d"} {
+		_ = cache.Set(key, "value")
+	}
+	var types []string
+	for event := range events {
+		types = append(types, event.Type)
+	}
+	if len(types) != 3 || types[2] != server.EventOverflow {
+		t.Errorf("Expected 2 events then an overflow, got %v", types)
+	}
+	// the writes go on without the watcher
+	_ = cache.Set("e", "value")
+}
+
+func TestCache_Watch_Close(t *testing.T) {
+	t.Parallel()
+	cacheCtx, stopCache := context.WithCancel(context.Background())
+	cache := NewCache[string](cacheCtx, config.CacheConfig{TTLSec: 10})
+	tests := []struct {
+		name string
+		stop func(cancelWatch context.CancelFunc)
+	}{
+		{name: "watch context", stop: func(cancelWatch context.CancelFunc) { cancelWatch() }},
+		{name: "cache context", stop: func(context.CancelFunc) { stopCache() }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			events, _ := cache.Watch(ctx, server.WatchOptions{Buffer: 10})
+			tt.stop(cancel)
+			select {
+			case _, ok := <-events:
+				if ok {
+					t.Errorf("Expected the channel to be closed")
+				}
+			case <-time.After(time.Second):
+				t.Fatalf("Timed out waiting for the channel to be closed")
+			}
+		})
+	}
+}
+
+func valueOf(s *string) string {
+	if s == nil {
+		return "<nil>"
+	}
+	return *s
+}
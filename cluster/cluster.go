@@ -0,0 +1,206 @@
+package cluster
+
+import (
+	"cache-api/config"
+	"cache-api/server"
+	"context"
+	"crypto/tls"
+	"net"
+	"net/http"
+	"slices"
+	"sync/atomic"
+	"time"
+
+	"github.com/cespare/xxhash/v2"
+	"github.com/dgryski/go-rendezvous"
+	"github.com/rs/zerolog"
+)
+
+const (
+	// requestTimeout bounds every request between the nodes
+	requestTimeout = 10 * time.Second
+	// maxRepairs bounds the repairs of the replicas running in the background, the reads sampled past it are not
+	// compared
+	maxRepairs = 16
+)
+
+// ring is a view of the nodes of the cluster
+type ring struct {
+	nodes []string
+	rdv   *rendezvous.Rendezvous
+}
+
+func newRing(nodes []string) *ring {
+	nodes = slices.Clone(nodes)
+	slices.Sort(nodes)
+	nodes = slices.Compact(nodes)
+	return &ring{nodes: nodes, rdv: rendezvous.New(nodes, xxhash.Sum64String)}
+}
+
+// Cluster routes the requests for a key to the nodes owning it. The owners of a key are the nodes ranking first for
+// it by rendezvous hashing, so a change of the nodes only moves the keys of the nodes that joined or left.
+type Cluster struct {
+	self     string
+	token    string
+	replicas int
+	redirect bool
+	scheme   string
+	// maxBodyBytes bounds the bodies buffered to be forwarded, 0 means no limit
+	maxBodyBytes int64
+	// readRepairChance is the share of the reads compared with the replicas, repairs holds a slot per running repair
+	readRepairChance float64
+	repairs          chan struct{}
+	ring             atomic.Pointer[ring]
+	gossip           atomic.Pointer[Gossip]
+	client           *http.Client
+	logger           *zerolog.Logger
+}
+
+// New returns the cluster of the node advertised by conf. Its nodes are the static peers of conf, or only itself
+// until SetNodes or WatchDNS provides them. The nodes are reached with https and tlsConfig if it is not nil.
+func New(conf config.ClusterConfig, tlsConfig *tls.Config, maxBodyBytes int64, logger *zerolog.Logger) *Cluster {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsConfig
+	c := &Cluster{
+		self:             conf.Advertise,
+		token:            conf.Token,
+		replicas:         max(conf.ReplicationFactor, 1),
+		redirect:         conf.Redirect,
+		scheme:           "http",
+		maxBodyBytes:     maxBodyBytes,
+		readRepairChance: conf.ReadRepairChance,
+		repairs:          make(chan struct{}, maxRepairs),
+		client:           &http.Client{Transport: transport, Timeout: requestTimeout},
+		logger:           logger,
+	}
+	if tlsConfig != nil {
+		c.scheme = "https"
+	}
+	nodes := conf.PeerList()
+	if len(nodes) == 0 {
+		nodes = []string{c.self}
+	}
+	c.ring.Store(newRing(nodes))
+	return c
+}
+
+// Self returns the address of this node
+func (c *Cluster) Self() string {
+	return c.self
+}
+
+// Nodes returns the addresses of the nodes, sorted
+func (c *Cluster) Nodes() []string {
+	return slices.Clone(c.ring.Load().nodes)
+}
+
+// SetNodes replaces the nodes of the cluster. This node is always kept, so it keeps serving its own keys.
+func (c *Cluster) SetNodes(nodes []string) {
+	if !slices.Contains(nodes, c.self) {
+		nodes = append(slices.Clone(nodes), c.self)
+	}
+	next := newRing(nodes)
+	if previous := c.ring.Swap(next); !slices.Equal(previous.nodes, next.nodes) {
+		c.logger.Info().Strs("nodes", next.nodes).Msg("Cluster nodes changed")
+	}
+}
+
+// SetGossip reports the members of gossip in the state of the cluster. The gossip sets the nodes through SetNodes.
+func (c *Cluster) SetGossip(gossip *Gossip) {
+	c.gossip.Store(gossip)
+}
+
+// ClusterState implements server.ClusterStateProvider
+func (c *Cluster) ClusterState() server.ClusterState {
+	state := server.ClusterState{Self: c.self, Nodes: c.Nodes(), ReplicationFactor: c.replicas}
+	if gossip := c.gossip.Load(); gossip != nil {
+		for _, m := range gossip.Members() {
+			state.Members = append(state.Members, server.ClusterMember{
+				Name: m.Name, Addr: m.Addr, State: m.State, Incarnation: m.Incarnation,
+			})
+		}
+		return state
+	}
+	for _, node := range state.Nodes {
+		state.Members = append(state.Members, server.ClusterMember{Name: node, State: StateAlive})
+	}
+	return state
+}
+
+// Owners returns the nodes holding key, from the one coordinating its reads and writes to its replicas
+func (c *Cluster) Owners(key string) []string {
+	r := c.ring.Load()
+	n := min(c.replicas, len(r.nodes))
+	owners := make([]string, 0, n)
+	owner := r.rdv.Lookup(key)
+	owners = append(owners, owner)
+	remaining := r.nodes
+	for len(owners) < n {
+		remaining = slices.DeleteFunc(slices.Clone(remaining), func(node string) bool { return node == owner })
+		owner = rendezvous.New(remaining, xxhash.Sum64String).Lookup(key)
+		owners = append(owners, owner)
+	}
+	return owners
+}
+
+// WatchDNS sets the nodes to the addresses host resolves to, with port, every interval until ctx is done. The nodes
+// are kept when the lookup fails. The addresses this node is advertised at are replaced by its advertised address,
+// so it is not listed twice when it is advertised by hostname.
+func (c *Cluster) WatchDNS(ctx context.Context, host string, port string, interval time.Duration,
+	lookup func(ctx context.Context, host string) ([]string, error)) {
+	var self []string
+	resolve := func() {
+		addrs, err := lookup(ctx, host)
+		if err != nil {
+			c.logger.Warn().Err(err).Str("host", host).Msg("Failed to resolve the cluster nodes")
+			return
+		}
+		if resolved, err := c.resolveSelf(ctx, lookup); err != nil {
+			// the addresses of the previous lookup are kept
+			c.logger.Warn().Err(err).Str("advertise", c.self).Msg("Failed to resolve the address of this node")
+		} else {
+			self = resolved
+		}
+		nodes := make([]string, 0, len(addrs))
+		for _, addr := range addrs {
+			node := net.JoinHostPort(addr, port)
+			if slices.Contains(self, node) {
+				node = c.self
+			}
+			nodes = append(nodes, node)
+		}
+		c.SetNodes(nodes)
+	}
+	resolve()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			resolve()
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// resolveSelf returns the addresses the advertised host of this node resolves to, with its port
+func (c *Cluster) resolveSelf(ctx context.Context, lookup func(ctx context.Context, host string) ([]string, error)) (
+	[]string, error) {
+	host, port, err := net.SplitHostPort(c.self)
+	if err != nil {
+		return nil, err
+	}
+	if net.ParseIP(host) != nil {
+		return []string{c.self}, nil
+	}
+	addrs, err := lookup(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+	self := make([]string, 0, len(addrs))
+	for _, addr := range addrs {
+		self = append(self, net.JoinHostPort(addr, port))
+	}
+	return self, nil
+}
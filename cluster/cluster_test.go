@@ -0,0 +1,182 @@
+package cluster
+
+import (
+	"cache-api/config"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"slices"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+func newTestCluster(self string, peers string, replicas int) *Cluster {
+	logger := zerolog.Nop()
+	return New(config.ClusterConfig{Advertise: self, Peers: peers, ReplicationFactor: replicas, Token: "token"},
+		nil, 0, &logger)
+}
+
+func TestCluster_Owners(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name          string
+		peers         string
+		replicas      int
+		expectedCount int
+	}{
+		{name: "Should own every key alone", peers: "a:1", replicas: 1, expectedCount: 1},
+		{name: "Should pick one owner", peers: "a:1,b:1,c:1", replicas: 1, expectedCount: 1},
+		{name: "Should pick distinct replicas", peers: "a:1,b:1,c:1", replicas: 2, expectedCount: 2},
+		{name: "Should cap the replicas to the nodes", peers: "a:1,b:1", replicas: 3, expectedCount: 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newTestCluster("a:1", tt.peers, tt.replicas)
+			for i := 0; i < 100; i++ {
+				owners := c.Owners(fmt.Sprintf("/key-%d", i))
+				if len(owners) != tt.expectedCount {
+					t.Fatalf("Expected %d owners, got %v", tt.expectedCount, owners)
+				}
+				sorted := slices.Clone(owners)
+				slices.Sort(sorted)
+				if len(slices.Compact(sorted)) != len(owners) {
+					t.Fatalf("Expected distinct owners, got %v", owners)
+				}
+			}
+		})
+	}
+}
+
+func TestCluster_OwnersAreStable(t *testing.T) {
+	t.Parallel()
+	c := newTestCluster("a:1", "a:1,b:1,c:1", 2)
+	reordered := newTestCluster("c:1", "c:1,b:1,a:1", 2)
+	grown := newTestCluster("a:1", "a:1,b:1,c:1,d:1", 1)
+	moved := 0
+	for i := 0; i < 1000; i++ {
+		key := fmt.Sprintf("/key-%d", i)
+		if !reflect.DeepEqual(c.Owners(key), reordered.Owners(key)) {
+			t.Fatalf("Expected the owners not to depend on the order of the peers for %s", key)
+		}
+		if owner := grown.Owners(key)[0]; owner != c.Owners(key)[0] {
+			if owner != "d:1" {
+				t.Fatalf("Expected keys to only move to the new node, %s moved to %s", key, owner)
+			}
+			moved++
+		}
+	}
+	if moved == 0 || moved > 400 {
+		t.Errorf("Expected about a quarter of the keys to move to the new node, got %d of 1000", moved)
+	}
+}
+
+func TestCluster_SetNodes(t *testing.T) {
+	t.Parallel()
+	c := newTestCluster("a:1", "", 1)
+	if nodes := c.Nodes(); !reflect.DeepEqual(nodes, []string{"a:1"}) {
+		t.Errorf("Expected the cluster to start with itself, got %v", nodes)
+	}
+	c.SetNodes([]string{"c:1", "b:1", "b:1"})
+	if nodes := c.Nodes(); !reflect.DeepEqual(nodes, []string{"a:1", "b:1", "c:1"}) {
+		t.Errorf("Expected the sorted nodes with itself, got %v", nodes)
+	}
+}
+
+func TestCluster_WatchDNS(t *testing.T) {
+	t.Parallel()
+	c := newTestCluster("10.0.0.1:8080", "", 1)
+	var calls atomic.Int32
+	lookup := func(_ context.Context, host string) ([]string, error) {
+		if host != "cache.svc" {
+			t.Errorf("Expected to resolve cache.svc, got %s", host)
+		}
+		if calls.Add(1) > 1 {
+			return nil, errors.New("no such host")
+		}
+		return []string{"10.0.0.2", "10.0.0.1"}, nil
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		c.WatchDNS(ctx, "cache.svc", "8080", time.Millisecond, lookup)
+	}()
+	for calls.Load() < 3 {
+		time.Sleep(time.Millisecond)
+	}
+	cancel()
+	<-done
+	expected := []string{"10.0.0.1:8080", "10.0.0.2:8080"}
+	if nodes := c.Nodes(); !reflect.DeepEqual(nodes, expected) {
+		t.Errorf("Expected the nodes to be kept after failed lookups %v, got %v", expected, nodes)
+	}
+}
+
+func TestCluster_WatchDNS_Hostname(t *testing.T) {
+	t.Parallel()
+	c := newTestCluster("cache-0.cache.svc:8080", "", 1)
+	lookup := func(_ context.Context, host string) ([]string, error) {
+		switch host {
+		case "cache.svc":
+			return []string{"10.0.0.2", "10.0.0.1"}, nil
+		case "cache-0.cache.svc":
+			return []string{"10.0.0.1"}, nil
+		}
+		return nil, errors.New("no such host")
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	c.WatchDNS(ctx, "cache.svc", "8080", time.Hour, lookup)
+	expected := []string{"10.0.0.2:8080", "cache-0.cache.svc:8080"}
+	if nodes := c.Nodes(); !reflect.DeepEqual(nodes, expected) {
+		t.Errorf("Expected this node once under its advertised address %v, got %v", expected, nodes)
+	}
+}
+
+func TestKeyOf(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		method      string
+		target      string
+		expectedKey string
+		expectedOK  bool
+	}{
+		{method: http.MethodGet, target: "/user:1", expectedKey: "/user:1", expectedOK: true},
+		{method: http.MethodPost, target: "/user:1", expectedKey: "/user:1", expectedOK: true},
+		{method: http.MethodPost, target: "/user:1/incr?delta=2", expectedKey: "/user:1", expectedOK: true},
+		{method: http.MethodGet, target: "/ns/team-a/user%2F1", expectedKey: "team-a/user/1", expectedOK: true},
+		{method: http.MethodPost, target: "/ns/team-a/user:1/incr", expectedKey: "team-a/user:1", expectedOK: true},
+		{method: http.MethodGet, target: "/user:1/incr"},
+		{method: http.MethodGet, target: "/_keys"},
+		{method: http.MethodGet, target: "/ns/team-a/_keys"},
+		{method: http.MethodGet, target: "/_admin/stats"},
+		{method: http.MethodPost, target: "/_tags/product/invalidate"},
+		{method: http.MethodDelete, target: "/_keys?prefix=a"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.method+" "+tt.target, func(t *testing.T) {
+			key, ok := keyOf(httptest.NewRequest(tt.method, tt.target, nil))
+			if key != tt.expectedKey || ok != tt.expectedOK {
+				t.Errorf("Expected %q and %v, got %q and %v", tt.expectedKey, tt.expectedOK, key, ok)
+			}
+		})
+	}
+}
+
+func TestCluster_ClusterState(t *testing.T) {
+	t.Parallel()
+	c := newTestCluster("a:1", "a:1,b:1", 2)
+	state := c.ClusterState()
+	if state.Self != "a:1" || state.ReplicationFactor != 2 || !reflect.DeepEqual(state.Nodes, []string{"a:1", "b:1"}) {
+		t.Errorf("Expected the state of the static cluster, got %+v", state)
+	}
+	if len(state.Members) != 2 || state.Members[1].Name != "b:1" || state.Members[1].State != StateAlive {
+		t.Errorf("Expected the static nodes to be alive members, got %+v", state.Members)
+	}
+}
@@ -0,0 +1,199 @@
+package cluster
+
+import (
+	"bytes"
+	"cache-api/cache"
+	"cache-api/server"
+	"encoding/json"
+	"io"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// fanOut is how the results of a request sent to every node are combined
+type fanOut int
+
+const (
+	// fanOutCount sums the counts of the removals of every node
+	fanOutCount fanOut = iota + 1
+	// fanOutList merges the pages of keys of every node
+	fanOutList
+)
+
+// fanOutOf returns how to combine the results of the request if it acts on the keys of every node: the listing and
+// bulk removal of keys, the invalidation of a tag and the flush
+func fanOutOf(r *http.Request) (fanOut, bool) {
+	segments := strings.Split(strings.TrimPrefix(r.URL.EscapedPath(), "/"), "/")
+	if len(segments) > 2 && segments[0] == "ns" {
+		segments = segments[2:]
+	}
+	switch {
+	case len(segments) == 1 && segments[0] == "_keys" && r.Method == http.MethodGet:
+		return fanOutList, true
+	case len(segments) == 1 && segments[0] == "_keys" && r.Method == http.MethodDelete:
+		return fanOutCount, true
+	case len(segments) == 3 && segments[0] == "_tags" && segments[2] == "invalidate" && r.Method == http.MethodPost:
+		return fanOutCount, true
+	case len(segments) == 2 && segments[0] == "_admin" && segments[1] == "flush" && r.Method == http.MethodPost:
+		return fanOutCount, true
+	}
+	return 0, false
+}
+
+// broadcast serves the request on this node with cache and sends it to every other node, combining their responses.
+// A failure of the request on this node is answered as is, as it is likely a bad request, and a failure on another
+// node is answered with 502 Bad Gateway so the client retries rather than getting a partial result.
+func (c *Cluster) broadcast(w http.ResponseWriter, r *http.Request, combine fanOut, cache http.Handler) {
+	body, ok := c.readBody(w, r)
+	if !ok {
+		return
+	}
+	nodes := c.Nodes()
+	responses := make([]*bufferedResponse, len(nodes))
+	var wg sync.WaitGroup
+	for i, node := range nodes {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if node == c.self {
+				local := r.Clone(r.Context())
+				local.Body = io.NopCloser(bytes.NewReader(body))
+				responses[i] = newBufferedResponse()
+				cache.ServeHTTP(responses[i], local)
+				return
+			}
+			resp, err := c.send(r.Context(), node, r.Method, r.URL.RequestURI(), r.Header, forwardedHeaders, body,
+				roleReplica)
+			if err != nil {
+				c.logger.Warn().Err(err).Str("node", node).Str("path", r.URL.Path).Msg("Failed to send request to node")
+				return
+			}
+			defer resp.Body.Close()
+			responses[i] = newBufferedResponse()
+			responses[i].status = resp.StatusCode
+			responses[i].header = resp.Header
+			if _, err := io.Copy(&responses[i].body, resp.Body); err != nil {
+				c.logger.Warn().Err(err).Str("node", node).Str("path", r.URL.Path).Msg("Failed to read response of node")
+				responses[i] = nil
+			}
+		}()
+	}
+	wg.Wait()
+
+	if local := responses[slices.Index(nodes, c.self)]; local.status >= http.StatusMultipleChoices {
+		local.copyTo(w)
+		return
+	}
+	for i, resp := range responses {
+		if resp == nil || resp.status >= http.StatusMultipleChoices {
+			if resp != nil {
+				c.logger.Warn().Str("node", nodes[i]).Int("status", resp.status).Str("path", r.URL.Path).
+					Msg("Node failed the request")
+			}
+			http.Error(w, http.StatusText(http.StatusBadGateway), http.StatusBadGateway)
+			return
+		}
+	}
+	var combined any
+	var err error
+	switch combine {
+	case fanOutCount:
+		combined, err = sumCounts(responses)
+	case fanOutList:
+		combined, err = mergeKeys(responses, r)
+	}
+	if err != nil {
+		c.logger.Error().Err(err).Str("path", r.URL.Path).Msg("Failed to combine the responses of the nodes")
+		http.Error(w, http.StatusText(http.StatusBadGateway), http.StatusBadGateway)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(combined); err != nil {
+		c.logger.Error().Err(err).Msg("Failed to write response")
+	}
+}
+
+// sumCounts adds up the counts of the responses, e.g. `{"deleted": 3}`. A key held by several replicas counts once
+// per node it was removed from.
+func sumCounts(responses []*bufferedResponse) (map[string]int, error) {
+	sum := make(map[string]int)
+	for _, resp := range responses {
+		var counts map[string]int
+		if err := json.Unmarshal(resp.body.Bytes(), &counts); err != nil {
+			return nil, err
+		}
+		for name, count := range counts {
+			sum[name] += count
+		}
+	}
+	return sum, nil
+}
+
+type keyResponse struct {
+	Key   string `json:"key"`
+	TTLMs *int64 `json:"ttl_ms,omitempty"`
+}
+
+type keysResponse struct {
+	Keys   []keyResponse `json:"keys"`
+	Cursor string        `json:"cursor"`
+}
+
+// mergeKeys merges the pages of keys of the nodes, in lexical order and without the copies of the replicas. A node
+// with more keys than its page may have keys sorting before the last keys of the other pages, so the merged page stops
+// at the first of the last keys of the nodes having more, and its cursor resumes every node after it.
+func mergeKeys(responses []*bufferedResponse, r *http.Request) (keysResponse, error) {
+	var keys []keyResponse
+	bound, bounded := "", false
+	for _, resp := range responses {
+		var page keysResponse
+		if err := json.Unmarshal(resp.body.Bytes(), &page); err != nil {
+			return keysResponse{}, err
+		}
+		if page.Cursor != "" && len(page.Keys) > 0 {
+			if last := page.Keys[len(page.Keys)-1].Key; !bounded || last < bound {
+				bound, bounded = last, true
+			}
+		}
+		keys = append(keys, page.Keys...)
+	}
+	slices.SortStableFunc(keys, func(a, b keyResponse) int { return strings.Compare(a.Key, b.Key) })
+	keys = slices.CompactFunc(keys, func(a, b keyResponse) bool { return a.Key == b.Key })
+	if bounded {
+		end, _ := slices.BinarySearchFunc(keys, bound, func(k keyResponse, bound string) int {
+			return strings.Compare(k.Key, bound)
+		})
+		keys = keys[:end+1]
+	}
+	// the nodes were asked for the same limit, so the merged page is as long as theirs
+	if limit, err := limitOf(r); err != nil {
+		return keysResponse{}, err
+	} else if len(keys) > limit {
+		keys, bounded = keys[:limit], true
+	}
+	merged := keysResponse{Keys: keys}
+	if merged.Keys == nil {
+		merged.Keys = []keyResponse{}
+	}
+	if bounded && len(keys) > 0 {
+		merged.Cursor = cache.CursorAfter(keys[len(keys)-1].Key)
+	}
+	return merged, nil
+}
+
+// limitOf returns the number of keys a page of the request holds at most, as the nodes parsed it already
+func limitOf(r *http.Request) (int, error) {
+	query := r.URL.Query()
+	if !query.Has("limit") {
+		return server.DefaultScanLimit, nil
+	}
+	limit, err := strconv.Atoi(query.Get("limit"))
+	if err != nil {
+		return 0, err
+	}
+	return min(limit, server.MaxScanLimit), nil
+}
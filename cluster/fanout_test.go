@@ -0,0 +1,114 @@
+package cluster
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"testing"
+)
+
+// keysOf returns the keys of the nodes, by the node holding them
+func keysOf(nodes []*testNode) map[string][]string {
+	keys := make(map[string][]string)
+	for _, node := range nodes {
+		for i := 0; i < 30; i++ {
+			key := fmt.Sprintf("user:%02d", i)
+			if _, ok := node.cache.Get(key); ok {
+				keys[node.addr] = append(keys[node.addr], key)
+			}
+		}
+	}
+	return keys
+}
+
+func TestRoute_FanOut_List(t *testing.T) {
+	t.Parallel()
+	nodes := startNodes(t, 3, 2, false)
+	var want []string
+	for i := 0; i < 30; i++ {
+		key := fmt.Sprintf("user:%02d", i)
+		if code, _ := do(t, http.MethodPost, nodes[0].server.URL+"/"+key, "value", nil); code != http.StatusCreated {
+			t.Fatalf("Expected status code %d, got %d", http.StatusCreated, code)
+		}
+		want = append(want, key)
+	}
+
+	var got []string
+	cursor := ""
+	for pages := 0; pages < 10; pages++ {
+		code, body := do(t, http.MethodGet, nodes[1].server.URL+"/_keys?prefix=user:&limit=7&cursor="+cursor, "", nil)
+		if code != http.StatusOK {
+			t.Fatalf("Expected status code %d, got %d %q", http.StatusOK, code, body)
+		}
+		var page keysResponse
+		if err := json.Unmarshal([]byte(body), &page); err != nil {
+			t.Fatal(err)
+		}
+		if len(page.Keys) > 7 {
+			t.Errorf("Expected at most 7 keys per page, got %d", len(page.Keys))
+		}
+		for _, key := range page.Keys {
+			got = append(got, key.Key)
+		}
+		if page.Cursor == "" {
+			break
+		}
+		cursor = page.Cursor
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("Expected every key once and in order, got %v", got)
+	}
+}
+
+func TestRoute_FanOut_Remove(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		want   string
+	}{
+		{name: "delete keys", method: http.MethodDelete, path: "/_keys?prefix=user:", want: `{"deleted":60}`},
+		{name: "invalidate tag", method: http.MethodPost, path: "/_tags/users/invalidate", want: `{"invalidated":60}`},
+		{name: "flush", method: http.MethodPost, path: "/_admin/flush", want: `{"deleted":60}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			nodes := startNodes(t, 3, 2, false)
+			for i := 0; i < 30; i++ {
+				url := fmt.Sprintf("%s/user:%02d", nodes[0].server.URL, i)
+				code, _ := do(t, http.MethodPost, url, "value", http.Header{"Cache-Tags": {"users"}})
+				if code != http.StatusCreated {
+					t.Fatalf("Expected status code %d, got %d", http.StatusCreated, code)
+				}
+			}
+			if len(keysOf(nodes)) < 2 {
+				t.Fatalf("Expected the keys to spread over the nodes")
+			}
+			// every copy of the keys is removed, whichever node the request reaches
+			code, body := do(t, tt.method, nodes[2].server.URL+tt.path, "", nil)
+			if code != http.StatusOK || body != tt.want+"\n" {
+				t.Errorf("Expected %s, got %d %q", tt.want, code, body)
+			}
+			if left := keysOf(nodes); len(left) != 0 {
+				t.Errorf("Expected no key left, got %v", left)
+			}
+		})
+	}
+}
+
+func TestRoute_FanOut_Failure(t *testing.T) {
+	t.Parallel()
+	nodes := startNodes(t, 3, 1, false)
+	nodes[1].server.Close()
+	code, _ := do(t, http.MethodDelete, nodes[0].server.URL+"/_keys?prefix=user:", "", nil)
+	if code != http.StatusBadGateway {
+		t.Errorf("Expected status code %d with a node down, got %d", http.StatusBadGateway, code)
+	}
+	// a request the node rejects itself is answered as is
+	if code, _ := do(t, http.MethodDelete, nodes[0].server.URL+"/_keys", "", nil); code != http.StatusBadRequest {
+		t.Errorf("Expected status code %d, got %d", http.StatusBadRequest, code)
+	}
+}
@@ -0,0 +1,482 @@
+package cluster
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/json"
+	"math"
+	"math/rand/v2"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+// The states of a member of the gossip
+const (
+	StateAlive = "alive"
+	// StateSuspect is a member that did not answer a probe, it is declared dead unless it refutes the suspicion
+	StateSuspect = "suspect"
+	StateDead    = "dead"
+	// StateLeft is a member that announced it was leaving
+	StateLeft = "left"
+)
+
+// The types of the gossip messages
+const (
+	msgPing = "ping"
+	// msgPingReq asks a member to probe the target on behalf of the sender
+	msgPingReq = "ping_req"
+	msgAck     = "ack"
+	// msgSync carries the state of every member, and is answered with an ack carrying the state known by the receiver
+	msgSync = "sync"
+)
+
+// maxPiggyback is the number of updates carried by a message on top of its own
+const maxPiggyback = 16
+
+// GossipTiming sets the pace of the gossip
+type GossipTiming struct {
+	// ProbeInterval is how often a member is probed
+	ProbeInterval time.Duration
+	// ProbeTimeout is how long a direct probe waits for its ack before asking other members to probe
+	ProbeTimeout time.Duration
+	// SuspicionTimeout is how long a suspect member has to refute the suspicion before it is declared dead
+	SuspicionTimeout time.Duration
+	// SyncInterval is how often the full state is exchanged with a member, preferring dead ones so partitions heal
+	SyncInterval time.Duration
+	// IndirectChecks is the number of members asked to probe a member that did not answer
+	IndirectChecks int
+	// RetransmitMult scales the number of messages an update is carried by, with the log of the number of members
+	RetransmitMult int
+}
+
+// DefaultGossipTiming returns the timing for a cluster on a local network
+func DefaultGossipTiming() GossipTiming {
+	return GossipTiming{
+		ProbeInterval:    time.Second,
+		ProbeTimeout:     300 * time.Millisecond,
+		SuspicionTimeout: 5 * time.Second,
+		SyncInterval:     30 * time.Second,
+		IndirectChecks:   3,
+		RetransmitMult:   4,
+	}
+}
+
+// Member is a node as known by the gossip
+type Member struct {
+	// Name is the address the node serves the cache at
+	Name string `json:"name"`
+	// Addr is the address the node gossips at
+	Addr  string `json:"addr"`
+	State string `json:"state"`
+	// Incarnation orders the updates about the member, only the member itself increases it to refute a suspicion
+	Incarnation uint64 `json:"incarnation"`
+}
+
+// gossipMessage is sent after the HMAC-SHA256 of its encoding keyed by the token of the cluster, so only the nodes
+// knowing the token take part in the gossip. Its replies are sent to the address the packet came from.
+type gossipMessage struct {
+	Type    string   `json:"type"`
+	Seq     uint64   `json:"seq,omitempty"`
+	Target  string   `json:"target,omitempty"`
+	Updates []Member `json:"updates,omitempty"`
+}
+
+type memberState struct {
+	Member
+	// changed is when the state last changed, to time out suspicions
+	changed time.Time
+}
+
+type broadcast struct {
+	member    Member
+	remaining int
+}
+
+// Gossip maintains the members of the cluster with the SWIM protocol. Every ProbeInterval a member is probed
+// directly, then through other members, and becomes suspect if none got an answer. A suspect member refutes the
+// suspicion by increasing its incarnation, or is declared dead after SuspicionTimeout. Changes are carried by the
+// probes and their acks, and the full state is exchanged with a member every SyncInterval.
+type Gossip struct {
+	self      Member
+	seeds     []string
+	transport Transport
+	// key signs the messages
+	key    []byte
+	timing GossipTiming
+	// onChange receives the names of the live members, alive or suspect, when they change
+	onChange func(nodes []string)
+	logger   *zerolog.Logger
+
+	// mutex guards the fields below
+	mutex      sync.Mutex
+	members    map[string]*memberState
+	broadcasts []*broadcast
+	acks       map[uint64]chan struct{}
+	probeOrder []string
+	nodes      []string
+	seq        atomic.Uint64
+}
+
+// NewGossip returns the gossip of the node serving at name and gossiping at addr over transport, signing its messages
+// with token. It joins through the seeds, the gossip addresses of some members. onChange is called with the mutex of
+// the gossip held, so it must not call the gossip.
+func NewGossip(name string, addr string, transport Transport, token string, seeds []string, timing GossipTiming,
+	onChange func(nodes []string), logger *zerolog.Logger) *Gossip {
+	// a restarted node starts with a higher incarnation than the one it was declared dead with
+	self := Member{Name: name, Addr: addr, State: StateAlive, Incarnation: uint64(time.Now().UnixNano())}
+	return &Gossip{
+		self:      self,
+		seeds:     slices.DeleteFunc(slices.Clone(seeds), func(seed string) bool { return seed == addr }),
+		transport: transport,
+		key:       []byte(token),
+		timing:    timing,
+		onChange:  onChange,
+		logger:    logger,
+		members:   map[string]*memberState{name: {Member: self, changed: time.Now()}},
+		acks:      make(map[uint64]chan struct{}),
+		nodes:     []string{name},
+	}
+}
+
+// Members returns the members known by the gossip, sorted by name
+func (g *Gossip) Members() []Member {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	members := make([]Member, 0, len(g.members))
+	for _, m := range g.members {
+		members = append(members, m.Member)
+	}
+	slices.SortFunc(members, func(a, b Member) int { return strings.Compare(a.Name, b.Name) })
+	return members
+}
+
+// Run gossips until ctx is done, then announces the node leaves and closes the transport
+func (g *Gossip) Run(ctx context.Context) {
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for packet := range g.transport.Packets() {
+			g.handle(packet)
+		}
+	}()
+	for _, seed := range g.seeds {
+		g.send(seed, gossipMessage{Type: msgSync, Updates: g.Members()})
+	}
+	probe := time.NewTicker(g.timing.ProbeInterval)
+	defer probe.Stop()
+	syncTicker := time.NewTicker(g.timing.SyncInterval)
+	defer syncTicker.Stop()
+	for {
+		select {
+		case <-probe.C:
+			g.expireSuspects()
+			g.probe(ctx)
+		case <-syncTicker.C:
+			g.sync()
+		case <-ctx.Done():
+			g.leave()
+			_ = g.transport.Close()
+			<-done
+			return
+		}
+	}
+}
+
+func (g *Gossip) handle(packet Packet) {
+	data, ok := g.verify(packet.Data)
+	if !ok {
+		// not logged above debug, so unauthenticated packets can not flood the logs
+		g.logger.Debug().Str("from", packet.From).Msg("Ignored an unauthenticated gossip message")
+		return
+	}
+	var msg gossipMessage
+	if err := json.Unmarshal(data, &msg); err != nil {
+		g.logger.Debug().Err(err).Str("from", packet.From).Msg("Ignored an invalid gossip message")
+		return
+	}
+	g.apply(msg.Updates)
+	switch msg.Type {
+	case msgPing:
+		g.send(packet.From, gossipMessage{Type: msgAck, Seq: msg.Seq})
+	case msgSync:
+		g.send(packet.From, gossipMessage{Type: msgAck, Seq: msg.Seq, Updates: g.Members()})
+	case msgAck:
+		g.mutex.Lock()
+		if ack, ok := g.acks[msg.Seq]; ok {
+			close(ack)
+			delete(g.acks, msg.Seq)
+		}
+		g.mutex.Unlock()
+	case msgPingReq:
+		go g.probeFor(msg, packet.From)
+	}
+}
+
+// probeFor probes the target of a ping request and acks the request to from if the target answers
+func (g *Gossip) probeFor(request gossipMessage, from string) {
+	seq := g.seq.Add(1)
+	ack := g.awaitAck(seq)
+	g.send(request.Target, gossipMessage{Type: msgPing, Seq: seq})
+	select {
+	case <-ack:
+		g.send(from, gossipMessage{Type: msgAck, Seq: request.Seq})
+	case <-time.After(g.timing.ProbeTimeout):
+		g.cancelAck(seq)
+	}
+}
+
+// probe checks the next member, and suspects it if neither it nor the members asked to probe it answered
+func (g *Gossip) probe(ctx context.Context) {
+	target, ok := g.nextTarget()
+	if !ok {
+		return
+	}
+	seq := g.seq.Add(1)
+	ack := g.awaitAck(seq)
+	defer g.cancelAck(seq)
+	g.send(target.Addr, gossipMessage{Type: msgPing, Seq: seq})
+	select {
+	case <-ack:
+		return
+	case <-time.After(g.timing.ProbeTimeout):
+	case <-ctx.Done():
+		return
+	}
+	for _, helper := range g.randomMembers(g.timing.IndirectChecks, target.Name) {
+		g.send(helper.Addr, gossipMessage{Type: msgPingReq, Seq: seq, Target: target.Addr})
+	}
+	select {
+	case <-ack:
+		return
+	case <-time.After(g.timing.ProbeInterval - g.timing.ProbeTimeout):
+	case <-ctx.Done():
+		return
+	}
+	target.State = StateSuspect
+	g.apply([]Member{target})
+}
+
+// sync exchanges the full state with a dead member, or with a live member or a seed if none is dead
+func (g *Gossip) sync() {
+	g.mutex.Lock()
+	var dead, live []string
+	for _, m := range g.members {
+		switch {
+		case m.Name == g.self.Name || m.State == StateLeft:
+		case m.State == StateDead:
+			dead = append(dead, m.Addr)
+		default:
+			live = append(live, m.Addr)
+		}
+	}
+	g.mutex.Unlock()
+	candidates := dead
+	if len(candidates) == 0 {
+		candidates = append(live, g.seeds...)
+	}
+	if len(candidates) == 0 {
+		return
+	}
+	g.send(candidates[rand.IntN(len(candidates))], gossipMessage{Type: msgSync, Updates: g.Members()})
+}
+
+// leave announces the node leaves to every live member
+func (g *Gossip) leave() {
+	g.mutex.Lock()
+	self := g.members[g.self.Name].Member
+	self.State = StateLeft
+	var live []string
+	for _, m := range g.members {
+		if m.Name != g.self.Name && (m.State == StateAlive || m.State == StateSuspect) {
+			live = append(live, m.Addr)
+		}
+	}
+	g.mutex.Unlock()
+	for _, addr := range live {
+		g.send(addr, gossipMessage{Type: msgPing, Updates: []Member{self}})
+	}
+}
+
+// expireSuspects declares dead the members that did not refute their suspicion in time
+func (g *Gossip) expireSuspects() {
+	g.mutex.Lock()
+	var expired []Member
+	for _, m := range g.members {
+		if m.State == StateSuspect && time.Since(m.changed) > g.timing.SuspicionTimeout {
+			dead := m.Member
+			dead.State = StateDead
+			expired = append(expired, dead)
+		}
+	}
+	g.mutex.Unlock()
+	g.apply(expired)
+}
+
+// nextTarget returns the next live member to probe, going through them in a random order
+func (g *Gossip) nextTarget() (Member, bool) {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	for {
+		if len(g.probeOrder) == 0 {
+			for name, m := range g.members {
+				if name != g.self.Name && (m.State == StateAlive || m.State == StateSuspect) {
+					g.probeOrder = append(g.probeOrder, name)
+				}
+			}
+			if len(g.probeOrder) == 0 {
+				return Member{}, false
+			}
+			rand.Shuffle(len(g.probeOrder), func(i, j int) {
+				g.probeOrder[i], g.probeOrder[j] = g.probeOrder[j], g.probeOrder[i]
+			})
+		}
+		name := g.probeOrder[0]
+		g.probeOrder = g.probeOrder[1:]
+		if m, ok := g.members[name]; ok && (m.State == StateAlive || m.State == StateSuspect) {
+			return m.Member, true
+		}
+	}
+}
+
+// randomMembers returns up to n random alive members other than this node and the excluded one
+func (g *Gossip) randomMembers(n int, exclude string) []Member {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	var candidates []Member
+	for name, m := range g.members {
+		if name != g.self.Name && name != exclude && m.State == StateAlive {
+			candidates = append(candidates, m.Member)
+		}
+	}
+	rand.Shuffle(len(candidates), func(i, j int) { candidates[i], candidates[j] = candidates[j], candidates[i] })
+	return candidates[:min(n, len(candidates))]
+}
+
+func (g *Gossip) awaitAck(seq uint64) chan struct{} {
+	ack := make(chan struct{})
+	g.mutex.Lock()
+	g.acks[seq] = ack
+	g.mutex.Unlock()
+	return ack
+}
+
+func (g *Gossip) cancelAck(seq uint64) {
+	g.mutex.Lock()
+	delete(g.acks, seq)
+	g.mutex.Unlock()
+}
+
+// apply merges updates about members into the state, queues the accepted ones to be gossiped further and notifies
+// onChange if the live members changed
+func (g *Gossip) apply(updates []Member) {
+	if len(updates) == 0 {
+		return
+	}
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	for _, update := range updates {
+		g.applyLocked(update)
+	}
+	var nodes []string
+	for name, m := range g.members {
+		if m.State == StateAlive || m.State == StateSuspect {
+			nodes = append(nodes, name)
+		}
+	}
+	slices.Sort(nodes)
+	if !slices.Equal(nodes, g.nodes) {
+		g.nodes = nodes
+		g.onChange(slices.Clone(nodes))
+	}
+}
+
+func (g *Gossip) applyLocked(update Member) {
+	if update.Name == g.self.Name {
+		self := g.members[g.self.Name]
+		if (update.State == StateSuspect || update.State == StateDead) && update.Incarnation >= self.Incarnation {
+			// refute the suspicion, the update about the new incarnation overrides it everywhere
+			self.Incarnation = update.Incarnation + 1
+			self.changed = time.Now()
+			g.queueLocked(self.Member)
+			g.logger.Info().Str("state", update.State).Msg("Refuted suspicion about this node")
+		}
+		return
+	}
+	m, known := g.members[update.Name]
+	accept := !known
+	if known {
+		switch update.State {
+		case StateAlive:
+			accept = update.Incarnation > m.Incarnation
+		case StateSuspect:
+			accept = update.Incarnation > m.Incarnation || (update.Incarnation == m.Incarnation && m.State == StateAlive)
+		case StateDead, StateLeft:
+			accept = update.Incarnation > m.Incarnation ||
+				(update.Incarnation == m.Incarnation && m.State != StateDead && m.State != StateLeft)
+		}
+	}
+	if !accept {
+		return
+	}
+	if !known || m.State != update.State {
+		g.logger.Info().Str("member", update.Name).Str("state", update.State).Msg("Member changed state")
+	}
+	g.members[update.Name] = &memberState{Member: update, changed: time.Now()}
+	g.queueLocked(update)
+}
+
+// queueLocked gossips the update with the next messages, replacing an older update about the same member
+func (g *Gossip) queueLocked(update Member) {
+	g.broadcasts = slices.DeleteFunc(g.broadcasts, func(b *broadcast) bool { return b.member.Name == update.Name })
+	retransmits := g.timing.RetransmitMult * int(math.Ceil(math.Log2(float64(len(g.members)+1))))
+	g.broadcasts = append(g.broadcasts, &broadcast{member: update, remaining: max(retransmits, 1)})
+}
+
+// piggyback returns the updates to carry with the next message
+func (g *Gossip) piggyback() []Member {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	var updates []Member
+	for _, b := range g.broadcasts[:min(maxPiggyback, len(g.broadcasts))] {
+		updates = append(updates, b.member)
+		b.remaining--
+	}
+	g.broadcasts = slices.DeleteFunc(g.broadcasts, func(b *broadcast) bool { return b.remaining <= 0 })
+	return updates
+}
+
+func (g *Gossip) send(addr string, msg gossipMessage) {
+	msg.Updates = append(msg.Updates, g.piggyback()...)
+	data, err := json.Marshal(msg)
+	if err != nil {
+		g.logger.Error().Err(err).Msg("Failed to encode gossip message")
+		return
+	}
+	if err := g.transport.Send(addr, g.sign(data)); err != nil {
+		g.logger.Debug().Err(err).Str("addr", addr).Msg("Failed to send gossip message")
+	}
+}
+
+// sign returns the packet carrying data, prefixed by its HMAC
+func (g *Gossip) sign(data []byte) []byte {
+	mac := hmac.New(sha256.New, g.key)
+	mac.Write(data)
+	return append(mac.Sum(nil), data...)
+}
+
+// verify returns the data of the packet if its HMAC is valid
+func (g *Gossip) verify(packet []byte) ([]byte, bool) {
+	if len(packet) < sha256.Size {
+		return nil, false
+	}
+	sum, data := packet[:sha256.Size], packet[sha256.Size:]
+	mac := hmac.New(sha256.New, g.key)
+	mac.Write(data)
+	return data, hmac.Equal(sum, mac.Sum(nil))
+}
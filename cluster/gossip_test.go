@@ -0,0 +1,277 @@
+package cluster
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+// memNetwork connects memTransports in memory, and can cut the links between them to simulate partitions
+type memNetwork struct {
+	mutex      sync.Mutex
+	transports map[string]*memTransport
+	cut        map[[2]string]bool
+}
+
+func newMemNetwork() *memNetwork {
+	return &memNetwork{transports: make(map[string]*memTransport), cut: make(map[[2]string]bool)}
+}
+
+func (n *memNetwork) listen(addr string) *memTransport {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	t := &memTransport{addr: addr, network: n, packets: make(chan Packet, 256)}
+	n.transports[addr] = t
+	return t
+}
+
+// partition cuts the links between the addresses of side and the others
+func (n *memNetwork) partition(side ...string) {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	for _, a := range side {
+		for b := range n.transports {
+			if !slices.Contains(side, b) {
+				n.cut[[2]string{a, b}] = true
+				n.cut[[2]string{b, a}] = true
+			}
+		}
+	}
+}
+
+// heal restores every link
+func (n *memNetwork) heal() {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	n.cut = make(map[[2]string]bool)
+}
+
+// memTransport loses the packets sent to closed or unreachable transports, or to full queues, like UDP
+type memTransport struct {
+	addr    string
+	network *memNetwork
+	packets chan Packet
+	closed  bool
+}
+
+func (t *memTransport) Send(addr string, data []byte) error {
+	t.network.mutex.Lock()
+	defer t.network.mutex.Unlock()
+	to, ok := t.network.transports[addr]
+	if !ok || to.closed || t.network.cut[[2]string{t.addr, addr}] {
+		return nil
+	}
+	select {
+	case to.packets <- Packet{From: t.addr, Data: data}:
+	default:
+	}
+	return nil
+}
+
+func (t *memTransport) Packets() <-chan Packet {
+	return t.packets
+}
+
+func (t *memTransport) Close() error {
+	t.network.mutex.Lock()
+	defer t.network.mutex.Unlock()
+	if !t.closed {
+		t.closed = true
+		close(t.packets)
+	}
+	return nil
+}
+
+type gossipNode struct {
+	cluster *Cluster
+	gossip  *Gossip
+	stop    context.CancelFunc
+	done    chan struct{}
+}
+
+var testTiming = GossipTiming{
+	ProbeInterval:    10 * time.Millisecond,
+	ProbeTimeout:     5 * time.Millisecond,
+	SuspicionTimeout: 50 * time.Millisecond,
+	SyncInterval:     50 * time.Millisecond,
+	IndirectChecks:   2,
+	RetransmitMult:   3,
+}
+
+// startGossip runs n nodes on network, every node but the first joining through the first
+func startGossip(t *testing.T, network *memNetwork, n int) []*gossipNode {
+	t.Helper()
+	logger := zerolog.Nop()
+	nodes := make([]*gossipNode, n)
+	for i := range nodes {
+		var seeds []string
+		if i > 0 {
+			seeds = []string{"node-0:7946"}
+		}
+		addr := fmt.Sprintf("node-%d:7946", i)
+		c := newTestCluster(fmt.Sprintf("node-%d:8080", i), "", 2)
+		g := NewGossip(c.Self(), addr, network.listen(addr), "token", seeds, testTiming, c.SetNodes, &logger)
+		c.SetGossip(g)
+		ctx, cancel := context.WithCancel(context.Background())
+		node := &gossipNode{cluster: c, gossip: g, stop: cancel, done: make(chan struct{})}
+		go func() {
+			defer close(node.done)
+			g.Run(ctx)
+		}()
+		nodes[i] = node
+	}
+	t.Cleanup(func() {
+		for _, node := range nodes {
+			node.stop()
+			<-node.done
+		}
+	})
+	return nodes
+}
+
+// names returns the cluster addresses of the nodes, sorted
+func names(nodes ...*gossipNode) []string {
+	var names []string
+	for _, node := range nodes {
+		names = append(names, node.cluster.Self())
+	}
+	return newRing(names).nodes
+}
+
+// stateOf returns the state of the member called name as seen by node
+func stateOf(node *gossipNode, name string) string {
+	for _, m := range node.gossip.Members() {
+		if m.Name == name {
+			return m.State
+		}
+	}
+	return ""
+}
+
+func TestGossip_Join(t *testing.T) {
+	t.Parallel()
+	nodes := startGossip(t, newMemNetwork(), 4)
+	waitFor(t, func() bool {
+		for _, node := range nodes {
+			if !reflect.DeepEqual(node.cluster.Nodes(), names(nodes...)) {
+				return false
+			}
+		}
+		return true
+	})
+	// every node agrees on the owners of the keys once the membership converged
+	for i := 0; i < 100; i++ {
+		key := fmt.Sprintf("/key-%d", i)
+		for _, node := range nodes[1:] {
+			if owners := node.cluster.Owners(key); !reflect.DeepEqual(owners, nodes[0].cluster.Owners(key)) {
+				t.Fatalf("Expected the nodes to agree on the owners of %s, got %v and %v", key, owners,
+					nodes[0].cluster.Owners(key))
+			}
+		}
+	}
+}
+
+func TestGossip_FailureDetection(t *testing.T) {
+	t.Parallel()
+	network := newMemNetwork()
+	nodes := startGossip(t, network, 3)
+	waitFor(t, func() bool { return len(nodes[0].cluster.Nodes()) == 3 && len(nodes[1].cluster.Nodes()) == 3 })
+
+	// a crashed node stops answering without announcing it leaves
+	network.partition("node-2:7946")
+	failed := nodes[2].cluster.Self()
+	waitFor(t, func() bool { return stateOf(nodes[0], failed) == StateDead && stateOf(nodes[1], failed) == StateDead })
+	for _, node := range nodes[:2] {
+		if got := node.cluster.Nodes(); !reflect.DeepEqual(got, names(nodes[:2]...)) {
+			t.Errorf("Expected the failed node to be removed, got %v", got)
+		}
+	}
+}
+
+func TestGossip_Leave(t *testing.T) {
+	t.Parallel()
+	nodes := startGossip(t, newMemNetwork(), 3)
+	waitFor(t, func() bool { return len(nodes[0].cluster.Nodes()) == 3 && len(nodes[1].cluster.Nodes()) == 3 })
+
+	nodes[2].stop()
+	<-nodes[2].done
+	left := nodes[2].cluster.Self()
+	waitFor(t, func() bool { return stateOf(nodes[0], left) == StateLeft && stateOf(nodes[1], left) == StateLeft })
+	if got := nodes[0].cluster.Nodes(); !reflect.DeepEqual(got, names(nodes[:2]...)) {
+		t.Errorf("Expected the node that left to be removed, got %v", got)
+	}
+}
+
+func TestGossip_PartitionHeals(t *testing.T) {
+	t.Parallel()
+	network := newMemNetwork()
+	nodes := startGossip(t, network, 4)
+	converged := func(want []string, nodes ...*gossipNode) func() bool {
+		return func() bool {
+			for _, node := range nodes {
+				if !reflect.DeepEqual(node.cluster.Nodes(), want) {
+					return false
+				}
+			}
+			return true
+		}
+	}
+	waitFor(t, converged(names(nodes...), nodes...))
+
+	network.partition("node-0:7946", "node-1:7946")
+	waitFor(t, converged(names(nodes[:2]...), nodes[:2]...))
+	waitFor(t, converged(names(nodes[2:]...), nodes[2:]...))
+
+	network.heal()
+	waitFor(t, converged(names(nodes...), nodes...))
+	for _, node := range nodes {
+		for _, m := range node.gossip.Members() {
+			if m.State != StateAlive {
+				t.Errorf("Expected %s to see %s alive after the partition healed, got %s", node.cluster.Self(),
+					m.Name, m.State)
+			}
+		}
+	}
+}
+
+func TestGossip_Authentication(t *testing.T) {
+	t.Parallel()
+	network := newMemNetwork()
+	nodes := startGossip(t, network, 1)
+	intruder := network.listen("intruder:7946")
+	logger := zerolog.Nop()
+	join := func(token string) {
+		g := NewGossip("intruder:8080", "intruder:7946", intruder, token, nil, testTiming, func([]string) {}, &logger)
+		g.send("node-0:7946", gossipMessage{Type: msgSync, Updates: g.Members()})
+	}
+
+	// messages not signed with the token of the cluster are dropped
+	join("wrong")
+	if err := intruder.Send("node-0:7946", []byte(`{"type":"sync","updates":[{"name":"intruder:8080"}]}`)); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(50 * time.Millisecond)
+	if state := stateOf(nodes[0], "intruder:8080"); state != "" {
+		t.Errorf("Expected unauthenticated messages to be dropped, got member in state %s", state)
+	}
+	select {
+	case packet := <-intruder.Packets():
+		t.Errorf("Expected no answer to unauthenticated messages, got %q", packet.Data)
+	default:
+	}
+
+	// signed messages are applied and answered at the address they came from
+	join("token")
+	waitFor(t, func() bool { return stateOf(nodes[0], "intruder:8080") == StateAlive })
+	select {
+	case <-intruder.Packets():
+	case <-time.After(5 * time.Second):
+		t.Error("Expected the sync to be answered at the address it came from")
+	}
+}
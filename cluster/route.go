@@ -0,0 +1,491 @@
+package cluster
+
+import (
+	"bytes"
+	"cache-api/auth"
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	// headerToken carries the token of the cluster on the requests between the nodes
+	headerToken = "X-Cluster-Token"
+	// headerPrincipal carries the name of the client authenticated by the node the request was sent to
+	headerPrincipal = "X-Cluster-Principal"
+	// headerRole tells the node receiving a request from another node what to do with it
+	headerRole = "X-Cluster-Role"
+	// roleCoordinate asks the node to serve the request as an owner of its key, replicating the writes
+	roleCoordinate = "coordinate"
+	// roleReplica asks the node to serve the request on its own cache only
+	roleReplica = "replica"
+
+	headerETag        = "ETag"
+	headerIfMatch     = "If-Match"
+	headerIfNoneMatch = "If-None-Match"
+	headerCacheTags   = "Cache-Tags"
+	headerRequestID   = "X-Request-ID"
+	incrSuffix        = "/incr"
+	ttlQueryName      = "ttl"
+)
+
+// forwardedHeaders are the headers of a client request that are relevant to the node serving it
+var forwardedHeaders = []string{"Content-Type", "Cache-Tags", "If-Match", "If-None-Match", headerRequestID}
+
+// replicatedHeaders are the headers of a write that are sent to the replicas, the preconditions were checked by the
+// coordinating node already
+var replicatedHeaders = []string{"Content-Type", "Cache-Tags", headerRequestID}
+
+// reservedKeys are the single segment routes that do not address a key
+var reservedKeys = []string{"_keys", "_namespaces", "_watch"}
+
+// keyOf returns the key the request reads or writes, qualified by its namespace, if it addresses a single key
+func keyOf(r *http.Request) (string, bool) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead && r.Method != http.MethodPost {
+		return "", false
+	}
+	segments := strings.Split(strings.TrimPrefix(r.URL.EscapedPath(), "/"), "/")
+	namespace := ""
+	if len(segments) > 2 && segments[0] == "ns" {
+		namespace, segments = segments[1], segments[2:]
+	}
+	switch {
+	case len(segments) == 1:
+	case len(segments) == 2 && segments[1] == "incr" && r.Method == http.MethodPost:
+	default:
+		return "", false
+	}
+	key, err := url.PathUnescape(segments[0])
+	if err != nil || key == "" || slices.Contains(reservedKeys, key) {
+		return "", false
+	}
+	return namespace + "/" + key, true
+}
+
+// storePath returns the path of the value the write of the request stores, and whether the write increments it. Like
+// keyOf, it looks at the segments of the path, so a key named incr is stored like any other key.
+func storePath(r *http.Request) (string, bool) {
+	path := r.URL.EscapedPath()
+	segments := strings.Split(strings.TrimPrefix(path, "/"), "/")
+	if len(segments) > 2 && segments[0] == "ns" {
+		segments = segments[2:]
+	}
+	if len(segments) == 2 && segments[1] == "incr" {
+		return strings.TrimSuffix(path, incrSuffix), true
+	}
+	return path, false
+}
+
+// keyMetadata is the part of the response of `GET /_admin/keys/{key}` needed to copy a key to another node
+type keyMetadata struct {
+	TTLMs int64    `json:"ttl_ms"`
+	Tags  []string `json:"tags"`
+}
+
+// metadataPath returns the path of the metadata of the key stored at path
+func metadataPath(path string) string {
+	i := strings.LastIndex(path, "/")
+	return path[:i] + "/_admin/keys" + path[i:]
+}
+
+// storeQuery returns the query of a write keeping the key for its remaining TTL, in whole seconds so the copy never
+// outlives the key. It is false if the key expires within a second, which is not worth copying.
+func (m keyMetadata) storeQuery() (string, bool) {
+	switch {
+	case m.TTLMs < 0:
+		return "", true
+	case m.TTLMs < 1000:
+		return "", false
+	}
+	return "?" + url.Values{ttlQueryName: {strconv.FormatInt(m.TTLMs/1000, 10)}}.Encode(), true
+}
+
+// header returns the headers of a write storing the key with its tags for the request of ID requestID
+func (m keyMetadata) header(requestID string) http.Header {
+	header := http.Header{}
+	if requestID != "" {
+		header.Set(headerRequestID, requestID)
+	}
+	if len(m.Tags) > 0 {
+		header.Set(headerCacheTags, strings.Join(m.Tags, ","))
+	}
+	return header
+}
+
+// Route serves the requests for the keys this node coordinates with next, which must be the handler of the caches,
+// and forwards or redirects the others to the node coordinating their key. The requests acting on the keys of every
+// node are sent to all of them, and the other requests not addressing a single key are served by next. Route must be
+// wrapped by Internal, so the requests it forwards are served by the other nodes.
+func (c *Cluster) Route(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if combine, ok := fanOutOf(r); ok {
+			c.broadcast(w, r, combine, next)
+			return
+		}
+		key, ok := keyOf(r)
+		if !ok {
+			next.ServeHTTP(w, r)
+			return
+		}
+		owners := c.Owners(key)
+		if owners[0] == c.self {
+			c.coordinate(w, r, owners, next)
+			return
+		}
+		if c.redirect {
+			http.Redirect(w, r, c.scheme+"://"+owners[0]+r.URL.RequestURI(), http.StatusTemporaryRedirect)
+			return
+		}
+		c.forward(w, r, owners, next)
+	})
+}
+
+// Internal serves the requests of the other nodes, authenticated by the token of the cluster, without passing them
+// to next. Requests to coordinate a key are served as Route would on its owner and replica requests by cache, which
+// must be the handler of the caches. Other requests are passed to next. The client the node sending the request
+// authenticated is the principal of the request, so it is audited on every node.
+func (c *Cluster) Internal(next http.Handler, cache http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token := r.Header.Get(headerToken)
+		if token == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if subtle.ConstantTimeCompare([]byte(token), []byte(c.token)) != 1 {
+			c.logger.Warn().Str("client", r.RemoteAddr).Msg("Rejected a cluster request with an invalid token")
+			http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+			return
+		}
+		if name := r.Header.Get(headerPrincipal); name != "" {
+			r = r.WithContext(auth.WithPrincipal(r.Context(), &auth.Principal{Name: name}))
+		}
+		key, ok := keyOf(r)
+		if r.Header.Get(headerRole) == roleCoordinate && ok {
+			c.coordinate(w, r, c.Owners(key), cache)
+			return
+		}
+		cache.ServeHTTP(w, r)
+	})
+}
+
+// forward sends the request to the first owner that answers and copies its response. If this node is one of the
+// owners, it coordinates the request itself once the owners before it did not answer.
+func (c *Cluster) forward(w http.ResponseWriter, r *http.Request, owners []string, cache http.Handler) {
+	body, ok := c.readBody(w, r)
+	if !ok {
+		return
+	}
+	for _, owner := range owners {
+		if owner == c.self {
+			r.Body = io.NopCloser(bytes.NewReader(body))
+			c.coordinate(w, r, owners, cache)
+			return
+		}
+		resp, err := c.send(r.Context(), owner, r.Method, r.URL.RequestURI(), r.Header, forwardedHeaders, body,
+			roleCoordinate)
+		if err != nil {
+			c.logger.Warn().Err(err).Str("node", owner).Msg("Failed to forward request")
+			continue
+		}
+		copyResponse(w, resp)
+		return
+	}
+	http.Error(w, http.StatusText(http.StatusBadGateway), http.StatusBadGateway)
+}
+
+// coordinate serves the request on this node as an owner of its key. Successful writes are sent to the other owners.
+// A read missing on this node is repaired from another owner, and a hit is compared with the other owners in the
+// background, which are repaired if they differ.
+func (c *Cluster) coordinate(w http.ResponseWriter, r *http.Request, owners []string, cache http.Handler) {
+	replicas := slices.DeleteFunc(slices.Clone(owners), func(node string) bool { return node == c.self })
+	if len(replicas) == 0 {
+		cache.ServeHTTP(w, r)
+		return
+	}
+	body, ok := c.readBody(w, r)
+	if !ok {
+		return
+	}
+	r.Body = io.NopCloser(bytes.NewReader(body))
+	recorder := newBufferedResponse()
+	cache.ServeHTTP(recorder, r)
+
+	switch {
+	case r.Method == http.MethodPost && recorder.status < http.StatusMultipleChoices:
+		path, increment := storePath(r)
+		if !increment {
+			if ttl := r.URL.Query().Get(ttlQueryName); ttl != "" {
+				path += "?" + url.Values{ttlQueryName: {ttl}}.Encode()
+			}
+			c.replicate(r.Context(), path, r.Header, body, replicas)
+			break
+		}
+		// the replicas store the result, as incrementing their own values could make them diverge, for the remaining
+		// TTL of the counter, which its increments keep
+		metadata, ok := c.inspect(r.Context(), c.self, path, cache)
+		query, live := metadata.storeQuery()
+		if ok && live {
+			c.replicate(r.Context(), path+query, metadata.header(r.Header.Get(headerRequestID)), recorder.body.Bytes(),
+				replicas)
+		}
+	case r.Method == http.MethodGet && recorder.status == http.StatusNotFound:
+		if c.repairLocal(r, replicas, cache) {
+			r.Body = http.NoBody
+			cache.ServeHTTP(w, r)
+			return
+		}
+	case r.Method == http.MethodGet && recorder.status == http.StatusOK && c.startRepair():
+		etag := recorder.Header().Get(headerETag)
+		value := bytes.Clone(recorder.body.Bytes())
+		ctx := context.WithoutCancel(r.Context())
+		go c.repairReplicas(ctx, r.Header.Get(headerRequestID), r.URL.EscapedPath(), etag, value, replicas, cache)
+	}
+	recorder.copyTo(w)
+}
+
+// replicate stores the value at path, which may carry a query, on the replicas with the replicated headers of header.
+// Failing replicas are repaired by the next read of the key.
+func (c *Cluster) replicate(ctx context.Context, path string, header http.Header, value []byte, replicas []string) {
+	var wg sync.WaitGroup
+	for _, replica := range replicas {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			resp, err := c.send(ctx, replica, http.MethodPost, path, header, replicatedHeaders, value, roleReplica)
+			if err == nil {
+				_ = resp.Body.Close()
+				if resp.StatusCode >= http.StatusMultipleChoices {
+					err = fmt.Errorf("unexpected status %d", resp.StatusCode)
+				}
+			}
+			if err != nil {
+				c.logger.Warn().Err(err).Str("node", replica).Str("path", path).Msg("Failed to replicate write")
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+// repairLocal looks for the key of the read on the replicas and stores the first value found on this node, with its
+// tags and for its remaining TTL, unless a write stored the key in the meantime. A key about to expire on the replica
+// is not repaired, so a key this node expired does not come back.
+func (c *Cluster) repairLocal(r *http.Request, replicas []string, cache http.Handler) bool {
+	path := r.URL.EscapedPath()
+	for _, replica := range replicas {
+		resp, err := c.send(r.Context(), replica, http.MethodGet, path, r.Header, []string{headerRequestID}, nil,
+			roleReplica)
+		if err != nil {
+			c.logger.Warn().Err(err).Str("node", replica).Msg("Failed to read from replica")
+			continue
+		}
+		value, err := io.ReadAll(resp.Body)
+		_ = resp.Body.Close()
+		if err != nil || resp.StatusCode != http.StatusOK {
+			continue
+		}
+		metadata, ok := c.inspect(r.Context(), replica, path, cache)
+		query, live := metadata.storeQuery()
+		if !ok || !live {
+			continue
+		}
+		// the repair is not made by the client of the read, so it does not get its context
+		store, err := http.NewRequest(http.MethodPost, path+query, bytes.NewReader(value))
+		if err != nil {
+			return false
+		}
+		store.Header = metadata.header(r.Header.Get(headerRequestID))
+		store.Header.Set(headerIfNoneMatch, "*")
+		recorder := newBufferedResponse()
+		cache.ServeHTTP(recorder, store)
+		if recorder.status == http.StatusPreconditionFailed {
+			return true
+		}
+		if recorder.status >= http.StatusMultipleChoices {
+			return false
+		}
+		c.logger.Debug().Str("path", r.URL.Path).Str("node", replica).Msg("Repaired key from replica")
+		return true
+	}
+	return false
+}
+
+// startRepair reports whether a read is compared with the replicas, which the read repair chance samples. At most
+// maxRepairs run at once, the reads sampled past them are not compared. A started repair ends with repairReplicas.
+func (c *Cluster) startRepair() bool {
+	if c.readRepairChance <= 0 || rand.Float64() >= c.readRepairChance {
+		return false
+	}
+	select {
+	case c.repairs <- struct{}{}:
+		return true
+	default:
+		return false
+	}
+}
+
+// repairReplicas stores the value on the replicas whose value of the key differs, found by a conditional read, with
+// the tags and the remaining TTL of the key on this node. The value is only stored if the replica still holds what the
+// read found, so a write made in the meantime is kept.
+func (c *Cluster) repairReplicas(ctx context.Context, requestID string, path string, etag string, value []byte,
+	replicas []string, cache http.Handler) {
+	defer func() { <-c.repairs }()
+	header := http.Header{}
+	header.Set(headerIfNoneMatch, etag)
+	header.Set(headerRequestID, requestID)
+	for _, replica := range replicas {
+		resp, err := c.send(ctx, replica, http.MethodGet, path, header, []string{headerIfNoneMatch, headerRequestID},
+			nil, roleReplica)
+		if err != nil {
+			c.logger.Warn().Err(err).Str("node", replica).Msg("Failed to read from replica")
+			continue
+		}
+		_, _ = io.Copy(io.Discard, resp.Body)
+		_ = resp.Body.Close()
+		store := http.Header{}
+		switch resp.StatusCode {
+		case http.StatusOK:
+			store.Set(headerIfMatch, resp.Header.Get(headerETag))
+		case http.StatusNotFound:
+			store.Set(headerIfNoneMatch, "*")
+		default:
+			continue
+		}
+		metadata, ok := c.inspect(ctx, c.self, path, cache)
+		query, live := metadata.storeQuery()
+		if !ok || !live {
+			return
+		}
+		for name, values := range metadata.header(requestID) {
+			store[name] = values
+		}
+		resp, err = c.send(ctx, replica, http.MethodPost, path+query, store,
+			[]string{headerIfMatch, headerIfNoneMatch, headerCacheTags, headerRequestID}, value, roleReplica)
+		if err != nil {
+			c.logger.Warn().Err(err).Str("node", replica).Msg("Failed to repair replica")
+			continue
+		}
+		_ = resp.Body.Close()
+		if resp.StatusCode == http.StatusPreconditionFailed {
+			c.logger.Debug().Str("path", path).Str("node", replica).Msg("Replica written during its repair")
+			continue
+		}
+		c.logger.Debug().Str("path", path).Str("node", replica).Msg("Repaired replica")
+	}
+}
+
+// inspect reads the metadata of the key stored at path on node, or from cache if node is this node
+func (c *Cluster) inspect(ctx context.Context, node string, path string, cache http.Handler) (keyMetadata, bool) {
+	var metadata keyMetadata
+	recorder := newBufferedResponse()
+	if node == c.self {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, metadataPath(path), nil)
+		if err != nil {
+			return metadata, false
+		}
+		cache.ServeHTTP(recorder, req)
+	} else {
+		resp, err := c.send(ctx, node, http.MethodGet, metadataPath(path), nil, nil, nil, roleReplica)
+		if err != nil {
+			c.logger.Warn().Err(err).Str("node", node).Msg("Failed to read metadata from node")
+			return metadata, false
+		}
+		defer resp.Body.Close()
+		recorder.status = resp.StatusCode
+		if _, err := recorder.body.ReadFrom(resp.Body); err != nil {
+			return metadata, false
+		}
+	}
+	if recorder.status != http.StatusOK || json.Unmarshal(recorder.body.Bytes(), &metadata) != nil {
+		return metadata, false
+	}
+	return metadata, true
+}
+
+// readBody reads the body of the request, so it can be sent more than once
+func (c *Cluster) readBody(w http.ResponseWriter, r *http.Request) ([]byte, bool) {
+	body := r.Body
+	if c.maxBodyBytes > 0 {
+		body = http.MaxBytesReader(w, r.Body, c.maxBodyBytes)
+	}
+	value, err := io.ReadAll(body)
+	if err != nil {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			http.Error(w, http.StatusText(http.StatusRequestEntityTooLarge), http.StatusRequestEntityTooLarge)
+			return nil, false
+		}
+		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		return nil, false
+	}
+	return value, true
+}
+
+// send makes a request to a node with the given headers of header and the token of the cluster
+func (c *Cluster) send(ctx context.Context, node string, method string, uri string, header http.Header,
+	names []string, body []byte, role string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, c.scheme+"://"+node+uri, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range names {
+		for _, value := range header.Values(name) {
+			req.Header.Add(name, value)
+		}
+	}
+	req.Header.Set(headerToken, c.token)
+	req.Header.Set(headerRole, role)
+	if principal, ok := auth.PrincipalFrom(ctx); ok {
+		req.Header.Set(headerPrincipal, principal.Name)
+	}
+	return c.client.Do(req)
+}
+
+func copyResponse(w http.ResponseWriter, resp *http.Response) {
+	defer resp.Body.Close()
+	for name, values := range resp.Header {
+		w.Header()[name] = values
+	}
+	w.WriteHeader(resp.StatusCode)
+	_, _ = io.Copy(w, resp.Body)
+}
+
+// bufferedResponse holds a response until the cluster decided what to do with it
+type bufferedResponse struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func newBufferedResponse() *bufferedResponse {
+	return &bufferedResponse{header: http.Header{}, status: http.StatusOK}
+}
+
+func (b *bufferedResponse) Header() http.Header {
+	return b.header
+}
+
+func (b *bufferedResponse) WriteHeader(status int) {
+	b.status = status
+}
+
+func (b *bufferedResponse) Write(p []byte) (int, error) {
+	return b.body.Write(p)
+}
+
+func (b *bufferedResponse) copyTo(w http.ResponseWriter) {
+	for name, values := range b.header {
+		w.Header()[name] = values
+	}
+	w.WriteHeader(b.status)
+	_, _ = w.Write(b.body.Bytes())
+}
@@ -0,0 +1,362 @@
+package cluster
+
+import (
+	"cache-api/auth"
+	"cache-api/cache"
+	"cache-api/config"
+	"cache-api/server"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+type testNode struct {
+	addr    string
+	cache   *cache.Cache[string]
+	server  *httptest.Server
+	cluster *Cluster
+}
+
+// startNodes runs a cluster of n nodes on loopback ports, each with its own in-memory cache
+func startNodes(t *testing.T, n int, replicas int, redirect bool) []*testNode {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	nodes := make([]*testNode, n)
+	addrs := make([]string, n)
+	for i := range nodes {
+		srv := httptest.NewUnstartedServer(nil)
+		nodes[i] = &testNode{addr: srv.Listener.Addr().String(), server: srv}
+		addrs[i] = nodes[i].addr
+	}
+	logger := zerolog.Nop()
+	for _, node := range nodes {
+		node.cache = cache.NewCache[string](ctx, config.CacheConfig{TTLSec: 60})
+		node.cluster = New(config.ClusterConfig{Advertise: node.addr, Peers: strings.Join(addrs, ","),
+			ReplicationFactor: replicas, ReadRepairChance: 1, Redirect: redirect, Token: "token"}, nil, 0, &logger)
+		inner := server.New(&logger, node.cache)
+		node.server.Config.Handler = node.cluster.Internal(node.cluster.Route(inner), inner)
+		node.server.Start()
+		t.Cleanup(node.server.Close)
+	}
+	return nodes
+}
+
+// nodeOf returns the node with the address
+func nodeOf(nodes []*testNode, addr string) *testNode {
+	for _, node := range nodes {
+		if node.addr == addr {
+			return node
+		}
+	}
+	return nil
+}
+
+// notOwning returns a node not owning key
+func notOwning(nodes []*testNode, key string) *testNode {
+	owners := nodes[0].cluster.Owners(key)
+	for _, node := range nodes {
+		if !strings.Contains(strings.Join(owners, ","), node.addr) {
+			return node
+		}
+	}
+	return nil
+}
+
+func do(t *testing.T, method string, url string, body string, header http.Header) (int, string) {
+	t.Helper()
+	req, err := http.NewRequest(method, url, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	content, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp.StatusCode, string(content)
+}
+
+func TestRoute_Forward(t *testing.T) {
+	t.Parallel()
+	nodes := startNodes(t, 3, 1, false)
+	entry := notOwning(nodes, "/user:1")
+	if code, _ := do(t, http.MethodPost, entry.server.URL+"/user:1", "alice", nil); code != http.StatusCreated {
+		t.Fatalf("Expected status code %d, got %d", http.StatusCreated, code)
+	}
+	owner := nodeOf(nodes, nodes[0].cluster.Owners("/user:1")[0])
+	if value, ok := owner.cache.Get("user:1"); !ok || value != "alice" {
+		t.Errorf("Expected the owner to hold the value, got %q", value)
+	}
+	if _, ok := entry.cache.Get("user:1"); ok {
+		t.Errorf("Expected the entry node not to hold the value")
+	}
+	for _, node := range nodes {
+		if code, body := do(t, http.MethodGet, node.server.URL+"/user:1", "", nil); code != http.StatusOK || body != "alice" {
+			t.Errorf("Expected every node to read 'alice', got %d %q", code, body)
+		}
+	}
+	if code, body := do(t, http.MethodPost, entry.server.URL+"/counter/incr?delta=2", "", nil); code != http.StatusOK || body != "2" {
+		t.Errorf("Expected the increment to return 2, got %d %q", code, body)
+	}
+}
+
+func TestRoute_Redirect(t *testing.T) {
+	t.Parallel()
+	nodes := startNodes(t, 3, 1, true)
+	entry := notOwning(nodes, "/user:1")
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+	resp, err := client.Get(entry.server.URL + "/user:1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = resp.Body.Close()
+	expected := "http://" + nodes[0].cluster.Owners("/user:1")[0] + "/user:1"
+	if resp.StatusCode != http.StatusTemporaryRedirect || resp.Header.Get("Location") != expected {
+		t.Errorf("Expected a redirect to %s, got %d %s", expected, resp.StatusCode, resp.Header.Get("Location"))
+	}
+	// the redirect keeps the method and the body of a write
+	if code, _ := do(t, http.MethodPost, entry.server.URL+"/user:1", "alice", nil); code != http.StatusCreated {
+		t.Errorf("Expected status code %d, got %d", http.StatusCreated, code)
+	}
+}
+
+func TestRoute_Replication(t *testing.T) {
+	t.Parallel()
+	nodes := startNodes(t, 3, 2, false)
+	key := "/user:1"
+	owners := nodes[0].cluster.Owners(key)
+	primary, replica := nodeOf(nodes, owners[0]), nodeOf(nodes, owners[1])
+	entry := notOwning(nodes, key)
+	if code, _ := do(t, http.MethodPost, entry.server.URL+key, "alice", nil); code != http.StatusCreated {
+		t.Fatalf("Expected status code %d, got %d", http.StatusCreated, code)
+	}
+	for _, node := range []*testNode{primary, replica} {
+		if value, _ := node.cache.Get("user:1"); value != "alice" {
+			t.Errorf("Expected both owners to hold the value, got %q", value)
+		}
+	}
+	if code, body := do(t, http.MethodPost, entry.server.URL+"/user:1/incr", "", nil); code != http.StatusConflict {
+		t.Errorf("Expected a conflict incrementing a string, got %d %q", code, body)
+	}
+
+	// a replica missing the key is repaired by a read
+	replica.cache.Delete("user:1")
+	if code, body := do(t, http.MethodGet, entry.server.URL+key, "", nil); code != http.StatusOK || body != "alice" {
+		t.Fatalf("Expected to read 'alice', got %d %q", code, body)
+	}
+	waitFor(t, func() bool {
+		value, _ := replica.cache.Get("user:1")
+		return value == "alice"
+	})
+
+	// the coordinating owner missing the key is repaired from the replica before answering
+	primary.cache.Delete("user:1")
+	if code, body := do(t, http.MethodGet, entry.server.URL+key, "", nil); code != http.StatusOK || body != "alice" {
+		t.Fatalf("Expected to read 'alice', got %d %q", code, body)
+	}
+	if value, _ := primary.cache.Get("user:1"); value != "alice" {
+		t.Errorf("Expected the owner to be repaired, got %q", value)
+	}
+}
+
+func TestRoute_ReplicatedWrites(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name    string
+		key     string
+		targets []string
+		body    string
+		want    string
+		minTTL  time.Duration
+		tags    []string
+	}{
+		{name: "key named incr", key: "incr", targets: []string{"/incr"}, body: "value", want: "value"},
+		{name: "ttl", key: "user:1", targets: []string{"/user:1?ttl=600"}, body: "alice", want: "alice",
+			minTTL: 590 * time.Second, tags: []string{"users"}},
+		// the increments keep the expiration of the counter on every owner
+		{name: "counter", key: "hits", targets: []string{"/hits/incr?ttl=600", "/hits/incr"}, want: "2",
+			minTTL: 590 * time.Second},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			nodes := startNodes(t, 3, 2, false)
+			entry := notOwning(nodes, "/"+tt.key)
+			for _, target := range tt.targets {
+				header := http.Header{"Cache-Tags": tt.tags}
+				if code, body := do(t, http.MethodPost, entry.server.URL+target, tt.body, header); code >= 300 {
+					t.Fatalf("Expected %s to succeed, got %d %q", target, code, body)
+				}
+			}
+			for _, owner := range nodes[0].cluster.Owners("/" + tt.key) {
+				node := nodeOf(nodes, owner)
+				if value, _ := node.cache.Get(tt.key); value != tt.want {
+					t.Errorf("Expected %s to hold %q, got %q", owner, tt.want, value)
+				}
+				metadata, _, _ := node.cache.Inspect(tt.key)
+				if metadata.TTL < tt.minTTL || len(metadata.Tags) != len(tt.tags) {
+					t.Errorf("Expected %s to keep the key for %s with the tags %v, got %+v", owner, tt.minTTL, tt.tags,
+						metadata)
+				}
+			}
+		})
+	}
+}
+
+func TestRoute_RepairLocal(t *testing.T) {
+	t.Parallel()
+	nodes := startNodes(t, 3, 2, false)
+	owners := nodes[0].cluster.Owners("/user:1")
+	primary, replica := nodeOf(nodes, owners[0]), nodeOf(nodes, owners[1])
+	entry := notOwning(nodes, "/user:1")
+
+	// the owner gets the tags and the remaining ttl of the replica
+	_ = replica.cache.SetWithTTL("user:1", "alice", []string{"users"}, 10*time.Minute)
+	if code, body := do(t, http.MethodGet, entry.server.URL+"/user:1", "", nil); code != http.StatusOK || body != "alice" {
+		t.Fatalf("Expected to read 'alice', got %d %q", code, body)
+	}
+	metadata, ok, _ := primary.cache.Inspect("user:1")
+	if !ok || metadata.TTL < 590*time.Second || len(metadata.Tags) != 1 || metadata.Tags[0] != "users" {
+		t.Errorf("Expected the owner to be repaired with the ttl and the tags, got %+v", metadata)
+	}
+
+	// a key about to expire on the replica is not brought back
+	owners = nodes[0].cluster.Owners("/user:2")
+	replica = nodeOf(nodes, owners[1])
+	_ = replica.cache.SetWithTTL("user:2", "bob", nil, 500*time.Millisecond)
+	if code, _ := do(t, http.MethodGet, entry.server.URL+"/user:2", "", nil); code != http.StatusNotFound {
+		t.Errorf("Expected status code %d, got %d", http.StatusNotFound, code)
+	}
+	if _, ok := nodeOf(nodes, owners[0]).cache.Get("user:2"); ok {
+		t.Errorf("Expected the owner not to be repaired with an expiring key")
+	}
+}
+
+func TestRoute_RepairReplicas(t *testing.T) {
+	t.Parallel()
+	nodes := startNodes(t, 3, 2, false)
+	owners := nodes[0].cluster.Owners("/user:1")
+	primary, replica := nodeOf(nodes, owners[0]), nodeOf(nodes, owners[1])
+	_ = primary.cache.SetWithTTL("user:1", "alice", []string{"users"}, 10*time.Minute)
+	_ = replica.cache.Set("user:1", "stale")
+	code, body := do(t, http.MethodGet, primary.server.URL+"/user:1", "", nil)
+	if code != http.StatusOK || body != "alice" {
+		t.Fatalf("Expected to read 'alice', got %d %q", code, body)
+	}
+	// the replica gets the tags and the remaining ttl of the owner
+	waitFor(t, func() bool {
+		value, _ := replica.cache.Get("user:1")
+		return value == "alice"
+	})
+	metadata, _, _ := replica.cache.Inspect("user:1")
+	if metadata.TTL < 590*time.Second || len(metadata.Tags) != 1 || metadata.Tags[0] != "users" {
+		t.Errorf("Expected the replica to be repaired with the ttl and the tags, got %+v", metadata)
+	}
+}
+
+func TestCluster_startRepair(t *testing.T) {
+	t.Parallel()
+	logger := zerolog.Nop()
+	never := New(config.ClusterConfig{Advertise: "a:8080", ReadRepairChance: 0}, nil, 0, &logger)
+	if never.startRepair() {
+		t.Error("Expected no repair with a chance of 0")
+	}
+	always := New(config.ClusterConfig{Advertise: "a:8080", ReadRepairChance: 1}, nil, 0, &logger)
+	for i := 0; i < maxRepairs; i++ {
+		if !always.startRepair() {
+			t.Fatalf("Expected repair %d to start with a chance of 1", i)
+		}
+	}
+	if always.startRepair() {
+		t.Errorf("Expected at most %d repairs at once", maxRepairs)
+	}
+}
+
+func TestRoute_Failover(t *testing.T) {
+	t.Parallel()
+	nodes := startNodes(t, 3, 2, false)
+	key := "/user:1"
+	owners := nodes[0].cluster.Owners(key)
+	entry := notOwning(nodes, key)
+	if code, _ := do(t, http.MethodPost, entry.server.URL+key, "alice", nil); code != http.StatusCreated {
+		t.Fatalf("Expected status code %d, got %d", http.StatusCreated, code)
+	}
+	nodeOf(nodes, owners[0]).server.Close()
+	if code, body := do(t, http.MethodGet, entry.server.URL+key, "", nil); code != http.StatusOK || body != "alice" {
+		t.Errorf("Expected the replica to answer while the owner is down, got %d %q", code, body)
+	}
+}
+
+func TestInternal_Token(t *testing.T) {
+	t.Parallel()
+	nodes := startNodes(t, 1, 1, false)
+	header := http.Header{headerToken: {"wrong"}}
+	if code, _ := do(t, http.MethodGet, nodes[0].server.URL+"/key", "", header); code != http.StatusForbidden {
+		t.Errorf("Expected status code %d, got %d", http.StatusForbidden, code)
+	}
+	header = http.Header{headerToken: {"token"}, headerRole: {roleReplica}}
+	if code, _ := do(t, http.MethodPost, nodes[0].server.URL+"/key", "value", header); code != http.StatusCreated {
+		t.Errorf("Expected status code %d, got %d", http.StatusCreated, code)
+	}
+}
+
+func TestInternal_Principal(t *testing.T) {
+	t.Parallel()
+	principals := make(chan string, 2)
+	record := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		name := ""
+		if principal, ok := auth.PrincipalFrom(r.Context()); ok {
+			name = principal.Name
+		}
+		principals <- name
+	})
+	srv := httptest.NewUnstartedServer(nil)
+	addr := srv.Listener.Addr().String()
+	logger := zerolog.Nop()
+	c := New(config.ClusterConfig{Advertise: addr, Peers: addr, ReplicationFactor: 1, Token: "token"}, nil, 0, &logger)
+	srv.Config.Handler = c.Internal(record, record)
+	srv.Start()
+	t.Cleanup(srv.Close)
+
+	// the client authenticated by the sending node is the principal on the receiving node
+	ctx := auth.WithPrincipal(context.Background(), &auth.Principal{Name: "alice"})
+	resp, err := c.send(ctx, addr, http.MethodGet, "/key", nil, nil, nil, roleReplica)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if name := <-principals; name != "alice" {
+		t.Errorf("Expected principal alice, got %q", name)
+	}
+	// without the cluster token, the header is left to authentication
+	do(t, http.MethodGet, srv.URL+"/key", "", http.Header{headerPrincipal: {"alice"}})
+	if name := <-principals; name != "" {
+		t.Errorf("Expected no principal, got %q", name)
+	}
+}
+
+// waitFor polls condition until it holds or five seconds passed
+func waitFor(t *testing.T, condition func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatalf("Timed out waiting for the condition")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
@@ -0,0 +1,85 @@
+package cluster
+
+import (
+	"errors"
+	"net"
+)
+
+// maxPacketSize is the largest UDP payload
+const maxPacketSize = 65507
+
+// Packet is a message received by a Transport
+type Packet struct {
+	From string
+	Data []byte
+}
+
+// Transport carries the packets of the gossip between the nodes. Packets may be lost, duplicated or reordered.
+type Transport interface {
+	// Send sends a packet to the node listening at addr
+	Send(addr string, data []byte) error
+	// Packets returns the packets received, it is closed once the transport is closed
+	Packets() <-chan Packet
+	Close() error
+}
+
+// UDPTransport is a Transport over UDP
+type UDPTransport struct {
+	conn    *net.UDPConn
+	packets chan Packet
+}
+
+// ListenUDP returns a transport receiving the packets sent to addr
+func ListenUDP(addr string) (*UDPTransport, error) {
+	udpAddr, err := net.ResolveUDPAddr("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := net.ListenUDP("udp", udpAddr)
+	if err != nil {
+		return nil, err
+	}
+	t := &UDPTransport{conn: conn, packets: make(chan Packet, 64)}
+	go t.receive()
+	return t, nil
+}
+
+// Addr returns the address the transport listens at
+func (t *UDPTransport) Addr() string {
+	return t.conn.LocalAddr().String()
+}
+
+func (t *UDPTransport) receive() {
+	defer close(t.packets)
+	buffer := make([]byte, maxPacketSize)
+	for {
+		n, from, err := t.conn.ReadFromUDP(buffer)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			continue
+		}
+		t.packets <- Packet{From: from.String(), Data: append([]byte(nil), buffer[:n]...)}
+	}
+}
+
+// Send implements Transport
+func (t *UDPTransport) Send(addr string, data []byte) error {
+	udpAddr, err := net.ResolveUDPAddr("udp", addr)
+	if err != nil {
+		return err
+	}
+	_, err = t.conn.WriteToUDP(data, udpAddr)
+	return err
+}
+
+// Packets implements Transport
+func (t *UDPTransport) Packets() <-chan Packet {
+	return t.packets
+}
+
+// Close implements Transport
+func (t *UDPTransport) Close() error {
+	return t.conn.Close()
+}
@@ -1,49 +1,282 @@
 package config
 
 import (
-	"fmt"
 	"os"
+	"strings"
 
 	"github.com/kelseyhightower/envconfig"
 )
 
 type CacheConfig struct {
-	TTLSec                   int `envconfig:"ttl_seconds" default:"1800"`          // default is 30 minutes
-	EvictionIntervalMilliSec int `envconfig:"eviction_interval_ms" default:"1000"` // default is 1 second
+	TTLSec                   int `envconfig:"ttl_seconds" json:"ttl_seconds" default:"1800"`                   // default is 30 minutes
+	EvictionIntervalMilliSec int `envconfig:"eviction_interval_ms" json:"eviction_interval_ms" default:"1000"` // default is 1 second
+	MaxSize                  int `envconfig:"max_size" json:"max_size" default:"0"`                            // default is unlimited
 }
+
+// WithDefaults returns a copy of the config where the unset settings are taken from defaults
+func (c CacheConfig) WithDefaults(defaults CacheConfig) CacheConfig {
+	if c.TTLSec == 0 {
+		c.TTLSec = defaults.TTLSec
+	}
+	if c.EvictionIntervalMilliSec == 0 {
+		c.EvictionIntervalMilliSec = defaults.EvictionIntervalMilliSec
+	}
+	if c.MaxSize == 0 {
+		c.MaxSize = defaults.MaxSize
+	}
+	return c
+}
+
 type Config struct {
-	Debug       bool   `envconfig:"debug" default:"false"`
-	Host        string `envconfig:"host" default:"0.0.0.0"`
-	Port        string `envconfig:"port" default:"8080"`
-	UseRedis    bool   `envconfig:"use_redis" default:"false"`
-	RedisConfig RedisConfig
-	Cache       CacheConfig // default is 30 minutes
+	Debug            bool   `envconfig:"debug" default:"false"`
+	Host             string `envconfig:"host" default:"0.0.0.0"`
+	Port             string `envconfig:"port" default:"8080"`
+	UseRedis         bool   `envconfig:"use_redis" default:"false"`
+	ShutdownDelaySec int    `envconfig:"shutdown_delay_seconds" default:"0"` // serving time with a failing readiness
+	ConfigFile       string `envconfig:"config_file"`                        // YAML file of settings not set in env
+	RedisConfig      RedisConfig
+	Cache            CacheConfig // default is 30 minutes
+	Namespaces       Namespaces  `envconfig:"namespaces"`
+	Auth             AuthConfig
+	RateLimit        RateLimitConfig
+	Limits           LimitsConfig
+	TLS              TLSConfig
+	Log              LogConfig
+	AccessLog        AccessLogConfig
+	Audit            AuditConfig
+	Replication      ReplicationConfig
+	Cluster          ClusterConfig
+	Invalidation     InvalidationConfig
+	Store            StoreConfig
+}
+
+// ClusterConfig makes several instances act as one logical cache, each node owning the keys it ranks first for by
+// rendezvous hashing. The cluster is enabled when Peers, DNS or GossipPort is set.
+type ClusterConfig struct {
+	// Advertise is the address the other nodes reach this node at, e.g. `cache-0.cache:8080`
+	Advertise string `envconfig:"cluster_advertise"`
+	// Peers are the comma separated addresses of all the nodes, including this one
+	Peers string `envconfig:"cluster_peers"`
+	// DNS is a host:port whose host resolves to the addresses of all the nodes, e.g. a headless service
+	DNS string `envconfig:"cluster_dns"`
+	// DNSIntervalSec is how often DNS is resolved again
+	DNSIntervalSec int `envconfig:"cluster_dns_interval_seconds" default:"10"`
+	// GossipPort is the UDP port the nodes discover each other on, the host is the one of Advertise
+	GossipPort int `envconfig:"cluster_gossip_port" default:"0"`
+	// Seeds are the comma separated gossip addresses of some nodes, which a node joining the cluster contacts first
+	Seeds string `envconfig:"cluster_seeds"`
+	// ReplicationFactor is the number of nodes holding each key
+	ReplicationFactor int `envconfig:"cluster_replication_factor" default:"1"`
+	// ReadRepairChance is the share of the reads compared with the other owners of their key, from 0 to 1
+	ReadRepairChance float64 `envconfig:"cluster_read_repair_chance" default:"0.1"`
+	// Redirect answers the requests for keys owned by other nodes with a redirect instead of forwarding them
+	Redirect bool `envconfig:"cluster_redirect" default:"false"`
+	// Token authenticates the requests between the nodes, which skip the authentication of clients
+	Token string `envconfig:"cluster_token" secret:"true"`
+}
+
+// Enabled reports whether the instance is a node of a cluster
+func (c ClusterConfig) Enabled() bool {
+	return c.Peers != "" || c.DNS != "" || c.GossipPort != 0
+}
+
+// PeerList returns the addresses of Peers
+func (c ClusterConfig) PeerList() []string {
+	return splitList(c.Peers)
+}
+
+// SeedList returns the addresses of Seeds
+func (c ClusterConfig) SeedList() []string {
+	return splitList(c.Seeds)
+}
+
+// splitList returns the non-empty items of a comma separated list
+func splitList(list string) []string {
+	var items []string
+	for _, item := range strings.Split(list, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// InvalidationConfig broadcasts the writes to the in-memory caches, so the other instances evict their copies
+type InvalidationConfig struct {
+	// Bus carries the invalidations, `redis` for pub/sub on the redis server or `http` to send them to Peers. Empty
+	// disables the invalidations.
+	Bus string `envconfig:"invalidation_bus"`
+	// Channel is the redis channel of the invalidations
+	Channel string `envconfig:"invalidation_channel" default:"cache-api:invalidations"`
+	// Listen is the address the invalidations of the peers are received on, e.g. `:7071`
+	Listen string `envconfig:"invalidation_listen"`
+	// Peers are the comma separated Listen addresses of the other instances
+	Peers string `envconfig:"invalidation_peers"`
+	// Token is shared by the peers, which must present it to send invalidations
+	Token string `envconfig:"invalidation_token" secret:"true"`
+	// Buffer is the number of invalidations waiting to be published, past which they are replaced by a flush
+	Buffer int `envconfig:"invalidation_buffer" default:"10000"`
+}
+
+// PeerList returns the addresses of Peers
+func (c InvalidationConfig) PeerList() []string {
+	return splitList(c.Peers)
+}
+
+// StoreConfig backs the default in-memory cache with a store, which the keys the cache misses are loaded from and the
+// writes are written to
+type StoreConfig struct {
+	// Backend is `file` for the files of Dir or `http` for the API at Origin. Empty disables the store.
+	Backend string `envconfig:"store_backend"`
+	Dir     string `envconfig:"store_dir"`
+	// Origin is the base URL of the API serving the value of every key at `{origin}/{key}`
+	Origin          string `envconfig:"store_origin"`
+	TimeoutMilliSec int    `envconfig:"store_timeout_ms" default:"5000"`
+	// ReadThrough loads the keys the cache misses from the store
+	ReadThrough bool `envconfig:"store_read_through" default:"true"`
+	// Write is `through` to write to the store before the writes return, `behind` to write to it in the background
+	// or `none` to leave it unchanged
+	Write                 string `envconfig:"store_write" default:"none"`
+	BatchSize             int    `envconfig:"store_batch_size" default:"100"`
+	FlushIntervalMilliSec int    `envconfig:"store_flush_interval_ms" default:"1000"`
+	// Buffer is the number of keys with writes waiting for the store, past which the writes of other keys fail
+	Buffer int `envconfig:"store_buffer" default:"10000"`
+}
+
+// ReplicationConfig configures the streaming of the in-memory caches from a primary instance to its followers
+type ReplicationConfig struct {
+	// Listen is the address the primary accepts followers on, e.g. `:7070`
+	Listen string `envconfig:"replication_listen"`
+	// Primary is the replication address of the primary, which makes this instance a read-only follower
+	Primary string `envconfig:"replication_primary"`
+	// Token is shared by the primary and its followers, which must present it to connect
+	Token string `envconfig:"replication_token" secret:"true"`
+	// Buffer is the number of changes queued per follower, a follower falling further behind resyncs from a snapshot
+	Buffer int `envconfig:"replication_buffer" default:"10000"`
+}
+
+// AuditConfig configures the record of the changes made to the caches
+type AuditConfig struct {
+	Enabled bool `envconfig:"audit_enabled" default:"false"`
+	// File receives the records, rotated by size and age. They are written to stdout if it is empty.
+	File        string `envconfig:"audit_file"`
+	MaxSizeMB   int    `envconfig:"audit_max_size_mb" default:"100"`
+	MaxAgeHours int    `envconfig:"audit_max_age_hours" default:"24"`
+	// MaxBackups is the number of rotated files kept, 0 keeps them all
+	MaxBackups int `envconfig:"audit_max_backups" default:"7"`
+}
+
+// AccessLogConfig configures the line logged for every request
+type AccessLogConfig struct {
+	Enabled bool `envconfig:"access_log_enabled" default:"true"`
+	// SampleRate is the share of the successful requests that are logged, failed requests are always logged
+	SampleRate float64 `envconfig:"access_log_sample_rate" default:"1"`
+}
+
+// LogConfig configures how the logs are written
+type LogConfig struct {
+	// Format is one of console, json and logfmt
+	Format string `envconfig:"log_format" default:"console"`
+	// Levels sets the levels of the components of the server, e.g. `server=debug,redis=warn`
+	Levels LogLevels `envconfig:"log_levels"`
+}
+
+// TLSConfig configures the transport of the HTTP server. TLS is served when CertFile is set.
+type TLSConfig struct {
+	CertFile string `envconfig:"tls_cert_file"`
+	KeyFile  string `envconfig:"tls_key_file"`
+	// ClientCAFile is a PEM bundle of the CAs client certificates must be signed by, which turns on mutual TLS
+	ClientCAFile string `envconfig:"tls_client_ca_file"`
+	// ReloadIntervalSec is how often the certificate files are checked for changes
+	ReloadIntervalSec int `envconfig:"tls_reload_interval_seconds" default:"10"`
+	// H2C serves HTTP/2 without TLS, for internal plaintext traffic
+	H2C bool `envconfig:"h2c" default:"false"`
+}
+
+// LimitsConfig bounds the keys and values clients can store. Zero means no limit.
+type LimitsConfig struct {
+	MaxValueBytes int64 `envconfig:"max_value_bytes" default:"1048576"` // default is 1 MiB
+	MaxKeyLength  int   `envconfig:"max_key_length" default:"250"`
+	// KeyPattern is a regular expression every key must match as a whole, e.g. `[A-Za-z0-9:_-]+`
+	KeyPattern string `envconfig:"key_pattern"`
+	// WatchBuffer is the number of events queued per watcher of `GET /_watch` before it is closed as too slow
+	WatchBuffer int `envconfig:"watch_buffer" default:"256"`
+	// WatchOrigins are the comma separated origins of the pages allowed to open a WebSocket to `GET /_watch`
+	// besides the one of the server, e.g. `https://app.example.com`, or * for any page
+	WatchOrigins string `envconfig:"watch_allowed_origins"`
+}
+
+// WatchOriginList returns the origins of WatchOrigins
+func (c LimitsConfig) WatchOriginList() []string {
+	return splitList(c.WatchOrigins)
+}
+
+// AuthConfig configures the authentication of the clients and what each of them is allowed to access.
+// See the auth package for the format of the values.
+type AuthConfig struct {
+	Enabled bool `envconfig:"auth_enabled" default:"false"`
+	// APIKeys maps principals to their static API keys, e.g. `alice=key1;bob=key2`
+	APIKeys string `envconfig:"auth_api_keys" secret:"true"`
+	// Rules grants access to the principals, e.g. `alice=rw@team-a/user:;bob=r@*/`
+	Rules string `envconfig:"auth_rules"`
+	// File is a JSON file defining principals, their API keys and rules, in addition to APIKeys and Rules
+	File string `envconfig:"auth_file"`
+	// JWKSFile is a JSON Web Key Set file holding the keys bearer JWTs are verified against
+	JWKSFile string `envconfig:"auth_jwks_file"`
+	// JWTIssuer and JWTAudience are checked against the iss and aud claims of JWTs if set
+	JWTIssuer   string `envconfig:"auth_jwt_issuer"`
+	JWTAudience string `envconfig:"auth_jwt_audience"`
+}
+
+// RateLimitConfig configures the token buckets limiting the requests of every client. Rates are in requests per
+// second and bursts are the number of requests a client can make at once after being idle.
+type RateLimitConfig struct {
+	Enabled    bool    `envconfig:"rate_limit_enabled" default:"false"`
+	ReadRate   float64 `envconfig:"rate_limit_read_rps" default:"100"`
+	ReadBurst  int     `envconfig:"rate_limit_read_burst" default:"200"`
+	WriteRate  float64 `envconfig:"rate_limit_write_rps" default:"20"`
+	WriteBurst int     `envconfig:"rate_limit_write_burst" default:"40"`
+	// IPRate and IPBurst limit the requests of every IP address before authentication, with AuthConfig.Enabled
+	IPRate  float64 `envconfig:"rate_limit_ip_rps" default:"200"`
+	IPBurst int     `envconfig:"rate_limit_ip_burst" default:"400"`
+	// Distributed keeps the buckets in redis, so the limits apply across all instances. It requires UseRedis.
+	Distributed bool `envconfig:"rate_limit_distributed" default:"false"`
 }
 
 type RedisConfig struct {
 	Host     string `envconfig:"redis_host" default:"localhost"`
 	Username string `envconfig:"reids_username" default:"localhost"`
-	Password string `envconfig:"redis_password" default:""`
+	Password string `envconfig:"redis_password" default:"" secret:"true"`
 	DB       int    `envconfig:"redis_db" default:"0"`
 }
 
+// NewWithName loads the config from the environment variables prefixed by serviceName, then the settings of the
+// config file that are not set in the environment, and validates it
 func NewWithName(serviceName string) (Config, error) {
-	var s Config
-	err := envconfig.Process(serviceName, &s)
+	s, _, err := load(serviceName)
 	if err != nil {
 		return s, err
 	}
-
-	return s, nil
+	return s, s.Validate()
 }
 
 func New() (Config, error) {
+	return NewWithName(os.Getenv("SERVICE_NAME"))
+}
+
+// load reads the config without validating it, and returns the values of the config file as well
+func load(serviceName string) (Config, map[string]string, error) {
 	var s Config
-	serviceName := os.Getenv("SERVICE_NAME")
 	err := envconfig.Process(serviceName, &s)
 	if err != nil {
-		return s, err
+		return s, nil, err
+	}
+	var fileValues map[string]string
+	if s.ConfigFile != "" {
+		fileValues, err = applyFile(&s, serviceName, s.ConfigFile)
+		if err != nil {
+			return s, nil, err
+		}
 	}
-	fmt.Printf("config loaded: %+v\n", s)
-	return s, nil
+	return s, fileValues, nil
 }
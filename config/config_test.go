@@ -11,6 +11,7 @@ func TestNewWithName(t *testing.T) {
 	_ = os.Setenv("HOST", "localhost")
 	_ = os.Setenv("TTL_SECONDS", "100")
 	_ = os.Setenv("EVICTION_INTERVAL_MS", "500")
+	_ = os.Setenv("TEST_SERVICE_NAMESPACES", "team-a:ttl_seconds=60")
 
 	conf, err := NewWithName("test_service")
 	if err != nil {
@@ -34,6 +35,10 @@ func TestNewWithName(t *testing.T) {
 	if conf.Cache.EvictionIntervalMilliSec != 500 {
 		t.Errorf("expected conf.EvictionIntervalMilliSec to equal %d, got %d", 500, conf.Cache.EvictionIntervalMilliSec)
 	}
+
+	if conf.Namespaces["team-a"].TTLSec != 60 {
+		t.Errorf("expected namespace team-a to have a TTLSec of %d, got %+v", 60, conf.Namespaces)
+	}
 }
 
 func TestNew(t *testing.T) {
@@ -0,0 +1,175 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/kelseyhightower/envconfig"
+	"gopkg.in/yaml.v3"
+)
+
+// configFileKey is the setting naming the config file, which can not be set from the file itself
+const configFileKey = "config_file"
+
+// setting is a leaf field of Config along with the names it is read from
+type setting struct {
+	field reflect.Value
+	// name is the name of the setting in the config file, which is also the alternative environment variable
+	name string
+	// key is the environment variable, prefixed by the service name and the names of the enclosing structs
+	key string
+	// secret settings are redacted when the config is printed
+	secret bool
+}
+
+// settings lists the leaf fields of the config, deriving their environment variables the way envconfig does
+func settings(conf *Config, serviceName string) []setting {
+	var result []setting
+	var walk func(v reflect.Value, prefix string)
+	walk = func(v reflect.Value, prefix string) {
+		t := v.Type()
+		for i := 0; i < v.NumField(); i++ {
+			field, structField := v.Field(i), t.Field(i)
+			name := structField.Tag.Get("envconfig")
+			key := structField.Name
+			if name != "" {
+				key = name
+			}
+			if prefix != "" {
+				key = prefix + "_" + key
+			}
+			key = strings.ToUpper(key)
+			if field.Kind() == reflect.Struct {
+				walk(field, key)
+				continue
+			}
+			result = append(result, setting{field: field, name: name, key: key, secret: structField.Tag.Get("secret") == "true"})
+		}
+	}
+	walk(reflect.ValueOf(conf).Elem(), serviceName)
+	return result
+}
+
+// envKey returns the environment variable the setting is read from, its key or else its alternative name, and whether
+// it is set
+func (s setting) envKey() (string, bool) {
+	if _, ok := os.LookupEnv(s.key); ok {
+		return s.key, true
+	}
+	alt := strings.ToUpper(s.name)
+	_, ok := os.LookupEnv(alt)
+	return alt, s.name != "" && ok
+}
+
+// set parses value into the field of the setting
+func (s setting) set(value string) error {
+	if decoder, ok := s.field.Addr().Interface().(envconfig.Decoder); ok {
+		return decoder.Decode(value)
+	}
+	switch s.field.Kind() {
+	case reflect.String:
+		s.field.SetString(value)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return err
+		}
+		s.field.SetBool(b)
+	case reflect.Int, reflect.Int64:
+		n, err := strconv.ParseInt(value, 10, s.field.Type().Bits())
+		if err != nil {
+			return err
+		}
+		s.field.SetInt(n)
+	case reflect.Float64:
+		f, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return err
+		}
+		s.field.SetFloat(f)
+	default:
+		return fmt.Errorf("unsupported type %s", s.field.Type())
+	}
+	return nil
+}
+
+// readFile reads a YAML config file holding the settings by the names of their alternative environment variables in
+// lower case, e.g. `ttl_seconds: 60`. The namespaces can be given as a spec string or as a mapping of the namespaces
+// to their settings.
+func readFile(path string) (map[string]string, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading config file %w", err)
+	}
+	var raw map[string]any
+	if err := yaml.Unmarshal(content, &raw); err != nil {
+		return nil, fmt.Errorf("error parsing config file %w", err)
+	}
+	values := make(map[string]string, len(raw))
+	for name, value := range raw {
+		switch v := value.(type) {
+		case map[string]any:
+			values[name] = namespacesSpec(v)
+		case nil:
+			values[name] = ""
+		case []any:
+			return nil, fmt.Errorf("setting %q of the config file can not be a list", name)
+		default:
+			values[name] = fmt.Sprint(v)
+		}
+	}
+	return values, nil
+}
+
+// namespacesSpec turns a mapping of namespaces to their settings into the spec decoded by Namespaces
+func namespacesSpec(namespaces map[string]any) string {
+	names := make([]string, 0, len(namespaces))
+	for name := range namespaces {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	specs := make([]string, 0, len(names))
+	for _, name := range names {
+		settings, _ := namespaces[name].(map[string]any)
+		pairs := make([]string, 0, len(settings))
+		for key, value := range settings {
+			pairs = append(pairs, fmt.Sprintf("%s=%v", key, value))
+		}
+		sort.Strings(pairs)
+		specs = append(specs, name+":"+strings.Join(pairs, ","))
+	}
+	return strings.Join(specs, ";")
+}
+
+// applyFile sets the settings of the config file that are not set in the environment, which takes precedence, and
+// returns the values of the file
+func applyFile(conf *Config, serviceName string, path string) (map[string]string, error) {
+	values, err := readFile(path)
+	if err != nil {
+		return nil, err
+	}
+	known := make(map[string]bool, len(values))
+	for _, s := range settings(conf, serviceName) {
+		value, ok := values[s.name]
+		if !ok || s.name == configFileKey {
+			continue
+		}
+		known[s.name] = true
+		if _, ok := s.envKey(); ok {
+			continue
+		}
+		if err := s.set(value); err != nil {
+			return nil, fmt.Errorf("setting %q of the config file: %w", s.name, err)
+		}
+	}
+	for name := range values {
+		if !known[name] {
+			return nil, fmt.Errorf("unknown setting %q in the config file", name)
+		}
+	}
+	return values, nil
+}
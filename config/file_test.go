@@ -0,0 +1,72 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func writeConfigFile(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestNewWithName_ConfigFile(t *testing.T) {
+	path := writeConfigFile(t, `
+debug: true
+ttl_seconds: 60
+max_size: 1000
+rate_limit_read_rps: 2.5
+namespaces:
+  team-a:
+    ttl_seconds: 10
+  team-b:
+    max_size: 5
+`)
+	t.Setenv("FILE_SERVICE_CONFIG_FILE", path)
+	t.Setenv("TTL_SECONDS", "100")
+
+	conf, err := NewWithName("file_service")
+	if err != nil {
+		t.Fatalf("error loading conf %v", err)
+	}
+	if !conf.Debug {
+		t.Errorf("expected conf.Debug to be true")
+	}
+	if conf.Cache.TTLSec != 100 {
+		t.Errorf("expected the environment to take precedence with a TTLSec of %d, got %d", 100, conf.Cache.TTLSec)
+	}
+	if conf.Cache.MaxSize != 1000 {
+		t.Errorf("expected conf.MaxSize to equal %d, got %d", 1000, conf.Cache.MaxSize)
+	}
+	if conf.RateLimit.ReadRate != 2.5 {
+		t.Errorf("expected conf.RateLimit.ReadRate to equal %v, got %v", 2.5, conf.RateLimit.ReadRate)
+	}
+	if conf.Namespaces["team-a"].TTLSec != 10 || conf.Namespaces["team-b"].MaxSize != 5 {
+		t.Errorf("expected the namespaces of the file, got %+v", conf.Namespaces)
+	}
+}
+
+func TestNewWithName_ConfigFileErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+	}{
+		{name: "unknown setting", content: "ttl: 60"},
+		{name: "invalid value", content: "max_size: many"},
+		{name: "list", content: "max_size: [1, 2]"},
+		{name: "invalid yaml", content: "max_size: : :"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("FILE_SERVICE_CONFIG_FILE", writeConfigFile(t, tt.content))
+			if _, err := NewWithName("file_service"); err == nil {
+				t.Errorf("expected an error")
+			}
+		})
+	}
+}
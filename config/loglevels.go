@@ -0,0 +1,55 @@
+package config
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// logLevelNames are the levels a component can log at
+var logLevelNames = map[string]bool{
+	"trace": true, "debug": true, "info": true, "warn": true, "error": true, "fatal": true, "panic": true,
+	"disabled": true,
+}
+
+// LogLevels maps the components of the server to their log level, the components left out follow DEBUG.
+//
+// It is decoded from comma separated component=level pairs:
+//
+//	server=debug,redis=warn
+type LogLevels map[string]string
+
+// Decode implements envconfig.Decoder
+func (l *LogLevels) Decode(value string) error {
+	levels := make(LogLevels)
+	for _, pair := range strings.Split(value, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		component, level, ok := strings.Cut(pair, "=")
+		if !ok {
+			return fmt.Errorf("log level %q is not in the component=level form", pair)
+		}
+		component, level = strings.TrimSpace(component), strings.ToLower(strings.TrimSpace(level))
+		if !logLevelNames[level] {
+			return fmt.Errorf("unknown log level %q of component %q", level, component)
+		}
+		if _, ok := levels[component]; ok {
+			return fmt.Errorf("duplicate log level of component %q", component)
+		}
+		levels[component] = level
+	}
+	*l = levels
+	return nil
+}
+
+// String returns the pairs of the components and their levels, sorted by component
+func (l LogLevels) String() string {
+	pairs := make([]string, 0, len(l))
+	for component, level := range l {
+		pairs = append(pairs, component+"="+level)
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
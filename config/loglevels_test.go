@@ -0,0 +1,46 @@
+package config
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestLogLevels_Decode(t *testing.T) {
+	tests := []struct {
+		name        string
+		value       string
+		expected    LogLevels
+		expectedErr bool
+	}{
+		{name: "empty", value: "", expected: LogLevels{}},
+		{
+			name:     "multiple components",
+			value:    "server=debug, redis=WARN",
+			expected: LogLevels{"server": "debug", "redis": "warn"},
+		},
+		{name: "unknown level", value: "server=loud", expectedErr: true},
+		{name: "duplicate component", value: "server=debug,server=info", expectedErr: true},
+		{name: "missing level", value: "server", expectedErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var levels LogLevels
+			err := levels.Decode(tt.value)
+			if tt.expectedErr {
+				if err == nil {
+					t.Errorf("expected an error decoding %q", tt.value)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("error decoding %q: %v", tt.value, err)
+			}
+			if !reflect.DeepEqual(levels, tt.expected) {
+				t.Errorf("expected %+v, got %+v", tt.expected, levels)
+			}
+			if got := levels.String(); len(tt.expected) == 2 && got != "redis=warn,server=debug" {
+				t.Errorf("expected the sorted pairs, got %s", got)
+			}
+		})
+	}
+}
@@ -0,0 +1,100 @@
+package config
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Namespaces maps the name of each namespace to the settings of its cache. Settings left out fall back to the ones
+// of the default cache, see CacheConfig.WithDefaults.
+//
+// It is decoded from a spec of semicolon separated namespaces, each with comma separated settings named like the
+// environment variables of CacheConfig:
+//
+//	team-a:ttl_seconds=60,max_size=1000;team-b:eviction_interval_ms=500;team-c
+type Namespaces map[string]CacheConfig
+
+var namespaceNameRegex = regexp.MustCompile(`^[A-Za-z0-9_.-]+$`)
+
+// Decode implements envconfig.Decoder
+func (n *Namespaces) Decode(value string) error {
+	namespaces := make(Namespaces)
+	for _, spec := range strings.Split(value, ";") {
+		spec = strings.TrimSpace(spec)
+		if spec == "" {
+			continue
+		}
+		name, settings, _ := strings.Cut(spec, ":")
+		name = strings.TrimSpace(name)
+		if !namespaceNameRegex.MatchString(name) {
+			return fmt.Errorf("invalid namespace name %q", name)
+		}
+		if _, ok := namespaces[name]; ok {
+			return fmt.Errorf("duplicate namespace %q", name)
+		}
+		conf, err := decodeCacheSettings(settings)
+		if err != nil {
+			return fmt.Errorf("namespace %q: %w", name, err)
+		}
+		namespaces[name] = conf
+	}
+	*n = namespaces
+	return nil
+}
+
+// String returns the spec of the namespaces, sorted by name
+func (n Namespaces) String() string {
+	names := make([]string, 0, len(n))
+	for name := range n {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	specs := make([]string, 0, len(names))
+	for _, name := range names {
+		conf := n[name]
+		var settings []string
+		if conf.TTLSec != 0 {
+			settings = append(settings, "ttl_seconds="+strconv.Itoa(conf.TTLSec))
+		}
+		if conf.EvictionIntervalMilliSec != 0 {
+			settings = append(settings, "eviction_interval_ms="+strconv.Itoa(conf.EvictionIntervalMilliSec))
+		}
+		if conf.MaxSize != 0 {
+			settings = append(settings, "max_size="+strconv.Itoa(conf.MaxSize))
+		}
+		specs = append(specs, name+":"+strings.Join(settings, ","))
+	}
+	return strings.Join(specs, ";")
+}
+
+func decodeCacheSettings(settings string) (CacheConfig, error) {
+	var conf CacheConfig
+	for _, setting := range strings.Split(settings, ",") {
+		setting = strings.TrimSpace(setting)
+		if setting == "" {
+			continue
+		}
+		key, value, ok := strings.Cut(setting, "=")
+		if !ok {
+			return conf, fmt.Errorf("setting %q is not in the key=value form", setting)
+		}
+		n, err := strconv.Atoi(strings.TrimSpace(value))
+		if err != nil {
+			return conf, fmt.Errorf("setting %q: %w", key, err)
+		}
+		switch strings.ToLower(strings.TrimSpace(key)) {
+		case "ttl_seconds":
+			conf.TTLSec = n
+		case "eviction_interval_ms":
+			conf.EvictionIntervalMilliSec = n
+		case "max_size":
+			conf.MaxSize = n
+		default:
+			return conf, fmt.Errorf("unknown setting %q", key)
+		}
+	}
+	return conf, nil
+}
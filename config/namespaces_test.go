@@ -0,0 +1,62 @@
+package config
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestNamespaces_Decode(t *testing.T) {
+	tests := []struct {
+		name        string
+		value       string
+		expected    Namespaces
+		expectedErr bool
+	}{
+		{
+			name:     "empty",
+			value:    "",
+			expected: Namespaces{},
+		},
+		{
+			name:  "multiple namespaces",
+			value: "team-a:ttl_seconds=60,max_size=1000; team-b:eviction_interval_ms=500;team_c",
+			expected: Namespaces{
+				"team-a": {TTLSec: 60, MaxSize: 1000},
+				"team-b": {EvictionIntervalMilliSec: 500},
+				"team_c": {},
+			},
+		},
+		{name: "invalid name", value: "team/a:ttl_seconds=1", expectedErr: true},
+		{name: "duplicate name", value: "a;a", expectedErr: true},
+		{name: "unknown setting", value: "a:size=1", expectedErr: true},
+		{name: "invalid value", value: "a:ttl_seconds=abc", expectedErr: true},
+		{name: "missing value", value: "a:ttl_seconds", expectedErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var namespaces Namespaces
+			err := namespaces.Decode(tt.value)
+			if tt.expectedErr {
+				if err == nil {
+					t.Errorf("expected an error decoding %q", tt.value)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("error decoding %q: %v", tt.value, err)
+			}
+			if !reflect.DeepEqual(namespaces, tt.expected) {
+				t.Errorf("expected %+v, got %+v", tt.expected, namespaces)
+			}
+		})
+	}
+}
+
+func TestCacheConfig_WithDefaults(t *testing.T) {
+	defaults := CacheConfig{TTLSec: 1800, EvictionIntervalMilliSec: 1000, MaxSize: 10}
+	got := CacheConfig{TTLSec: 60}.WithDefaults(defaults)
+	expected := CacheConfig{TTLSec: 60, EvictionIntervalMilliSec: 1000, MaxSize: 10}
+	if got != expected {
+		t.Errorf("expected %+v, got %+v", expected, got)
+	}
+}
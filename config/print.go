@@ -0,0 +1,89 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// redactedValue replaces the values of the secret settings when the config is printed
+const redactedValue = "[REDACTED]"
+
+// Source tells where the value of a setting came from
+type Source string
+
+const (
+	SourceDefault Source = "default"
+	SourceEnv     Source = "env"
+	SourceDotEnv  Source = ".env"
+	SourceFile    Source = "file"
+)
+
+// Value is a setting of the effective config, with its secret values redacted
+type Value struct {
+	// Name is the name of the setting in the config file
+	Name string
+	// Key is the environment variable the setting is read from, the alternative name if that is the one set
+	Key    string
+	Value  string
+	Source Source
+}
+
+// redacted returns a copy of the config where the secret settings that are set are replaced by redactedValue
+func (c Config) redacted() Config {
+	for _, s := range settings(&c, "") {
+		if s.secret && s.field.String() != "" {
+			s.field.SetString(redactedValue)
+		}
+	}
+	return c
+}
+
+// String lists the settings by name, with the secrets redacted, so the config can be logged
+func (c Config) String() string {
+	r := c.redacted()
+	var b strings.Builder
+	for i, s := range settings(&r, "") {
+		if i > 0 {
+			b.WriteByte(' ')
+		}
+		fmt.Fprintf(&b, "%s=%v", s.name, s.field.Interface())
+	}
+	return b.String()
+}
+
+// MarshalJSON encodes the settings as an object keyed by their names, like the config file, with the secrets redacted
+func (c Config) MarshalJSON() ([]byte, error) {
+	r := c.redacted()
+	values := make(map[string]any)
+	for _, s := range settings(&r, "") {
+		values[s.name] = s.field.Interface()
+	}
+	return json.Marshal(values)
+}
+
+// Explain loads the config like NewWithName, without validating it, and tells where the value of every setting came
+// from. dotenv holds the variables read from the .env file, which are loaded into the environment as well.
+func Explain(serviceName string, dotenv map[string]string) (Config, []Value, error) {
+	conf, fileValues, err := load(serviceName)
+	if err != nil {
+		return conf, nil, err
+	}
+	r := conf.redacted()
+	var values []Value
+	for _, s := range settings(&r, serviceName) {
+		value := Value{Name: s.name, Key: s.key, Value: fmt.Sprint(s.field.Interface()), Source: SourceDefault}
+		if key, ok := s.envKey(); ok {
+			value.Key, value.Source = key, SourceEnv
+			// godotenv does not override the variables that are already set, so the value tells them apart
+			if fromDotEnv, ok := dotenv[key]; ok && os.Getenv(key) == fromDotEnv {
+				value.Source = SourceDotEnv
+			}
+		} else if _, ok := fileValues[s.name]; ok && s.name != configFileKey {
+			value.Source = SourceFile
+		}
+		values = append(values, value)
+	}
+	return conf, values, nil
+}
@@ -0,0 +1,80 @@
+package config
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestConfig_Redacted(t *testing.T) {
+	t.Parallel()
+	c := validConfig()
+	c.RedisConfig.Password = "hunter2"
+	c.Auth.APIKeys = "alice=secret-key"
+	c.Namespaces = Namespaces{"team-a": {TTLSec: 60}}
+
+	s := c.String()
+	if strings.Contains(s, "hunter2") || strings.Contains(s, "secret-key") {
+		t.Errorf("Expected the secrets to be redacted, got %s", s)
+	}
+	if !strings.Contains(s, "redis_password="+redactedValue) || !strings.Contains(s, "namespaces=team-a:ttl_seconds=60") {
+		t.Errorf("Expected the settings by name, got %s", s)
+	}
+
+	content, err := json.Marshal(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var values map[string]any
+	if err := json.Unmarshal(content, &values); err != nil {
+		t.Fatal(err)
+	}
+	if values["redis_password"] != redactedValue || values["auth_api_keys"] != redactedValue {
+		t.Errorf("Expected the secrets to be redacted, got %s", content)
+	}
+	if values["port"] != "8080" || values["ttl_seconds"] != float64(1800) {
+		t.Errorf("Expected the settings by name, got %s", content)
+	}
+	if c.RedisConfig.Password != "hunter2" {
+		t.Errorf("Expected the config to be left unchanged, got %s", c.RedisConfig.Password)
+	}
+}
+
+func TestExplain(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte("max_size: 10\nttl_seconds: 5"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("EXPLAIN_SERVICE_CONFIG_FILE", path)
+	t.Setenv("EXPLAIN_SERVICE_CACHE_TTL_SECONDS", "20")
+	t.Setenv("REDIS_PASSWORD", "hunter2")
+	t.Setenv("EXPLAIN_SERVICE_PORT", "9000")
+
+	_, values, err := Explain("explain_service", map[string]string{"REDIS_PASSWORD": "hunter2"})
+	if err != nil {
+		t.Fatalf("error explaining conf %v", err)
+	}
+	want := map[string]Value{
+		"ttl_seconds":    {Key: "EXPLAIN_SERVICE_CACHE_TTL_SECONDS", Value: "20", Source: SourceEnv},
+		"max_size":       {Key: "EXPLAIN_SERVICE_CACHE_MAX_SIZE", Value: "10", Source: SourceFile},
+		"redis_password": {Key: "REDIS_PASSWORD", Value: redactedValue, Source: SourceDotEnv},
+		"port":           {Key: "EXPLAIN_SERVICE_PORT", Value: "9000", Source: SourceEnv},
+		"use_redis":      {Key: "EXPLAIN_SERVICE_USE_REDIS", Value: "false", Source: SourceDefault},
+	}
+	for _, value := range values {
+		expected, ok := want[value.Name]
+		if !ok {
+			continue
+		}
+		expected.Name = value.Name
+		if value != expected {
+			t.Errorf("Expected %+v, got %+v", expected, value)
+		}
+		delete(want, value.Name)
+	}
+	if len(want) != 0 {
+		t.Errorf("Expected the settings %v to be explained", want)
+	}
+}
@@ -0,0 +1,82 @@
+package config
+
+import (
+	"context"
+	"os"
+	"reflect"
+	"time"
+)
+
+// hotSettings are the settings that apply to a running server, by their name in the config file
+var hotSettings = map[string]bool{
+	"debug":                  true,
+	"log_levels":             true,
+	"ttl_seconds":            true,
+	"eviction_interval_ms":   true,
+	"max_size":               true,
+	"rate_limit_read_rps":    true,
+	"rate_limit_read_burst":  true,
+	"rate_limit_write_rps":   true,
+	"rate_limit_write_burst": true,
+	"rate_limit_ip_rps":      true,
+	"rate_limit_ip_burst":    true,
+}
+
+// Reload returns current with the hot settings taken from next, along with the names of the changed settings that
+// can not apply to a running server. The settings of the namespaces are hot as long as the set of namespaces stays
+// the same.
+func Reload(current Config, next Config) (Config, []string) {
+	reloaded := current
+	var rejected []string
+	nextSettings := settings(&next, "")
+	for i, s := range settings(&reloaded, "") {
+		value := nextSettings[i].field
+		if reflect.DeepEqual(s.field.Interface(), value.Interface()) {
+			continue
+		}
+		if hotSettings[s.name] || (s.name == "namespaces" && sameNamespaces(current.Namespaces, next.Namespaces)) {
+			s.field.Set(value)
+			continue
+		}
+		rejected = append(rejected, s.name)
+	}
+	return reloaded, rejected
+}
+
+func sameNamespaces(a Namespaces, b Namespaces) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for name := range a {
+		if _, ok := b[name]; !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// Watch calls reload whenever the modification time of the file at path changes, checking it every interval until
+// ctx is done
+func Watch(ctx context.Context, path string, interval time.Duration, reload func()) {
+	modTime := func() time.Time {
+		info, err := os.Stat(path)
+		if err != nil {
+			return time.Time{}
+		}
+		return info.ModTime()
+	}
+	last := modTime()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if current := modTime(); !current.Equal(last) {
+				last = current
+				reload()
+			}
+		}
+	}
+}
@@ -0,0 +1,105 @@
+package config
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestReload(t *testing.T) {
+	t.Parallel()
+	current := Config{
+		Port:       "8080",
+		Cache:      CacheConfig{TTLSec: 60, MaxSize: 10},
+		Namespaces: Namespaces{"team-a": {TTLSec: 5}},
+	}
+
+	tests := []struct {
+		name         string
+		next         Config
+		want         Config
+		wantRejected []string
+	}{
+		{
+			name: "hot settings",
+			next: Config{
+				Debug:      true,
+				Port:       "8080",
+				Cache:      CacheConfig{TTLSec: 30, MaxSize: 20, EvictionIntervalMilliSec: 100},
+				RateLimit:  RateLimitConfig{ReadRate: 5},
+				Namespaces: Namespaces{"team-a": {TTLSec: 1}},
+			},
+			want: Config{
+				Debug:      true,
+				Port:       "8080",
+				Cache:      CacheConfig{TTLSec: 30, MaxSize: 20, EvictionIntervalMilliSec: 100},
+				RateLimit:  RateLimitConfig{ReadRate: 5},
+				Namespaces: Namespaces{"team-a": {TTLSec: 1}},
+			},
+		},
+		{
+			name: "restart settings",
+			next: Config{
+				Port:       "9090",
+				UseRedis:   true,
+				Cache:      CacheConfig{TTLSec: 30, MaxSize: 10},
+				Namespaces: Namespaces{"team-b": {TTLSec: 5}},
+			},
+			want: Config{
+				Port:       "8080",
+				Cache:      CacheConfig{TTLSec: 30, MaxSize: 10},
+				Namespaces: Namespaces{"team-a": {TTLSec: 5}},
+			},
+			wantRejected: []string{"port", "use_redis", "namespaces"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, rejected := Reload(current, tt.next)
+			if got.Debug != tt.want.Debug || got.Port != tt.want.Port || got.UseRedis != tt.want.UseRedis ||
+				got.Cache != tt.want.Cache || got.RateLimit != tt.want.RateLimit {
+				t.Errorf("Expected %+v, got %+v", tt.want, got)
+			}
+			if len(got.Namespaces) != len(tt.want.Namespaces) {
+				t.Errorf("Expected namespaces %+v, got %+v", tt.want.Namespaces, got.Namespaces)
+			}
+			for name, nsConf := range tt.want.Namespaces {
+				if got.Namespaces[name] != nsConf {
+					t.Errorf("Expected namespaces %+v, got %+v", tt.want.Namespaces, got.Namespaces)
+				}
+			}
+			if !slices.Equal(rejected, tt.wantRejected) {
+				t.Errorf("Expected rejected settings %v, got %v", tt.wantRejected, rejected)
+			}
+		})
+	}
+	if current.Cache.TTLSec != 60 {
+		t.Errorf("Expected the current config to be left unchanged, got %+v", current.Cache)
+	}
+}
+
+func TestWatch(t *testing.T) {
+	t.Parallel()
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte("debug: false"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	reloaded := make(chan struct{}, 1)
+	go Watch(ctx, path, 10*time.Millisecond, func() { reloaded <- struct{}{} })
+
+	time.Sleep(30 * time.Millisecond)
+	modified := time.Now().Add(time.Second)
+	if err := os.Chtimes(path, modified, modified); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-reloaded:
+	case <-time.After(time.Second):
+		t.Fatal("Expected reload to be called after the file changed")
+	}
+}
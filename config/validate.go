@@ -0,0 +1,177 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
+)
+
+// Validate checks the settings for values the server can not run with, and returns all the problems found at once
+func (c Config) Validate() error {
+	var errs []error
+	check := func(ok bool, format string, args ...any) {
+		if !ok {
+			errs = append(errs, fmt.Errorf(format, args...))
+		}
+	}
+
+	port, err := strconv.Atoi(c.Port)
+	check(err == nil && port > 0 && port <= 65535, "port must be a number between 1 and 65535, got %q", c.Port)
+	check(c.ShutdownDelaySec >= 0, "shutdown_delay_seconds must not be negative, got %d", c.ShutdownDelaySec)
+	check(c.RedisConfig.DB >= 0, "redis_db must not be negative, got %d", c.RedisConfig.DB)
+
+	errs = append(errs, c.Cache.validate("")...)
+	names := make([]string, 0, len(c.Namespaces))
+	for name := range c.Namespaces {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		errs = append(errs, c.Namespaces[name].validate(fmt.Sprintf("namespace %q: ", name))...)
+	}
+
+	check(c.Limits.MaxValueBytes >= 0, "max_value_bytes must not be negative, got %d", c.Limits.MaxValueBytes)
+	check(c.Limits.MaxKeyLength >= 0, "max_key_length must not be negative, got %d", c.Limits.MaxKeyLength)
+	check(c.Limits.WatchBuffer > 0, "watch_buffer must be positive, got %d", c.Limits.WatchBuffer)
+	if c.Limits.KeyPattern != "" {
+		_, err := regexp.Compile(c.Limits.KeyPattern)
+		check(err == nil, "key_pattern is not a valid regular expression: %v", err)
+	}
+
+	if c.RateLimit.Enabled {
+		check(c.RateLimit.ReadRate > 0, "rate_limit_read_rps must be positive, got %v", c.RateLimit.ReadRate)
+		check(c.RateLimit.ReadBurst > 0, "rate_limit_read_burst must be positive, got %d", c.RateLimit.ReadBurst)
+		check(c.RateLimit.WriteRate > 0, "rate_limit_write_rps must be positive, got %v", c.RateLimit.WriteRate)
+		check(c.RateLimit.WriteBurst > 0, "rate_limit_write_burst must be positive, got %d", c.RateLimit.WriteBurst)
+		check(c.RateLimit.IPRate > 0, "rate_limit_ip_rps must be positive, got %v", c.RateLimit.IPRate)
+		check(c.RateLimit.IPBurst > 0, "rate_limit_ip_burst must be positive, got %d", c.RateLimit.IPBurst)
+		check(!c.RateLimit.Distributed || c.UseRedis, "rate_limit_distributed requires use_redis")
+	}
+
+	check((c.TLS.CertFile == "") == (c.TLS.KeyFile == ""), "tls_cert_file and tls_key_file must be set together")
+	check(c.TLS.ClientCAFile == "" || c.TLS.CertFile != "", "tls_client_ca_file requires tls_cert_file")
+	check(c.TLS.CertFile == "" || c.TLS.ReloadIntervalSec > 0,
+		"tls_reload_interval_seconds must be positive, got %d", c.TLS.ReloadIntervalSec)
+
+	check(c.Log.Format == "console" || c.Log.Format == "json" || c.Log.Format == "logfmt",
+		"log_format must be one of console, json and logfmt, got %q", c.Log.Format)
+
+	check(c.AccessLog.SampleRate >= 0 && c.AccessLog.SampleRate <= 1,
+		"access_log_sample_rate must be between 0 and 1, got %v", c.AccessLog.SampleRate)
+
+	check(c.Audit.MaxSizeMB >= 0, "audit_max_size_mb must not be negative, got %d", c.Audit.MaxSizeMB)
+	check(c.Audit.MaxAgeHours >= 0, "audit_max_age_hours must not be negative, got %d", c.Audit.MaxAgeHours)
+	check(c.Audit.MaxBackups >= 0, "audit_max_backups must not be negative, got %d", c.Audit.MaxBackups)
+
+	if c.Replication.Listen != "" || c.Replication.Primary != "" {
+		check(!c.UseRedis, "replication_listen and replication_primary require the in-memory cache")
+		check(c.Replication.Buffer > 0, "replication_buffer must be positive, got %d", c.Replication.Buffer)
+		check(c.Replication.Token != "", "replication_listen and replication_primary require replication_token")
+	}
+
+	if c.Cluster.Enabled() {
+		discovery := 0
+		for _, set := range []bool{c.Cluster.Peers != "", c.Cluster.DNS != "", c.Cluster.GossipPort != 0} {
+			if set {
+				discovery++
+			}
+		}
+		check(!c.UseRedis, "cluster_peers, cluster_dns and cluster_gossip_port require the in-memory cache")
+		check(discovery == 1, "only one of cluster_peers, cluster_dns and cluster_gossip_port can be set")
+		check(c.Cluster.Advertise != "", "cluster_peers, cluster_dns and cluster_gossip_port require cluster_advertise")
+		check(c.Cluster.Token != "", "cluster_peers, cluster_dns and cluster_gossip_port require cluster_token")
+		check(c.Cluster.ReplicationFactor > 0, "cluster_replication_factor must be positive, got %d",
+			c.Cluster.ReplicationFactor)
+		check(c.Cluster.ReadRepairChance >= 0 && c.Cluster.ReadRepairChance <= 1,
+			"cluster_read_repair_chance must be between 0 and 1, got %g", c.Cluster.ReadRepairChance)
+		check(c.Cluster.DNSIntervalSec > 0, "cluster_dns_interval_seconds must be positive, got %d",
+			c.Cluster.DNSIntervalSec)
+		if c.Cluster.Peers != "" && c.Cluster.Advertise != "" {
+			check(slices.Contains(c.Cluster.PeerList(), c.Cluster.Advertise),
+				"cluster_peers must include cluster_advertise %q", c.Cluster.Advertise)
+		}
+		if c.Cluster.DNS != "" {
+			_, _, err := net.SplitHostPort(c.Cluster.DNS)
+			check(err == nil, "cluster_dns must be a host:port, got %q", c.Cluster.DNS)
+		}
+		if c.Cluster.GossipPort != 0 {
+			check(c.Cluster.GossipPort > 0 && c.Cluster.GossipPort < 65536,
+				"cluster_gossip_port must be a port, got %d", c.Cluster.GossipPort)
+			if c.Cluster.Advertise != "" {
+				_, _, err := net.SplitHostPort(c.Cluster.Advertise)
+				check(err == nil, "cluster_gossip_port requires cluster_advertise to be a host:port, got %q",
+					c.Cluster.Advertise)
+			}
+			for _, seed := range c.Cluster.SeedList() {
+				_, _, err := net.SplitHostPort(seed)
+				check(err == nil, "cluster_seeds must be host:port addresses, got %q", seed)
+			}
+		}
+	} else {
+		check(c.Cluster.Seeds == "", "cluster_seeds requires cluster_gossip_port")
+	}
+
+	if c.Invalidation.Bus != "" {
+		check(!c.UseRedis, "invalidation_bus requires the in-memory cache")
+		check(c.Invalidation.Bus == "redis" || c.Invalidation.Bus == "http",
+			"invalidation_bus must be redis or http, got %q", c.Invalidation.Bus)
+		check(c.Invalidation.Bus != "http" || c.Invalidation.Listen != "", "invalidation_bus http requires invalidation_listen")
+		check(c.Invalidation.Bus != "http" || c.Invalidation.Token != "", "invalidation_bus http requires invalidation_token")
+		check(c.Invalidation.Bus != "redis" || c.Invalidation.Channel != "", "invalidation_bus redis requires invalidation_channel")
+		check(c.Replication.Primary == "", "invalidation_bus can not be set on a replication follower")
+		check(c.Invalidation.Buffer > 0, "invalidation_buffer must be positive, got %d", c.Invalidation.Buffer)
+	}
+
+	if c.Store.Backend != "" {
+		check(!c.UseRedis, "store_backend requires the in-memory cache")
+		check(c.Store.Backend == "file" || c.Store.Backend == "http",
+			"store_backend must be file or http, got %q", c.Store.Backend)
+		check(c.Store.Backend != "file" || c.Store.Dir != "", "store_backend file requires store_dir")
+		if c.Store.Backend == "http" {
+			origin, err := url.Parse(c.Store.Origin)
+			check(err == nil && (origin.Scheme == "http" || origin.Scheme == "https") && origin.Host != "",
+				"store_backend http requires store_origin to be an http or https URL, got %q", c.Store.Origin)
+		}
+		check(c.Store.TimeoutMilliSec > 0, "store_timeout_ms must be positive, got %d", c.Store.TimeoutMilliSec)
+		check(c.Store.Write == "none" || c.Store.Write == "through" || c.Store.Write == "behind",
+			"store_write must be none, through or behind, got %q", c.Store.Write)
+		check(c.Store.ReadThrough || c.Store.Write != "none", "store_backend requires store_read_through or store_write")
+		if c.Store.Write == "behind" {
+			check(c.Store.BatchSize > 0, "store_batch_size must be positive, got %d", c.Store.BatchSize)
+			check(c.Store.FlushIntervalMilliSec > 0, "store_flush_interval_ms must be positive, got %d",
+				c.Store.FlushIntervalMilliSec)
+			check(c.Store.Buffer > 0, "store_buffer must be positive, got %d", c.Store.Buffer)
+		}
+		check(c.Replication.Primary == "", "store_backend can not be set on a replication follower")
+	}
+
+	if c.Auth.Enabled {
+		check(c.Auth.APIKeys != "" || c.Auth.File != "" || c.Auth.JWKSFile != "",
+			"auth_enabled requires auth_api_keys, auth_file or auth_jwks_file")
+	}
+	check(c.Auth.JWKSFile != "" || (c.Auth.JWTIssuer == "" && c.Auth.JWTAudience == ""),
+		"auth_jwt_issuer and auth_jwt_audience require auth_jwks_file")
+
+	return errors.Join(errs...)
+}
+
+// validate checks the settings of a cache, prefixing the errors to tell the caches apart
+func (c CacheConfig) validate(prefix string) []error {
+	var errs []error
+	if c.TTLSec < 0 {
+		errs = append(errs, fmt.Errorf("%sttl_seconds must not be negative, got %d", prefix, c.TTLSec))
+	}
+	if c.EvictionIntervalMilliSec < 0 {
+		errs = append(errs, fmt.Errorf("%seviction_interval_ms must not be negative, got %d", prefix,
+			c.EvictionIntervalMilliSec))
+	}
+	if c.MaxSize < 0 {
+		errs = append(errs, fmt.Errorf("%smax_size must not be negative, got %d", prefix, c.MaxSize))
+	}
+	return errs
+}
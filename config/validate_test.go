@@ -0,0 +1,226 @@
+package config
+
+import (
+	"strings"
+	"testing"
+)
+
+func validConfig() Config {
+	return Config{
+		Port:        "8080",
+		Cache:       CacheConfig{TTLSec: 1800, EvictionIntervalMilliSec: 1000},
+		RateLimit:   RateLimitConfig{ReadRate: 100, ReadBurst: 200, WriteRate: 20, WriteBurst: 40, IPRate: 200, IPBurst: 400},
+		Limits:      LimitsConfig{MaxValueBytes: 1048576, MaxKeyLength: 250, WatchBuffer: 256},
+		TLS:         TLSConfig{ReloadIntervalSec: 10},
+		RedisConfig: RedisConfig{Host: "localhost"},
+		Log:         LogConfig{Format: "console"},
+		AccessLog:   AccessLogConfig{Enabled: true, SampleRate: 1},
+	}
+}
+
+func TestConfig_Validate(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name     string
+		modify   func(c *Config)
+		wantErrs []string
+	}{
+		{name: "valid", modify: func(c *Config) {}},
+		{
+			name: "port and ttl",
+			modify: func(c *Config) {
+				c.Port = "http"
+				c.Cache.TTLSec = -1
+			},
+			wantErrs: []string{`port must be a number between 1 and 65535, got "http"`, "ttl_seconds must not be negative"},
+		},
+		{
+			name:     "port out of range",
+			modify:   func(c *Config) { c.Port = "70000" },
+			wantErrs: []string{"port must be a number between 1 and 65535"},
+		},
+		{
+			name:     "namespace",
+			modify:   func(c *Config) { c.Namespaces = Namespaces{"team-a": {MaxSize: -5}} },
+			wantErrs: []string{`namespace "team-a": max_size must not be negative, got -5`},
+		},
+		{
+			name:     "key pattern",
+			modify:   func(c *Config) { c.Limits.KeyPattern = "[a-z" },
+			wantErrs: []string{"key_pattern is not a valid regular expression"},
+		},
+		{
+			name:     "watch buffer",
+			modify:   func(c *Config) { c.Limits.WatchBuffer = 0 },
+			wantErrs: []string{"watch_buffer must be positive, got 0"},
+		},
+		{
+			name: "rate limits",
+			modify: func(c *Config) {
+				c.RateLimit.Enabled = true
+				c.RateLimit.WriteRate = 0
+				c.RateLimit.IPBurst = 0
+				c.RateLimit.Distributed = true
+			},
+			wantErrs: []string{
+				"rate_limit_write_rps must be positive",
+				"rate_limit_ip_burst must be positive",
+				"rate_limit_distributed requires use_redis",
+			},
+		},
+		{
+			name:     "rate limits disabled",
+			modify:   func(c *Config) { c.RateLimit.WriteRate = 0 },
+			wantErrs: nil,
+		},
+		{
+			name:     "tls",
+			modify:   func(c *Config) { c.TLS.KeyFile, c.TLS.ClientCAFile = "key.pem", "ca.pem" },
+			wantErrs: []string{"tls_cert_file and tls_key_file must be set together", "tls_client_ca_file requires tls_cert_file"},
+		},
+		{
+			name:     "log format",
+			modify:   func(c *Config) { c.Log.Format = "xml" },
+			wantErrs: []string{`log_format must be one of console, json and logfmt, got "xml"`},
+		},
+		{
+			name:     "access log sample rate",
+			modify:   func(c *Config) { c.AccessLog.SampleRate = 1.5 },
+			wantErrs: []string{"access_log_sample_rate must be between 0 and 1, got 1.5"},
+		},
+		{
+			name:     "audit",
+			modify:   func(c *Config) { c.Audit.MaxSizeMB, c.Audit.MaxBackups = -1, -2 },
+			wantErrs: []string{"audit_max_size_mb must not be negative, got -1", "audit_max_backups must not be negative, got -2"},
+		},
+		{
+			name:   "replication",
+			modify: func(c *Config) { c.UseRedis, c.Replication.Primary, c.Replication.Buffer = true, "primary:7070", 0 },
+			wantErrs: []string{
+				"replication_listen and replication_primary require the in-memory cache",
+				"replication_buffer must be positive, got 0",
+				"replication_listen and replication_primary require replication_token",
+			},
+		},
+		{
+			name: "cluster",
+			modify: func(c *Config) {
+				c.Cluster = ClusterConfig{Peers: "a:8080,b:8080", DNS: "cache", ReplicationFactor: 0, ReadRepairChance: 1.5,
+					DNSIntervalSec: 10}
+			},
+			wantErrs: []string{
+				"only one of cluster_peers, cluster_dns and cluster_gossip_port can be set",
+				"cluster_peers, cluster_dns and cluster_gossip_port require cluster_advertise",
+				"cluster_peers, cluster_dns and cluster_gossip_port require cluster_token",
+				"cluster_replication_factor must be positive, got 0",
+				"cluster_read_repair_chance must be between 0 and 1, got 1.5",
+				`cluster_dns must be a host:port, got "cache"`,
+			},
+		},
+		{
+			name: "cluster advertise",
+			modify: func(c *Config) {
+				c.Cluster = ClusterConfig{Peers: "a:8080", Advertise: "c:8080", Token: "t", ReplicationFactor: 1, DNSIntervalSec: 10}
+			},
+			wantErrs: []string{`cluster_peers must include cluster_advertise "c:8080"`},
+		},
+		{
+			name: "cluster gossip",
+			modify: func(c *Config) {
+				c.Cluster = ClusterConfig{GossipPort: 70000, Seeds: "a:7946,b", Advertise: "c", Token: "t",
+					ReplicationFactor: 1, DNSIntervalSec: 10}
+			},
+			wantErrs: []string{
+				"cluster_gossip_port must be a port, got 70000",
+				`cluster_gossip_port requires cluster_advertise to be a host:port, got "c"`,
+				`cluster_seeds must be host:port addresses, got "b"`,
+			},
+		},
+		{
+			name:     "cluster seeds",
+			modify:   func(c *Config) { c.Cluster.Seeds = "a:7946" },
+			wantErrs: []string{"cluster_seeds requires cluster_gossip_port"},
+		},
+		{
+			name: "invalidation",
+			modify: func(c *Config) {
+				c.Replication = ReplicationConfig{Primary: "primary:7070", Token: "t", Buffer: 1}
+				c.Invalidation = InvalidationConfig{Bus: "http", Buffer: 0}
+			},
+			wantErrs: []string{
+				"invalidation_bus http requires invalidation_listen",
+				"invalidation_bus http requires invalidation_token",
+				"invalidation_bus can not be set on a replication follower",
+				"invalidation_buffer must be positive, got 0",
+			},
+		},
+		{
+			name: "store",
+			modify: func(c *Config) {
+				c.Replication = ReplicationConfig{Primary: "primary:7070", Token: "t", Buffer: 1}
+				c.Store = StoreConfig{Backend: "file", Write: "behind", TimeoutMilliSec: 1000, FlushIntervalMilliSec: 1000}
+			},
+			wantErrs: []string{
+				"store_backend file requires store_dir",
+				"store_batch_size must be positive, got 0",
+				"store_buffer must be positive, got 0",
+				"store_backend can not be set on a replication follower",
+			},
+		},
+		{
+			name: "store backend",
+			modify: func(c *Config) {
+				c.UseRedis = true
+				c.Store = StoreConfig{Backend: "http", Origin: "api.example.com", Write: "around"}
+			},
+			wantErrs: []string{
+				"store_backend requires the in-memory cache",
+				`store_backend http requires store_origin to be an http or https URL, got "api.example.com"`,
+				"store_timeout_ms must be positive, got 0",
+				`store_write must be none, through or behind, got "around"`,
+			},
+		},
+		{
+			name: "store without reads or writes",
+			modify: func(c *Config) {
+				c.Store = StoreConfig{Backend: "http", Origin: "https://api.example.com", TimeoutMilliSec: 1000, Write: "none"}
+			},
+			wantErrs: []string{"store_backend requires store_read_through or store_write"},
+		},
+		{
+			name:     "invalidation bus",
+			modify:   func(c *Config) { c.UseRedis, c.Invalidation = true, InvalidationConfig{Bus: "multicast", Buffer: 1} },
+			wantErrs: []string{"invalidation_bus requires the in-memory cache", `invalidation_bus must be redis or http, got "multicast"`},
+		},
+		{
+			name:     "auth",
+			modify:   func(c *Config) { c.Auth.Enabled, c.Auth.JWTIssuer = true, "issuer" },
+			wantErrs: []string{"auth_enabled requires", "auth_jwt_issuer and auth_jwt_audience require auth_jwks_file"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := validConfig()
+			tt.modify(&c)
+			err := c.Validate()
+			if len(tt.wantErrs) == 0 {
+				if err != nil {
+					t.Errorf("Unexpected error %v", err)
+				}
+				return
+			}
+			if err == nil {
+				t.Fatalf("Expected errors %v", tt.wantErrs)
+			}
+			lines := strings.Split(err.Error(), "\n")
+			if len(lines) != len(tt.wantErrs) {
+				t.Errorf("Expected %d errors, got %q", len(tt.wantErrs), err)
+			}
+			for _, want := range tt.wantErrs {
+				if !strings.Contains(err.Error(), want) {
+					t.Errorf("Expected %q in %q", want, err)
+				}
+			}
+		})
+	}
+}
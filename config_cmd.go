@@ -0,0 +1,45 @@
+package main
+
+import (
+	"cache-api/config"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"text/tabwriter"
+
+	"github.com/joho/godotenv"
+)
+
+const configUsage = "usage: cache-api config print"
+
+// runConfig runs the `config` subcommand. `config print` writes the effective config with the source of every value,
+// then fails if the config is not valid.
+func runConfig(args []string, stdout io.Writer) error {
+	if len(args) != 1 || args[0] != "print" {
+		return errors.New(configUsage)
+	}
+	dotenv, err := godotenv.Read()
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("error reading dotenv %w", err)
+	}
+	if err := godotenv.Load(); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("error lodaing dotenv %w", err)
+	}
+	conf, values, err := config.Explain(os.Getenv("SERVICE_NAME"), dotenv)
+	if err != nil {
+		return fmt.Errorf("error lodaing config %w", err)
+	}
+	w := tabwriter.NewWriter(stdout, 0, 0, 2, ' ', 0)
+	_, _ = fmt.Fprintln(w, "SETTING\tVARIABLE\tVALUE\tSOURCE")
+	for _, value := range values {
+		_, _ = fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", value.Name, value.Key, value.Value, value.Source)
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	if err := conf.Validate(); err != nil {
+		return fmt.Errorf("invalid config:\n%w", err)
+	}
+	return nil
+}
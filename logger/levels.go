@@ -0,0 +1,158 @@
+package logger
+
+import (
+	"fmt"
+	"sort"
+	"sync/atomic"
+
+	"github.com/rs/zerolog"
+)
+
+// DefaultComponent names the level the components without a level of their own follow
+const DefaultComponent = "default"
+
+// Levels holds the log level of every component, which can be changed while logging. The loggers made by Logger
+// drop the events below the level of their component.
+type Levels struct {
+	defaultLevel atomic.Int32
+	components   map[string]*componentLevel
+}
+
+type componentLevel struct {
+	// level is the level of the component, or zerolog.NoLevel to follow the default level
+	level atomic.Int32
+}
+
+// NewLevels returns the levels of the given components, all following the default level, which is debug if debug is
+// set and info otherwise
+func NewLevels(debug bool, components ...string) *Levels {
+	l := &Levels{components: make(map[string]*componentLevel, len(components))}
+	for _, component := range components {
+		c := &componentLevel{}
+		c.level.Store(int32(zerolog.NoLevel))
+		l.components[component] = c
+	}
+	l.SetDebug(debug)
+	return l
+}
+
+// SetDebug sets the default level to debug or info
+func (l *Levels) SetDebug(debug bool) {
+	level := zerolog.InfoLevel
+	if debug {
+		level = zerolog.DebugLevel
+	}
+	l.defaultLevel.Store(int32(level))
+}
+
+// Set changes the level of a component, or the default level for DefaultComponent. Setting zerolog.NoLevel makes the
+// component follow the default level again.
+func (l *Levels) Set(component string, level zerolog.Level) error {
+	if component == DefaultComponent {
+		if level == zerolog.NoLevel {
+			return fmt.Errorf("the %s level can not be unset", DefaultComponent)
+		}
+		l.defaultLevel.Store(int32(level))
+		return nil
+	}
+	c, ok := l.components[component]
+	if !ok {
+		return fmt.Errorf("unknown log component %q", component)
+	}
+	c.level.Store(int32(level))
+	return nil
+}
+
+// SetAll sets the level of every component, the components left out follow the default level
+func (l *Levels) SetAll(levels map[string]zerolog.Level) error {
+	for component := range levels {
+		if _, ok := l.components[component]; !ok {
+			return fmt.Errorf("unknown log component %q", component)
+		}
+	}
+	for component, c := range l.components {
+		level, ok := levels[component]
+		if !ok {
+			level = zerolog.NoLevel
+		}
+		c.level.Store(int32(level))
+	}
+	return nil
+}
+
+// Level returns the effective level of a component, or the default level for DefaultComponent
+func (l *Levels) Level(component string) zerolog.Level {
+	if c, ok := l.components[component]; ok {
+		if level := zerolog.Level(c.level.Load()); level != zerolog.NoLevel {
+			return level
+		}
+	}
+	return zerolog.Level(l.defaultLevel.Load())
+}
+
+// Components returns the names of the components, sorted
+func (l *Levels) Components() []string {
+	names := make([]string, 0, len(l.components))
+	for name := range l.components {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Logger returns a logger following the level of component, tagging its events with the component unless it is
+// DefaultComponent. The level is checked before an event is built, so the events below it cost next to nothing. base
+// should not be restricted to a level of its own, nor sampled, as the level replaces its sampler.
+func (l *Levels) Logger(base zerolog.Logger, component string) zerolog.Logger {
+	if component != DefaultComponent {
+		base = base.With().Str("component", component).Logger()
+	}
+	return base.Sample(levelSampler{levels: l, component: component})
+}
+
+// levelSampler drops the events below the level of its component. zerolog asks the sampler of a logger whether to log
+// an event before building it, and its loggers can not change their level once shared.
+type levelSampler struct {
+	levels    *Levels
+	component string
+}
+
+func (s levelSampler) Sample(level zerolog.Level) bool {
+	return level == zerolog.NoLevel || level >= s.levels.Level(s.component)
+}
+
+// LogLevels returns the effective level of every component and the default level, by their names
+func (l *Levels) LogLevels() map[string]string {
+	levels := make(map[string]string, len(l.components)+1)
+	levels[DefaultComponent] = l.Level(DefaultComponent).String()
+	for component := range l.components {
+		levels[component] = l.Level(component).String()
+	}
+	return levels
+}
+
+// SetLogLevels changes the levels of the given components by their names, an empty level makes a component follow
+// the default level again. Nothing changes if a component or a level is unknown.
+func (l *Levels) SetLogLevels(levels map[string]string) error {
+	parsed := make(map[string]zerolog.Level, len(levels))
+	for component, name := range levels {
+		if _, ok := l.components[component]; !ok && component != DefaultComponent {
+			return fmt.Errorf("unknown log component %q", component)
+		}
+		level := zerolog.NoLevel
+		if name != "" {
+			var err error
+			level, err = zerolog.ParseLevel(name)
+			if err != nil || level == zerolog.NoLevel {
+				return fmt.Errorf("invalid log level %q of component %q", name, component)
+			}
+		} else if component == DefaultComponent {
+			return fmt.Errorf("the %s level can not be unset", DefaultComponent)
+		}
+		parsed[component] = level
+	}
+	for component, level := range parsed {
+		_ = l.Set(component, level)
+	}
+	return nil
+}
@@ -0,0 +1,111 @@
+package logger
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/rs/zerolog"
+)
+
+func TestLevels_Logger(t *testing.T) {
+	out := &bytes.Buffer{}
+	base := New(LogConfig{ConsoleOut: out, ConsoleErr: &bytes.Buffer{}, Debug: true})
+	levels := NewLevels(false, "server", "redis")
+	logger := levels.Logger(base, DefaultComponent)
+	server := levels.Logger(base, "server")
+	redis := levels.Logger(base, "redis")
+
+	logger.Debug().Msg("hidden default msg")
+	server.Debug().Msg("hidden server msg")
+	if err := levels.Set("server", zerolog.DebugLevel); err != nil {
+		t.Fatal(err)
+	}
+	server.Debug().Msg("shown server msg")
+	redis.Debug().Msg("hidden redis msg")
+	if err := levels.Set(DefaultComponent, zerolog.DebugLevel); err != nil {
+		t.Fatal(err)
+	}
+	redis.Debug().Msg("shown redis msg")
+
+	if strings.Contains(out.String(), "hidden") {
+		t.Errorf("expected the events below the levels to be dropped, got '%s'", out.String())
+	}
+	assertContains(t, out, "DBG shown server msg component=server")
+	assertContains(t, out, "DBG shown redis msg component=redis")
+}
+
+func TestLevels_Logger_NotBuilt(t *testing.T) {
+	levels := NewLevels(false, "server")
+	base := New(LogConfig{ConsoleOut: &bytes.Buffer{}, ConsoleErr: &bytes.Buffer{}}).Level(zerolog.TraceLevel)
+	server := levels.Logger(base, "server")
+	derived := server.With().Str("request_id", "1").Logger()
+	// a nil event is one zerolog did not build
+	if event := derived.Debug(); event != nil {
+		t.Errorf("expected the debug event not to be built")
+	}
+	if err := levels.Set("server", zerolog.TraceLevel); err != nil {
+		t.Fatal(err)
+	}
+	if event := derived.Trace(); event == nil {
+		t.Errorf("expected the trace event to be built once the level changed")
+	}
+}
+
+func TestLevels_Set(t *testing.T) {
+	levels := NewLevels(true, "server", "cache")
+	if levels.Level("server") != zerolog.DebugLevel {
+		t.Errorf("expected server to follow the default level, got %s", levels.Level("server"))
+	}
+	if err := levels.Set("nope", zerolog.InfoLevel); err == nil {
+		t.Errorf("expected an error for an unknown component")
+	}
+	if err := levels.Set(DefaultComponent, zerolog.NoLevel); err == nil {
+		t.Errorf("expected an error unsetting the default level")
+	}
+	if err := levels.SetAll(map[string]zerolog.Level{"cache": zerolog.WarnLevel}); err != nil {
+		t.Fatal(err)
+	}
+	if levels.Level("cache") != zerolog.WarnLevel || levels.Level("server") != zerolog.DebugLevel {
+		t.Errorf("expected cache at warn and server at debug, got %s and %s", levels.Level("cache"), levels.Level("server"))
+	}
+	if err := levels.Set("cache", zerolog.NoLevel); err != nil {
+		t.Fatal(err)
+	}
+	if levels.Level("cache") != zerolog.DebugLevel {
+		t.Errorf("expected cache to follow the default level again, got %s", levels.Level("cache"))
+	}
+	if err := levels.SetAll(map[string]zerolog.Level{"nope": zerolog.WarnLevel}); err == nil {
+		t.Errorf("expected an error for an unknown component")
+	}
+}
+
+func TestLevels_SetLogLevels(t *testing.T) {
+	levels := NewLevels(false, "server", "redis")
+	if err := levels.SetLogLevels(map[string]string{"server": "debug", DefaultComponent: "warn"}); err != nil {
+		t.Fatal(err)
+	}
+	got := levels.LogLevels()
+	if got["server"] != "debug" || got["redis"] != "warn" || got[DefaultComponent] != "warn" {
+		t.Errorf("expected server at debug and the others at warn, got %v", got)
+	}
+	invalid := []map[string]string{
+		{"server": "info", "nope": "info"},
+		{"server": "loud"},
+		{DefaultComponent: ""},
+	}
+	for _, levelsByName := range invalid {
+		if err := levels.SetLogLevels(levelsByName); err == nil {
+			t.Errorf("expected an error setting %v", levelsByName)
+		}
+	}
+	if levels.Level("server") != zerolog.DebugLevel {
+		t.Errorf("expected invalid levels to change nothing, got server at %s", levels.Level("server"))
+	}
+	if err := levels.SetLogLevels(map[string]string{"server": ""}); err != nil {
+		t.Fatal(err)
+	}
+	if levels.Level("server") != zerolog.WarnLevel {
+		t.Errorf("expected server to follow the default level again, got %s", levels.Level("server"))
+	}
+}
@@ -1,17 +1,29 @@
 package logger
 
 import (
+	"fmt"
 	"github.com/rs/zerolog"
 	"io"
 	"os"
+	"strconv"
+	"strings"
 	"time"
 )
 
+// The formats of the logs
+const (
+	FormatConsole = "console"
+	FormatJSON    = "json"
+	FormatLogfmt  = "logfmt"
+)
+
 type LogConfig struct {
 	ConsoleOut io.Writer
 	ConsoleErr io.Writer
 	Debug      bool
 	UseColor   bool
+	// Format is one of FormatConsole, FormatJSON and FormatLogfmt, console if empty
+	Format string
 }
 
 func New(config LogConfig) zerolog.Logger {
@@ -28,13 +40,13 @@ func New(config LogConfig) zerolog.Logger {
 	}
 	writer := zerolog.MultiLevelWriter(
 		levelWriter{
-			Writer: zerolog.ConsoleWriter{Out: consoleOut, TimeFormat: time.RFC3339, NoColor: !config.UseColor},
+			Writer: formatWriter(consoleOut, config),
 			Levels: []zerolog.Level{
 				zerolog.DebugLevel, zerolog.InfoLevel, zerolog.WarnLevel,
 			},
 		},
 		levelWriter{
-			Writer: zerolog.ConsoleWriter{Out: consoleErr, TimeFormat: time.RFC3339, NoColor: !config.UseColor},
+			Writer: formatWriter(consoleErr, config),
 			Levels: []zerolog.Level{
 				zerolog.ErrorLevel, zerolog.FatalLevel, zerolog.PanicLevel,
 			},
@@ -47,6 +59,70 @@ func New(config LogConfig) zerolog.Logger {
 	return zerolog.New(writer).With().Timestamp().Logger().Level(level)
 }
 
+// formatWriter wraps out to write the events in the format of the config
+func formatWriter(out io.Writer, config LogConfig) io.Writer {
+	switch config.Format {
+	case FormatJSON:
+		return out
+	case FormatLogfmt:
+		return zerolog.ConsoleWriter{
+			Out:     out,
+			NoColor: true,
+			FormatTimestamp: func(i interface{}) string {
+				return "time=" + logfmtValue(i)
+			},
+			FormatLevel: func(i interface{}) string {
+				return "level=" + logfmtValue(i)
+			},
+			FormatMessage: func(i interface{}) string {
+				return "msg=" + logfmtValue(i)
+			},
+			FormatFieldName: func(i interface{}) string {
+				return fmt.Sprint(i) + "="
+			},
+			FormatFieldValue:    logfmtFieldValue,
+			FormatErrFieldName:  func(i interface{}) string { return fmt.Sprint(i) + "=" },
+			FormatErrFieldValue: logfmtFieldValue,
+		}
+	default:
+		return zerolog.ConsoleWriter{Out: out, TimeFormat: time.RFC3339, NoColor: !config.UseColor}
+	}
+}
+
+// logfmtValue quotes the value if it is empty or holds spaces, quotes or equal signs
+func logfmtValue(i interface{}) string {
+	s := fmt.Sprint(i)
+	if i == nil {
+		s = ""
+	}
+	if s == "" || strings.ContainsAny(s, " \t\n\"=") {
+		return strconv.Quote(s)
+	}
+	return s
+}
+
+// logfmtFieldValue writes the value of a field, which the console writer already quotes if needed, or encodes as JSON
+// if it is not a string or a number
+func logfmtFieldValue(i interface{}) string {
+	if b, ok := i.([]byte); ok {
+		return string(b)
+	}
+	return fmt.Sprint(i)
+}
+
+// SupportsColor reports whether out is a terminal and colors are not turned off by the NO_COLOR variable
+func SupportsColor(out io.Writer) bool {
+	if os.Getenv("NO_COLOR") != "" {
+		return false
+	}
+	file, ok := out.(*os.File)
+	if !ok {
+		return false
+	}
+	info, err := file.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
 type levelWriter struct {
 	io.Writer
 	Levels []zerolog.Level
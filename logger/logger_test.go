@@ -37,3 +37,30 @@ func assertContains(t *testing.T, got *bytes.Buffer, expected string) {
 		t.Errorf("expected '%s' to contain '%s'", gotStr, expected)
 	}
 }
+
+func TestNew_Formats(t *testing.T) {
+	tests := []struct {
+		format   string
+		expected []string
+	}{
+		{format: FormatConsole, expected: []string{"INF this is an info level msg key=value"}},
+		{format: FormatJSON, expected: []string{`"level":"info"`, `"key":"value"`, `"message":"this is an info level msg"`}},
+		{format: FormatLogfmt, expected: []string{`level=info msg="this is an info level msg" key=value list=[1] spaced="a b"`, "time="}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.format, func(t *testing.T) {
+			out := &bytes.Buffer{}
+			logger := New(LogConfig{ConsoleOut: out, ConsoleErr: &bytes.Buffer{}, Format: tt.format})
+			logger.Info().Str("key", "value").Str("spaced", "a b").Ints("list", []int{1}).Msg("this is an info level msg")
+			for _, expected := range tt.expected {
+				assertContains(t, out, expected)
+			}
+		})
+	}
+}
+
+func TestSupportsColor(t *testing.T) {
+	if SupportsColor(&bytes.Buffer{}) {
+		t.Errorf("expected a buffer not to support color")
+	}
+}
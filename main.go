@@ -1,28 +1,70 @@
 package main
 
 import (
+	"cache-api/audit"
+	"cache-api/auth"
 	"cache-api/cache"
+	"cache-api/cluster"
 	"cache-api/config"
 	logger2 "cache-api/logger"
+	"cache-api/ratelimit"
 	"cache-api/server"
+	"cache-api/tlsconfig"
 	"context"
+	"crypto/tls"
 	"errors"
 	"fmt"
 	"io"
+	"maps"
 	"net"
 	"net/http"
 	"os"
 	"os/signal"
+	"regexp"
+	"strconv"
 	"sync"
+	"syscall"
 	"time"
 
 	"github.com/joho/godotenv"
+	"github.com/rs/zerolog"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
 )
 
-func run(ctx context.Context, stdout io.Writer, stderr io.Writer) error {
+// configWatchInterval is how often the config file is checked for changes
+const configWatchInterval = 5 * time.Second
 
-	ctx, cancel := signal.NotifyContext(ctx, os.Interrupt)
+// logComponents are the parts of the server whose log levels can be set independently
+var logComponents = []string{"server", "cache", "redis", "access", "replication", "cluster", "invalidation"}
+
+// logLevels returns the log levels of the config, with the components it leaves out following the default level
+func logLevels(conf config.Config) map[string]string {
+	levels := make(map[string]string, len(logComponents))
+	for _, component := range logComponents {
+		levels[component] = ""
+	}
+	for component, level := range conf.Log.Levels {
+		levels[component] = level
+	}
+	return levels
+}
+
+// reconfigurable is implemented by the caches whose settings can change while they are running
+type reconfigurable interface {
+	Reconfigure(conf config.CacheConfig)
+}
+
+// run serves the cache configured by the environment variables prefixed by serviceName until ctx is done or an
+// interrupt or termination signal is received
+func run(ctx context.Context, serviceName string, stdout io.Writer, stderr io.Writer) error {
+
+	ctx, cancel := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
 	defer cancel()
+	// serveCtx is canceled once the server stopped serving rather than when the shutdown starts, so the caches and
+	// their background work keep going while the requests drain
+	serveCtx, stopServing := context.WithCancel(context.WithoutCancel(ctx))
+	defer stopServing()
 
 	// loading config
 	err := godotenv.Load()
@@ -31,43 +73,377 @@ func run(ctx context.Context, stdout io.Writer, stderr io.Writer) error {
 			return fmt.Errorf("error lodaing dotenv %w", err)
 		}
 	}
-	conf, err := config.New()
+	conf, err := config.NewWithName(serviceName)
 	if err != nil {
 		return fmt.Errorf("error lodaing config %w", err)
 	}
 
-	// creating logger
-	logger := logger2.New(logger2.LogConfig{
+	// creating loggers, the levels decide which events are written
+	base := logger2.New(logger2.LogConfig{
 		ConsoleOut: stdout,
 		ConsoleErr: stderr,
-		UseColor:   true,
-		Debug:      conf.Debug,
-	})
+		UseColor:   logger2.SupportsColor(stdout),
+		Format:     conf.Log.Format,
+	}).Level(zerolog.TraceLevel)
+	levels := logger2.NewLevels(conf.Debug, logComponents...)
+	if err := levels.SetLogLevels(logLevels(conf)); err != nil {
+		return fmt.Errorf("error setting log levels %w", err)
+	}
+	logger := levels.Logger(base, logger2.DefaultComponent)
+	serverLogger := levels.Logger(base, "server")
+	cacheLogger := levels.Logger(base, "cache")
+	redisLogger := levels.Logger(base, "redis")
+	logger.Info().Stringer("config", conf).Msg("config loaded")
 
 	var c server.Cache
+	var redisCache *cache.RedisCache
+	namespaces := make(map[string]server.Cache, len(conf.Namespaces))
+	// memoryCaches are the in-memory caches by the name they are replicated under
+	memoryCaches := make(map[string]*cache.Cache[string], len(conf.Namespaces)+1)
 	if conf.UseRedis {
 		logger.Info().Msg("using redis as the cache")
-		c, err = cache.NewRedisCache(ctx, &conf.Cache, &conf.RedisConfig, &logger)
+		redisCache, err = cache.NewRedisCache(serveCtx, &conf.Cache, &conf.RedisConfig, &redisLogger)
 		if err != nil {
 			logger.Error().Err(err).Msg("error creating redis cache")
 			return err
 		}
+		c = redisCache
+		for name, nsConf := range conf.Namespaces {
+			if nsConf.MaxSize != 0 || nsConf.EvictionIntervalMilliSec != 0 {
+				logger.Warn().Str("namespace", name).Msg("only the ttl of a namespace applies to the redis cache")
+			}
+			namespaces[name] = redisCache.Namespace(name, nsConf.WithDefaults(conf.Cache))
+		}
 	} else {
 		logger.Info().Msg("using in-memory cache")
-		c = cache.NewCache[string](ctx, conf.Cache)
+		memoryCache := cache.NewCache[string](serveCtx, conf.Cache)
+		memoryCache.SetLogger(&cacheLogger)
+		c = memoryCache
+		memoryCaches["default"] = memoryCache
+		for name, nsConf := range conf.Namespaces {
+			nsCache := cache.NewCache[string](serveCtx, nsConf.WithDefaults(conf.Cache))
+			nsLogger := cacheLogger.With().Str("namespace", name).Logger()
+			nsCache.SetLogger(&nsLogger)
+			namespaces[name] = nsCache
+			memoryCaches["ns/"+name] = nsCache
+		}
+	}
+	for name := range namespaces {
+		logger.Info().Str("namespace", name).Msg("serving namespace")
 	}
 
 	// creating server
-	srv := server.New(&logger, c)
+	limits := server.Limits{
+		MaxValueBytes: conf.Limits.MaxValueBytes,
+		MaxKeyLength:  conf.Limits.MaxKeyLength,
+		WatchBuffer:   conf.Limits.WatchBuffer,
+		WatchOrigins:  conf.Limits.WatchOriginList(),
+	}
+	if conf.Limits.KeyPattern != "" {
+		limits.KeyPattern, err = regexp.Compile("^(?:" + conf.Limits.KeyPattern + ")$")
+		if err != nil {
+			return fmt.Errorf("error compiling key pattern %w", err)
+		}
+	}
+	// the watch streams end when the http server starts shutting down, which would wait for them otherwise
+	streamsCtx, stopStreams := context.WithCancel(serveCtx)
+	defer stopStreams()
+	opts := []server.Option{server.WithNamespaces(namespaces), server.WithLimits(limits), server.WithLogLevels(levels),
+		server.WithStreamsContext(streamsCtx)}
+	// the http server, the replication and the cluster share the certificates, clientTLS being the config of the
+	// connections to the other instances
+	var serverTLS, clientTLS *tls.Config
+	if conf.TLS.CertFile != "" {
+		serverTLS, err = tlsconfig.New(serveCtx, conf.TLS, &serverLogger)
+		if err != nil {
+			logger.Error().Err(err).Msg("error creating tls config")
+			return err
+		}
+		clientTLS, err = tlsconfig.NewClient(serveCtx, conf.TLS, &serverLogger)
+		if err != nil {
+			logger.Error().Err(err).Msg("error creating client tls config")
+			return err
+		}
+	}
+	// validation ensures replication is only set up for the in-memory caches
+	replicationLogger := levels.Logger(base, "replication")
+	if conf.Replication.Listen != "" {
+		listener, err := net.Listen("tcp", conf.Replication.Listen)
+		if err != nil {
+			return fmt.Errorf("error listening for followers %w", err)
+		}
+		if serverTLS != nil {
+			listener = tls.NewListener(listener, serverTLS)
+		}
+		logger.Info().Str("addr", listener.Addr().String()).Msg("accepting replication followers")
+		primary := cache.NewPrimary(memoryCaches, conf.Replication, &replicationLogger)
+		go func() {
+			if err := primary.Serve(serveCtx, listener); err != nil {
+				logger.Error().Err(err).Msg("error serving replication followers")
+			}
+		}()
+	}
+	if conf.Replication.Primary != "" {
+		logger.Info().Str("primary", conf.Replication.Primary).Msg("following primary, writes are disabled")
+		for name, memoryCache := range memoryCaches {
+			go cache.NewFollower(memoryCache, name, conf.Replication, clientTLS, &replicationLogger).Run(serveCtx)
+		}
+		opts = append(opts, server.WithReadOnly())
+	}
+	// validation ensures the invalidations are only broadcast for the in-memory caches
+	if conf.Invalidation.Bus != "" {
+		invalidationLogger := levels.Logger(base, "invalidation")
+		var broadcaster cache.Broadcaster
+		if conf.Invalidation.Bus == "redis" {
+			redisBroadcaster, err := cache.NewRedisBroadcaster(serveCtx, &conf.RedisConfig, conf.Invalidation.Channel,
+				&invalidationLogger)
+			if err != nil {
+				logger.Error().Err(err).Msg("error creating redis broadcaster")
+				return err
+			}
+			defer func() {
+				if err := redisBroadcaster.Close(); err != nil {
+					logger.Error().Err(err).Msg("error closing redis broadcaster")
+				}
+			}()
+			broadcaster = redisBroadcaster
+		} else {
+			listener, err := net.Listen("tcp", conf.Invalidation.Listen)
+			if err != nil {
+				return fmt.Errorf("error listening for invalidations %w", err)
+			}
+			httpBroadcaster := cache.NewHTTPBroadcaster(serveCtx, conf.Invalidation.PeerList(), conf.Invalidation.Token,
+				conf.Invalidation.Buffer, &invalidationLogger)
+			go func() {
+				if err := httpBroadcaster.Serve(serveCtx, listener); err != nil {
+					logger.Error().Err(err).Msg("error receiving invalidations")
+				}
+			}()
+			broadcaster = httpBroadcaster
+		}
+		logger.Info().Str("bus", conf.Invalidation.Bus).Msg("broadcasting invalidations")
+		go cache.NewInvalidator(memoryCaches, broadcaster, conf.Invalidation.Buffer, &invalidationLogger).Run(serveCtx)
+	}
+	// validation ensures the store only backs the in-memory cache
+	var writeBehind *cache.WriteBehind[string]
+	// writeBehindDone is closed once the write-behind store stopped writing in the background
+	var writeBehindDone chan struct{}
+	if conf.Store.Backend != "" {
+		storeLogger := levels.Logger(base, "store")
+		memoryCache := memoryCaches["default"]
+		var loader cache.Loader[string]
+		var store cache.Store[string]
+		if conf.Store.Backend == "file" {
+			fileStore, err := cache.NewFileStore(conf.Store.Dir)
+			if err != nil {
+				logger.Error().Err(err).Msg("error creating file store")
+				return err
+			}
+			loader, store = fileStore, fileStore
+		} else {
+			origin := cache.NewHTTPOrigin(conf.Store.Origin,
+				time.Duration(conf.Store.TimeoutMilliSec)*time.Millisecond, conf.Limits.MaxValueBytes)
+			loader, store = origin, origin
+		}
+		if conf.Store.ReadThrough {
+			memoryCache.SetLoader(loader)
+		}
+		switch conf.Store.Write {
+		case "through":
+			memoryCache.SetStore(store)
+		case "behind":
+			writeBehind = cache.NewWriteBehind(memoryCache, store, cache.WriteBehindOptions{
+				Batch:    conf.Store.BatchSize,
+				Interval: time.Duration(conf.Store.FlushIntervalMilliSec) * time.Millisecond,
+				Buffer:   conf.Store.Buffer,
+			}, &storeLogger)
+			writeBehindDone = make(chan struct{})
+			go func() {
+				defer close(writeBehindDone)
+				writeBehind.Run(serveCtx)
+			}()
+		}
+		logger.Info().Str("backend", conf.Store.Backend).Bool("read_through", conf.Store.ReadThrough).
+			Str("write", conf.Store.Write).Msg("store enabled")
+	}
+	if conf.Audit.Enabled {
+		auditor, err := audit.New(conf.Audit, stdout, &logger)
+		if err != nil {
+			logger.Error().Err(err).Msg("error creating auditor")
+			return err
+		}
+		defer func() {
+			if err := auditor.Close(); err != nil {
+				logger.Error().Err(err).Msg("error closing audit file")
+			}
+		}()
+		logger.Info().Str("file", conf.Audit.File).Msg("audit log enabled")
+		opts = append(opts, server.WithAuditor(auditor))
+	}
+	var clusterNode *cluster.Cluster
+	// gossipDone is closed once the node announced it leaves the gossip
+	var gossipDone chan struct{}
+	if conf.Cluster.Enabled() {
+		clusterLogger := levels.Logger(base, "cluster")
+		clusterNode = cluster.New(conf.Cluster, clientTLS, conf.Limits.MaxValueBytes, &clusterLogger)
+		if conf.Cluster.DNS != "" {
+			// validation ensures the address has a port
+			host, port, _ := net.SplitHostPort(conf.Cluster.DNS)
+			interval := time.Duration(conf.Cluster.DNSIntervalSec) * time.Second
+			go clusterNode.WatchDNS(serveCtx, host, port, interval, net.DefaultResolver.LookupHost)
+		}
+		if conf.Cluster.GossipPort != 0 {
+			transport, err := cluster.ListenUDP(net.JoinHostPort(conf.Host, strconv.Itoa(conf.Cluster.GossipPort)))
+			if err != nil {
+				logger.Error().Err(err).Msg("error listening for gossip")
+				return err
+			}
+			// validation ensures the address has a port, the other nodes gossip with the advertised host
+			host, _, _ := net.SplitHostPort(conf.Cluster.Advertise)
+			gossip := cluster.NewGossip(conf.Cluster.Advertise,
+				net.JoinHostPort(host, strconv.Itoa(conf.Cluster.GossipPort)), transport, conf.Cluster.Token,
+				conf.Cluster.SeedList(), cluster.DefaultGossipTiming(), clusterNode.SetNodes, &clusterLogger)
+			clusterNode.SetGossip(gossip)
+			gossipDone = make(chan struct{})
+			go func() {
+				defer close(gossipDone)
+				gossip.Run(serveCtx)
+			}()
+		}
+		logger.Info().Str("advertise", conf.Cluster.Advertise).Strs("nodes", clusterNode.Nodes()).
+			Int("replication_factor", conf.Cluster.ReplicationFactor).Msg("cluster enabled")
+		opts = append(opts, server.WithCluster(clusterNode))
+	}
+	var srv http.Handler = server.New(&serverLogger, c, opts...)
+	cacheHandler := srv
+	if clusterNode != nil {
+		srv = clusterNode.Route(srv)
+	}
+	var policy *ratelimit.Policy
+	var limiter ratelimit.Limiter
+	if conf.RateLimit.Enabled {
+		limiter = ratelimit.NewLocalLimiter()
+		if conf.RateLimit.Distributed {
+			// validation ensures redis is used
+			limiter = redisCache
+		}
+		logger.Info().Bool("distributed", conf.RateLimit.Distributed).Msg("rate limiting enabled")
+		policy = ratelimit.NewPolicy(
+			ratelimit.Limit{Rate: conf.RateLimit.ReadRate, Burst: conf.RateLimit.ReadBurst},
+			ratelimit.Limit{Rate: conf.RateLimit.WriteRate, Burst: conf.RateLimit.WriteBurst})
+		srv = ratelimit.Middleware(srv, limiter, policy, &serverLogger)
+	}
+	// authentication wraps rate limiting, so clients are limited per principal once authenticated
+	if conf.Auth.Enabled {
+		authenticator, err := auth.New(conf.Auth)
+		if err != nil {
+			logger.Error().Err(err).Msg("error creating authenticator")
+			return err
+		}
+		logger.Info().Msg("authentication enabled")
+		srv = authenticator.Middleware(srv, &serverLogger)
+		// the addresses are limited before authentication, so invalid credentials can not flood the server
+		if policy != nil {
+			policy.SetIP(ratelimit.Limit{Rate: conf.RateLimit.IPRate, Burst: conf.RateLimit.IPBurst})
+			srv = ratelimit.IPMiddleware(srv, limiter, policy, &serverLogger)
+		}
+	}
+	// the requests of the other nodes skip authentication and rate limiting, their clients went through them already
+	if clusterNode != nil {
+		srv = clusterNode.Internal(srv, cacheHandler)
+	}
+	// the access log wraps authentication and rate limiting, so the requests they reject are logged as well
+	if conf.AccessLog.Enabled {
+		accessLogger := levels.Logger(base, "access")
+		srv = server.AccessLog(srv, &accessLogger, conf.AccessLog.SampleRate)
+	}
+	caches := map[string]server.Cache{"default": c}
+	for name, nsCache := range namespaces {
+		caches["ns/"+name] = nsCache
+	}
+	health := server.NewHealth(caches, &serverLogger)
+	srv = health.Handler(srv)
 	httpServer := &http.Server{
 		Addr:    net.JoinHostPort(conf.Host, conf.Port),
 		Handler: srv,
 	}
+	httpServer.RegisterOnShutdown(stopStreams)
+	if serverTLS != nil {
+		httpServer.TLSConfig = serverTLS
+		logger.Info().Bool("mtls", conf.TLS.ClientCAFile != "").Msg("tls enabled")
+	} else if conf.TLS.H2C {
+		logger.Info().Msg("h2c enabled")
+		httpServer.Handler = h2c.NewHandler(srv, &http2.Server{})
+	}
+
+	// reloading config, the settings that can not change while running are kept until the next restart
+	var reloadMutex sync.Mutex
+	current := conf
+	reload := func() {
+		reloadMutex.Lock()
+		defer reloadMutex.Unlock()
+		next, err := config.NewWithName(serviceName)
+		if err != nil {
+			logger.Error().Err(err).Msg("error reloading config")
+			return
+		}
+		reloaded, rejected := config.Reload(current, next)
+		for _, name := range rejected {
+			logger.Warn().Str("setting", name).Msg("setting can not change while running, restart to apply it")
+		}
+		if reloaded.Debug != current.Debug || !maps.Equal(reloaded.Log.Levels, current.Log.Levels) {
+			// levels changed at runtime are kept unless the config changes them
+			levels.SetDebug(reloaded.Debug)
+			if err := levels.SetLogLevels(logLevels(reloaded)); err != nil {
+				logger.Error().Err(err).Msg("error reloading log levels")
+			}
+		}
+		current = reloaded
+		if r, ok := c.(reconfigurable); ok {
+			r.Reconfigure(current.Cache)
+		}
+		for name, nsConf := range current.Namespaces {
+			if r, ok := namespaces[name].(reconfigurable); ok {
+				r.Reconfigure(nsConf.WithDefaults(current.Cache))
+			}
+		}
+		if policy != nil {
+			policy.Set(
+				ratelimit.Limit{Rate: current.RateLimit.ReadRate, Burst: current.RateLimit.ReadBurst},
+				ratelimit.Limit{Rate: current.RateLimit.WriteRate, Burst: current.RateLimit.WriteBurst})
+			if current.Auth.Enabled {
+				policy.SetIP(ratelimit.Limit{Rate: current.RateLimit.IPRate, Burst: current.RateLimit.IPBurst})
+			}
+		}
+		logger.Info().Msg("reloaded config")
+	}
+	hangup := make(chan os.Signal, 1)
+	signal.Notify(hangup, syscall.SIGHUP)
+	defer signal.Stop(hangup)
+	go func() {
+		for {
+			select {
+			case <-hangup:
+				reload()
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	if conf.ConfigFile != "" {
+		logger.Info().Str("file", conf.ConfigFile).Msg("watching config file")
+		go config.Watch(ctx, conf.ConfigFile, configWatchInterval, reload)
+	}
 
 	// start listening to server
 	go func() {
 		logger.Info().Msgf("listening on %s", httpServer.Addr)
-		if err := httpServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		listen := httpServer.ListenAndServe
+		if httpServer.TLSConfig != nil {
+			// the certificate is served by TLSConfig.GetCertificate
+			listen = func() error { return httpServer.ListenAndServeTLS("", "") }
+		}
+		if err := listen(); err != nil && err != http.ErrServerClosed {
 			logger.Error().Err(err).Msg("error listening and serving")
 			cancel()
 		}
@@ -81,12 +457,28 @@ func run(ctx context.Context, stdout io.Writer, stderr io.Writer) error {
 		<-ctx.Done()
 		// make a new context for the Shutdown (thanks Alessandro Rosetti)
 		logger.Info().Msg("shutting down")
+		health.StartShutdown()
+		if conf.ShutdownDelaySec > 0 {
+			// keep serving while load balancers notice the failing readiness and drain traffic
+			time.Sleep(time.Duration(conf.ShutdownDelaySec) * time.Second)
+		}
 		shutdownCtx := context.Background()
 		shutdownCtx, cancel := context.WithTimeout(shutdownCtx, 10*time.Second)
 		defer cancel()
 		if err := httpServer.Shutdown(shutdownCtx); err != nil {
 			logger.Error().Err(err).Msg("error shutting down http server")
 		}
+		stopServing()
+		if gossipDone != nil {
+			<-gossipDone
+		}
+		// the writes waiting for the store are written once no request can add more
+		if writeBehind != nil {
+			<-writeBehindDone
+			if err := writeBehind.Flush(shutdownCtx); err != nil {
+				logger.Error().Err(err).Msg("error flushing writes to the store")
+			}
+		}
 	}()
 	wg.Wait()
 	return nil
@@ -94,7 +486,13 @@ func run(ctx context.Context, stdout io.Writer, stderr io.Writer) error {
 
 func main() {
 	ctx := context.Background()
-	if err := run(ctx, os.Stdout, os.Stderr); err != nil {
+	var err error
+	if len(os.Args) > 1 && os.Args[1] == "config" {
+		err = runConfig(os.Args[2:], os.Stdout)
+	} else {
+		err = run(ctx, os.Getenv("SERVICE_NAME"), os.Stdout, os.Stderr)
+	}
+	if err != nil {
 		_, _ = fmt.Fprintf(os.Stderr, "%s\n", err)
 		os.Exit(1)
 	}
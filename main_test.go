@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+// freePort returns a loopback port nothing listens on
+func freePort(t *testing.T) string {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	_, port, _ := net.SplitHostPort(listener.Addr().String())
+	return port
+}
+
+// waitReady polls the readiness probe of the url until it answers
+func waitReady(t *testing.T, url string) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		resp, err := http.Get(url + "/readyz")
+		if err == nil {
+			_ = resp.Body.Close()
+			if resp.StatusCode == http.StatusOK {
+				return
+			}
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Timed out waiting for %s to be ready", url)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestRun_Cluster(t *testing.T) {
+	const nodes = 3
+	ports := make([]string, nodes)
+	peers := make([]string, nodes)
+	for i := range ports {
+		ports[i] = freePort(t)
+		peers[i] = "127.0.0.1:" + ports[i]
+	}
+	// the settings shared by the nodes are not prefixed, the ones of each node are prefixed by its service name
+	t.Setenv("CLUSTER_PEERS", strings.Join(peers, ","))
+	t.Setenv("CLUSTER_TOKEN", "secret")
+	t.Setenv("CLUSTER_REPLICATION_FACTOR", "2")
+	t.Setenv("HOST", "127.0.0.1")
+	ctx, cancel := context.WithCancel(context.Background())
+	errs := make(chan error, nodes)
+	for i := range ports {
+		name := fmt.Sprintf("node%d", i)
+		t.Setenv(strings.ToUpper(name)+"_PORT", ports[i])
+		t.Setenv(strings.ToUpper(name)+"_CLUSTER_CLUSTER_ADVERTISE", peers[i])
+		go func() { errs <- run(ctx, name, io.Discard, io.Discard) }()
+	}
+	defer func() {
+		cancel()
+		for range ports {
+			if err := <-errs; err != nil {
+				t.Errorf("Expected the node to stop without error, got %v", err)
+			}
+		}
+	}()
+	for _, peer := range peers {
+		waitReady(t, "http://"+peer)
+	}
+
+	for i := 0; i < 10; i++ {
+		key := fmt.Sprintf("key-%d", i)
+		resp, err := http.Post("http://"+peers[i%nodes]+"/"+key, "text/plain", strings.NewReader("value-"+key))
+		if err != nil {
+			t.Fatal(err)
+		}
+		_ = resp.Body.Close()
+		if resp.StatusCode != http.StatusCreated {
+			t.Fatalf("Expected status code %d, got %d", http.StatusCreated, resp.StatusCode)
+		}
+	}
+	for i := 0; i < 10; i++ {
+		key := fmt.Sprintf("key-%d", i)
+		for _, peer := range peers {
+			resp, err := http.Get("http://" + peer + "/" + key)
+			if err != nil {
+				t.Fatal(err)
+			}
+			body, _ := io.ReadAll(resp.Body)
+			_ = resp.Body.Close()
+			if resp.StatusCode != http.StatusOK || string(body) != "value-"+key {
+				t.Errorf("Expected %s to read %q, got %d %q", peer, "value-"+key, resp.StatusCode, body)
+			}
+		}
+	}
+}
@@ -0,0 +1,184 @@
+package ratelimit
+
+import (
+	"cache-api/auth"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+const (
+	headerRetryAfter = "Retry-After"
+
+	errTooManyRequestsResponse = "Too Many Requests"
+
+	// sweepInterval is how often the local limiter forgets the buckets that refilled completely
+	sweepInterval = time.Minute
+)
+
+// Limit is a token bucket refilling Rate tokens per second up to Burst tokens. Every request takes one token.
+type Limit struct {
+	Rate  float64
+	Burst int
+}
+
+// Policy holds the read and write limits and the limit of the IP addresses, which can be changed while requests are
+// served
+type Policy struct {
+	limits atomic.Pointer[[2]Limit]
+	ip     atomic.Pointer[Limit]
+}
+
+// NewPolicy returns a policy with the given read and write limits
+func NewPolicy(read Limit, write Limit) *Policy {
+	p := &Policy{}
+	p.Set(read, write)
+	return p
+}
+
+// Set replaces the read and write limits. The buckets keep their tokens, so the new limits apply as they refill.
+func (p *Policy) Set(read Limit, write Limit) {
+	p.limits.Store(&[2]Limit{read, write})
+}
+
+// Limits returns the read and write limits
+func (p *Policy) Limits() (read Limit, write Limit) {
+	limits := p.limits.Load()
+	return limits[0], limits[1]
+}
+
+// SetIP replaces the limit of every IP address applied by IPMiddleware, a zero rate turns it off
+func (p *Policy) SetIP(limit Limit) {
+	p.ip.Store(&limit)
+}
+
+// IP returns the limit of every IP address, zero if it was not set
+func (p *Policy) IP() Limit {
+	if limit := p.ip.Load(); limit != nil {
+		return *limit
+	}
+	return Limit{}
+}
+
+// Limiter takes a token from the bucket of key, and returns how long to wait for the next token if there is none
+type Limiter interface {
+	Allow(key string, limit Limit) (allowed bool, retryAfter time.Duration, err error)
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// LocalLimiter keeps the buckets in memory, so its limits apply to a single instance
+type LocalLimiter struct {
+	mutex     sync.Mutex
+	buckets   map[string]*bucket
+	lastSweep time.Time
+	now       func() time.Time
+}
+
+// NewLocalLimiter creates a limiter keeping its buckets in memory
+func NewLocalLimiter() *LocalLimiter {
+	return &LocalLimiter{
+		buckets: make(map[string]*bucket),
+		now:     time.Now,
+	}
+}
+
+func (l *LocalLimiter) Allow(key string, limit Limit) (bool, time.Duration, error) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	now := l.now()
+	if now.Sub(l.lastSweep) > sweepInterval {
+		l.sweep(now, limit)
+	}
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{tokens: float64(limit.Burst), last: now}
+		l.buckets[key] = b
+	}
+	b.tokens = math.Min(float64(limit.Burst), b.tokens+now.Sub(b.last).Seconds()*limit.Rate)
+	b.last = now
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0, nil
+	}
+	return false, time.Duration((1 - b.tokens) / limit.Rate * float64(time.Second)), nil
+}
+
+// sweep drops the buckets that would be full by now, as a new bucket starts full anyway. Buckets of all limits share
+// the map, so the slowest refill of the given limit is an approximation that only delays forgetting some buckets.
+func (l *LocalLimiter) sweep(now time.Time, limit Limit) {
+	for key, b := range l.buckets {
+		if b.tokens+now.Sub(b.last).Seconds()*limit.Rate >= float64(limit.Burst) {
+			delete(l.buckets, key)
+		}
+	}
+	l.lastSweep = now
+}
+
+// Middleware limits the requests of every client to the read limit for GET and HEAD requests and to the write limit
+// for the others. Clients are identified by their principal if the request is authenticated, otherwise by their IP.
+// Requests over the limit get 429 with a Retry-After header. If the limiter fails, the request is let through.
+func Middleware(next http.Handler, limiter Limiter, policy *Policy, logger *zerolog.Logger) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		read, write := policy.Limits()
+		limit, kind := write, "write"
+		if r.Method == http.MethodGet || r.Method == http.MethodHead {
+			limit, kind = read, "read"
+		}
+		if allow(w, limiter, kind, clientKey(r), limit, logger) {
+			next.ServeHTTP(w, r)
+		}
+	})
+}
+
+// IPMiddleware limits the requests of every IP address to the IP limit of the policy, whatever their credentials.
+// It goes in front of authentication, so the clients sending invalid credentials are limited as well.
+func IPMiddleware(next http.Handler, limiter Limiter, policy *Policy, logger *zerolog.Logger) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if limit := policy.IP(); limit.Rate <= 0 || allow(w, limiter, "any", "ip:"+remoteIP(r), limit, logger) {
+			next.ServeHTTP(w, r)
+		}
+	})
+}
+
+// allow takes a token from the bucket of the client for the kind of requests, or writes a 429 response and returns
+// false if there is none
+func allow(w http.ResponseWriter, limiter Limiter, kind string, client string, limit Limit,
+	logger *zerolog.Logger) bool {
+	allowed, retryAfter, err := limiter.Allow(kind+":"+client, limit)
+	if err != nil {
+		logger.Error().Err(err).Str("client", client).Msg("error checking rate limit")
+		return true
+	}
+	if !allowed {
+		logger.Debug().Str("client", client).Str("kind", kind).Dur("retry_after", retryAfter).Msg("rate limited")
+		w.Header().Set(headerRetryAfter, strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+		http.Error(w, errTooManyRequestsResponse, http.StatusTooManyRequests)
+		return false
+	}
+	return true
+}
+
+func clientKey(r *http.Request) string {
+	if principal, ok := auth.PrincipalFrom(r.Context()); ok {
+		return "principal:" + principal.Name
+	}
+	return "ip:" + remoteIP(r)
+}
+
+func remoteIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
@@ -0,0 +1,159 @@
+package ratelimit
+
+import (
+	"cache-api/auth"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+func TestLocalLimiter_Allow(t *testing.T) {
+	t.Parallel()
+	now := time.Unix(1_700_000_000, 0)
+	limiter := NewLocalLimiter()
+	limiter.now = func() time.Time { return now }
+	limit := Limit{Rate: 2, Burst: 3}
+
+	for i := 0; i < 3; i++ {
+		if allowed, _, _ := limiter.Allow("client", limit); !allowed {
+			t.Fatalf("Expected request %d of the burst to be allowed", i)
+		}
+	}
+	allowed, retryAfter, _ := limiter.Allow("client", limit)
+	if allowed {
+		t.Fatal("Expected the request after the burst to be limited")
+	}
+	if retryAfter != 500*time.Millisecond {
+		t.Errorf("Expected to retry after 500ms, got %s", retryAfter)
+	}
+	if allowed, _, _ := limiter.Allow("other", limit); !allowed {
+		t.Error("Expected another client to have its own bucket")
+	}
+
+	now = now.Add(500 * time.Millisecond)
+	if allowed, _, _ := limiter.Allow("client", limit); !allowed {
+		t.Error("Expected a token to be refilled")
+	}
+	if allowed, _, _ := limiter.Allow("client", limit); allowed {
+		t.Error("Expected a single token to be refilled")
+	}
+
+	now = now.Add(2 * sweepInterval)
+	limiter.Allow("client", limit)
+	if _, ok := limiter.buckets["other"]; ok {
+		t.Error("Expected the full bucket to be swept")
+	}
+}
+
+type mockLimiter struct {
+	Allowed    bool
+	RetryAfter time.Duration
+	Err        error
+	Keys       []string
+	Limits     []Limit
+}
+
+func (m *mockLimiter) Allow(key string, limit Limit) (bool, time.Duration, error) {
+	m.Keys = append(m.Keys, key)
+	m.Limits = append(m.Limits, limit)
+	return m.Allowed, m.RetryAfter, m.Err
+}
+
+func TestMiddleware(t *testing.T) {
+	t.Parallel()
+	read := Limit{Rate: 10, Burst: 20}
+	write := Limit{Rate: 1, Burst: 2}
+	logger := zerolog.Nop()
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+
+	t.Run("read by ip", func(t *testing.T) {
+		limiter := &mockLimiter{Allowed: true}
+		req := httptest.NewRequest(http.MethodGet, "/key", nil)
+		req.RemoteAddr = "10.0.0.1:1234"
+		responseRecorder := httptest.NewRecorder()
+		Middleware(next, limiter, NewPolicy(read, write), &logger).ServeHTTP(responseRecorder, req)
+		if responseRecorder.Code != http.StatusOK {
+			t.Fatalf("Expected status code %d, got %d", http.StatusOK, responseRecorder.Code)
+		}
+		if limiter.Keys[0] != "read:ip:10.0.0.1" || limiter.Limits[0] != read {
+			t.Errorf("Expected the read limit of the ip, got %s %+v", limiter.Keys[0], limiter.Limits[0])
+		}
+	})
+
+	t.Run("write by principal", func(t *testing.T) {
+		limiter := &mockLimiter{Allowed: true}
+		req := httptest.NewRequest(http.MethodPost, "/key", nil)
+		req = req.WithContext(auth.WithPrincipal(req.Context(), &auth.Principal{Name: "alice"}))
+		Middleware(next, limiter, NewPolicy(read, write), &logger).ServeHTTP(httptest.NewRecorder(), req)
+		if limiter.Keys[0] != "write:principal:alice" || limiter.Limits[0] != write {
+			t.Errorf("Expected the write limit of the principal, got %s %+v", limiter.Keys[0], limiter.Limits[0])
+		}
+	})
+
+	t.Run("limited", func(t *testing.T) {
+		limiter := &mockLimiter{RetryAfter: 1500 * time.Millisecond}
+		req := httptest.NewRequest(http.MethodPost, "/key", nil)
+		responseRecorder := httptest.NewRecorder()
+		Middleware(next, limiter, NewPolicy(read, write), &logger).ServeHTTP(responseRecorder, req)
+		if responseRecorder.Code != http.StatusTooManyRequests {
+			t.Fatalf("Expected status code %d, got %d", http.StatusTooManyRequests, responseRecorder.Code)
+		}
+		if responseRecorder.Header().Get(headerRetryAfter) != "2" {
+			t.Errorf("Expected Retry-After to be rounded up to 2, got %s", responseRecorder.Header().Get(headerRetryAfter))
+		}
+	})
+
+	t.Run("policy change", func(t *testing.T) {
+		limiter := &mockLimiter{Allowed: true}
+		policy := NewPolicy(read, write)
+		handler := Middleware(next, limiter, policy, &logger)
+		changed := Limit{Rate: 5, Burst: 5}
+		policy.Set(changed, write)
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/key", nil))
+		if limiter.Limits[0] != changed {
+			t.Errorf("Expected the changed read limit %+v, got %+v", changed, limiter.Limits[0])
+		}
+	})
+
+	t.Run("ip before authentication", func(t *testing.T) {
+		limiter := &mockLimiter{}
+		policy := NewPolicy(read, write)
+		ip := Limit{Rate: 50, Burst: 100}
+		policy.SetIP(ip)
+		req := httptest.NewRequest(http.MethodGet, "/key", nil)
+		req.RemoteAddr = "10.0.0.1:1234"
+		responseRecorder := httptest.NewRecorder()
+		IPMiddleware(next, limiter, policy, &logger).ServeHTTP(responseRecorder, req)
+		if responseRecorder.Code != http.StatusTooManyRequests {
+			t.Fatalf("Expected status code %d, got %d", http.StatusTooManyRequests, responseRecorder.Code)
+		}
+		if limiter.Keys[0] != "any:ip:10.0.0.1" || limiter.Limits[0] != ip {
+			t.Errorf("Expected the limit of the ip, got %s %+v", limiter.Keys[0], limiter.Limits[0])
+		}
+	})
+
+	t.Run("ip limit off", func(t *testing.T) {
+		limiter := &mockLimiter{}
+		responseRecorder := httptest.NewRecorder()
+		IPMiddleware(next, limiter, NewPolicy(read, write), &logger).ServeHTTP(responseRecorder,
+			httptest.NewRequest(http.MethodGet, "/key", nil))
+		if responseRecorder.Code != http.StatusOK || len(limiter.Keys) != 0 {
+			t.Errorf("Expected the request to be let through without a limit, got %d %v", responseRecorder.Code,
+				limiter.Keys)
+		}
+	})
+
+	t.Run("limiter error", func(t *testing.T) {
+		limiter := &mockLimiter{Err: errors.New("redis is down")}
+		req := httptest.NewRequest(http.MethodGet, "/key", nil)
+		responseRecorder := httptest.NewRecorder()
+		Middleware(next, limiter, NewPolicy(read, write), &logger).ServeHTTP(responseRecorder, req)
+		if responseRecorder.Code != http.StatusOK {
+			t.Errorf("Expected the request to be let through, got %d", responseRecorder.Code)
+		}
+	})
+}
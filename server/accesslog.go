@@ -0,0 +1,192 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	crand "crypto/rand"
+	"encoding/hex"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+const headerRequestID = "X-Request-ID"
+
+// requestIDRegex limits the request IDs taken from clients to what is safe to log and echo back
+var requestIDRegex = regexp.MustCompile(`^[A-Za-z0-9._:-]{1,128}$`)
+
+type requestInfoKey struct{}
+
+// requestInfo collects what the handlers learn about a request for its access log line
+type requestInfo struct {
+	id        string
+	route     string
+	namespace string
+	// cache is hit or miss for the reads of a key
+	cache string
+}
+
+// RequestIDFrom returns the ID the access log assigned to the request of ctx
+func RequestIDFrom(ctx context.Context) (string, bool) {
+	info, ok := ctx.Value(requestInfoKey{}).(*requestInfo)
+	if !ok {
+		return "", false
+	}
+	return info.id, true
+}
+
+func requestInfoFrom(r *http.Request) *requestInfo {
+	info, _ := r.Context().Value(requestInfoKey{}).(*requestInfo)
+	return info
+}
+
+// requestLogger returns logger with the ID of the request, so the events of a handler can be correlated with the
+// access log line of its request
+func requestLogger(r *http.Request, logger *zerolog.Logger) *zerolog.Logger {
+	id, ok := RequestIDFrom(r.Context())
+	if !ok {
+		return logger
+	}
+	l := logger.With().Str("request_id", id).Logger()
+	return &l
+}
+
+// recordRoute keeps the pattern of mux matching the request for the access log. Namespaces are served by a mux of
+// their own, so the innermost route is kept.
+func recordRoute(mux *http.ServeMux) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if info := requestInfoFrom(r); info != nil {
+			_, pattern := mux.Handler(r)
+			// patterns start with the method if they have one, which is logged on its own
+			if _, route, ok := strings.Cut(pattern, " "); ok {
+				pattern = route
+			}
+			info.route = pattern
+		}
+		mux.ServeHTTP(w, r)
+	})
+}
+
+// recordNamespace keeps the namespace of the request for the access log
+func recordNamespace(r *http.Request, namespace string) {
+	if info := requestInfoFrom(r); info != nil {
+		info.namespace = namespace
+	}
+}
+
+// recordCache keeps whether the key read by the request was found for the access log
+func recordCache(r *http.Request, hit bool) {
+	if info := requestInfoFrom(r); info != nil {
+		info.cache = "miss"
+		if hit {
+			info.cache = "hit"
+		}
+	}
+}
+
+// AccessLog assigns every request an ID, or keeps the one of its X-Request-ID header, which is echoed in the
+// response. The context of the request holds a logger with the ID, see zerolog.Ctx. Once served, the request is
+// logged at Info level with its method, route, status, response size, latency, cache hit or miss and client.
+// Successful requests are logged with the probability sampleRate, failed ones always are.
+func AccessLog(next http.Handler, logger *zerolog.Logger, sampleRate float64) http.Handler {
+	return accessLog(next, logger, func() bool { return sampleRate >= 1 || rand.Float64() < sampleRate })
+}
+
+func accessLog(next http.Handler, logger *zerolog.Logger, sample func() bool) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		id := r.Header.Get(headerRequestID)
+		if !requestIDRegex.MatchString(id) {
+			id = newRequestID()
+		}
+		w.Header().Set(headerRequestID, id)
+		info := &requestInfo{id: id}
+		scoped := logger.With().Str("request_id", id).Logger()
+		ctx := context.WithValue(scoped.WithContext(r.Context()), requestInfoKey{}, info)
+		recorder := &responseRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(recorder, r.WithContext(ctx))
+
+		if recorder.status < http.StatusBadRequest && !sample() {
+			return
+		}
+		client, _, err := net.SplitHostPort(r.RemoteAddr)
+		if err != nil {
+			client = r.RemoteAddr
+		}
+		event := scoped.Info().
+			Str("method", r.Method).
+			Str("route", info.route).
+			Int("status", recorder.status).
+			Int64("bytes", recorder.bytes).
+			Dur("latency", time.Since(start)).
+			Str("client", client)
+		if info.namespace != "" {
+			event = event.Str("namespace", info.namespace)
+		}
+		if info.cache != "" {
+			event = event.Str("cache", info.cache)
+		}
+		event.Msg("Request served")
+	})
+}
+
+func newRequestID() string {
+	b := make([]byte, 16)
+	_, _ = crand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// responseRecorder keeps the status and the size of the response for the access log
+type responseRecorder struct {
+	http.ResponseWriter
+	status      int
+	bytes       int64
+	wroteHeader bool
+}
+
+func (r *responseRecorder) WriteHeader(status int) {
+	if !r.wroteHeader {
+		r.status = status
+		r.wroteHeader = true
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *responseRecorder) Write(p []byte) (int, error) {
+	r.wroteHeader = true
+	n, err := r.ResponseWriter.Write(p)
+	r.bytes += int64(n)
+	return n, err
+}
+
+// Flush lets streaming handlers flush through the recorder
+func (r *responseRecorder) Flush() {
+	if flusher, ok := r.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Hijack lets WebSocket handlers take over the connection through the recorder, whose response is then the switch of
+// protocols
+func (r *responseRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := r.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, http.ErrNotSupported
+	}
+	conn, rw, err := hijacker.Hijack()
+	if err == nil && !r.wroteHeader {
+		r.status = http.StatusSwitchingProtocols
+		r.wroteHeader = true
+	}
+	return conn, rw, err
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer
+func (r *responseRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
@@ -0,0 +1,157 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/rs/zerolog"
+	"golang.org/x/net/websocket"
+)
+
+type accessLine struct {
+	Level     string `json:"level"`
+	RequestID string `json:"request_id"`
+	Method    string `json:"method"`
+	Route     string `json:"route"`
+	Status    int    `json:"status"`
+	Bytes     int64  `json:"bytes"`
+	Client    string `json:"client"`
+	Namespace string `json:"namespace"`
+	Cache     string `json:"cache"`
+}
+
+func TestAccessLog(t *testing.T) {
+	t.Parallel()
+	nop := zerolog.Nop()
+	handler := New(&nop, &mockCache{Hit: true, GetValue: "value"},
+		WithNamespaces(map[string]Cache{"team-a": &mockCache{}}))
+
+	tests := []struct {
+		name          string
+		method        string
+		target        string
+		requestID     string
+		sample        bool
+		want          *accessLine
+		wantRequestID string
+	}{
+		{
+			name: "hit", method: http.MethodGet, target: "/key", sample: true,
+			want: &accessLine{Level: "info", Method: http.MethodGet, Route: "/{key}", Status: http.StatusOK, Bytes: 5, Client: "192.0.2.1", Cache: "hit"},
+		},
+		{
+			name: "namespace miss", method: http.MethodGet, target: "/ns/team-a/key", sample: true,
+			want: &accessLine{Level: "info", Method: http.MethodGet, Route: "/{key}", Status: http.StatusNotFound, Bytes: 14, Client: "192.0.2.1", Namespace: "team-a", Cache: "miss"},
+		},
+		{
+			name: "propagated request id", method: http.MethodGet, target: "/key", requestID: "abc-123", sample: true,
+			want:          &accessLine{Level: "info", RequestID: "abc-123", Method: http.MethodGet, Route: "/{key}", Status: http.StatusOK, Bytes: 5, Client: "192.0.2.1", Cache: "hit"},
+			wantRequestID: "abc-123",
+		},
+		{
+			name: "invalid request id", method: http.MethodGet, target: "/key", requestID: "bad id\n", sample: true,
+			want: &accessLine{Level: "info", Method: http.MethodGet, Route: "/{key}", Status: http.StatusOK, Bytes: 5, Client: "192.0.2.1", Cache: "hit"},
+		},
+		{name: "sampled out", method: http.MethodGet, target: "/key"},
+		{
+			name: "failure is always logged", method: http.MethodGet, target: "/ns/nope/key",
+			want: &accessLine{Level: "info", Method: http.MethodGet, Route: "/ns/{namespace}/", Status: http.StatusNotFound, Bytes: 19, Client: "192.0.2.1"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out := &bytes.Buffer{}
+			logger := zerolog.New(out)
+			req := httptest.NewRequest(tt.method, tt.target, nil)
+			if tt.requestID != "" {
+				req.Header.Set(headerRequestID, tt.requestID)
+			}
+			responseRecorder := httptest.NewRecorder()
+			accessLog(handler, &logger, func() bool { return tt.sample }).ServeHTTP(responseRecorder, req)
+
+			requestID := responseRecorder.Header().Get(headerRequestID)
+			if requestID == "" || (tt.wantRequestID != "" && requestID != tt.wantRequestID) {
+				t.Errorf("Expected the request id %q in the response, got %q", tt.wantRequestID, requestID)
+			}
+			if tt.want == nil {
+				if out.Len() != 0 {
+					t.Errorf("Expected no access log line, got %s", out)
+				}
+				return
+			}
+			var got accessLine
+			if err := json.Unmarshal(out.Bytes(), &got); err != nil {
+				t.Fatalf("Expected one access log line, got %s: %v", out, err)
+			}
+			if got.RequestID != requestID {
+				t.Errorf("Expected the request id %s in the access log, got %s", requestID, got.RequestID)
+			}
+			tt.want.RequestID = requestID
+			if got != *tt.want {
+				t.Errorf("Expected %+v, got %+v", *tt.want, got)
+			}
+		})
+	}
+}
+
+func TestAccessLog_RequestScope(t *testing.T) {
+	t.Parallel()
+	out := &bytes.Buffer{}
+	logger := zerolog.New(out)
+	var gotID string
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotID, _ = RequestIDFrom(r.Context())
+		zerolog.Ctx(r.Context()).Warn().Msg("from the handler")
+	})
+	req := httptest.NewRequest(http.MethodGet, "/key", nil)
+	req.Header.Set(headerRequestID, "req-1")
+	AccessLog(next, &logger, 0).ServeHTTP(httptest.NewRecorder(), req)
+	if gotID != "req-1" {
+		t.Errorf("Expected the request id in the context, got %q", gotID)
+	}
+	var line accessLine
+	if err := json.NewDecoder(out).Decode(&line); err != nil {
+		t.Fatal(err)
+	}
+	if line.RequestID != "req-1" {
+		t.Errorf("Expected the logger of the context to carry the request id, got %+v", line)
+	}
+}
+
+// lineWriter sends every log line it is written to a channel
+type lineWriter chan []byte
+
+func (w lineWriter) Write(p []byte) (int, error) {
+	w <- append([]byte(nil), p...)
+	return len(p), nil
+}
+
+func TestAccessLog_WebSocket(t *testing.T) {
+	t.Parallel()
+	lines := make(lineWriter, 1)
+	logger := zerolog.New(lines)
+	nop := zerolog.Nop()
+	cache := &mockWatchCache{events: watchedEvents[:1], opts: make(chan WatchOptions, 1)}
+	server := httptest.NewServer(AccessLog(New(&nop, cache), &logger, 1))
+	defer server.Close()
+	conn, err := websocket.Dial("ws"+strings.TrimPrefix(server.URL, "http")+"/_watch", "", server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var event Event
+	if err := websocket.JSON.Receive(conn, &event); err != nil {
+		t.Fatal(err)
+	}
+	_ = conn.Close()
+	var line accessLine
+	if err := json.Unmarshal(<-lines, &line); err != nil {
+		t.Fatal(err)
+	}
+	if line.Route != "/_watch" || line.Status != http.StatusSwitchingProtocols {
+		t.Errorf("Expected a switch of protocols on /_watch, got %+v", line)
+	}
+}
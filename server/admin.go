@@ -0,0 +1,187 @@
+package server
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+// KeyInspector is implemented by caches that can report the metadata of a key
+type KeyInspector interface {
+	// Inspect returns the metadata of key and whether it was found
+	Inspect(key string) (KeyMetadata, bool, error)
+}
+
+// KeyMetadata describes a stored key. Fields a backend does not know are left zero.
+type KeyMetadata struct {
+	// TTL is the remaining time to live, -1 if the key does not expire
+	TTL     time.Duration
+	Size    int
+	Version uint64
+	Created time.Time
+	Tags    []string
+}
+
+// Flusher is implemented by caches that can remove all their keys at once
+type Flusher interface {
+	Flush() (int, error)
+}
+
+// ExpiredDeleter is implemented by caches that remove expired keys themselves rather than relying on the backend
+type ExpiredDeleter interface {
+	DeleteExpired() int
+}
+
+// EvictionRunner is implemented by caches whose background removal of expired keys can be stopped and restarted
+type EvictionRunner interface {
+	StopEviction()
+	StartEviction()
+	IsEvictionRunning() bool
+}
+
+type statsResponse struct {
+	Keys               int        `json:"keys"`
+	Bytes              int64      `json:"bytes"`
+	Hits               uint64     `json:"hits"`
+	Misses             uint64     `json:"misses"`
+	Evictions          uint64     `json:"evictions"`
+	Oldest             *time.Time `json:"oldest,omitempty"`
+	TTLSec             int64      `json:"ttl_seconds"`
+	MaxSize            int        `json:"max_size"`
+	EvictionIntervalMs int64      `json:"eviction_interval_ms"`
+}
+
+type keyMetadataResponse struct {
+	Key     string     `json:"key"`
+	TTLMs   int64      `json:"ttl_ms"`
+	Size    int        `json:"size"`
+	Version uint64     `json:"version,omitempty"`
+	Created *time.Time `json:"created,omitempty"`
+	Tags    []string   `json:"tags,omitempty"`
+}
+
+type evictionResponse struct {
+	Running bool `json:"running"`
+}
+
+// registerAdmin adds the `/_admin` routes the cache supports to the mux, leaving out the ones removing keys if
+// readOnly is set
+func registerAdmin(mux *http.ServeMux, cache Cache, auditor Auditor, readOnly bool, logger *zerolog.Logger) {
+	if provider, ok := cache.(StatsProvider); ok {
+		mux.HandleFunc("GET /_admin/stats", adminStats(provider, logger))
+	}
+	if flusher, ok := cache.(Flusher); ok && !readOnly {
+		mux.HandleFunc("POST /_admin/flush", flush(flusher, auditor, logger))
+	}
+	if deleter, ok := cache.(ExpiredDeleter); ok && !readOnly {
+		mux.HandleFunc("POST /_admin/expire", deleteExpired(deleter, auditor, logger))
+	}
+	if inspector, ok := cache.(KeyInspector); ok {
+		mux.HandleFunc("GET /_admin/keys/{key}", inspectKey(inspector, logger))
+	}
+	if runner, ok := cache.(EvictionRunner); ok {
+		mux.HandleFunc("GET /_admin/eviction", eviction(runner, nil, logger))
+		mux.HandleFunc("POST /_admin/eviction/stop", eviction(runner, runner.StopEviction, logger))
+		mux.HandleFunc("POST /_admin/eviction/start", eviction(runner, runner.StartEviction, logger))
+	}
+}
+
+// adminStats handles `GET /_admin/stats`
+func adminStats(provider StatsProvider, logger *zerolog.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		logger := requestLogger(r, logger)
+		stats, err := provider.Stats()
+		if err != nil {
+			logger.Error().Err(err).Msg("Failed to get stats")
+			http.Error(w, errInternalServerResponse, http.StatusInternalServerError)
+			return
+		}
+		response := statsResponse{
+			Keys:               stats.Keys,
+			Bytes:              stats.Bytes,
+			Hits:               stats.Hits,
+			Misses:             stats.Misses,
+			Evictions:          stats.Evictions,
+			TTLSec:             int64(stats.TTL / time.Second),
+			MaxSize:            stats.MaxSize,
+			EvictionIntervalMs: stats.EvictionInterval.Milliseconds(),
+		}
+		if !stats.Oldest.IsZero() {
+			response.Oldest = &stats.Oldest
+		}
+		writeJSON(w, http.StatusOK, response, logger)
+	}
+}
+
+// flush handles `POST /_admin/flush`
+func flush(flusher Flusher, auditor Auditor, logger *zerolog.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		logger := requestLogger(r, logger)
+		flushed, err := flusher.Flush()
+		if err != nil {
+			logger.Error().Err(err).Msg("Failed to flush cache")
+			http.Error(w, errInternalServerResponse, http.StatusInternalServerError)
+			return
+		}
+		logger.Info().Int("deleted", flushed).Msg("Flushed cache")
+		auditor.Audit(r, AuditEvent{Action: AuditFlush, Count: flushed})
+		writeJSON(w, http.StatusOK, deleteKeysResponse{Deleted: flushed}, logger)
+	}
+}
+
+// deleteExpired handles `POST /_admin/expire`
+func deleteExpired(deleter ExpiredDeleter, auditor Auditor, logger *zerolog.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		logger := requestLogger(r, logger)
+		deleted := deleter.DeleteExpired()
+		logger.Info().Int("deleted", deleted).Msg("Deleted expired keys")
+		auditor.Audit(r, AuditEvent{Action: AuditExpire, Count: deleted})
+		writeJSON(w, http.StatusOK, deleteKeysResponse{Deleted: deleted}, logger)
+	}
+}
+
+// inspectKey handles `GET /_admin/keys/{key}`
+func inspectKey(inspector KeyInspector, logger *zerolog.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		logger := requestLogger(r, logger)
+		key := r.PathValue(keyPathName)
+		metadata, ok, err := inspector.Inspect(key)
+		if err != nil {
+			logger.Error().Err(err).Str("key", key).Msg("Failed to inspect key")
+			http.Error(w, errInternalServerResponse, http.StatusInternalServerError)
+			return
+		}
+		if !ok {
+			http.Error(w, errNotFoundResponse, http.StatusNotFound)
+			return
+		}
+		response := keyMetadataResponse{
+			Key:     key,
+			TTLMs:   metadata.TTL.Milliseconds(),
+			Size:    metadata.Size,
+			Version: metadata.Version,
+			Tags:    metadata.Tags,
+		}
+		if metadata.TTL < 0 {
+			response.TTLMs = -1
+		}
+		if !metadata.Created.IsZero() {
+			response.Created = &metadata.Created
+		}
+		writeJSON(w, http.StatusOK, response, logger)
+	}
+}
+
+// eviction handles `GET /_admin/eviction` and, with an action, `POST /_admin/eviction/stop` and
+// `POST /_admin/eviction/start`
+func eviction(runner EvictionRunner, action func(), logger *zerolog.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		logger := requestLogger(r, logger)
+		if action != nil {
+			action()
+			logger.Info().Bool("running", runner.IsEvictionRunning()).Msg("Changed eviction")
+		}
+		writeJSON(w, http.StatusOK, evictionResponse{Running: runner.IsEvictionRunning()}, logger)
+	}
+}
@@ -0,0 +1,137 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+type mockAdminCache struct {
+	mockStatsCache
+	Metadata     map[string]KeyMetadata
+	FlushCalls   int
+	ExpiredCalls int
+	Running      bool
+}
+
+func (m *mockAdminCache) Inspect(key string) (KeyMetadata, bool, error) {
+	metadata, ok := m.Metadata[key]
+	return metadata, ok, nil
+}
+
+func (m *mockAdminCache) Flush() (int, error) {
+	m.FlushCalls++
+	return 3, nil
+}
+
+func (m *mockAdminCache) DeleteExpired() int {
+	m.ExpiredCalls++
+	return 2
+}
+
+func (m *mockAdminCache) StopEviction()           { m.Running = false }
+func (m *mockAdminCache) StartEviction()          { m.Running = true }
+func (m *mockAdminCache) IsEvictionRunning() bool { return m.Running }
+
+func TestServer_Admin(t *testing.T) {
+	t.Parallel()
+	created := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	cache := &mockAdminCache{
+		mockStatsCache: mockStatsCache{StatsValue: Stats{
+			Keys: 4, Bytes: 100, Hits: 7, Misses: 3, Evictions: 1, Oldest: created, TTL: time.Minute,
+		}},
+		Metadata: map[string]KeyMetadata{
+			"user:1": {TTL: 1500 * time.Millisecond, Size: 5, Version: 9, Created: created, Tags: []string{"user"}},
+			"static": {TTL: -1, Size: 1},
+		},
+		Running: true,
+	}
+	logger := zerolog.Nop()
+	handler := New(&logger, cache)
+	serve := func(method string, target string) *httptest.ResponseRecorder {
+		responseRecorder := httptest.NewRecorder()
+		handler.ServeHTTP(responseRecorder, httptest.NewRequest(method, target, nil))
+		return responseRecorder
+	}
+
+	responseRecorder := serve(http.MethodGet, "/_admin/stats")
+	if responseRecorder.Code != http.StatusOK {
+		t.Fatalf("Expected status code %d, got %d", http.StatusOK, responseRecorder.Code)
+	}
+	var stats statsResponse
+	if err := json.Unmarshal(responseRecorder.Body.Bytes(), &stats); err != nil {
+		t.Fatal(err)
+	}
+	if stats.Keys != 4 || stats.Bytes != 100 || stats.Hits != 7 || stats.Misses != 3 || stats.Evictions != 1 ||
+		stats.TTLSec != 60 || stats.Oldest == nil || !stats.Oldest.Equal(created) {
+		t.Errorf("Unexpected stats %+v", stats)
+	}
+
+	var deleted deleteKeysResponse
+	responseRecorder = serve(http.MethodPost, "/_admin/flush")
+	if err := json.Unmarshal(responseRecorder.Body.Bytes(), &deleted); err != nil {
+		t.Fatal(err)
+	}
+	if cache.FlushCalls != 1 || deleted.Deleted != 3 {
+		t.Errorf("Expected the cache to be flushed, got %d calls and %+v", cache.FlushCalls, deleted)
+	}
+	responseRecorder = serve(http.MethodPost, "/_admin/expire")
+	if err := json.Unmarshal(responseRecorder.Body.Bytes(), &deleted); err != nil {
+		t.Fatal(err)
+	}
+	if cache.ExpiredCalls != 1 || deleted.Deleted != 2 {
+		t.Errorf("Expected the expired keys to be deleted, got %d calls and %+v", cache.ExpiredCalls, deleted)
+	}
+
+	responseRecorder = serve(http.MethodGet, "/_admin/keys/user:1")
+	var metadata keyMetadataResponse
+	if err := json.Unmarshal(responseRecorder.Body.Bytes(), &metadata); err != nil {
+		t.Fatal(err)
+	}
+	if metadata.Key != "user:1" || metadata.TTLMs != 1500 || metadata.Size != 5 || metadata.Version != 9 ||
+		metadata.Created == nil || len(metadata.Tags) != 1 {
+		t.Errorf("Unexpected metadata %+v", metadata)
+	}
+	responseRecorder = serve(http.MethodGet, "/_admin/keys/static")
+	if err := json.Unmarshal(responseRecorder.Body.Bytes(), &metadata); err != nil {
+		t.Fatal(err)
+	}
+	if metadata.TTLMs != -1 {
+		t.Errorf("Expected a ttl of -1 for a key without expiry, got %d", metadata.TTLMs)
+	}
+	if code := serve(http.MethodGet, "/_admin/keys/missing").Code; code != http.StatusNotFound {
+		t.Errorf("Expected status code %d for a missing key, got %d", http.StatusNotFound, code)
+	}
+
+	var eviction evictionResponse
+	responseRecorder = serve(http.MethodPost, "/_admin/eviction/stop")
+	if err := json.Unmarshal(responseRecorder.Body.Bytes(), &eviction); err != nil {
+		t.Fatal(err)
+	}
+	if eviction.Running || cache.Running {
+		t.Errorf("Expected eviction to be stopped")
+	}
+	responseRecorder = serve(http.MethodPost, "/_admin/eviction/start")
+	if err := json.Unmarshal(responseRecorder.Body.Bytes(), &eviction); err != nil {
+		t.Fatal(err)
+	}
+	if !eviction.Running || !cache.Running {
+		t.Errorf("Expected eviction to be running")
+	}
+}
+
+func TestServer_AdminUnsupported(t *testing.T) {
+	t.Parallel()
+	logger := zerolog.Nop()
+	handler := New(&logger, &mockCache{})
+	req := httptest.NewRequest(http.MethodPost, "/_admin/flush", nil)
+	responseRecorder := httptest.NewRecorder()
+	handler.ServeHTTP(responseRecorder, req)
+	if responseRecorder.Code == http.StatusOK {
+		t.Errorf("Expected /_admin/flush not to be served by a cache that can not flush")
+	}
+}
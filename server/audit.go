@@ -0,0 +1,65 @@
+package server
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+)
+
+// The actions recorded by an Auditor
+const (
+	AuditSet           = "set"
+	AuditIncrement     = "increment"
+	AuditDeleteKeys    = "delete_keys"
+	AuditInvalidateTag = "invalidate_tag"
+	AuditFlush         = "flush"
+	AuditExpire        = "expire"
+)
+
+// AuditEvent describes a change a request made to a cache
+type AuditEvent struct {
+	Action    string
+	Namespace string
+	Key       string
+	// ValueHash is the SHA-256 of the value written, the value itself is never recorded
+	ValueHash string
+	// Prefix, Pattern and Tag select the keys of a bulk action
+	Prefix  string
+	Pattern string
+	Tag     string
+	// Count is the number of keys a bulk action removed
+	Count int
+}
+
+// Auditor records the changes made to the caches, along with who made them
+type Auditor interface {
+	Audit(r *http.Request, event AuditEvent)
+}
+
+// WithAuditor records the successful changes to the default cache and to every namespace
+func WithAuditor(auditor Auditor) Option {
+	return func(o *options) {
+		o.auditor = auditor
+	}
+}
+
+type nopAuditor struct{}
+
+func (nopAuditor) Audit(*http.Request, AuditEvent) {}
+
+// namespaceAuditor tags the events of a namespace with its name
+type namespaceAuditor struct {
+	Auditor
+	namespace string
+}
+
+func (a namespaceAuditor) Audit(r *http.Request, event AuditEvent) {
+	event.Namespace = a.namespace
+	a.Auditor.Audit(r, event)
+}
+
+// hashValue returns the hex encoded SHA-256 of value
+func hashValue(value string) string {
+	sum := sha256.Sum256([]byte(value))
+	return hex.EncodeToString(sum[:])
+}
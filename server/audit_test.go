@@ -0,0 +1,120 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/rs/zerolog"
+)
+
+type mockAuditor struct {
+	Events []AuditEvent
+}
+
+func (m *mockAuditor) Audit(_ *http.Request, event AuditEvent) {
+	m.Events = append(m.Events, event)
+}
+
+func TestHashValue(t *testing.T) {
+	t.Parallel()
+	expected := "2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824"
+	if got := hashValue("hello"); got != expected {
+		t.Errorf("Expected hash %s, got %s", expected, got)
+	}
+}
+
+func TestServer_Audit(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name     string
+		cache    Cache
+		method   string
+		target   string
+		body     string
+		expected []AuditEvent
+	}{
+		{
+			name:     "Should audit a set with the hash of the value",
+			cache:    &mockCache{},
+			method:   http.MethodPost,
+			target:   "/key",
+			body:     "hello",
+			expected: []AuditEvent{{Action: AuditSet, Key: "key", ValueHash: hashValue("hello")}},
+		},
+		{
+			name:     "Should audit a set in a namespace",
+			cache:    &mockCache{},
+			method:   http.MethodPost,
+			target:   "/ns/team-a/key",
+			body:     "hello",
+			expected: []AuditEvent{{Action: AuditSet, Namespace: "team-a", Key: "key", ValueHash: hashValue("hello")}},
+		},
+		{
+			name:     "Should not audit a rejected set",
+			cache:    &mockCache{},
+			method:   http.MethodPost,
+			target:   "/key",
+			body:     "",
+			expected: nil,
+		},
+		{
+			name:     "Should not audit a get",
+			cache:    &mockCache{Hit: true, GetValue: "hello"},
+			method:   http.MethodGet,
+			target:   "/key",
+			expected: nil,
+		},
+		{
+			name:     "Should audit an increment with the hash of the new value",
+			cache:    &mockCounter{Value: 41},
+			method:   http.MethodPost,
+			target:   "/counter/incr",
+			expected: []AuditEvent{{Action: AuditIncrement, Key: "counter", ValueHash: hashValue("42")}},
+		},
+		{
+			name:     "Should audit a delete with its selection and count",
+			cache:    &mockDeleter{Deleted: 4},
+			method:   http.MethodDelete,
+			target:   "/_keys?prefix=tenant42:",
+			expected: []AuditEvent{{Action: AuditDeleteKeys, Prefix: "tenant42:", Count: 4}},
+		},
+		{
+			name:     "Should audit a tag invalidation",
+			cache:    &mockTagCache{Invalidated: 2},
+			method:   http.MethodPost,
+			target:   "/_tags/product:12/invalidate",
+			expected: []AuditEvent{{Action: AuditInvalidateTag, Tag: "product:12", Count: 2}},
+		},
+		{
+			name:     "Should audit a flush",
+			cache:    &mockAdminCache{},
+			method:   http.MethodPost,
+			target:   "/_admin/flush",
+			expected: []AuditEvent{{Action: AuditFlush, Count: 3}},
+		},
+		{
+			name:     "Should audit the deletion of expired keys",
+			cache:    &mockAdminCache{},
+			method:   http.MethodPost,
+			target:   "/_admin/expire",
+			expected: []AuditEvent{{Action: AuditExpire, Count: 2}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			auditor := &mockAuditor{}
+			logger := zerolog.Nop()
+			handler := New(&logger, tt.cache, WithAuditor(auditor),
+				WithNamespaces(map[string]Cache{"team-a": &mockCache{}}))
+			req := httptest.NewRequest(tt.method, tt.target, strings.NewReader(tt.body))
+			responseRecorder := httptest.NewRecorder()
+			handler.ServeHTTP(responseRecorder, req)
+			if !reflect.DeepEqual(auditor.Events, tt.expected) {
+				t.Errorf("Expected events %+v, got %+v", tt.expected, auditor.Events)
+			}
+		})
+	}
+}
@@ -0,0 +1,46 @@
+package server
+
+import (
+	"net/http"
+
+	"github.com/rs/zerolog"
+)
+
+// ClusterMember is a node of the cluster as seen by this node
+type ClusterMember struct {
+	// Name is the address the node serves the cache at
+	Name string `json:"name"`
+	// Addr is the address the node is discovered at, empty for static nodes
+	Addr        string `json:"addr,omitempty"`
+	State       string `json:"state"`
+	Incarnation uint64 `json:"incarnation,omitempty"`
+}
+
+// ClusterState is the view this node has of its cluster
+type ClusterState struct {
+	Self string `json:"self"`
+	// Nodes are the nodes owning keys, sorted
+	Nodes             []string        `json:"nodes"`
+	ReplicationFactor int             `json:"replication_factor"`
+	Members           []ClusterMember `json:"members"`
+}
+
+// ClusterStateProvider reports the state of the cluster the server is a node of
+type ClusterStateProvider interface {
+	ClusterState() ClusterState
+}
+
+// WithCluster serves the state of the cluster at `GET /_admin/cluster`
+func WithCluster(cluster ClusterStateProvider) Option {
+	return func(o *options) {
+		o.cluster = cluster
+	}
+}
+
+// clusterState handles `GET /_admin/cluster`
+func clusterState(cluster ClusterStateProvider, logger *zerolog.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		logger := requestLogger(r, logger)
+		writeJSON(w, http.StatusOK, cluster.ClusterState(), logger)
+	}
+}
@@ -0,0 +1,52 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/rs/zerolog"
+)
+
+type mockCluster struct {
+	state ClusterState
+}
+
+func (m mockCluster) ClusterState() ClusterState {
+	return m.state
+}
+
+func TestServer_ClusterState(t *testing.T) {
+	t.Parallel()
+	logger := zerolog.Nop()
+	state := ClusterState{
+		Self:              "a:8080",
+		Nodes:             []string{"a:8080", "b:8080"},
+		ReplicationFactor: 2,
+		Members: []ClusterMember{
+			{Name: "a:8080", Addr: "a:7946", State: "alive", Incarnation: 1},
+			{Name: "b:8080", Addr: "b:7946", State: "suspect", Incarnation: 3},
+		},
+	}
+	handler := New(&logger, &mockCache{}, WithCluster(mockCluster{state: state}))
+	responseRecorder := httptest.NewRecorder()
+	handler.ServeHTTP(responseRecorder, httptest.NewRequest(http.MethodGet, "/_admin/cluster", nil))
+	if responseRecorder.Code != http.StatusOK {
+		t.Fatalf("Expected status code %d, got %d", http.StatusOK, responseRecorder.Code)
+	}
+	var got ClusterState
+	if err := json.NewDecoder(responseRecorder.Body).Decode(&got); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, state) {
+		t.Errorf("Expected %+v, got %+v", state, got)
+	}
+
+	responseRecorder = httptest.NewRecorder()
+	New(&logger, &mockCache{}).ServeHTTP(responseRecorder, httptest.NewRequest(http.MethodGet, "/_admin/cluster", nil))
+	if responseRecorder.Code == http.StatusOK {
+		t.Errorf("Expected /_admin/cluster not to be served outside a cluster")
+	}
+}
@@ -0,0 +1,139 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+const (
+	deltaQueryName   = "delta"
+	initialQueryName = "initial"
+	ttlQueryName     = "ttl"
+	minQueryName     = "min"
+	maxQueryName     = "max"
+)
+
+// Counter is implemented by caches that can update integer values atomically
+type Counter interface {
+	// Increment adds delta to the integer stored at key and returns the new value
+	Increment(key string, delta int64, opts CounterOptions) (int64, error)
+	// Decrement subtracts delta from the integer stored at key and returns the new value
+	Decrement(key string, delta int64, opts CounterOptions) (int64, error)
+}
+
+// CounterOptions tunes how Increment and Decrement treat missing keys and the resulting value
+type CounterOptions struct {
+	// Initial is the value a missing key starts from before delta is applied
+	Initial int64
+	// TTL is the time to live of a counter created by the operation, 0 means the default TTL of the cache.
+	// Updating an existing counter keeps its expiration.
+	TTL time.Duration
+	// Min and Max clamp the resulting value when set
+	Min *int64
+	Max *int64
+}
+
+// Clamp limits value to the bounds of the options
+func (o CounterOptions) Clamp(value int64) int64 {
+	if o.Min != nil && value < *o.Min {
+		value = *o.Min
+	}
+	if o.Max != nil && value > *o.Max {
+		value = *o.Max
+	}
+	return value
+}
+
+var (
+	// ErrNotInteger is returned by a Counter when the stored value is not an integer
+	ErrNotInteger = errors.New("value is not an integer")
+	// ErrOverflow is returned by a Counter when the result is out of the range of int64, and no bound in the
+	// direction of the delta clamps it
+	ErrOverflow = errors.New("result is out of the range of integers")
+)
+
+// increment handles `POST /{key}/incr`. The delta, initial value, TTL in seconds and bounds are given as query
+// parameters, a negative delta decrements the counter. The response body is the new value.
+func increment(counter Counter, limits Limits, auditor Auditor, logger *zerolog.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		logger := requestLogger(r, logger)
+		key, ok := limits.pathKey(w, r, logger)
+		if !ok {
+			return
+		}
+		logger.Debug().Str("key", key).Msg("Received increment key request")
+		delta, opts, err := parseCounterQuery(r)
+		if err != nil {
+			logger.Debug().Err(err).Str("key", key).Msg("Invalid increment request")
+			http.Error(w, errBadRequestResponse, http.StatusBadRequest)
+			return
+		}
+		value, err := counter.Increment(key, delta, opts)
+		if errors.Is(err, ErrNotInteger) {
+			http.Error(w, errConflictResponse, http.StatusConflict)
+			return
+		}
+		if errors.Is(err, ErrOverflow) {
+			http.Error(w, errOverflowResponse, http.StatusConflict)
+			return
+		}
+		if err != nil {
+			logger.Error().Err(err).Msg("Failed to increment value in cache")
+			http.Error(w, errInternalServerResponse, http.StatusInternalServerError)
+			return
+		}
+		auditor.Audit(r, AuditEvent{Action: AuditIncrement, Key: key, ValueHash: hashValue(strconv.FormatInt(value, 10))})
+		w.WriteHeader(http.StatusOK)
+		_, err = w.Write([]byte(strconv.FormatInt(value, 10)))
+		if err != nil {
+			logger.Error().Err(err).Msg("Failed to write response")
+		}
+	}
+}
+
+func parseCounterQuery(r *http.Request) (int64, CounterOptions, error) {
+	query := r.URL.Query()
+	var opts CounterOptions
+	delta := int64(1)
+	var err error
+	if query.Has(deltaQueryName) {
+		delta, err = strconv.ParseInt(query.Get(deltaQueryName), 10, 64)
+		if err != nil {
+			return 0, opts, err
+		}
+	}
+	if query.Has(initialQueryName) {
+		opts.Initial, err = strconv.ParseInt(query.Get(initialQueryName), 10, 64)
+		if err != nil {
+			return 0, opts, err
+		}
+	}
+	if opts.TTL, err = parseTTL(r); err != nil {
+		return 0, opts, err
+	}
+	if opts.Min, err = parseOptionalInt(query.Get(minQueryName)); err != nil {
+		return 0, opts, err
+	}
+	if opts.Max, err = parseOptionalInt(query.Get(maxQueryName)); err != nil {
+		return 0, opts, err
+	}
+	if opts.Min != nil && opts.Max != nil && *opts.Min > *opts.Max {
+		return 0, opts, errors.New("min must not be greater than max")
+	}
+	return delta, opts, nil
+}
+
+func parseOptionalInt(value string) (*int64, error) {
+	if value == "" {
+		return nil, nil
+	}
+	n, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return nil, err
+	}
+	return &n, nil
+}
@@ -0,0 +1,134 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/rs/zerolog"
+)
+
+type mockCounter struct {
+	mockCache
+	Value   int64
+	Err     error
+	Deltas  []int64
+	Options []CounterOptions
+}
+
+func (m *mockCounter) Increment(_ string, delta int64, opts CounterOptions) (int64, error) {
+	m.Deltas = append(m.Deltas, delta)
+	m.Options = append(m.Options, opts)
+	if m.Err != nil {
+		return 0, m.Err
+	}
+	return opts.Clamp(m.Value + delta), nil
+}
+
+func (m *mockCounter) Decrement(key string, delta int64, opts CounterOptions) (int64, error) {
+	return m.Increment(key, -delta, opts)
+}
+
+func TestServer_Increment(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name           string
+		query          string
+		err            error
+		expectedStatus int
+		expectedBody   string
+		expectedDelta  int64
+	}{
+		{
+			name:           "Should increment by 1 by default",
+			query:          "",
+			expectedStatus: http.StatusOK,
+			expectedBody:   "11",
+			expectedDelta:  1,
+		},
+		{
+			name:           "Should decrement with a negative delta",
+			query:          "?delta=-3",
+			expectedStatus: http.StatusOK,
+			expectedBody:   "7",
+			expectedDelta:  -3,
+		},
+		{
+			name:           "Should clamp to max",
+			query:          "?delta=100&max=20",
+			expectedStatus: http.StatusOK,
+			expectedBody:   "20",
+			expectedDelta:  100,
+		},
+		{
+			name:           "Should return 400 for an invalid delta",
+			query:          "?delta=abc",
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "Should return 400 when min is greater than max",
+			query:          "?min=5&max=1",
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "Should return 409 when the value is not an integer",
+			query:          "",
+			err:            ErrNotInteger,
+			expectedStatus: http.StatusConflict,
+			expectedDelta:  1,
+		},
+		{
+			name:           "Should return 409 when the result overflows",
+			query:          "",
+			err:            ErrOverflow,
+			expectedStatus: http.StatusConflict,
+			expectedBody:   errOverflowResponse + "\n",
+			expectedDelta:  1,
+		},
+		{
+			name:           "Should return 400 for a ttl past the bound",
+			query:          "?ttl=8000000000",
+			expectedStatus: http.StatusBadRequest,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			counter := &mockCounter{Value: 10, Err: tt.err}
+			logger := zerolog.Nop()
+			handler := New(&logger, counter)
+			req := httptest.NewRequest(http.MethodPost, "/requests/incr"+tt.query, nil)
+			responseRecorder := httptest.NewRecorder()
+			handler.ServeHTTP(responseRecorder, req)
+			if responseRecorder.Code != tt.expectedStatus {
+				t.Fatalf("Expected status code %d, got %d", tt.expectedStatus, responseRecorder.Code)
+			}
+			if tt.expectedBody != "" && responseRecorder.Body.String() != tt.expectedBody {
+				t.Errorf("Expected response body %s, got %s", tt.expectedBody, responseRecorder.Body.String())
+			}
+			if tt.expectedDelta != 0 && (len(counter.Deltas) != 1 || counter.Deltas[0] != tt.expectedDelta) {
+				t.Errorf("Expected Increment to be called once with %d, got %v", tt.expectedDelta, counter.Deltas)
+			}
+		})
+	}
+}
+
+func TestParseCounterQuery(t *testing.T) {
+	req := httptest.NewRequest(http.MethodPost, "/key/incr?delta=5&initial=10&ttl=60&min=0&max=100", nil)
+	delta, opts, err := parseCounterQuery(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if delta != 5 || opts.Initial != 10 || opts.TTL.Seconds() != 60 {
+		t.Errorf("Unexpected delta %d or options %+v", delta, opts)
+	}
+	if opts.Min == nil || *opts.Min != 0 || opts.Max == nil || *opts.Max != 100 {
+		t.Errorf("Expected bounds [0, 100], got %v %v", opts.Min, opts.Max)
+	}
+
+	req = httptest.NewRequest(http.MethodPost, "/key/incr?ttl=-1", nil)
+	if _, _, err = parseCounterQuery(req); err == nil {
+		t.Errorf("Expected an error for a negative ttl")
+	}
+}
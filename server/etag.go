@@ -0,0 +1,111 @@
+package server
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	headerETag        = "ETag"
+	headerIfMatch     = "If-Match"
+	headerIfNoneMatch = "If-None-Match"
+	anyETag           = "*"
+)
+
+// computeETag returns a strong entity tag for the given value. The tag is derived from a hash of the content, so
+// the same value always produces the same tag regardless of which backend stored it.
+func computeETag(value string) string {
+	sum := sha256.Sum256([]byte(value))
+	return `"` + hex.EncodeToString(sum[:16]) + `"`
+}
+
+// parseETags splits the comma separated list of entity tags found in the given header values
+func parseETags(values []string) []string {
+	tags := make([]string, 0, len(values))
+	for _, value := range values {
+		for _, tag := range strings.Split(value, ",") {
+			tag = strings.TrimSpace(tag)
+			if tag != "" {
+				tags = append(tags, tag)
+			}
+		}
+	}
+	return tags
+}
+
+// matchesWeak reports whether etag matches any of the tags using the weak comparison function (RFC 9110 8.8.3.2),
+// which is the one required for If-None-Match
+func matchesWeak(tags []string, etag string) bool {
+	for _, tag := range tags {
+		if tag == anyETag || strings.TrimPrefix(tag, "W/") == strings.TrimPrefix(etag, "W/") {
+			return true
+		}
+	}
+	return false
+}
+
+// matchesStrong reports whether etag matches any of the tags using the strong comparison function, which is the
+// one required for If-Match. Weak tags never match.
+func matchesStrong(tags []string, etag string) bool {
+	for _, tag := range tags {
+		if tag == anyETag {
+			return true
+		}
+		if !strings.HasPrefix(tag, "W/") && tag == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// preconditionFailed evaluates If-Match and If-None-Match of a write request against the current value of the key.
+// exists tells whether the key is currently present and current is its value.
+func preconditionFailed(r *http.Request, current string, exists bool) bool {
+	if ifMatch := parseETags(r.Header.Values(headerIfMatch)); len(ifMatch) > 0 {
+		if !exists || !matchesStrong(ifMatch, computeETag(current)) {
+			return true
+		}
+	}
+	if ifNoneMatch := parseETags(r.Header.Values(headerIfNoneMatch)); len(ifNoneMatch) > 0 {
+		if exists && matchesWeak(ifNoneMatch, computeETag(current)) {
+			return true
+		}
+	}
+	return false
+}
+
+// hasPreconditions reports whether the request carries any conditional header that needs the current value
+func hasPreconditions(r *http.Request) bool {
+	return r.Header.Get(headerIfMatch) != "" || r.Header.Get(headerIfNoneMatch) != ""
+}
+
+// conditionalSet stores the value only if the preconditions of the request hold. If the cache keeps versions, the
+// check and the write are done atomically with CompareAndSwap, otherwise it falls back to a Get followed by a Set.
+func conditionalSet(cache Cache, r *http.Request, key string, value string, tags []string,
+	ttl time.Duration) (bool, error) {
+	versioned, ok := cache.(VersionedCache)
+	if !ok {
+		current, exists := cache.Get(key)
+		if preconditionFailed(r, current, exists) {
+			return false, nil
+		}
+		return true, setValue(cache, key, value, tags, ttl)
+	}
+	current, version, exists := versioned.GetWithVersion(key)
+	if preconditionFailed(r, current, exists) {
+		return false, nil
+	}
+	if expiring, ok := cache.(ExpiringCache); ok && ttl > 0 {
+		_, swapped, err := expiring.CompareAndSwapWithTTL(key, version, value, tags, ttl)
+		return swapped, err
+	}
+	if tagCache, ok := cache.(TagCache); ok && len(tags) > 0 {
+		_, swapped, err := tagCache.CompareAndSwapWithTags(key, version, value, tags)
+		return swapped, err
+	}
+	_, swapped, err := versioned.CompareAndSwap(key, version, value)
+	return swapped, err
+}
@@ -0,0 +1,81 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestComputeETag(t *testing.T) {
+	first := computeETag("value")
+	if first != computeETag("value") {
+		t.Errorf("Expected the same value to produce the same etag")
+	}
+	if first == computeETag("other") {
+		t.Errorf("Expected different values to produce different etags")
+	}
+	if first[0] != '"' || first[len(first)-1] != '"' {
+		t.Errorf("Expected etag %s to be quoted", first)
+	}
+}
+
+func TestETagMatching(t *testing.T) {
+	etag := computeETag("value")
+	tests := []struct {
+		name   string
+		header []string
+		weak   bool
+		strong bool
+	}{
+		{name: "empty header", header: nil, weak: false, strong: false},
+		{name: "wildcard", header: []string{"*"}, weak: true, strong: true},
+		{name: "exact", header: []string{etag}, weak: true, strong: true},
+		{name: "weak tag", header: []string{"W/" + etag}, weak: true, strong: false},
+		{name: "list", header: []string{`"a", ` + etag}, weak: true, strong: true},
+		{name: "multiple headers", header: []string{`"a"`, etag}, weak: true, strong: true},
+		{name: "mismatch", header: []string{`"a"`}, weak: false, strong: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tags := parseETags(tt.header)
+			if got := matchesWeak(tags, etag); got != tt.weak {
+				t.Errorf("Expected weak match to be %v, got %v", tt.weak, got)
+			}
+			if got := matchesStrong(tags, etag); got != tt.strong {
+				t.Errorf("Expected strong match to be %v, got %v", tt.strong, got)
+			}
+		})
+	}
+}
+
+func TestPreconditionFailed(t *testing.T) {
+	etag := computeETag("current")
+	tests := []struct {
+		name        string
+		ifMatch     string
+		ifNoneMatch string
+		exists      bool
+		expected    bool
+	}{
+		{name: "no conditions", exists: true, expected: false},
+		{name: "If-Match matches", ifMatch: etag, exists: true, expected: false},
+		{name: "If-Match mismatch", ifMatch: `"stale"`, exists: true, expected: true},
+		{name: "If-Match on missing key", ifMatch: "*", exists: false, expected: true},
+		{name: "If-None-Match * on missing key", ifNoneMatch: "*", exists: false, expected: false},
+		{name: "If-None-Match * on existing key", ifNoneMatch: "*", exists: true, expected: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/key", nil)
+			if tt.ifMatch != "" {
+				req.Header.Set(headerIfMatch, tt.ifMatch)
+			}
+			if tt.ifNoneMatch != "" {
+				req.Header.Set(headerIfNoneMatch, tt.ifNoneMatch)
+			}
+			if got := preconditionFailed(req, "current", tt.exists); got != tt.expected {
+				t.Errorf("Expected preconditionFailed to be %v, got %v", tt.expected, got)
+			}
+		})
+	}
+}
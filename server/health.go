@@ -0,0 +1,101 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"sort"
+	"sync/atomic"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+const (
+	// readinessTimeout bounds the checks of a readiness probe, so a hanging backend fails the probe instead of
+	// blocking it
+	readinessTimeout = time.Second
+
+	errServiceUnavailableResponse = "Service Unavailable"
+)
+
+// HealthChecker is implemented by caches that can tell whether their backend is usable
+type HealthChecker interface {
+	Check(ctx context.Context) error
+}
+
+type checkResponse struct {
+	Name  string `json:"name"`
+	Error string `json:"error,omitempty"`
+}
+
+type readinessResponse struct {
+	Ready  bool            `json:"ready"`
+	Checks []checkResponse `json:"checks"`
+}
+
+// Health serves the probes of the process: `/livez` and `/healthz` answer as long as the process serves requests,
+// and `/readyz` answers only if every checked cache is usable and shutdown has not begun
+type Health struct {
+	checks       map[string]HealthChecker
+	shuttingDown atomic.Bool
+	logger       *zerolog.Logger
+}
+
+// NewHealth creates the probes, checking the caches of the map that implement HealthChecker for readiness
+func NewHealth(caches map[string]Cache, logger *zerolog.Logger) *Health {
+	checks := make(map[string]HealthChecker, len(caches))
+	for name, cache := range caches {
+		if checker, ok := cache.(HealthChecker); ok {
+			checks[name] = checker
+		}
+	}
+	return &Health{checks: checks, logger: logger}
+}
+
+// StartShutdown makes readiness fail from now on, so load balancers stop sending traffic before the server stops
+func (h *Health) StartShutdown() {
+	h.shuttingDown.Store(true)
+}
+
+// Handler serves the probes and passes every other request to next. The probes are answered before next, so they
+// are not subject to the authentication or the rate limits of next.
+func (h *Health) Handler(next http.Handler) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /livez", h.live)
+	mux.HandleFunc("GET /healthz", h.live)
+	mux.HandleFunc("GET /readyz", h.ready)
+	mux.Handle("/", next)
+	return mux
+}
+
+func (h *Health) live(w http.ResponseWriter, _ *http.Request) {
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write([]byte("ok"))
+}
+
+func (h *Health) ready(w http.ResponseWriter, r *http.Request) {
+	if h.shuttingDown.Load() {
+		http.Error(w, errServiceUnavailableResponse, http.StatusServiceUnavailable)
+		return
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), readinessTimeout)
+	defer cancel()
+	response := readinessResponse{Ready: true, Checks: make([]checkResponse, 0, len(h.checks))}
+	for name, checker := range h.checks {
+		check := checkResponse{Name: name}
+		if err := checker.Check(ctx); err != nil {
+			h.logger.Warn().Err(err).Str("check", name).Msg("Readiness check failed")
+			check.Error = err.Error()
+			response.Ready = false
+		}
+		response.Checks = append(response.Checks, check)
+	}
+	sort.Slice(response.Checks, func(i, j int) bool {
+		return response.Checks[i].Name < response.Checks[j].Name
+	})
+	status := http.StatusOK
+	if !response.Ready {
+		status = http.StatusServiceUnavailable
+	}
+	writeJSON(w, status, response, h.logger)
+}
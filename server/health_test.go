@@ -0,0 +1,69 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/rs/zerolog"
+)
+
+type mockHealthCache struct {
+	mockCache
+	CheckErr error
+}
+
+func (m *mockHealthCache) Check(context.Context) error {
+	return m.CheckErr
+}
+
+func TestHealth(t *testing.T) {
+	t.Parallel()
+	logger := zerolog.Nop()
+	healthy := &mockHealthCache{}
+	unhealthy := &mockHealthCache{CheckErr: errors.New("redis is down")}
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+	})
+
+	serve := func(handler http.Handler, target string) *httptest.ResponseRecorder {
+		responseRecorder := httptest.NewRecorder()
+		handler.ServeHTTP(responseRecorder, httptest.NewRequest(http.MethodGet, target, nil))
+		return responseRecorder
+	}
+
+	health := NewHealth(map[string]Cache{"default": healthy, "plain": &mockCache{}}, &logger)
+	handler := health.Handler(next)
+	for _, target := range []string{"/livez", "/healthz", "/readyz"} {
+		if code := serve(handler, target).Code; code != http.StatusOK {
+			t.Errorf("Expected %s to return %d, got %d", target, http.StatusOK, code)
+		}
+	}
+	if code := serve(handler, "/key").Code; code != http.StatusTeapot {
+		t.Errorf("Expected other requests to be passed on, got %d", code)
+	}
+
+	health.StartShutdown()
+	if code := serve(handler, "/readyz").Code; code != http.StatusServiceUnavailable {
+		t.Errorf("Expected readiness to fail once shutdown began, got %d", code)
+	}
+	if code := serve(handler, "/livez").Code; code != http.StatusOK {
+		t.Errorf("Expected liveness to pass during shutdown, got %d", code)
+	}
+
+	handler = NewHealth(map[string]Cache{"default": healthy, "ns/team-a": unhealthy}, &logger).Handler(next)
+	responseRecorder := serve(handler, "/readyz")
+	if responseRecorder.Code != http.StatusServiceUnavailable {
+		t.Fatalf("Expected readiness to fail with an unusable cache, got %d", responseRecorder.Code)
+	}
+	var response readinessResponse
+	if err := json.Unmarshal(responseRecorder.Body.Bytes(), &response); err != nil {
+		t.Fatal(err)
+	}
+	if len(response.Checks) != 2 || response.Checks[1].Name != "ns/team-a" || response.Checks[1].Error == "" {
+		t.Errorf("Expected the failing check to be reported, got %+v", response.Checks)
+	}
+}
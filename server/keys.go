@@ -0,0 +1,172 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+const (
+	prefixQueryName  = "prefix"
+	patternQueryName = "pattern"
+	cursorQueryName  = "cursor"
+	limitQueryName   = "limit"
+	withTTLQueryName = "ttl"
+	// MaxScanLimit is the most keys a page of `GET /_keys` holds, whatever limit the request asks for
+	MaxScanLimit = 1000
+	// DefaultScanLimit is the number of keys per page when ScanOptions.Limit is not set
+	DefaultScanLimit = 100
+)
+
+// KeyScanner is implemented by caches that can enumerate their keys page by page
+type KeyScanner interface {
+	// ScanKeys returns a page of keys matching the options and the cursor to continue from. The returned cursor is
+	// empty when there are no more keys.
+	ScanKeys(opts ScanOptions) ([]KeyInfo, string, error)
+}
+
+// KeyDeleter is implemented by caches that can remove every key matching a prefix or a pattern
+type KeyDeleter interface {
+	// DeleteKeys removes the keys starting with prefix and matching the glob-style pattern, and returns how many
+	// were removed. An empty prefix or pattern matches every key.
+	DeleteKeys(prefix string, pattern string) (int, error)
+}
+
+// ScanOptions selects the keys returned by ScanKeys
+type ScanOptions struct {
+	// Prefix only matches keys starting with it, empty matches every key
+	Prefix string
+	// Pattern only matches keys matching the glob-style pattern (`*`, `?`, `[a-z]`, `\` to escape) as in redis,
+	// empty matches every key
+	Pattern string
+	// Cursor is the cursor returned by the previous page, empty starts a new scan
+	Cursor string
+	// Limit is the number of keys to return per page, DefaultScanLimit if not set. Backends that cannot stop in the
+	// middle of a batch treat it as a hint.
+	Limit int
+	// WithTTL fills the TTL of the returned keys
+	WithTTL bool
+}
+
+// KeyInfo describes a key returned by ScanKeys
+type KeyInfo struct {
+	Key string
+	// TTL is the remaining time to live of the key, -1 if it never expires. Only set if requested.
+	TTL time.Duration
+}
+
+var errBadLimit = errors.New("limit must be a positive integer")
+
+// ErrInvalidCursor is returned by a KeyScanner when the cursor was not returned by a previous scan
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+type keyResponse struct {
+	Key   string `json:"key"`
+	TTLMs *int64 `json:"ttl_ms,omitempty"`
+}
+
+type keysResponse struct {
+	Keys   []keyResponse `json:"keys"`
+	Cursor string        `json:"cursor"`
+}
+
+type deleteKeysResponse struct {
+	Deleted int `json:"deleted"`
+}
+
+// listKeys handles `GET /_keys?prefix=...&cursor=...&limit=...&ttl=true`
+func listKeys(scanner KeyScanner, logger *zerolog.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		logger := requestLogger(r, logger)
+		opts, err := parseScanQuery(r)
+		if err != nil {
+			http.Error(w, errBadRequestResponse, http.StatusBadRequest)
+			return
+		}
+		logger.Debug().Str("prefix", opts.Prefix).Str("cursor", opts.Cursor).Msg("Received list keys request")
+		keys, cursor, err := scanner.ScanKeys(opts)
+		if errors.Is(err, ErrInvalidCursor) {
+			http.Error(w, errBadRequestResponse, http.StatusBadRequest)
+			return
+		}
+		if err != nil {
+			logger.Error().Err(err).Msg("Failed to scan keys")
+			http.Error(w, errInternalServerResponse, http.StatusInternalServerError)
+			return
+		}
+		response := keysResponse{Keys: make([]keyResponse, 0, len(keys)), Cursor: cursor}
+		for _, info := range keys {
+			key := keyResponse{Key: info.Key}
+			if opts.WithTTL {
+				ttl := info.TTL.Milliseconds()
+				if info.TTL < 0 {
+					ttl = -1
+				}
+				key.TTLMs = &ttl
+			}
+			response.Keys = append(response.Keys, key)
+		}
+		writeJSON(w, http.StatusOK, response, logger)
+	}
+}
+
+// deleteKeys handles `DELETE /_keys?prefix=...&pattern=...`. At least one of prefix or pattern is required, so a
+// forgotten parameter cannot flush the whole cache.
+func deleteKeys(deleter KeyDeleter, auditor Auditor, logger *zerolog.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		logger := requestLogger(r, logger)
+		query := r.URL.Query()
+		prefix, pattern := query.Get(prefixQueryName), query.Get(patternQueryName)
+		if prefix == "" && pattern == "" {
+			http.Error(w, errBadRequestResponse, http.StatusBadRequest)
+			return
+		}
+		logger.Debug().Str("prefix", prefix).Str("pattern", pattern).Msg("Received delete keys request")
+		deleted, err := deleter.DeleteKeys(prefix, pattern)
+		if err != nil {
+			logger.Error().Err(err).Msg("Failed to delete keys")
+			http.Error(w, errInternalServerResponse, http.StatusInternalServerError)
+			return
+		}
+		logger.Info().Str("prefix", prefix).Str("pattern", pattern).Int("deleted", deleted).Msg("Deleted keys")
+		auditor.Audit(r, AuditEvent{Action: AuditDeleteKeys, Prefix: prefix, Pattern: pattern, Count: deleted})
+		writeJSON(w, http.StatusOK, deleteKeysResponse{Deleted: deleted}, logger)
+	}
+}
+
+func parseScanQuery(r *http.Request) (ScanOptions, error) {
+	query := r.URL.Query()
+	opts := ScanOptions{
+		Prefix:  query.Get(prefixQueryName),
+		Pattern: query.Get(patternQueryName),
+		Cursor:  query.Get(cursorQueryName),
+		Limit:   DefaultScanLimit,
+	}
+	if query.Has(limitQueryName) {
+		limit, err := strconv.Atoi(query.Get(limitQueryName))
+		if err != nil || limit <= 0 {
+			return opts, errBadLimit
+		}
+		opts.Limit = min(limit, MaxScanLimit)
+	}
+	if query.Has(withTTLQueryName) {
+		withTTL, err := strconv.ParseBool(query.Get(withTTLQueryName))
+		if err != nil {
+			return opts, err
+		}
+		opts.WithTTL = withTTL
+	}
+	return opts, nil
+}
+
+func writeJSON(w http.ResponseWriter, status int, body any, logger *zerolog.Logger) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(body); err != nil {
+		logger.Error().Err(err).Msg("Failed to write response")
+	}
+}
@@ -0,0 +1,188 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+type mockScanner struct {
+	mockCache
+	Keys      []KeyInfo
+	Next      string
+	Err       error
+	ScanCalls []ScanOptions
+}
+
+func (m *mockScanner) ScanKeys(opts ScanOptions) ([]KeyInfo, string, error) {
+	m.ScanCalls = append(m.ScanCalls, opts)
+	return m.Keys, m.Next, m.Err
+}
+
+type mockDeleter struct {
+	mockCache
+	Deleted     int
+	DeleteCalls [][]string
+}
+
+func (m *mockDeleter) DeleteKeys(prefix string, pattern string) (int, error) {
+	m.DeleteCalls = append(m.DeleteCalls, []string{prefix, pattern})
+	return m.Deleted, nil
+}
+
+func TestServer_DeleteKeys(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name            string
+		query           string
+		expectedStatus  int
+		expectedPrefix  string
+		expectedPattern string
+	}{
+		{
+			name:           "Should delete keys by prefix",
+			query:          "?prefix=tenant42:",
+			expectedStatus: http.StatusOK,
+			expectedPrefix: "tenant42:",
+		},
+		{
+			name:            "Should delete keys by pattern",
+			query:           "?pattern=*:session",
+			expectedStatus:  http.StatusOK,
+			expectedPattern: "*:session",
+		},
+		{
+			name:           "Should return 400 without prefix and pattern",
+			query:          "",
+			expectedStatus: http.StatusBadRequest,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			deleter := &mockDeleter{Deleted: 3}
+			logger := zerolog.Nop()
+			handler := New(&logger, deleter)
+			req := httptest.NewRequest(http.MethodDelete, "/_keys"+tt.query, nil)
+			responseRecorder := httptest.NewRecorder()
+			handler.ServeHTTP(responseRecorder, req)
+			if responseRecorder.Code != tt.expectedStatus {
+				t.Fatalf("Expected status code %d, got %d", tt.expectedStatus, responseRecorder.Code)
+			}
+			if tt.expectedStatus != http.StatusOK {
+				if len(deleter.DeleteCalls) != 0 {
+					t.Errorf("Expected DeleteKeys not to be called")
+				}
+				return
+			}
+			if len(deleter.DeleteCalls) != 1 ||
+				deleter.DeleteCalls[0][0] != tt.expectedPrefix ||
+				deleter.DeleteCalls[0][1] != tt.expectedPattern {
+				t.Errorf("Expected DeleteKeys(%s, %s), got %v", tt.expectedPrefix, tt.expectedPattern, deleter.DeleteCalls)
+			}
+			var response deleteKeysResponse
+			if err := json.NewDecoder(responseRecorder.Body).Decode(&response); err != nil {
+				t.Fatal(err)
+			}
+			if response.Deleted != 3 {
+				t.Errorf("Expected 3 deleted keys, got %d", response.Deleted)
+			}
+		})
+	}
+}
+
+func TestServer_ListKeys(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name           string
+		query          string
+		err            error
+		expectedStatus int
+		expectedOpts   ScanOptions
+	}{
+		{
+			name:           "Should list keys with default options",
+			query:          "",
+			expectedStatus: http.StatusOK,
+			expectedOpts:   ScanOptions{Limit: DefaultScanLimit},
+		},
+		{
+			name:           "Should pass prefix, cursor and limit to the cache",
+			query:          "?prefix=user:&cursor=abc&limit=5&ttl=true",
+			expectedStatus: http.StatusOK,
+			expectedOpts:   ScanOptions{Prefix: "user:", Cursor: "abc", Limit: 5, WithTTL: true},
+		},
+		{
+			name:           "Should cap the limit",
+			query:          "?limit=100000",
+			expectedStatus: http.StatusOK,
+			expectedOpts:   ScanOptions{Limit: MaxScanLimit},
+		},
+		{
+			name:           "Should return 400 for an invalid limit",
+			query:          "?limit=-1",
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "Should return 400 for an invalid cursor",
+			query:          "?cursor=invalid",
+			err:            ErrInvalidCursor,
+			expectedStatus: http.StatusBadRequest,
+			expectedOpts:   ScanOptions{Cursor: "invalid", Limit: DefaultScanLimit},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			scanner := &mockScanner{
+				Keys: []KeyInfo{{Key: "user:1", TTL: 2 * time.Second}, {Key: "user:2", TTL: -1}},
+				Next: "next",
+				Err:  tt.err,
+			}
+			logger := zerolog.Nop()
+			handler := New(&logger, scanner)
+			req := httptest.NewRequest(http.MethodGet, "/_keys"+tt.query, nil)
+			responseRecorder := httptest.NewRecorder()
+			handler.ServeHTTP(responseRecorder, req)
+			if responseRecorder.Code != tt.expectedStatus {
+				t.Fatalf("Expected status code %d, got %d", tt.expectedStatus, responseRecorder.Code)
+			}
+			if tt.expectedOpts.Limit != 0 && (len(scanner.ScanCalls) != 1 || scanner.ScanCalls[0] != tt.expectedOpts) {
+				t.Errorf("Expected ScanKeys to be called with %+v, got %+v", tt.expectedOpts, scanner.ScanCalls)
+			}
+		})
+	}
+}
+
+func TestServer_ListKeysResponse(t *testing.T) {
+	t.Parallel()
+	scanner := &mockScanner{
+		Keys: []KeyInfo{{Key: "user:1", TTL: 2 * time.Second}, {Key: "user:2", TTL: -1}},
+		Next: "next",
+	}
+	logger := zerolog.Nop()
+	handler := New(&logger, scanner)
+	req := httptest.NewRequest(http.MethodGet, "/_keys?ttl=true", nil)
+	responseRecorder := httptest.NewRecorder()
+	handler.ServeHTTP(responseRecorder, req)
+
+	var response keysResponse
+	if err := json.NewDecoder(responseRecorder.Body).Decode(&response); err != nil {
+		t.Fatal(err)
+	}
+	if response.Cursor != "next" {
+		t.Errorf("Expected cursor 'next', got %s", response.Cursor)
+	}
+	if len(response.Keys) != 2 {
+		t.Fatalf("Expected 2 keys, got %d", len(response.Keys))
+	}
+	if *response.Keys[0].TTLMs != 2000 || *response.Keys[1].TTLMs != -1 {
+		t.Errorf("Expected TTLs 2000 and -1, got %d and %d", *response.Keys[0].TTLMs, *response.Keys[1].TTLMs)
+	}
+}
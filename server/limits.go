@@ -0,0 +1,120 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/rs/zerolog"
+)
+
+const (
+	// reservedKeyPrefix starts the routes acting on many keys at once, e.g. `/_keys`, so keys can not start with it
+	reservedKeyPrefix = "_"
+
+	errRequestEntityTooLargeResponse = "Request Entity Too Large"
+)
+
+// probeKeys are the paths of the probes served by Health in front of the keys, so keys can not be named after them
+var probeKeys = []string{"livez", "healthz", "readyz"}
+
+var (
+	errReservedKey = fmt.Errorf("keys starting with %q are reserved", reservedKeyPrefix)
+	errProbeKey    = fmt.Errorf("keys %s are reserved for the probes", strings.Join(probeKeys, ", "))
+	errKeyTooLong  = errors.New("key is too long")
+	errKeyPattern  = errors.New("key does not match the allowed pattern")
+	errOrigin      = errors.New("origin is not allowed")
+)
+
+// Limits bounds what clients can store and watch. Zero values mean no limit.
+type Limits struct {
+	// MaxValueBytes is the maximum size of a stored value
+	MaxValueBytes int64
+	// MaxKeyLength is the maximum length of a key in bytes
+	MaxKeyLength int
+	// KeyPattern is matched against every key if set
+	KeyPattern *regexp.Regexp
+	// WatchBuffer is the number of events queued per watcher, DefaultWatchBuffer if not set
+	WatchBuffer int
+	// WatchOrigins are the origins of the pages allowed to open a WebSocket to `GET /_watch` besides the one of the
+	// server, * allowing any of them
+	WatchOrigins []string
+}
+
+// WithLimits applies limits to the keys and values of the default cache and of every namespace
+func WithLimits(limits Limits) Option {
+	return func(o *options) {
+		o.limits = limits
+	}
+}
+
+// validateKey checks key against the reserved prefix, the probes and the limits
+func (l Limits) validateKey(key string) error {
+	if strings.HasPrefix(key, reservedKeyPrefix) {
+		return errReservedKey
+	}
+	if slices.Contains(probeKeys, key) {
+		return errProbeKey
+	}
+	if l.MaxKeyLength > 0 && len(key) > l.MaxKeyLength {
+		return errKeyTooLong
+	}
+	if l.KeyPattern != nil && !l.KeyPattern.MatchString(key) {
+		return errKeyPattern
+	}
+	return nil
+}
+
+// pathKey returns the key of the request, or writes a 400 response and returns false if it is missing or invalid
+func (l Limits) pathKey(w http.ResponseWriter, r *http.Request, logger *zerolog.Logger) (string, bool) {
+	key := r.PathValue(keyPathName)
+	if key == "" {
+		http.Error(w, errBadRequestResponse, http.StatusBadRequest)
+		return "", false
+	}
+	if err := l.validateKey(key); err != nil {
+		logger.Debug().Err(err).Str("key", key).Msg("Invalid key")
+		http.Error(w, errBadRequestResponse, http.StatusBadRequest)
+		return "", false
+	}
+	return key, true
+}
+
+// readValue reads the body of the request up to MaxValueBytes, or writes a 400 or 413 response and returns false
+func (l Limits) readValue(w http.ResponseWriter, r *http.Request, logger *zerolog.Logger) ([]byte, bool) {
+	body := r.Body
+	if l.MaxValueBytes > 0 {
+		body = http.MaxBytesReader(w, r.Body, l.MaxValueBytes)
+	}
+	value, err := io.ReadAll(body)
+	if err != nil {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			logger.Debug().Int64("limit", maxBytesErr.Limit).Msg("Request body too large")
+			http.Error(w, errRequestEntityTooLargeResponse, http.StatusRequestEntityTooLarge)
+			return nil, false
+		}
+		logger.Warn().Err(err).Msg("Failed to read request body")
+		http.Error(w, errBadRequestResponse, http.StatusBadRequest)
+		return nil, false
+	}
+	return value, true
+}
+
+// checkOrigin checks the Origin header of a WebSocket request against the host of the request and WatchOrigins.
+// Clients that are not browsers do not send the header, and are allowed.
+func (l Limits) checkOrigin(r *http.Request) error {
+	origin := r.Header.Get("Origin")
+	if origin == "" || slices.Contains(l.WatchOrigins, "*") || slices.Contains(l.WatchOrigins, origin) {
+		return nil
+	}
+	if parsed, err := url.Parse(origin); err == nil && parsed.Host == r.Host {
+		return nil
+	}
+	return errOrigin
+}
@@ -0,0 +1,98 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/rs/zerolog"
+)
+
+func TestLimits_validateKey(t *testing.T) {
+	t.Parallel()
+	limits := Limits{MaxKeyLength: 8, KeyPattern: regexp.MustCompile(`^[a-z0-9:]+$`)}
+	tests := []struct {
+		key     string
+		wantErr error
+	}{
+		{key: "user:1"},
+		{key: "_keys", wantErr: errReservedKey},
+		{key: "healthz", wantErr: errProbeKey},
+		{key: "readyz", wantErr: errProbeKey},
+		{key: "user:1234", wantErr: errKeyTooLong},
+		{key: "User:1", wantErr: errKeyPattern},
+	}
+	for _, tt := range tests {
+		t.Run(tt.key, func(t *testing.T) {
+			if err := limits.validateKey(tt.key); err != tt.wantErr {
+				t.Errorf("Expected error %v, got %v", tt.wantErr, err)
+			}
+		})
+	}
+	if err := (Limits{}).validateKey(strings.Repeat("a", 10_000)); err != nil {
+		t.Errorf("Expected no limit on the key length by default, got %v", err)
+	}
+}
+
+func TestLimits_checkOrigin(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name    string
+		origins []string
+		origin  string
+		wantErr error
+	}{
+		{name: "no origin"},
+		{name: "same host", origin: "http://cache.example.com"},
+		{name: "other host", origin: "https://evil.example.com", wantErr: errOrigin},
+		{name: "allowed origin", origins: []string{"https://app.example.com"}, origin: "https://app.example.com"},
+		{name: "other scheme", origins: []string{"https://app.example.com"}, origin: "http://app.example.com",
+			wantErr: errOrigin},
+		{name: "any origin", origins: []string{"*"}, origin: "https://evil.example.com"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "http://cache.example.com/_watch", nil)
+			if tt.origin != "" {
+				req.Header.Set("Origin", tt.origin)
+			}
+			if err := (Limits{WatchOrigins: tt.origins}).checkOrigin(req); err != tt.wantErr {
+				t.Errorf("Expected error %v, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestServer_Limits(t *testing.T) {
+	t.Parallel()
+	logger := zerolog.Nop()
+	limits := Limits{MaxValueBytes: 4, MaxKeyLength: 8}
+	tests := []struct {
+		name       string
+		method     string
+		target     string
+		body       string
+		wantStatus int
+	}{
+		{name: "value within limit", method: http.MethodPost, target: "/key", body: "1234", wantStatus: http.StatusCreated},
+		{name: "value too large", method: http.MethodPost, target: "/key", body: "12345", wantStatus: http.StatusRequestEntityTooLarge},
+		{name: "key too long", method: http.MethodPost, target: "/key-too-long", body: "1", wantStatus: http.StatusBadRequest},
+		{name: "reserved key", method: http.MethodPost, target: "/_key", body: "1", wantStatus: http.StatusBadRequest},
+		{name: "get reserved key", method: http.MethodGet, target: "/_key", wantStatus: http.StatusBadRequest},
+		{name: "namespace value too large", method: http.MethodPost, target: "/ns/team-a/key", body: "12345", wantStatus: http.StatusRequestEntityTooLarge},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler := New(&logger, &mockCache{}, WithLimits(limits),
+				WithNamespaces(map[string]Cache{"team-a": &mockCache{}}))
+			req := httptest.NewRequest(tt.method, tt.target, strings.NewReader(tt.body))
+			responseRecorder := httptest.NewRecorder()
+			handler.ServeHTTP(responseRecorder, req)
+			if responseRecorder.Code != tt.wantStatus {
+				t.Errorf("Expected status code %d, got %d", tt.wantStatus, responseRecorder.Code)
+			}
+		})
+	}
+}
@@ -0,0 +1,56 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/rs/zerolog"
+)
+
+// LogLevelController reads and changes the log levels of the components of the server while it runs
+type LogLevelController interface {
+	// LogLevels returns the effective level of every component, including the default level
+	LogLevels() map[string]string
+	// SetLogLevels changes the levels of the given components and leaves the others. An empty level makes a
+	// component follow the default level again. Nothing changes if any of the levels is invalid.
+	SetLogLevels(levels map[string]string) error
+}
+
+type logLevelsBody struct {
+	Levels map[string]string `json:"levels"`
+}
+
+// WithLogLevels serves the log levels at `GET /_admin/log/levels` and lets them change with
+// `PUT /_admin/log/levels`
+func WithLogLevels(levels LogLevelController) Option {
+	return func(o *options) {
+		o.logLevels = levels
+	}
+}
+
+// getLogLevels handles `GET /_admin/log/levels`
+func getLogLevels(levels LogLevelController, logger *zerolog.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		logger := requestLogger(r, logger)
+		writeJSON(w, http.StatusOK, logLevelsBody{Levels: levels.LogLevels()}, logger)
+	}
+}
+
+// setLogLevels handles `PUT /_admin/log/levels`
+func setLogLevels(levels LogLevelController, logger *zerolog.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		logger := requestLogger(r, logger)
+		var body logLevelsBody
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			http.Error(w, errBadRequestResponse, http.StatusBadRequest)
+			return
+		}
+		if err := levels.SetLogLevels(body.Levels); err != nil {
+			logger.Debug().Err(err).Msg("Invalid log levels")
+			http.Error(w, errBadRequestResponse, http.StatusBadRequest)
+			return
+		}
+		logger.Info().Interface("levels", body.Levels).Msg("Changed log levels")
+		writeJSON(w, http.StatusOK, logLevelsBody{Levels: levels.LogLevels()}, logger)
+	}
+}
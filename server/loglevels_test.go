@@ -0,0 +1,72 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/rs/zerolog"
+)
+
+type mockLogLevels struct {
+	Levels map[string]string
+}
+
+func (m *mockLogLevels) LogLevels() map[string]string {
+	return m.Levels
+}
+
+func (m *mockLogLevels) SetLogLevels(levels map[string]string) error {
+	for component := range levels {
+		if _, ok := m.Levels[component]; !ok {
+			return errors.New("unknown component")
+		}
+	}
+	for component, level := range levels {
+		m.Levels[component] = level
+	}
+	return nil
+}
+
+func TestServer_LogLevels(t *testing.T) {
+	t.Parallel()
+	levels := &mockLogLevels{Levels: map[string]string{"default": "info", "server": "info"}}
+	logger := zerolog.Nop()
+	handler := New(&logger, &mockCache{}, WithLogLevels(levels))
+
+	tests := []struct {
+		name       string
+		method     string
+		body       string
+		wantStatus int
+		wantServer string
+	}{
+		{name: "get", method: http.MethodGet, wantStatus: http.StatusOK, wantServer: "info"},
+		{name: "set", method: http.MethodPut, body: `{"levels":{"server":"debug"}}`, wantStatus: http.StatusOK, wantServer: "debug"},
+		{name: "unknown component", method: http.MethodPut, body: `{"levels":{"nope":"debug"}}`, wantStatus: http.StatusBadRequest},
+		{name: "invalid body", method: http.MethodPut, body: `levels`, wantStatus: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, "/_admin/log/levels", strings.NewReader(tt.body))
+			responseRecorder := httptest.NewRecorder()
+			handler.ServeHTTP(responseRecorder, req)
+			if responseRecorder.Code != tt.wantStatus {
+				t.Fatalf("Expected status code %d, got %d", tt.wantStatus, responseRecorder.Code)
+			}
+			if tt.wantStatus != http.StatusOK {
+				return
+			}
+			var response logLevelsBody
+			if err := json.Unmarshal(responseRecorder.Body.Bytes(), &response); err != nil {
+				t.Fatal(err)
+			}
+			if response.Levels["server"] != tt.wantServer {
+				t.Errorf("Expected the server level %s, got %+v", tt.wantServer, response.Levels)
+			}
+		})
+	}
+}
@@ -0,0 +1,96 @@
+package server
+
+import (
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+const namespacePathName = "namespace"
+
+// StatsProvider is implemented by caches that can report their size and settings
+type StatsProvider interface {
+	Stats() (Stats, error)
+}
+
+// Stats describes the content and the settings of a cache
+type Stats struct {
+	// Keys is the number of keys in the cache
+	Keys int
+	// TTL is the time to live of the entries, 0 means no expiration
+	TTL time.Duration
+	// MaxSize is the maximum number of keys, 0 means unlimited
+	MaxSize int
+	// EvictionInterval is the interval of the background removal of expired keys, 0 if the backend expires keys
+	// on its own
+	EvictionInterval time.Duration
+	// Bytes is the size of the stored keys and values
+	Bytes int64
+	// Hits and Misses count the reads of keys that were found and not found
+	Hits   uint64
+	Misses uint64
+	// Evictions counts the keys removed because they expired or the cache was full
+	Evictions uint64
+	// Oldest is when the oldest key was created, zero if unknown or the cache is empty
+	Oldest time.Time
+}
+
+type namespaceResponse struct {
+	Name               string `json:"name"`
+	Keys               *int   `json:"keys,omitempty"`
+	TTLSec             *int64 `json:"ttl_seconds,omitempty"`
+	MaxSize            *int   `json:"max_size,omitempty"`
+	EvictionIntervalMs *int64 `json:"eviction_interval_ms,omitempty"`
+}
+
+type namespacesResponse struct {
+	Namespaces []namespaceResponse `json:"namespaces"`
+}
+
+// namespaceRouter serves `/ns/{namespace}/...` with the routes of the namespace's own handler
+func namespaceRouter(handlers map[string]http.Handler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		namespace := r.PathValue(namespacePathName)
+		handler, ok := handlers[namespace]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		recordNamespace(r, namespace)
+		handler.ServeHTTP(w, r)
+	}
+}
+
+// listNamespaces handles `GET /_namespaces`
+func listNamespaces(namespaces map[string]Cache, logger *zerolog.Logger) http.HandlerFunc {
+	names := make([]string, 0, len(namespaces))
+	for name := range namespaces {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return func(w http.ResponseWriter, r *http.Request) {
+		logger := requestLogger(r, logger)
+		response := namespacesResponse{Namespaces: make([]namespaceResponse, 0, len(names))}
+		for _, name := range names {
+			namespace := namespaceResponse{Name: name}
+			if provider, ok := namespaces[name].(StatsProvider); ok {
+				stats, err := provider.Stats()
+				if err != nil {
+					logger.Error().Err(err).Str("namespace", name).Msg("Failed to get namespace stats")
+					http.Error(w, errInternalServerResponse, http.StatusInternalServerError)
+					return
+				}
+				ttlSec := int64(stats.TTL / time.Second)
+				evictionIntervalMs := stats.EvictionInterval.Milliseconds()
+				namespace.Keys = &stats.Keys
+				namespace.TTLSec = &ttlSec
+				namespace.MaxSize = &stats.MaxSize
+				namespace.EvictionIntervalMs = &evictionIntervalMs
+			}
+			response.Namespaces = append(response.Namespaces, namespace)
+		}
+		writeJSON(w, http.StatusOK, response, logger)
+	}
+}
@@ -0,0 +1,104 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+type mockStatsCache struct {
+	mockCache
+	StatsValue Stats
+}
+
+func (m *mockStatsCache) Stats() (Stats, error) {
+	return m.StatsValue, nil
+}
+
+func TestServer_Namespaces(t *testing.T) {
+	t.Parallel()
+	root := &mockCache{}
+	teamA := &mockCache{Hit: true, GetValue: "team-a-value"}
+	teamB := &mockCache{}
+	logger := zerolog.Nop()
+	handler := New(&logger, root, WithNamespaces(map[string]Cache{"team-a": teamA, "team-b": teamB}))
+
+	req := httptest.NewRequest(http.MethodGet, "/ns/team-a/key", nil)
+	responseRecorder := httptest.NewRecorder()
+	handler.ServeHTTP(responseRecorder, req)
+	if responseRecorder.Code != http.StatusOK {
+		t.Fatalf("Expected status code %d, got %d", http.StatusOK, responseRecorder.Code)
+	}
+	if responseRecorder.Body.String() != "team-a-value" {
+		t.Errorf("Expected the value of the namespace, got %s", responseRecorder.Body.String())
+	}
+	if len(teamA.GetCalls) != 1 || teamA.GetCalls[0] != "key" {
+		t.Errorf("Expected Get to be called on the namespace with 'key', got %v", teamA.GetCalls)
+	}
+
+	req = httptest.NewRequest(http.MethodPost, "/ns/team-b/key", strings.NewReader("value"))
+	responseRecorder = httptest.NewRecorder()
+	handler.ServeHTTP(responseRecorder, req)
+	if responseRecorder.Code != http.StatusCreated {
+		t.Fatalf("Expected status code %d, got %d", http.StatusCreated, responseRecorder.Code)
+	}
+	if len(teamB.SetCalls) != 1 || len(root.SetCalls) != 0 {
+		t.Errorf("Expected Set to be called on the namespace only")
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/ns/unknown/key", nil)
+	responseRecorder = httptest.NewRecorder()
+	handler.ServeHTTP(responseRecorder, req)
+	if responseRecorder.Code != http.StatusNotFound {
+		t.Errorf("Expected status code %d for an unknown namespace, got %d", http.StatusNotFound, responseRecorder.Code)
+	}
+}
+
+func TestServer_ListNamespaces(t *testing.T) {
+	t.Parallel()
+	teamA := &mockStatsCache{StatsValue: Stats{Keys: 3, TTL: time.Minute, MaxSize: 10, EvictionInterval: time.Second}}
+	teamB := &mockCache{}
+	logger := zerolog.Nop()
+	handler := New(&logger, &mockCache{}, WithNamespaces(map[string]Cache{"team-b": teamB, "team-a": teamA}))
+
+	req := httptest.NewRequest(http.MethodGet, "/_namespaces", nil)
+	responseRecorder := httptest.NewRecorder()
+	handler.ServeHTTP(responseRecorder, req)
+	if responseRecorder.Code != http.StatusOK {
+		t.Fatalf("Expected status code %d, got %d", http.StatusOK, responseRecorder.Code)
+	}
+	var response namespacesResponse
+	if err := json.NewDecoder(responseRecorder.Body).Decode(&response); err != nil {
+		t.Fatal(err)
+	}
+	if len(response.Namespaces) != 2 {
+		t.Fatalf("Expected 2 namespaces, got %d", len(response.Namespaces))
+	}
+	first, second := response.Namespaces[0], response.Namespaces[1]
+	if first.Name != "team-a" || second.Name != "team-b" {
+		t.Errorf("Expected namespaces sorted by name, got %s and %s", first.Name, second.Name)
+	}
+	if *first.Keys != 3 || *first.TTLSec != 60 || *first.MaxSize != 10 || *first.EvictionIntervalMs != 1000 {
+		t.Errorf("Unexpected stats %+v", first)
+	}
+	if second.Keys != nil {
+		t.Errorf("Expected no stats for a cache without stats")
+	}
+}
+
+func TestServer_NoNamespaces(t *testing.T) {
+	t.Parallel()
+	logger := zerolog.Nop()
+	handler := New(&logger, &mockCache{})
+	req := httptest.NewRequest(http.MethodGet, "/_namespaces", nil)
+	responseRecorder := httptest.NewRecorder()
+	handler.ServeHTTP(responseRecorder, req)
+	if responseRecorder.Code == http.StatusOK {
+		t.Errorf("Expected /_namespaces not to be served without namespaces")
+	}
+}
@@ -1,7 +1,7 @@
 package server
 
 import (
-	"io"
+	"context"
 	"net/http"
 
 	"github.com/rs/zerolog"
@@ -9,10 +9,13 @@ import (
 )
 
 const (
-	keyPathName               = "key"
-	errBadRequestResponse     = "Bad Request"
-	errNotFoundResponse       = "Key Not Found"
-	errInternalServerResponse = "Internal Server Error"
+	keyPathName                   = "key"
+	errBadRequestResponse         = "Bad Request"
+	errNotFoundResponse           = "Key Not Found"
+	errInternalServerResponse     = "Internal Server Error"
+	errPreconditionFailedResponse = "Precondition Failed"
+	errConflictResponse           = "Conflict"
+	errOverflowResponse           = "Integer Overflow"
 )
 
 type Cache interface {
@@ -20,28 +23,122 @@ type Cache interface {
 	Get(key string) (string, bool)
 }
 
-func New(logger *zerolog.Logger, cache Cache) http.Handler {
+// VersionedCache is implemented by caches that keep a monotonically increasing version per entry. It lets the
+// handlers apply conditional writes atomically instead of doing a racy Get followed by a Set.
+type VersionedCache interface {
+	Cache
+	// GetWithVersion returns the value, its version and whether the key was found. Missing keys have version 0.
+	GetWithVersion(key string) (string, uint64, bool)
+	// CompareAndSwap stores value only if the current version of key is expectedVersion, returning the new version.
+	// An expectedVersion of 0 means the key must not exist.
+	CompareAndSwap(key string, expectedVersion uint64, value string) (uint64, bool, error)
+}
+
+// Option configures the handler returned by New
+type Option func(*options)
+
+type options struct {
+	namespaces map[string]Cache
+	limits     Limits
+	logLevels  LogLevelController
+	auditor    Auditor
+	readOnly   bool
+	cluster    ClusterStateProvider
+	streams    context.Context
+}
+
+// WithNamespaces serves each cache of the map under `/ns/{namespace}/` with the same routes as the default cache,
+// and lists them at `GET /_namespaces`
+func WithNamespaces(namespaces map[string]Cache) Option {
+	return func(o *options) {
+		o.namespaces = namespaces
+	}
+}
+
+// WithReadOnly serves only the routes that do not change the caches, for replicas whose data comes from elsewhere.
+// Writes are answered with 405 Method Not Allowed.
+func WithReadOnly() Option {
+	return func(o *options) {
+		o.readOnly = true
+	}
+}
+
+func New(logger *zerolog.Logger, cache Cache, opts ...Option) http.Handler {
+	o := options{auditor: nopAuditor{}}
+	for _, opt := range opts {
+		opt(&o)
+	}
 	mux := http.NewServeMux()
-	mux.HandleFunc("GET /{key}", get(cache, logger))
-	mux.HandleFunc("POST /{key}", store(cache, logger))
-	var handler http.Handler = mux
-	return handler
+	mux.HandleFunc("GET /{key}", get(cache, o.limits, logger))
+	if !o.readOnly {
+		mux.HandleFunc("POST /{key}", store(cache, o.limits, o.auditor, logger))
+	}
+	if counter, ok := cache.(Counter); ok && !o.readOnly {
+		mux.HandleFunc("POST /{key}/incr", increment(counter, o.limits, o.auditor, logger))
+	}
+	if scanner, ok := cache.(KeyScanner); ok {
+		mux.HandleFunc("GET /_keys", listKeys(scanner, logger))
+	}
+	if deleter, ok := cache.(KeyDeleter); ok && !o.readOnly {
+		mux.HandleFunc("DELETE /_keys", deleteKeys(deleter, o.auditor, logger))
+	}
+	if watcher, ok := cache.(Watcher); ok {
+		mux.HandleFunc("GET /_watch", watch(watcher, o.limits, o.streams, logger))
+	}
+	if tagCache, ok := cache.(TagCache); ok && !o.readOnly {
+		mux.HandleFunc("POST /_tags/{tag}/invalidate", invalidateTag(tagCache, o.auditor, logger))
+	}
+	registerAdmin(mux, cache, o.auditor, o.readOnly, logger)
+	if o.logLevels != nil {
+		mux.HandleFunc("GET /_admin/log/levels", getLogLevels(o.logLevels, logger))
+		mux.HandleFunc("PUT /_admin/log/levels", setLogLevels(o.logLevels, logger))
+	}
+	if o.cluster != nil {
+		mux.HandleFunc("GET /_admin/cluster", clusterState(o.cluster, logger))
+	}
+	if len(o.namespaces) > 0 {
+		handlers := make(map[string]http.Handler, len(o.namespaces))
+		for name, nsCache := range o.namespaces {
+			nsLogger := logger.With().Str("namespace", name).Logger()
+			nsOpts := []Option{
+				WithLimits(o.limits),
+				WithAuditor(namespaceAuditor{Auditor: o.auditor, namespace: name}),
+				WithStreamsContext(o.streams),
+			}
+			if o.readOnly {
+				nsOpts = append(nsOpts, WithReadOnly())
+			}
+			handlers[name] = http.StripPrefix("/ns/"+name, New(&nsLogger, nsCache, nsOpts...))
+		}
+		mux.HandleFunc("/ns/{namespace}/", namespaceRouter(handlers))
+		mux.HandleFunc("GET /_namespaces", listNamespaces(o.namespaces, logger))
+	}
+	return recordRoute(mux)
 }
 
-func get(cache Cache, logger *zerolog.Logger) http.HandlerFunc {
+func get(cache Cache, limits Limits, logger *zerolog.Logger) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		key := r.PathValue(keyPathName)
-		if key == "" {
-			http.Error(w, errBadRequestResponse, http.StatusBadRequest)
+		logger := requestLogger(r, logger)
+		key, ok := limits.pathKey(w, r, logger)
+		if !ok {
 			return
 		}
 		logger.Debug().Str("key", key).Msg("Received GET key request")
 		value, ok := cache.Get(key)
 		if !ok {
 			logger.Debug().Str("key", key).Msg("Cache miss.")
+			recordCache(r, false)
 			http.Error(w, errNotFoundResponse, http.StatusNotFound)
 			return
 		}
+		recordCache(r, true)
+		etag := computeETag(value)
+		w.Header().Set(headerETag, etag)
+		if matchesWeak(parseETags(r.Header.Values(headerIfNoneMatch)), etag) {
+			logger.Debug().Str("key", key).Msg("Cache hit, not modified.")
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
 		w.WriteHeader(http.StatusOK)
 		_, err := w.Write([]byte(value))
 		if err != nil {
@@ -51,18 +148,16 @@ func get(cache Cache, logger *zerolog.Logger) http.HandlerFunc {
 	}
 }
 
-func store(cache Cache, logger *zerolog.Logger) http.HandlerFunc {
+func store(cache Cache, limits Limits, auditor Auditor, logger *zerolog.Logger) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		key := r.PathValue(keyPathName)
-		if key == "" {
-			http.Error(w, errBadRequestResponse, http.StatusBadRequest)
+		logger := requestLogger(r, logger)
+		key, ok := limits.pathKey(w, r, logger)
+		if !ok {
 			return
 		}
 		logger.Debug().Str("key", key).Msg("Received Post key request")
-		value, err := io.ReadAll(r.Body)
-		if err != nil {
-			logger.Warn().Err(err).Msg("Failed to read request body")
-			http.Error(w, errBadRequestResponse, http.StatusBadRequest)
+		value, ok := limits.readValue(w, r, logger)
+		if !ok {
 			return
 		}
 		valueStr := string(value)
@@ -70,12 +165,42 @@ func store(cache Cache, logger *zerolog.Logger) http.HandlerFunc {
 			http.Error(w, errBadRequestResponse, http.StatusBadRequest)
 			return
 		}
-		err = cache.Set(key, valueStr)
+		tags := parseTags(r)
+		ttl, err := parseTTL(r)
 		if err != nil {
+			logger.Debug().Err(err).Str("key", key).Msg("Invalid store request")
+			http.Error(w, errBadRequestResponse, http.StatusBadRequest)
+			return
+		}
+		if _, ok := cache.(ExpiringCache); ttl > 0 && !ok {
+			logger.Debug().Str("key", key).Msg("The cache does not support a ttl per key")
+			http.Error(w, errBadRequestResponse, http.StatusBadRequest)
+			return
+		}
+		if hasPreconditions(r) {
+			swapped, err := conditionalSet(cache, r, key, valueStr, tags, ttl)
+			if err != nil {
+				logger.Error().Err(err).Msg("Failed to store value in cache")
+				http.Error(w, errInternalServerResponse, http.StatusInternalServerError)
+				return
+			}
+			if !swapped {
+				logger.Debug().Str("key", key).Msg("Precondition failed.")
+				http.Error(w, errPreconditionFailedResponse, http.StatusPreconditionFailed)
+				return
+			}
+			auditor.Audit(r, AuditEvent{Action: AuditSet, Key: key, ValueHash: hashValue(valueStr)})
+			w.Header().Set(headerETag, computeETag(valueStr))
+			w.WriteHeader(http.StatusCreated)
+			return
+		}
+		if err := setValue(cache, key, valueStr, tags, ttl); err != nil {
 			logger.Error().Err(err).Msg("Failed to store value in cache")
 			http.Error(w, errInternalServerResponse, http.StatusInternalServerError)
 			return
 		}
+		auditor.Audit(r, AuditEvent{Action: AuditSet, Key: key, ValueHash: hashValue(valueStr)})
+		w.Header().Set(headerETag, computeETag(valueStr))
 		w.WriteHeader(http.StatusCreated)
 	}
 }
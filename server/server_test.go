@@ -158,3 +158,189 @@ func TestServer_Post(t *testing.T) {
 		})
 	}
 }
+
+func TestServer_ConditionalGet(t *testing.T) {
+	t.Parallel()
+	cache := &mockCache{Hit: true, GetValue: "cache_value"}
+	logger := zerolog.Nop()
+	handler := New(&logger, cache)
+
+	req := httptest.NewRequest(http.MethodGet, "/user-id", nil)
+	responseRecorder := httptest.NewRecorder()
+	handler.ServeHTTP(responseRecorder, req)
+	etag := responseRecorder.Header().Get("ETag")
+	if etag == "" {
+		t.Fatalf("Expected an ETag header in the response")
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/user-id", nil)
+	req.Header.Set("If-None-Match", etag)
+	responseRecorder = httptest.NewRecorder()
+	handler.ServeHTTP(responseRecorder, req)
+	if responseRecorder.Code != http.StatusNotModified {
+		t.Fatalf("Expected status code %d, got %d", http.StatusNotModified, responseRecorder.Code)
+	}
+	if responseRecorder.Body.Len() != 0 {
+		t.Errorf("Expected an empty body, got %s", responseRecorder.Body.String())
+	}
+}
+
+func TestServer_ConditionalPost(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name           string
+		hit            bool
+		header         string
+		headerValue    string
+		expectedStatus int
+	}{
+		{
+			name:           "Should return 201 when If-Match matches the current value",
+			hit:            true,
+			header:         "If-Match",
+			headerValue:    computeETag("current"),
+			expectedStatus: http.StatusCreated,
+		},
+		{
+			name:           "Should return 412 when If-Match does not match the current value",
+			hit:            true,
+			header:         "If-Match",
+			headerValue:    computeETag("stale"),
+			expectedStatus: http.StatusPreconditionFailed,
+		},
+		{
+			name:           "Should return 412 when If-None-Match is * and the key exists",
+			hit:            true,
+			header:         "If-None-Match",
+			headerValue:    "*",
+			expectedStatus: http.StatusPreconditionFailed,
+		},
+		{
+			name:           "Should return 201 when If-None-Match is * and the key does not exist",
+			hit:            false,
+			header:         "If-None-Match",
+			headerValue:    "*",
+			expectedStatus: http.StatusCreated,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			cache := &mockCache{Hit: tt.hit, GetValue: "current"}
+			logger := zerolog.Nop()
+			handler := New(&logger, cache)
+			req := httptest.NewRequest(http.MethodPost, "/user-id", strings.NewReader("new"))
+			req.Header.Set(tt.header, tt.headerValue)
+			responseRecorder := httptest.NewRecorder()
+			handler.ServeHTTP(responseRecorder, req)
+			if responseRecorder.Code != tt.expectedStatus {
+				t.Fatalf("Expected status code %d, got %d", tt.expectedStatus, responseRecorder.Code)
+			}
+			expectedSetCalls := 0
+			if tt.expectedStatus == http.StatusCreated {
+				expectedSetCalls = 1
+				if responseRecorder.Header().Get("ETag") != computeETag("new") {
+					t.Errorf("Expected the ETag of the stored value in the response")
+				}
+			}
+			if len(cache.SetCalls) != expectedSetCalls {
+				t.Errorf("Expected cache.Set to be called %d times, got %d", expectedSetCalls, len(cache.SetCalls))
+			}
+		})
+	}
+}
+
+type mockVersionedCache struct {
+	mockCache
+	Version  uint64
+	CASCalls []uint64
+	Swapped  bool
+}
+
+func (m *mockVersionedCache) GetWithVersion(key string) (string, uint64, bool) {
+	value, ok := m.Get(key)
+	return value, m.Version, ok
+}
+
+func (m *mockVersionedCache) CompareAndSwap(key string, expectedVersion uint64, value string) (uint64, bool, error) {
+	m.CASCalls = append(m.CASCalls, expectedVersion)
+	if !m.Swapped {
+		return m.Version + 1, false, nil
+	}
+	return m.Version + 1, true, m.Set(key, value)
+}
+
+func TestServer_ConditionalPostWithVersionedCache(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name           string
+		swapped        bool
+		expectedStatus int
+	}{
+		{
+			name:           "Should return 201 when the value did not change in between",
+			swapped:        true,
+			expectedStatus: http.StatusCreated,
+		},
+		{
+			name:           "Should return 412 when the value changed after the precondition was checked",
+			swapped:        false,
+			expectedStatus: http.StatusPreconditionFailed,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			cache := &mockVersionedCache{
+				mockCache: mockCache{Hit: true, GetValue: "current"},
+				Version:   7,
+				Swapped:   tt.swapped,
+			}
+			logger := zerolog.Nop()
+			handler := New(&logger, cache)
+			req := httptest.NewRequest(http.MethodPost, "/user-id", strings.NewReader("new"))
+			req.Header.Set("If-Match", computeETag("current"))
+			responseRecorder := httptest.NewRecorder()
+			handler.ServeHTTP(responseRecorder, req)
+			if responseRecorder.Code != tt.expectedStatus {
+				t.Fatalf("Expected status code %d, got %d", tt.expectedStatus, responseRecorder.Code)
+			}
+			if len(cache.CASCalls) != 1 || cache.CASCalls[0] != 7 {
+				t.Errorf("Expected CompareAndSwap to be called once with version 7, got %v", cache.CASCalls)
+			}
+		})
+	}
+}
+
+func TestServer_ReadOnly(t *testing.T) {
+	t.Parallel()
+	logger := zerolog.Nop()
+	handler := New(&logger, &mockAdminCache{}, WithReadOnly(), WithLogLevels(&mockLogLevels{Levels: map[string]string{}}),
+		WithNamespaces(map[string]Cache{"team-a": &mockCounter{}}))
+	tests := []struct {
+		name           string
+		method         string
+		target         string
+		expectedStatus int
+	}{
+		{name: "Should serve reads", method: http.MethodGet, target: "/key", expectedStatus: http.StatusNotFound},
+		{name: "Should reject sets", method: http.MethodPost, target: "/key", expectedStatus: http.StatusMethodNotAllowed},
+		{name: "Should reject sets in namespaces", method: http.MethodPost, target: "/ns/team-a/key", expectedStatus: http.StatusMethodNotAllowed},
+		{name: "Should not serve increments", method: http.MethodPost, target: "/ns/team-a/key/incr", expectedStatus: http.StatusNotFound},
+		{name: "Should not serve flushes", method: http.MethodPost, target: "/_admin/flush", expectedStatus: http.StatusNotFound},
+		{name: "Should serve stats", method: http.MethodGet, target: "/_admin/stats", expectedStatus: http.StatusOK},
+		{name: "Should serve log levels", method: http.MethodPut, target: "/_admin/log/levels", expectedStatus: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.target, strings.NewReader("value"))
+			responseRecorder := httptest.NewRecorder()
+			handler.ServeHTTP(responseRecorder, req)
+			if responseRecorder.Code != tt.expectedStatus {
+				t.Errorf("Expected status code %d, got %d", tt.expectedStatus, responseRecorder.Code)
+			}
+		})
+	}
+}
@@ -0,0 +1,80 @@
+package server
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+const (
+	headerCacheTags = "Cache-Tags"
+	tagPathName     = "tag"
+)
+
+// TagCache is implemented by caches that can attach tags to their entries and invalidate every entry carrying a tag
+type TagCache interface {
+	VersionedCache
+	// SetWithTags stores the value and attaches the tags to it, replacing the tags of the previous value
+	SetWithTags(key string, value string, tags []string) error
+	// CompareAndSwapWithTags is CompareAndSwap attaching the tags to the new value
+	CompareAndSwapWithTags(key string, expectedVersion uint64, value string, tags []string) (uint64, bool, error)
+	// InvalidateTag removes every entry carrying the tag and returns how many were removed
+	InvalidateTag(tag string) (int, error)
+}
+
+type invalidateTagResponse struct {
+	Invalidated int `json:"invalidated"`
+}
+
+// parseTags returns the de-duplicated tags of the comma separated Cache-Tags headers of the request
+func parseTags(r *http.Request) []string {
+	var tags []string
+	seen := make(map[string]bool)
+	for _, value := range r.Header.Values(headerCacheTags) {
+		for _, tag := range strings.Split(value, ",") {
+			tag = strings.TrimSpace(tag)
+			if tag == "" || seen[tag] {
+				continue
+			}
+			seen[tag] = true
+			tags = append(tags, tag)
+		}
+	}
+	return tags
+}
+
+// setValue stores the value with its tags if there are any and the cache supports them, for ttl if it is not 0. The
+// caller checks that a cache given a ttl supports it.
+func setValue(cache Cache, key string, value string, tags []string, ttl time.Duration) error {
+	if expiring, ok := cache.(ExpiringCache); ok && ttl > 0 {
+		return expiring.SetWithTTL(key, value, tags, ttl)
+	}
+	if tagCache, ok := cache.(TagCache); ok && len(tags) > 0 {
+		return tagCache.SetWithTags(key, value, tags)
+	}
+	return cache.Set(key, value)
+}
+
+// invalidateTag handles `POST /_tags/{tag}/invalidate`
+func invalidateTag(cache TagCache, auditor Auditor, logger *zerolog.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		logger := requestLogger(r, logger)
+		tag := r.PathValue(tagPathName)
+		if tag == "" {
+			http.Error(w, errBadRequestResponse, http.StatusBadRequest)
+			return
+		}
+		logger.Debug().Str("tag", tag).Msg("Received invalidate tag request")
+		invalidated, err := cache.InvalidateTag(tag)
+		if err != nil {
+			logger.Error().Err(err).Str("tag", tag).Msg("Failed to invalidate tag")
+			http.Error(w, errInternalServerResponse, http.StatusInternalServerError)
+			return
+		}
+		logger.Info().Str("tag", tag).Int("invalidated", invalidated).Msg("Invalidated tag")
+		auditor.Audit(r, AuditEvent{Action: AuditInvalidateTag, Tag: tag, Count: invalidated})
+		writeJSON(w, http.StatusOK, invalidateTagResponse{Invalidated: invalidated}, logger)
+	}
+}
@@ -0,0 +1,103 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/rs/zerolog"
+)
+
+type mockTagCache struct {
+	mockVersionedCache
+	SetTags         [][]string
+	CASTags         [][]string
+	InvalidateCalls []string
+	Invalidated     int
+}
+
+func (m *mockTagCache) SetWithTags(key string, value string, tags []string) error {
+	m.SetTags = append(m.SetTags, tags)
+	return m.Set(key, value)
+}
+
+func (m *mockTagCache) CompareAndSwapWithTags(key string, expectedVersion uint64, value string, tags []string) (uint64, bool, error) {
+	m.CASTags = append(m.CASTags, tags)
+	return m.CompareAndSwap(key, expectedVersion, value)
+}
+
+func (m *mockTagCache) InvalidateTag(tag string) (int, error) {
+	m.InvalidateCalls = append(m.InvalidateCalls, tag)
+	return m.Invalidated, nil
+}
+
+func TestParseTags(t *testing.T) {
+	req := httptest.NewRequest(http.MethodPost, "/key", nil)
+	req.Header.Add("Cache-Tags", "product:12, category:4,,")
+	req.Header.Add("Cache-Tags", "category:4, brand:1")
+	expected := []string{"product:12", "category:4", "brand:1"}
+	if got := parseTags(req); !reflect.DeepEqual(got, expected) {
+		t.Errorf("Expected tags %v, got %v", expected, got)
+	}
+
+	req = httptest.NewRequest(http.MethodPost, "/key", nil)
+	if got := parseTags(req); got != nil {
+		t.Errorf("Expected no tags, got %v", got)
+	}
+}
+
+func TestServer_PostWithTags(t *testing.T) {
+	t.Parallel()
+	cache := &mockTagCache{mockVersionedCache: mockVersionedCache{Swapped: true}}
+	logger := zerolog.Nop()
+	handler := New(&logger, cache)
+
+	req := httptest.NewRequest(http.MethodPost, "/page:1", strings.NewReader("value"))
+	req.Header.Set("Cache-Tags", "product:12, category:4")
+	responseRecorder := httptest.NewRecorder()
+	handler.ServeHTTP(responseRecorder, req)
+	if responseRecorder.Code != http.StatusCreated {
+		t.Fatalf("Expected status code %d, got %d", http.StatusCreated, responseRecorder.Code)
+	}
+	if len(cache.SetTags) != 1 || !reflect.DeepEqual(cache.SetTags[0], []string{"product:12", "category:4"}) {
+		t.Errorf("Expected SetWithTags to be called with the tags, got %v", cache.SetTags)
+	}
+
+	req = httptest.NewRequest(http.MethodPost, "/page:2", strings.NewReader("value"))
+	req.Header.Set("Cache-Tags", "product:12")
+	req.Header.Set("If-None-Match", "*")
+	responseRecorder = httptest.NewRecorder()
+	handler.ServeHTTP(responseRecorder, req)
+	if responseRecorder.Code != http.StatusCreated {
+		t.Fatalf("Expected status code %d, got %d", http.StatusCreated, responseRecorder.Code)
+	}
+	if len(cache.CASTags) != 1 || !reflect.DeepEqual(cache.CASTags[0], []string{"product:12"}) {
+		t.Errorf("Expected CompareAndSwapWithTags to be called with the tags, got %v", cache.CASTags)
+	}
+}
+
+func TestServer_InvalidateTag(t *testing.T) {
+	t.Parallel()
+	cache := &mockTagCache{Invalidated: 2}
+	logger := zerolog.Nop()
+	handler := New(&logger, cache)
+	req := httptest.NewRequest(http.MethodPost, "/_tags/product:12/invalidate", nil)
+	responseRecorder := httptest.NewRecorder()
+	handler.ServeHTTP(responseRecorder, req)
+	if responseRecorder.Code != http.StatusOK {
+		t.Fatalf("Expected status code %d, got %d", http.StatusOK, responseRecorder.Code)
+	}
+	if len(cache.InvalidateCalls) != 1 || cache.InvalidateCalls[0] != "product:12" {
+		t.Errorf("Expected InvalidateTag to be called with 'product:12', got %v", cache.InvalidateCalls)
+	}
+	var response invalidateTagResponse
+	if err := json.NewDecoder(responseRecorder.Body).Decode(&response); err != nil {
+		t.Fatal(err)
+	}
+	if response.Invalidated != 2 {
+		t.Errorf("Expected 2 invalidated keys, got %d", response.Invalidated)
+	}
+}
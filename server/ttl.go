@@ -0,0 +1,37 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// ExpiringCache is implemented by caches that can give an entry a time to live of its own
+type ExpiringCache interface {
+	TagCache
+	// SetWithTTL is SetWithTags keeping the value for ttl, 0 means the default TTL of the cache
+	SetWithTTL(key string, value string, tags []string, ttl time.Duration) error
+	// CompareAndSwapWithTTL is CompareAndSwapWithTags keeping the new value for ttl
+	CompareAndSwapWithTTL(key string, expectedVersion uint64, value string, tags []string,
+		ttl time.Duration) (uint64, bool, error)
+}
+
+// maxTTLSec bounds the TTL of the requests, so the expiration stays in the range of time.Time
+const maxTTLSec = 100 * 365 * 24 * 60 * 60
+
+// parseTTL returns the TTL in seconds of the ttl query parameter of the request, 0 if it has none
+func parseTTL(r *http.Request) (time.Duration, error) {
+	query := r.URL.Query()
+	if !query.Has(ttlQueryName) {
+		return 0, nil
+	}
+	ttlSec, err := strconv.ParseInt(query.Get(ttlQueryName), 10, 64)
+	if err != nil {
+		return 0, err
+	}
+	if ttlSec < 0 || ttlSec > maxTTLSec {
+		return 0, fmt.Errorf("ttl must be between 0 and %d seconds", maxTTLSec)
+	}
+	return time.Duration(ttlSec) * time.Second, nil
+}
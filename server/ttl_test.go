@@ -0,0 +1,78 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+type mockExpiringCache struct {
+	mockTagCache
+	SetTTLs []time.Duration
+	CASTTLs []time.Duration
+}
+
+func (m *mockExpiringCache) SetWithTTL(key string, value string, tags []string, ttl time.Duration) error {
+	m.SetTTLs = append(m.SetTTLs, ttl)
+	return m.SetWithTags(key, value, tags)
+}
+
+func (m *mockExpiringCache) CompareAndSwapWithTTL(key string, expectedVersion uint64, value string, tags []string,
+	ttl time.Duration) (uint64, bool, error) {
+	m.CASTTLs = append(m.CASTTLs, ttl)
+	return m.CompareAndSwapWithTags(key, expectedVersion, value, tags)
+}
+
+func TestServer_PostWithTTL(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name        string
+		target      string
+		conditional bool
+		wantStatus  int
+		wantSet     []time.Duration
+		wantCAS     []time.Duration
+	}{
+		{name: "ttl", target: "/key?ttl=60", wantStatus: http.StatusCreated, wantSet: []time.Duration{time.Minute}},
+		{name: "conditional ttl", target: "/key?ttl=5", conditional: true, wantStatus: http.StatusCreated,
+			wantCAS: []time.Duration{5 * time.Second}},
+		{name: "default ttl", target: "/key?ttl=0", wantStatus: http.StatusCreated},
+		{name: "negative ttl", target: "/key?ttl=-1", wantStatus: http.StatusBadRequest},
+		{name: "ttl past 100 years", target: "/key?ttl=3153600001", wantStatus: http.StatusBadRequest},
+		{name: "invalid ttl", target: "/key?ttl=soon", wantStatus: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cache := &mockExpiringCache{mockTagCache: mockTagCache{mockVersionedCache: mockVersionedCache{Swapped: true}}}
+			logger := zerolog.Nop()
+			req := httptest.NewRequest(http.MethodPost, tt.target, strings.NewReader("value"))
+			if tt.conditional {
+				req.Header.Set("If-None-Match", "*")
+			}
+			responseRecorder := httptest.NewRecorder()
+			New(&logger, cache).ServeHTTP(responseRecorder, req)
+			if responseRecorder.Code != tt.wantStatus {
+				t.Fatalf("Expected status code %d, got %d", tt.wantStatus, responseRecorder.Code)
+			}
+			if len(cache.SetTTLs) != len(tt.wantSet) || len(tt.wantSet) > 0 && cache.SetTTLs[0] != tt.wantSet[0] {
+				t.Errorf("Expected SetWithTTL to be called with %v, got %v", tt.wantSet, cache.SetTTLs)
+			}
+			if len(cache.CASTTLs) != len(tt.wantCAS) || len(tt.wantCAS) > 0 && cache.CASTTLs[0] != tt.wantCAS[0] {
+				t.Errorf("Expected CompareAndSwapWithTTL to be called with %v, got %v", tt.wantCAS, cache.CASTTLs)
+			}
+		})
+	}
+
+	// a cache keeping every value for its own TTL rejects the requests asking for another one
+	logger := zerolog.Nop()
+	req := httptest.NewRequest(http.MethodPost, "/key?ttl=60", strings.NewReader("value"))
+	responseRecorder := httptest.NewRecorder()
+	New(&logger, &mockCache{}).ServeHTTP(responseRecorder, req)
+	if responseRecorder.Code != http.StatusBadRequest {
+		t.Errorf("Expected status code %d, got %d", http.StatusBadRequest, responseRecorder.Code)
+	}
+}
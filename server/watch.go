@@ -0,0 +1,193 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog"
+	"golang.org/x/net/websocket"
+)
+
+// The types of the events of a cache
+const (
+	EventSet    = "set"
+	EventDelete = "delete"
+	EventExpire = "expire"
+	// EventEvict is the removal of a key to make room in a full cache
+	EventEvict = "evict"
+	// EventFlush is the removal of every key, it has no key
+	EventFlush = "flush"
+	// EventOverflow is the last event of a watcher that fell more than its buffer behind and missed events
+	EventOverflow = "overflow"
+)
+
+const (
+	keyQueryName = "key"
+	// DefaultWatchBuffer is the number of events queued per watcher when Limits.WatchBuffer is not set
+	DefaultWatchBuffer = 256
+	// watchHeartbeat is how often an idle stream is written to, so proxies do not close it
+	watchHeartbeat = 15 * time.Second
+)
+
+// Event is a change of a key of a cache
+type Event struct {
+	Type string `json:"type"`
+	Key  string `json:"key,omitempty"`
+	// Value is the value set, for the set events of caches that know it
+	Value   *string `json:"value,omitempty"`
+	Version uint64  `json:"version,omitempty"`
+}
+
+// WatchOptions selects the keys a watcher receives the events of
+type WatchOptions struct {
+	// Key only matches the key itself, empty matches every key starting with Prefix
+	Key    string
+	Prefix string
+	// Buffer is the number of events queued for the watcher
+	Buffer int
+}
+
+// Matches reports whether the event of key is selected by the options. Flushes have no key and match every watcher.
+func (o WatchOptions) Matches(key string) bool {
+	if key == "" {
+		return true
+	}
+	if o.Key != "" {
+		return key == o.Key
+	}
+	return strings.HasPrefix(key, o.Prefix)
+}
+
+// Watcher is implemented by caches that can stream the changes of their keys
+type Watcher interface {
+	// Watch returns the events of the keys selected by opts from now on. The channel is closed once ctx is done, or
+	// after an EventOverflow once the watcher is more than opts.Buffer events behind.
+	Watch(ctx context.Context, opts WatchOptions) (<-chan Event, error)
+}
+
+// WithStreamsContext ends the watch streams once ctx is done. A graceful shutdown of the http.Server waits for the
+// requests in progress, so the streams must end when it begins rather than with the caches.
+func WithStreamsContext(ctx context.Context) Option {
+	return func(o *options) {
+		o.streams = ctx
+	}
+}
+
+// watch handles `GET /_watch`, streaming the events as Server-Sent Events, or as WebSocket messages if the request
+// asks for an upgrade. The stream ends when the client leaves, or when streams is done if it is set.
+func watch(watcher Watcher, limits Limits, streams context.Context, logger *zerolog.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		logger := requestLogger(r, logger)
+		opts := WatchOptions{
+			Key:    r.URL.Query().Get(keyQueryName),
+			Prefix: r.URL.Query().Get(prefixQueryName),
+			Buffer: limits.WatchBuffer,
+		}
+		if opts.Buffer <= 0 {
+			opts.Buffer = DefaultWatchBuffer
+		}
+		if opts.Key != "" && opts.Prefix != "" {
+			http.Error(w, errBadRequestResponse, http.StatusBadRequest)
+			return
+		}
+		if opts.Key != "" {
+			if err := limits.validateKey(opts.Key); err != nil {
+				logger.Debug().Err(err).Str("key", opts.Key).Msg("Invalid key")
+				http.Error(w, errBadRequestResponse, http.StatusBadRequest)
+				return
+			}
+		}
+		ctx, cancel := context.WithCancel(r.Context())
+		defer cancel()
+		if streams != nil {
+			defer context.AfterFunc(streams, cancel)()
+		}
+		events, err := watcher.Watch(ctx, opts)
+		if err != nil {
+			logger.Error().Err(err).Msg("Failed to watch keys")
+			http.Error(w, errInternalServerResponse, http.StatusInternalServerError)
+			return
+		}
+		logger.Debug().Str("key", opts.Key).Str("prefix", opts.Prefix).Msg("Watching keys")
+		// a WebSocket takes over the connection, which only HTTP/1 lets the handler do
+		if strings.EqualFold(r.Header.Get("Upgrade"), "websocket") && r.ProtoMajor == 1 {
+			server := websocket.Server{
+				// browsers let any page open a WebSocket, with the cookies and credentials of the user, so the page
+				// must be one of the allowed origins
+				Handshake: func(_ *websocket.Config, r *http.Request) error {
+					if err := limits.checkOrigin(r); err != nil {
+						logger.Warn().Err(err).Str("origin", r.Header.Get("Origin")).Msg("Rejected WebSocket")
+						return err
+					}
+					return nil
+				},
+				Handler: func(conn *websocket.Conn) {
+					streamWebSocket(conn, events, cancel, logger)
+				},
+			}
+			server.ServeHTTP(w, r)
+			return
+		}
+		streamSSE(w, events, logger)
+	}
+}
+
+// streamSSE writes the events as Server-Sent Events until the channel is closed
+func streamSSE(w http.ResponseWriter, events <-chan Event, logger *zerolog.Logger) {
+	controller := http.NewResponseController(w)
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	if err := controller.Flush(); err != nil {
+		logger.Error().Err(err).Msg("Failed to flush event stream")
+		return
+	}
+	heartbeat := time.NewTicker(watchHeartbeat)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case event, ok := <-events:
+			if !ok {
+				return
+			}
+			data, err := json.Marshal(event)
+			if err != nil {
+				logger.Error().Err(err).Msg("Failed to encode event")
+				return
+			}
+			if _, err := fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event.Type, data); err != nil {
+				return
+			}
+		case <-heartbeat.C:
+			if _, err := fmt.Fprint(w, ": heartbeat\n\n"); err != nil {
+				return
+			}
+		}
+		if err := controller.Flush(); err != nil {
+			return
+		}
+	}
+}
+
+// streamWebSocket sends the events as JSON messages until the channel is closed. The messages of the client are
+// ignored, and stop is called once it closes the connection.
+func streamWebSocket(conn *websocket.Conn, events <-chan Event, stop context.CancelFunc, logger *zerolog.Logger) {
+	defer conn.Close()
+	go func() {
+		defer stop()
+		var message []byte
+		for websocket.Message.Receive(conn, &message) == nil {
+		}
+	}()
+	for event := range events {
+		_ = conn.SetWriteDeadline(time.Now().Add(watchHeartbeat))
+		if err := websocket.JSON.Send(conn, event); err != nil {
+			logger.Debug().Err(err).Msg("Failed to send event")
+			return
+		}
+	}
+}
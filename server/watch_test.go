@@ -0,0 +1,200 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/rs/zerolog"
+	"golang.org/x/net/websocket"
+)
+
+// mockWatchCache streams its events to every watcher, then keeps the stream open until the watcher is done
+type mockWatchCache struct {
+	mockCache
+	events []Event
+	opts   chan WatchOptions
+}
+
+func (m *mockWatchCache) Watch(ctx context.Context, opts WatchOptions) (<-chan Event, error) {
+	m.opts <- opts
+	events := make(chan Event, len(m.events))
+	for _, event := range m.events {
+		events <- event
+	}
+	context.AfterFunc(ctx, func() { close(events) })
+	return events, nil
+}
+
+func newWatchServer(t *testing.T, events ...Event) (*httptest.Server, chan WatchOptions) {
+	t.Helper()
+	return newWatchServerWith(t, context.Background(), events...)
+}
+
+func newWatchServerWith(t *testing.T, streams context.Context, events ...Event) (*httptest.Server, chan WatchOptions) {
+	t.Helper()
+	logger := zerolog.Nop()
+	cache := &mockWatchCache{events: events, opts: make(chan WatchOptions, 1)}
+	server := httptest.NewServer(New(&logger, cache, WithLimits(Limits{MaxKeyLength: 10, WatchBuffer: 5}),
+		WithStreamsContext(streams)))
+	t.Cleanup(server.Close)
+	return server, cache.opts
+}
+
+var watchedEvents = []Event{
+	{Type: EventSet, Key: "user:1", Value: ptr("alice"), Version: 3},
+	{Type: EventDelete, Key: "user:1"},
+}
+
+func TestServer_Watch_SSE(t *testing.T) {
+	t.Parallel()
+	server, opts := newWatchServer(t, watchedEvents...)
+	resp, err := http.Get(server.URL + "/_watch?prefix=user:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected status code %d, got %d", http.StatusOK, resp.StatusCode)
+	}
+	if contentType := resp.Header.Get("Content-Type"); contentType != "text/event-stream" {
+		t.Errorf("Expected content type text/event-stream, got %s", contentType)
+	}
+	if got := <-opts; got.Prefix != "user:" || got.Key != "" || got.Buffer != 5 {
+		t.Errorf("Expected the prefix user: with a buffer of 5, got %+v", got)
+	}
+
+	reader := bufio.NewReader(resp.Body)
+	for _, want := range watchedEvents {
+		var eventType, data string
+		for {
+			line, err := reader.ReadString('\n')
+			if err != nil {
+				t.Fatal(err)
+			}
+			line = strings.TrimSuffix(line, "\n")
+			if line == "" {
+				break
+			}
+			if value, ok := strings.CutPrefix(line, "event: "); ok {
+				eventType = value
+			}
+			if value, ok := strings.CutPrefix(line, "data: "); ok {
+				data = value
+			}
+		}
+		var got Event
+		if err := json.Unmarshal([]byte(data), &got); err != nil {
+			t.Fatal(err)
+		}
+		if eventType != want.Type || got.Type != want.Type || got.Key != want.Key || got.Version != want.Version {
+			t.Errorf("Expected %+v, got %s %+v", want, eventType, got)
+		}
+	}
+}
+
+func TestServer_Watch_WebSocket(t *testing.T) {
+	t.Parallel()
+	server, opts := newWatchServer(t, watchedEvents...)
+	conn, err := websocket.Dial("ws"+strings.TrimPrefix(server.URL, "http")+"/_watch?key=user:1", "", server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if got := <-opts; got.Key != "user:1" || got.Prefix != "" {
+		t.Errorf("Expected the key user:1, got %+v", got)
+	}
+	for _, want := range watchedEvents {
+		var got Event
+		if err := websocket.JSON.Receive(conn, &got); err != nil {
+			t.Fatal(err)
+		}
+		if got.Type != want.Type || got.Key != want.Key || got.Version != want.Version {
+			t.Errorf("Expected %+v, got %+v", want, got)
+		}
+	}
+}
+
+func TestServer_Watch_WebSocketOrigin(t *testing.T) {
+	t.Parallel()
+	server, _ := newWatchServer(t, watchedEvents...)
+	// a page of another site can not open a WebSocket with the credentials of the user
+	_, err := websocket.Dial("ws"+strings.TrimPrefix(server.URL, "http")+"/_watch", "", "https://evil.example.com")
+	if err == nil {
+		t.Error("Expected the WebSocket of another origin to be rejected")
+	}
+}
+
+func TestServer_Watch_StreamsContext(t *testing.T) {
+	t.Parallel()
+	streams, stopStreams := context.WithCancel(context.Background())
+	server, opts := newWatchServerWith(t, streams)
+	resp, err := http.Get(server.URL + "/_watch")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	<-opts
+	// the stream ends once the streams are stopped, although the client is still reading
+	stopStreams()
+	if _, err := io.ReadAll(resp.Body); err != nil {
+		t.Errorf("Expected the stream to end, got %v", err)
+	}
+}
+
+func TestServer_Watch_BadRequest(t *testing.T) {
+	t.Parallel()
+	server, _ := newWatchServer(t)
+	tests := []struct {
+		name  string
+		query string
+	}{
+		{name: "key and prefix", query: "?key=a&prefix=b"},
+		{name: "invalid key", query: "?key=too-long-for-the-limit"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, err := http.Get(server.URL + "/_watch" + tt.query)
+			if err != nil {
+				t.Fatal(err)
+			}
+			_ = resp.Body.Close()
+			if resp.StatusCode != http.StatusBadRequest {
+				t.Errorf("Expected status code %d, got %d", http.StatusBadRequest, resp.StatusCode)
+			}
+		})
+	}
+}
+
+func TestWatchOptions_Matches(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name string
+		opts WatchOptions
+		key  string
+		want bool
+	}{
+		{name: "every key", opts: WatchOptions{}, key: "a", want: true},
+		{name: "same key", opts: WatchOptions{Key: "user:1"}, key: "user:1", want: true},
+		{name: "longer key", opts: WatchOptions{Key: "user:1"}, key: "user:10", want: false},
+		{name: "prefix", opts: WatchOptions{Prefix: "user:"}, key: "user:10", want: true},
+		{name: "other prefix", opts: WatchOptions{Prefix: "user:"}, key: "order:1", want: false},
+		{name: "flush", opts: WatchOptions{Key: "user:1"}, key: "", want: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.opts.Matches(tt.key); got != tt.want {
+				t.Errorf("Expected %v, got %v", tt.want, got)
+			}
+		})
+	}
+}
+
+func ptr(s string) *string {
+	return &s
+}
@@ -0,0 +1,180 @@
+package tlsconfig
+
+import (
+	"cache-api/config"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+const defaultReloadInterval = 10 * time.Second
+
+// CertReloader serves a certificate loaded from files and reloads it whenever the files change on disk, so rotated
+// certificates are picked up without a restart
+type CertReloader struct {
+	certFile string
+	keyFile  string
+	logger   *zerolog.Logger
+
+	mutex   sync.RWMutex
+	cert    *tls.Certificate
+	modTime time.Time
+}
+
+// NewCertReloader loads the certificate and starts checking the files for changes every interval until ctx is done
+func NewCertReloader(ctx context.Context, certFile string, keyFile string, interval time.Duration, logger *zerolog.Logger) (*CertReloader, error) {
+	r := &CertReloader{certFile: certFile, keyFile: keyFile, logger: logger}
+	if _, err := r.Reload(); err != nil {
+		return nil, err
+	}
+	if interval <= 0 {
+		interval = defaultReloadInterval
+	}
+	go r.watch(ctx, interval)
+	return r, nil
+}
+
+// Reload loads the certificate again if one of its files changed since the last load, and reports whether it did.
+// On error, the previous certificate is kept.
+func (r *CertReloader) Reload() (bool, error) {
+	modTime, err := r.latestModTime()
+	if err != nil {
+		return false, err
+	}
+	r.mutex.RLock()
+	unchanged := r.cert != nil && modTime.Equal(r.modTime)
+	r.mutex.RUnlock()
+	if unchanged {
+		return false, nil
+	}
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return false, fmt.Errorf("error loading certificate %w", err)
+	}
+	r.mutex.Lock()
+	r.cert = &cert
+	r.modTime = modTime
+	r.mutex.Unlock()
+	return true, nil
+}
+
+func (r *CertReloader) latestModTime() (time.Time, error) {
+	var latest time.Time
+	for _, file := range []string{r.certFile, r.keyFile} {
+		info, err := os.Stat(file)
+		if err != nil {
+			return latest, err
+		}
+		if info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+	return latest, nil
+}
+
+func (r *CertReloader) watch(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			reloaded, err := r.Reload()
+			if err != nil {
+				r.logger.Error().Err(err).Msg("error reloading tls certificate, keeping the previous one")
+			} else if reloaded {
+				r.logger.Info().Str("cert_file", r.certFile).Msg("reloaded tls certificate")
+			}
+		}
+	}
+}
+
+// GetCertificate returns the current certificate, to be used as tls.Config.GetCertificate
+func (r *CertReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	return r.cert, nil
+}
+
+// GetClientCertificate returns the current certificate, to be used as tls.Config.GetClientCertificate
+func (r *CertReloader) GetClientCertificate(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	return r.cert, nil
+}
+
+// New creates the TLS config of the server from the certificate files of conf. If a client CA is configured, clients
+// must present a certificate signed by it.
+func New(ctx context.Context, conf config.TLSConfig, logger *zerolog.Logger) (*tls.Config, error) {
+	if conf.CertFile == "" || conf.KeyFile == "" {
+		return nil, errors.New("both a certificate and a key file are required")
+	}
+	reloader, err := NewCertReloader(ctx, conf.CertFile, conf.KeyFile,
+		time.Duration(conf.ReloadIntervalSec)*time.Second, logger)
+	if err != nil {
+		return nil, err
+	}
+	tlsConfig := &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: reloader.GetCertificate,
+		NextProtos:     []string{"h2", "http/1.1"},
+	}
+	if conf.ClientCAFile != "" {
+		pool := x509.NewCertPool()
+		if err := appendClientCA(pool, conf.ClientCAFile); err != nil {
+			return nil, err
+		}
+		tlsConfig.ClientCAs = pool
+		tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return tlsConfig, nil
+}
+
+// NewClient creates the TLS config of the connections between the instances, of the cluster and of the replication,
+// from the certificate files of conf. The instances present their own certificate, so they pass mutual TLS, and trust
+// the system roots and the client CA, which signs the certificates of the instances when it is configured.
+func NewClient(ctx context.Context, conf config.TLSConfig, logger *zerolog.Logger) (*tls.Config, error) {
+	if conf.CertFile == "" || conf.KeyFile == "" {
+		return nil, errors.New("both a certificate and a key file are required")
+	}
+	reloader, err := NewCertReloader(ctx, conf.CertFile, conf.KeyFile,
+		time.Duration(conf.ReloadIntervalSec)*time.Second, logger)
+	if err != nil {
+		return nil, err
+	}
+	tlsConfig := &tls.Config{
+		MinVersion:           tls.VersionTLS12,
+		GetClientCertificate: reloader.GetClientCertificate,
+	}
+	if conf.ClientCAFile != "" {
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if err := appendClientCA(pool, conf.ClientCAFile); err != nil {
+			return nil, err
+		}
+		tlsConfig.RootCAs = pool
+	}
+	return tlsConfig, nil
+}
+
+// appendClientCA adds the certificates of the client CA file to pool
+func appendClientCA(pool *x509.CertPool, file string) error {
+	content, err := os.ReadFile(file)
+	if err != nil {
+		return fmt.Errorf("error reading client ca file %w", err)
+	}
+	if !pool.AppendCertsFromPEM(content) {
+		return errors.New("no certificate found in client ca file")
+	}
+	return nil
+}
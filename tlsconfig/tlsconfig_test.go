@@ -0,0 +1,216 @@
+package tlsconfig
+
+import (
+	"cache-api/config"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"log"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+type testCert struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pem  []byte
+}
+
+// issue creates a certificate for commonName, signed by parent or self-signed if parent is nil
+func issue(t *testing.T, commonName string, parent *testCert, isCA bool) *testCert {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: commonName},
+		IPAddresses:           []net.IP{net.IPv4(127, 0, 0, 1)},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  isCA,
+	}
+	signer, signerKey := template, key
+	if parent != nil {
+		signer, signerKey = parent.cert, parent.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, signer, &key.PublicKey, signerKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testCert{cert: cert, key: key, pem: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})}
+}
+
+func (c *testCert) write(t *testing.T, certFile string, keyFile string) {
+	t.Helper()
+	keyDER, err := x509.MarshalECPrivateKey(c.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(certFile, c.pem, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCertReloader_Reload(t *testing.T) {
+	t.Parallel()
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "tls.crt"), filepath.Join(dir, "tls.key")
+	issue(t, "first", nil, false).write(t, certFile, keyFile)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	logger := zerolog.Nop()
+	reloader, err := NewCertReloader(ctx, certFile, keyFile, time.Hour, &logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	reloaded, err := reloader.Reload()
+	if err != nil || reloaded {
+		t.Errorf("Expected unchanged files not to be reloaded, got %v %v", reloaded, err)
+	}
+
+	issue(t, "second", nil, false).write(t, certFile, keyFile)
+	later := time.Now().Add(time.Minute)
+	for _, file := range []string{certFile, keyFile} {
+		if err := os.Chtimes(file, later, later); err != nil {
+			t.Fatal(err)
+		}
+	}
+	reloaded, err = reloader.Reload()
+	if err != nil || !reloaded {
+		t.Fatalf("Expected changed files to be reloaded, got %v %v", reloaded, err)
+	}
+	cert, _ := reloader.GetCertificate(nil)
+	if cert.Leaf == nil {
+		cert.Leaf, _ = x509.ParseCertificate(cert.Certificate[0])
+	}
+	if cert.Leaf.Subject.CommonName != "second" {
+		t.Errorf("Expected the new certificate, got %s", cert.Leaf.Subject.CommonName)
+	}
+
+	if err := os.WriteFile(certFile, []byte("garbage"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(certFile, later.Add(time.Minute), later.Add(time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := reloader.Reload(); err == nil {
+		t.Error("Expected an error for an invalid certificate")
+	}
+	if cert, _ := reloader.GetCertificate(nil); cert == nil {
+		t.Error("Expected the previous certificate to be kept")
+	}
+}
+
+func TestNew_MutualTLS(t *testing.T) {
+	t.Parallel()
+	dir := t.TempDir()
+	ca := issue(t, "ca", nil, true)
+	caFile := filepath.Join(dir, "ca.crt")
+	if err := os.WriteFile(caFile, ca.pem, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	certFile, keyFile := filepath.Join(dir, "tls.crt"), filepath.Join(dir, "tls.key")
+	issue(t, "server", ca, false).write(t, certFile, keyFile)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	logger := zerolog.Nop()
+	tlsConfig, err := New(ctx, config.TLSConfig{CertFile: certFile, KeyFile: keyFile, ClientCAFile: caFile}, &logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}), ErrorLog: log.New(io.Discard, "", 0)}
+	go func() { _ = srv.Serve(tls.NewListener(listener, tlsConfig)) }()
+	defer srv.Close()
+	url := "https://" + listener.Addr().String()
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+	client := issue(t, "client", ca, false)
+	clientCert := tls.Certificate{Certificate: [][]byte{client.cert.Raw}, PrivateKey: client.key}
+
+	withCert := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{
+		RootCAs: roots, Certificates: []tls.Certificate{clientCert},
+	}}}
+	resp, err := withCert.Get(url)
+	if err != nil {
+		t.Fatalf("Expected a client with a certificate to connect, got %v", err)
+	}
+	_ = resp.Body.Close()
+
+	withoutCert := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: roots}}}
+	if resp, err := withoutCert.Get(url); err == nil {
+		_ = resp.Body.Close()
+		t.Error("Expected a client without a certificate to be rejected")
+	}
+}
+
+func TestNewClient_MutualTLS(t *testing.T) {
+	t.Parallel()
+	dir := t.TempDir()
+	ca := issue(t, "ca", nil, true)
+	caFile := filepath.Join(dir, "ca.crt")
+	if err := os.WriteFile(caFile, ca.pem, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	certFile, keyFile := filepath.Join(dir, "tls.crt"), filepath.Join(dir, "tls.key")
+	issue(t, "node", ca, false).write(t, certFile, keyFile)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	logger := zerolog.Nop()
+	conf := config.TLSConfig{CertFile: certFile, KeyFile: keyFile, ClientCAFile: caFile}
+	serverConfig, err := New(ctx, conf, &logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clientConfig, err := NewClient(ctx, conf, &logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}), ErrorLog: log.New(io.Discard, "", 0)}
+	go func() { _ = srv.Serve(tls.NewListener(listener, serverConfig)) }()
+	defer srv.Close()
+
+	// a node reaches another node presenting the same certificate
+	client := &http.Client{Transport: &http.Transport{TLSClientConfig: clientConfig}}
+	resp, err := client.Get("https://" + listener.Addr().String())
+	if err != nil {
+		t.Fatalf("Expected a node to connect to another node, got %v", err)
+	}
+	_ = resp.Body.Close()
+}